@@ -127,6 +127,28 @@ func generateCNIResult(cniVersion string, cniResponse *request.PodResponse) (*cu
 		result.Routes = append(result.Routes, &route)
 	}
 
+	// extra routes of subnets
+	for _, extraRoute := range cniResponse.Routes {
+		_, dst, err := net.ParseCIDR(extraRoute.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse route destination %v: %v", extraRoute.Dst, err)
+		}
+
+		result.Routes = append(result.Routes, &types.Route{
+			Dst: *dst,
+			GW:  net.ParseIP(extraRoute.Gateway),
+		})
+	}
+
+	if cniResponse.DNS != nil {
+		result.DNS = types.DNS{
+			Nameservers: cniResponse.DNS.Nameservers,
+			Domain:      cniResponse.DNS.Domain,
+			Search:      cniResponse.DNS.Search,
+			Options:     cniResponse.DNS.Options,
+		}
+	}
+
 	// for chained cni plugins
 	hostVeth, err := netlink.LinkByName(cniResponse.HostInterface)
 	if err != nil {
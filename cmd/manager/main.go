@@ -102,6 +102,7 @@ func main() {
 
 	if err = (&networking.IPInstanceReconciler{
 		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerIPInstance + "Controller"),
 		IPAMManager:           ipamManager,
 		IPAMStore:             ipamStore,
 		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPInstance]),
@@ -150,6 +151,57 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err = (&networking.VirtualIPReconciler{
+		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerVirtualIP + "Controller"),
+		IPAMManager:           ipamManager,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerVirtualIP]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerVirtualIP)
+		os.Exit(1)
+	}
+
+	if err = (&networking.AnycastIPReconciler{
+		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerAnycastIP + "Controller"),
+		IPAMManager:           ipamManager,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerAnycastIP]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerAnycastIP)
+		os.Exit(1)
+	}
+
+	if err = (&networking.IPReservationReconciler{
+		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor(networking.ControllerIPReservation + "Controller"),
+		IPAMManager:           ipamManager,
+		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerIPReservation]),
+	}).SetupWithManager(mgr); err != nil {
+		entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerIPReservation)
+		os.Exit(1)
+	}
+
+	if feature.LoadBalancerEnabled() {
+		if err = (&networking.LoadBalancerIPReconciler{
+			Client:                mgr.GetClient(),
+			Recorder:              mgr.GetEventRecorderFor(networking.ControllerLoadBalancerIP + "Controller"),
+			IPAMManager:           ipamManager,
+			ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerLoadBalancerIP]),
+		}).SetupWithManager(mgr); err != nil {
+			entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerLoadBalancerIP)
+			os.Exit(1)
+		}
+
+		if err = (&networking.LoadBalancerReconciler{
+			Client:                mgr.GetClient(),
+			Recorder:              mgr.GetEventRecorderFor(networking.ControllerLoadBalancer + "Controller"),
+			ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerLoadBalancer]),
+		}).SetupWithManager(mgr); err != nil {
+			entryLog.Error(err, "unable to inject controller", "controller", networking.ControllerLoadBalancer)
+			os.Exit(1)
+		}
+	}
+
 	if err = (&networking.QuotaReconciler{
 		Client:                mgr.GetClient(),
 		ControllerConcurrency: concurrency.ControllerConcurrency(controllerConcurrency[networking.ControllerQuota]),
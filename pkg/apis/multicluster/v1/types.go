@@ -32,4 +32,10 @@ type VTEPInfo struct {
 	// MAC is the MAC address of this VTEP.
 	// +kubebuilder:validation:Required
 	MAC string `json:"mac,omitempty"`
+	// SecondaryIP is the IP address of this VTEP in the other address family, for dual-stack underlay.
+	// +kubebuilder:validation:Optional
+	SecondaryIP string `json:"secondaryIP,omitempty"`
+	// WireGuardPublicKey is the public key of wireguard device on this VTEP, empty if overlay traffic is not encrypted.
+	// +kubebuilder:validation:Optional
+	WireGuardPublicKey string `json:"wireguardPublicKey,omitempty"`
 }
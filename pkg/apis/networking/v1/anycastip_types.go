@@ -0,0 +1,89 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnycastIPSpec defines the desired state of AnycastIP
+type AnycastIPSpec struct {
+	// Subnet is the subnet of bgp network which the anycast ip is allocated from.
+	// +kubebuilder:validation:Required
+	Subnet string `json:"subnet"`
+	// Address is the expected anycast ip, it will be allocated automatically if empty.
+	// +kubebuilder:validation:Optional
+	Address string `json:"address,omitempty"`
+	// Selector selects pods in the same namespace, the anycast ip will be configured on
+	// all the running pods selected.
+	// +kubebuilder:validation:Required
+	Selector *metav1.LabelSelector `json:"selector"`
+}
+
+// AnycastIPPod is a pod which the anycast ip is configured on.
+type AnycastIPPod struct {
+	Name     string `json:"name"`
+	NodeName string `json:"nodeName"`
+	// Ready means the pod is ready, it is for display only. The anycast ip is advertised by
+	// the node only if there are ready pods on it, which is observed by daemon directly.
+	// +kubebuilder:validation:Optional
+	Ready bool `json:"ready"`
+}
+
+// AnycastIPStatus defines the observed state of AnycastIP
+type AnycastIPStatus struct {
+	// +kubebuilder:validation:Optional
+	Network string `json:"network,omitempty"`
+	// +kubebuilder:validation:Optional
+	Version IPVersion `json:"version,omitempty"`
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// Pods are the running pods selected, sorted by name.
+	// +kubebuilder:validation:Optional
+	Pods []AnycastIPPod `json:"pods,omitempty"`
+	// +kubebuilder:validation:Optional
+	ReadyPods int32 `json:"readyPods"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.ip`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyPods`
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.spec.subnet`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.status.network`
+
+// AnycastIP is the Schema for the anycastips API
+type AnycastIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AnycastIPSpec   `json:"spec,omitempty"`
+	Status AnycastIPStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AnycastIPList contains a list of AnycastIP
+type AnycastIPList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AnycastIP `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AnycastIP{}, &AnycastIPList{})
+}
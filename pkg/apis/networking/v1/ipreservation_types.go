@@ -0,0 +1,79 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPReservationSpec defines the desired state of IPReservation
+type IPReservationSpec struct {
+	// Subnet is the subnet which the reserved ip belongs to.
+	// +kubebuilder:validation:Required
+	Subnet string `json:"subnet"`
+	// IP is the address to be reserved.
+	// +kubebuilder:validation:Required
+	IP string `json:"ip"`
+	// Owner describes who takes the reserved ip, e.g. a load balancer, a printer or a vm.
+	// +kubebuilder:validation:Optional
+	Owner string `json:"owner,omitempty"`
+	// Description is extra information of reservation, e.g. the related ticket.
+	// +kubebuilder:validation:Optional
+	Description string `json:"description,omitempty"`
+}
+
+// IPReservationStatus defines the observed state of IPReservation
+type IPReservationStatus struct {
+	// +kubebuilder:validation:Optional
+	Network string `json:"network,omitempty"`
+	// +kubebuilder:validation:Optional
+	Version IPVersion `json:"version,omitempty"`
+	// Reserved shows whether the ip has been taken up in IPAM.
+	// +kubebuilder:validation:Optional
+	Reserved bool `json:"reserved,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.spec.ip`
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.spec.subnet`
+// +kubebuilder:printcolumn:name="Owner",type=string,JSONPath=`.spec.owner`
+// +kubebuilder:printcolumn:name="Reserved",type=boolean,JSONPath=`.status.reserved`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.status.network`
+
+// IPReservation is the Schema for the ipreservations API
+type IPReservation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPReservationSpec   `json:"spec,omitempty"`
+	Status IPReservationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IPReservationList contains a list of IPReservation
+type IPReservationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPReservation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPReservation{}, &IPReservationList{})
+}
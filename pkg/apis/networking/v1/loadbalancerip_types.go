@@ -0,0 +1,82 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LoadBalancerIPSpec defines the desired state of LoadBalancerIP
+type LoadBalancerIPSpec struct {
+	// Service is the name of the LoadBalancer type service in the same namespace which
+	// the ip is allocated for.
+	// +kubebuilder:validation:Required
+	Service string `json:"service"`
+	// Version is the ip family of the load balancer ip.
+	// +kubebuilder:validation:Required
+	Version IPVersion `json:"version"`
+	// Subnet is the load balancer subnet which the ip is allocated from, all the load balancer
+	// subnets of the same ip family will be tried if empty.
+	// +kubebuilder:validation:Optional
+	Subnet string `json:"subnet,omitempty"`
+	// Address is the expected load balancer ip, it will be allocated automatically if empty.
+	// +kubebuilder:validation:Optional
+	Address string `json:"address,omitempty"`
+}
+
+// LoadBalancerIPStatus defines the observed state of LoadBalancerIP
+type LoadBalancerIPStatus struct {
+	// +kubebuilder:validation:Optional
+	Network string `json:"network,omitempty"`
+	// +kubebuilder:validation:Optional
+	Subnet string `json:"subnet,omitempty"`
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// NodeName is the node elected to announce the ip by gratuitous arp in vlan network.
+	// +kubebuilder:validation:Optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.ip`
+// +kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.service`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.nodeName`
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.status.subnet`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.status.network`
+
+// LoadBalancerIP is the Schema for the loadbalancerips API
+type LoadBalancerIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LoadBalancerIPSpec   `json:"spec,omitempty"`
+	Status LoadBalancerIPStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LoadBalancerIPList contains a list of LoadBalancerIP
+type LoadBalancerIPList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LoadBalancerIP `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LoadBalancerIP{}, &LoadBalancerIPList{})
+}
@@ -38,6 +38,12 @@ type NetworkSpec struct {
 	Mode NetworkMode `json:"mode,omitempty"`
 	// +kubebuilder:validation:Optional
 	Config *NetworkConfig `json:"config,omitempty"`
+	// MTU of pods in this network, it can be overridden by subnet. The mtu of node interface
+	// minus encapsulation overhead will be used if not specified.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=576
+	// +kubebuilder:validation:Maximum=65535
+	MTU *int32 `json:"mtu,omitempty"`
 }
 
 // NetworkStatus defines the observed state of Network
@@ -58,6 +64,57 @@ type NetworkStatus struct {
 	IPv6Statistics *Count `json:"ipv6Statistics,omitempty"`
 	// +kubebuilder:validation:Optional
 	DualStackStatistics *Count `json:"dualStackStatistics,omitempty"`
+	// BGPStatus aggregates the bgp states reported by nodes of bgp network.
+	// +kubebuilder:validation:Optional
+	BGPStatus *NetworkBGPStatus `json:"bgpStatus,omitempty"`
+}
+
+type BGPSessionState string
+
+const (
+	BGPSessionStateUnknown     = BGPSessionState("Unknown")
+	BGPSessionStateIdle        = BGPSessionState("Idle")
+	BGPSessionStateConnect     = BGPSessionState("Connect")
+	BGPSessionStateActive      = BGPSessionState("Active")
+	BGPSessionStateOpenSent    = BGPSessionState("OpenSent")
+	BGPSessionStateOpenConfirm = BGPSessionState("OpenConfirm")
+	BGPSessionStateEstablished = BGPSessionState("Established")
+)
+
+// BGPPeerStatus is the observed state of the bgp session between a node and one of its peers.
+type BGPPeerStatus struct {
+	// Address is the discovered link-local address for an unnumbered peer, which might be empty
+	// before the peer is discovered.
+	Address string `json:"address"`
+	// Interface is the interface of an unnumbered peer.
+	// +kubebuilder:validation:Optional
+	Interface string          `json:"interface,omitempty"`
+	State     BGPSessionState `json:"state"`
+	// EstablishedTime is the time when the session was established, from which the uptime is
+	// calculated. It is empty if the session is not established.
+	// +kubebuilder:validation:Optional
+	EstablishedTime *metav1.Time `json:"establishedTime,omitempty"`
+	// +kubebuilder:validation:Optional
+	ReceivedPrefixes int64 `json:"receivedPrefixes"`
+	// +kubebuilder:validation:Optional
+	AdvertisedPrefixes int64 `json:"advertisedPrefixes"`
+}
+
+// NodeBGPStatus is the bgp state of node reported by daemon in NodeNetworkStatus.
+type NodeBGPStatus struct {
+	Peers []BGPPeerStatus `json:"peers,omitempty"`
+}
+
+// NetworkBGPStatus is the summary of bgp states of nodes in a bgp network.
+type NetworkBGPStatus struct {
+	// +kubebuilder:validation:Optional
+	EstablishedSessions int32 `json:"establishedSessions"`
+	// +kubebuilder:validation:Optional
+	TotalSessions int32 `json:"totalSessions"`
+	// DisconnectedNodes are the nodes of network without any established session, including
+	// the ones which have not reported bgp states yet.
+	// +kubebuilder:validation:Optional
+	DisconnectedNodes []string `json:"disconnectedNodes,omitempty"`
 }
 
 // +kubebuilder:object:root=true
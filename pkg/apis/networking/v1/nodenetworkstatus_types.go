@@ -0,0 +1,48 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// NodeNetworkStatus is the network state of a node reported by daemon, it has the same name as
+// the node and is garbage collected along with the node.
+type NodeNetworkStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// BGP is the bgp state of node, it is empty if no bgp peer is configured on node.
+	// +kubebuilder:validation:Optional
+	BGP *NodeBGPStatus `json:"bgp,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NodeNetworkStatusList contains a list of NodeNetworkStatus
+type NodeNetworkStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeNetworkStatus `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeNetworkStatus{}, &NodeNetworkStatusList{})
+}
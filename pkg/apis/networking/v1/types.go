@@ -26,9 +26,16 @@ const (
 type NetworkMode string
 
 const (
-	NetworkModeBGP   = NetworkMode("BGP")
-	NetworkModeVlan  = NetworkMode("Vlan")
-	NetworkModeVxlan = NetworkMode("Vxlan")
+	NetworkModeBGP    = NetworkMode("BGP")
+	NetworkModeVlan   = NetworkMode("Vlan")
+	NetworkModeVxlan  = NetworkMode("Vxlan")
+	NetworkModeGeneve = NetworkMode("Geneve")
+)
+
+type EncryptionMode string
+
+const (
+	EncryptionModeWireGuard = EncryptionMode("WireGuard")
 )
 
 type Count struct {
@@ -75,11 +82,117 @@ type SubnetConfig struct {
 	Private *bool `json:"private"`
 	// +kubebuilder:validation:Optional
 	AllowSubnets []string `json:"allowSubnets"`
+	// +kubebuilder:validation:Optional
+	Routes []SubnetRoute `json:"routes,omitempty"`
+	// +kubebuilder:validation:Optional
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// IngressBandwidth is the default ingress bandwidth limit of pods which do not
+	// set kubernetes.io/ingress-bandwidth annotation, e.g., 10M.
+	// +kubebuilder:validation:Optional
+	IngressBandwidth string `json:"ingressBandwidth,omitempty"`
+	// EgressBandwidth is the default egress bandwidth limit of pods which do not
+	// set kubernetes.io/egress-bandwidth annotation, e.g., 10M.
+	// +kubebuilder:validation:Optional
+	EgressBandwidth string `json:"egressBandwidth,omitempty"`
+	// MTU of pods in this subnet, it takes precedence over the mtu of network.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=576
+	// +kubebuilder:validation:Maximum=65535
+	MTU *int32 `json:"mtu,omitempty"`
+	// LoadBalancer marks the subnet as a pool of load balancer ips of services, pods never
+	// get ips from it.
+	// +kubebuilder:validation:Optional
+	LoadBalancer *bool `json:"loadBalancer,omitempty"`
+	// BGPPath configures the bgp paths advertised for this subnet and its pod ips, fields specified
+	// here take precedence over the ones of network.
+	// +kubebuilder:validation:Optional
+	BGPPath *BGPPathConfig `json:"bgpPath,omitempty"`
+}
+
+type SubnetRoute struct {
+	// Dst is the destination CIDR of route.
+	// +kubebuilder:validation:Required
+	Dst string `json:"dst"`
+	// Gateway is the next hop of route, subnet gateway will be used if empty.
+	// +kubebuilder:validation:Optional
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type DNSConfig struct {
+	// +kubebuilder:validation:Optional
+	Nameservers []string `json:"nameservers,omitempty"`
+	// +kubebuilder:validation:Optional
+	Domain string `json:"domain,omitempty"`
+	// +kubebuilder:validation:Optional
+	Search []string `json:"search,omitempty"`
+	// +kubebuilder:validation:Optional
+	Options []string `json:"options,omitempty"`
 }
 
 type NetworkConfig struct {
 	// +kubebuilder:validation:Optional
 	BGPPeers []BGPPeer `json:"bgpPeers,omitempty"`
+	// Uplink interfaces of vlan network on nodes, in the same format as --prefer-vlan-interfaces
+	// of daemon, e.g., "bond1,eth1". It can be overridden by node annotation, and the default vlan
+	// interface of node will be used if not specified. It must not be changed while there are ip
+	// instances in network.
+	// +kubebuilder:validation:Optional
+	VlanUplinkInterfaces string `json:"vlanUplinkInterfaces,omitempty"`
+	// Outer S-tag of vlan network, pods will be double tagged (802.1ad) if specified. It must not
+	// be changed while there are ip instances in network.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	OuterVlanID *int32 `json:"outerVlanID,omitempty"`
+	// Encryption of overlay traffic between nodes, no encryption if not specified. The mtu of pods
+	// is reduced by the overhead of wireguard, which only takes effect on newly created pods. Existing
+	// pods should be recreated after encryption is enabled, or their packets might be fragmented.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=WireGuard
+	Encryption EncryptionMode `json:"encryption,omitempty"`
+	// Route overlay traffic to pods on nodes in the same subnet of vtep ip directly via node ips
+	// without encapsulation, pods on other nodes are still reached through overlay tunnel.
+	// +kubebuilder:validation:Optional
+	DirectRouting bool `json:"directRouting,omitempty"`
+	// Allow-list of bgp network to import routes learned from peers, e.g., "10.0.0.0/8". A received
+	// route is installed for pods of this network only if its prefix is contained by one of them, and
+	// no route will be imported if not specified.
+	// +kubebuilder:validation:Optional
+	BGPImportPrefixes []string `json:"bgpImportPrefixes,omitempty"`
+	// Service ips advertised to bgp peers by nodes of bgp network, nothing is advertised if not specified.
+	// +kubebuilder:validation:Optional
+	BGPServiceAdvertisement *BGPServiceAdvertisement `json:"bgpServiceAdvertisement,omitempty"`
+	// BGPPath configures the bgp paths advertised for subnets and pod ips of bgp network.
+	// +kubebuilder:validation:Optional
+	BGPPath *BGPPathConfig `json:"bgpPath,omitempty"`
+}
+
+type BGPPathConfig struct {
+	// Standard communities attached to paths, in the format of "ASN:VALUE" or well-known names,
+	// e.g., "65000:100". Host routes of pod ips always carry no-export besides these.
+	// +kubebuilder:validation:Optional
+	Communities []string `json:"communities,omitempty"`
+	// Local preference attached to paths, which only takes effect with ibgp peers.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	LocalPreference *int32 `json:"localPreference,omitempty"`
+	// Only advertise the aggregate subnet prefixes, host routes of pod ips are not advertised.
+	// +kubebuilder:validation:Optional
+	AggregateOnly *bool `json:"aggregateOnly,omitempty"`
+}
+
+type BGPServiceAdvertisement struct {
+	// Advertise cluster ips of services from all nodes.
+	// +kubebuilder:validation:Optional
+	ClusterIPs bool `json:"clusterIPs,omitempty"`
+	// Advertise external ips of services. For services with "Local" external traffic policy, they are
+	// only advertised from nodes with ready endpoints.
+	// +kubebuilder:validation:Optional
+	ExternalIPs bool `json:"externalIPs,omitempty"`
+	// Advertise ingress ips in load balancer status of services. For services with "Local" external
+	// traffic policy, they are only advertised from nodes with ready endpoints.
+	// +kubebuilder:validation:Optional
+	LoadBalancerIPs bool `json:"loadBalancerIPs,omitempty"`
 }
 
 type Address struct {
@@ -98,12 +211,38 @@ type Address struct {
 type BGPPeer struct {
 	// +kubebuilder:validation:Required
 	ASN int32 `json:"asn"`
-	// +kubebuilder:validation:Required
-	Address string `json:"address"`
+	// Address of peer, it must be specified unless Unnumbered is true.
+	// +kubebuilder:validation:Optional
+	Address string `json:"address,omitempty"`
+	// Unnumbered makes every node peer with the router on the other side of its bgp interface, which
+	// is chosen by --prefer-bgp-interfaces of daemon, through ipv6 link-local addresses, known as bgp
+	// unnumbered. IPv4 routes are advertised with ipv6 next hops (RFC 5549).
+	// +kubebuilder:validation:Optional
+	Unnumbered bool `json:"unnumbered,omitempty"`
 	// +kubebuilder:validation:Optional
 	GracefulRestartSeconds int32 `json:"gracefulRestartSeconds,omitempty"`
 	// +kubebuilder:validation:Optional
 	Password string `json:"password,omitempty"`
+	// BFD session will be established with peer for fast failure detection if specified, and
+	// bgp session will be torn down once bfd session goes down.
+	// +kubebuilder:validation:Optional
+	BFD *BFDConfig `json:"bfd,omitempty"`
+}
+
+type BFDConfig struct {
+	// Min interval in milliseconds to send bfd control packets, 300 by default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=10
+	DesiredMinTxInterval int32 `json:"desiredMinTxInterval,omitempty"`
+	// Min interval in milliseconds to receive bfd control packets, 300 by default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=10
+	RequiredMinRxInterval int32 `json:"requiredMinRxInterval,omitempty"`
+	// Count of missing bfd control packets to take session down, 3 by default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=255
+	DetectMultiplier int32 `json:"detectMultiplier,omitempty"`
 }
 
 type IPPhase string
@@ -21,8 +21,51 @@ import (
 	"math"
 	"math/big"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ip"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// VXLAN uses a 50-byte header
+	VxlanMTUOverhead = 50
+	// VXLAN over ipv6 uses a 70-byte header
+	VxlanIPv6MTUOverhead = 70
+	// Geneve without options uses a 50-byte header
+	GeneveMTUOverhead = 50
+	// Geneve over ipv6 without options uses a 70-byte header
+	GeneveIPv6MTUOverhead = 70
+	// WireGuard uses a 60-byte header over ipv4 and 80-byte over ipv6, the larger one is always used
+	WireGuardMTUOverhead = 80
+
+	// Default timers of bfd session with bgp peer
+	DefaultBFDIntervalMilliseconds = 300
+	DefaultBFDDetectMultiplier     = 3
+
+	minBFDIntervalMilliseconds = 10
+
+	maxGracefulRestartSeconds = 65535
+
+	minMTU     = 576
+	minIPv6MTU = 1280
+	maxMTU     = 65535
+)
+
+var (
+	minBandwidth = resource.MustParse("1k")
+	maxBandwidth = resource.MustParse("1P")
+
+	// well-known bgp communities defined by RFC 1997, RFC 3765 and RFC 7999
+	wellKnownBGPCommunities = map[string]uint32{
+		"no-export":           0xFFFFFF01,
+		"no-advertise":        0xFFFFFF02,
+		"no-export-subconfed": 0xFFFFFF03,
+		"no-peer":             0xFFFFFF04,
+		"blackhole":           0xFFFF029A,
+	}
 )
 
 // TODO: unit tests
@@ -35,6 +78,14 @@ func IsPrivateSubnet(subnet *Subnet) bool {
 	return *subnet.Spec.Config.Private
 }
 
+func IsLoadBalancerSubnet(subnet *Subnet) bool {
+	if subnet == nil || subnet.Spec.Config == nil || subnet.Spec.Config.LoadBalancer == nil {
+		return false
+	}
+
+	return *subnet.Spec.Config.LoadBalancer
+}
+
 func IsIPv6Subnet(subnet *Subnet) bool {
 	if subnet == nil {
 		return false
@@ -85,6 +136,16 @@ func IsIPv6IPInstance(ip *IPInstance) bool {
 	return false
 }
 
+// IsStatefulIPInstance returns true if ip instance is owned by a stateful workload rather than a pod,
+// whose ip may be retained and reused by the recreated pod.
+func IsStatefulIPInstance(ip *IPInstance) bool {
+	if ip == nil {
+		return false
+	}
+	owner := metav1.GetControllerOf(ip)
+	return owner != nil && owner.Kind != "Pod"
+}
+
 func ValidateAddressRange(ar *AddressRange) (err error) {
 	var (
 		isIPv6   bool
@@ -162,6 +223,211 @@ func ValidateAddressRange(ar *AddressRange) (err error) {
 	return nil
 }
 
+// ValidateSubnetRoutes checks if routes are valid for subnet with the address range, gateways
+// are only allowed when they are directly reachable from subnet
+func ValidateSubnetRoutes(routes []SubnetRoute, ar *AddressRange, allowGateway bool) error {
+	_, cidr, err := net.ParseCIDR(ar.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid range CIDR %s", ar.CIDR)
+	}
+	isIPv6 := ar.Version == IPv6
+
+	for _, route := range routes {
+		_, dst, err := net.ParseCIDR(route.Dst)
+		if err != nil {
+			return fmt.Errorf("invalid route destination %s", route.Dst)
+		}
+		if dstIsIPv6 := dst.IP.To4() == nil; dstIsIPv6 != isIPv6 {
+			return fmt.Errorf("address families of ip version and route destination %s mismatch", route.Dst)
+		}
+
+		if len(route.Gateway) == 0 {
+			continue
+		}
+		if !allowGateway {
+			return fmt.Errorf("route gateway is not supported for route %s", route.Dst)
+		}
+		gateway := net.ParseIP(route.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("invalid route gateway %s", route.Gateway)
+		}
+		if !cidr.Contains(gateway) {
+			return fmt.Errorf("route gateway %s is not in CIDR %s", route.Gateway, ar.CIDR)
+		}
+	}
+
+	return nil
+}
+
+func ValidateDNSConfig(dns *DNSConfig) error {
+	if dns == nil {
+		return nil
+	}
+
+	for _, nameserver := range dns.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return fmt.Errorf("invalid nameserver %s", nameserver)
+		}
+	}
+	return nil
+}
+
+func GetSubnetRoutes(subnetSpec *SubnetSpec) []SubnetRoute {
+	if subnetSpec == nil || subnetSpec.Config == nil {
+		return nil
+	}
+
+	return subnetSpec.Config.Routes
+}
+
+func GetSubnetDNSConfig(subnetSpec *SubnetSpec) *DNSConfig {
+	if subnetSpec == nil || subnetSpec.Config == nil {
+		return nil
+	}
+
+	return subnetSpec.Config.DNS
+}
+
+func GetSubnetBandwidth(subnetSpec *SubnetSpec) (ingress, egress string) {
+	if subnetSpec == nil || subnetSpec.Config == nil {
+		return "", ""
+	}
+
+	return subnetSpec.Config.IngressBandwidth, subnetSpec.Config.EgressBandwidth
+}
+
+// ParseBandwidth parses bandwidth in the format of kubernetes.io/ingress-bandwidth annotation,
+// and returns the value in bits per second.
+func ParseBandwidth(bandwidth string) (uint64, error) {
+	quantity, err := resource.ParseQuantity(bandwidth)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %s: %v", bandwidth, err)
+	}
+
+	if quantity.Cmp(minBandwidth) < 0 {
+		return 0, fmt.Errorf("bandwidth %s is too small, must be at least %s", bandwidth, minBandwidth.String())
+	}
+	if quantity.Cmp(maxBandwidth) > 0 {
+		return 0, fmt.Errorf("bandwidth %s is too large, must be at most %s", bandwidth, maxBandwidth.String())
+	}
+
+	return uint64(quantity.Value()), nil
+}
+
+func ValidateSubnetBandwidth(subnetSpec *SubnetSpec) error {
+	ingress, egress := GetSubnetBandwidth(subnetSpec)
+	for _, bandwidth := range []string{ingress, egress} {
+		if len(bandwidth) == 0 {
+			continue
+		}
+		if _, err := ParseBandwidth(bandwidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMTU returns the mtu of pods in subnet, mtu of subnet takes precedence over the one of network.
+// Zero means mtu is not specified.
+func GetMTU(network *Network, subnet *Subnet) int {
+	if subnet != nil && subnet.Spec.Config != nil && subnet.Spec.Config.MTU != nil {
+		return int(*subnet.Spec.Config.MTU)
+	}
+
+	if network != nil && network.Spec.MTU != nil {
+		return int(*network.Spec.MTU)
+	}
+
+	return 0
+}
+
+// GetOuterVlanID returns the outer S-tag of network, nil means the network is not double tagged.
+func GetOuterVlanID(network *Network) *int32 {
+	if network == nil || network.Spec.Config == nil {
+		return nil
+	}
+	return network.Spec.Config.OuterVlanID
+}
+
+// GetVlanUplinkInterfaces returns the prefer string of vlan uplink interfaces specified in network.
+func GetVlanUplinkInterfaces(network *Network) string {
+	if network == nil || network.Spec.Config == nil {
+		return ""
+	}
+	return network.Spec.Config.VlanUplinkInterfaces
+}
+
+// IsWireGuardEnabled returns true if traffic of network between nodes is encrypted by wireguard.
+func IsWireGuardEnabled(network *Network) bool {
+	return network != nil && network.Spec.Config != nil &&
+		network.Spec.Config.Encryption == EncryptionModeWireGuard
+}
+
+// IsDirectRoutingEnabled returns true if overlay traffic of network is routed directly between
+// nodes in the same subnet.
+func IsDirectRoutingEnabled(network *Network) bool {
+	return network != nil && network.Spec.Config != nil && network.Spec.Config.DirectRouting
+}
+
+// GetMTUOverhead returns the encapsulation overhead of network mode, which should
+// be subtracted from the mtu of node interface. Encapsulation over ipv6 underlay
+// costs more than the one over ipv4.
+func GetMTUOverhead(mode NetworkMode, ipv6Underlay bool) int {
+	switch mode {
+	case NetworkModeVxlan:
+		if ipv6Underlay {
+			return VxlanIPv6MTUOverhead
+		}
+		return VxlanMTUOverhead
+	case NetworkModeGeneve:
+		if ipv6Underlay {
+			return GeneveIPv6MTUOverhead
+		}
+		return GeneveMTUOverhead
+	}
+	return 0
+}
+
+// ValidateVlanConfig validates the uplink interfaces and outer vlan id of network,
+// which are only supported by vlan network.
+func ValidateVlanConfig(config *NetworkConfig, mode NetworkMode) error {
+	if config == nil || (len(config.VlanUplinkInterfaces) == 0 && config.OuterVlanID == nil) {
+		return nil
+	}
+
+	if mode != NetworkModeVlan {
+		return fmt.Errorf("vlan uplink interfaces and outer vlan id are only supported by vlan network")
+	}
+
+	if config.OuterVlanID != nil && (*config.OuterVlanID < 1 || *config.OuterVlanID > 4094) {
+		return fmt.Errorf("outer vlan id %d is out of range [1, 4094]", *config.OuterVlanID)
+	}
+	return nil
+}
+
+// ValidateMTU validates the mtu of pods, overlay packets encrypted by wireguard are
+// encapsulated again and will carry the overhead of both.
+func ValidateMTU(mtu *int32, mode NetworkMode, isIPv6, wireGuardEnabled bool) error {
+	if mtu == nil {
+		return nil
+	}
+
+	lowerBound := minMTU
+	if isIPv6 {
+		lowerBound = minIPv6MTU
+	}
+	// vtep ip family is decided on each node, so the overhead over ipv6 is always considered
+	upperBound := maxMTU - GetMTUOverhead(mode, true)
+	if wireGuardEnabled {
+		upperBound -= WireGuardMTUOverhead
+	}
+
+	if int(*mtu) < lowerBound || int(*mtu) > upperBound {
+		return fmt.Errorf("mtu %d is out of range [%d, %d]", *mtu, lowerBound, upperBound)
+	}
+	return nil
+}
+
 func IsSubnetAutoNatOutgoing(subnetSpec *SubnetSpec) bool {
 	if subnetSpec == nil || subnetSpec.Config == nil || subnetSpec.Config.AutoNatOutgoing == nil {
 		return true
@@ -238,3 +504,220 @@ func ipToInt(ip net.IP) *big.Int {
 func intToIP(i *big.Int) net.IP {
 	return net.IP(i.Bytes())
 }
+
+// ValidateEncryptionConfig validates the encryption of network, which is only supported by overlay network.
+func ValidateEncryptionConfig(config *NetworkConfig, networkType NetworkType) error {
+	if config == nil || len(config.Encryption) == 0 {
+		return nil
+	}
+
+	if config.Encryption != EncryptionModeWireGuard {
+		return fmt.Errorf("unknown encryption mode %s", config.Encryption)
+	}
+
+	if networkType != NetworkTypeOverlay {
+		return fmt.Errorf("encryption is only supported by overlay network")
+	}
+	return nil
+}
+
+// ValidateDirectRoutingConfig validates the direct routing of network, which is only supported by
+// overlay network without encryption.
+func ValidateDirectRoutingConfig(config *NetworkConfig, networkType NetworkType) error {
+	if config == nil || !config.DirectRouting {
+		return nil
+	}
+
+	if networkType != NetworkTypeOverlay {
+		return fmt.Errorf("direct routing is only supported by overlay network")
+	}
+
+	if len(config.Encryption) != 0 {
+		return fmt.Errorf("direct routing can not be enabled with encryption")
+	}
+	return nil
+}
+
+// IsUnnumberedBGPPeer checks if the bgp peer is reached through the bgp interface of node rather than address.
+func IsUnnumberedBGPPeer(peer *BGPPeer) bool {
+	return peer != nil && peer.Unnumbered
+}
+
+// ValidateBGPPeer validates the bgp peer, which must be either specified by address or unnumbered.
+func ValidateBGPPeer(peer *BGPPeer) error {
+	switch {
+	case IsUnnumberedBGPPeer(peer):
+		if len(peer.Address) != 0 {
+			return fmt.Errorf("bgp peer address %v must not be specified for unnumbered peer", peer.Address)
+		}
+		if peer.BFD != nil {
+			return fmt.Errorf("bfd is not supported by unnumbered bgp peer")
+		}
+	case net.ParseIP(peer.Address) == nil:
+		return fmt.Errorf("invalid bgp peer ip address %v", peer.Address)
+	}
+
+	if peer.GracefulRestartSeconds < 0 || peer.GracefulRestartSeconds > maxGracefulRestartSeconds {
+		return fmt.Errorf("bgp peer graceful restart seconds %v is out of range [0, %v]",
+			peer.GracefulRestartSeconds, maxGracefulRestartSeconds)
+	}
+
+	return ValidateBFDConfig(peer.BFD)
+}
+
+// ValidateBFDConfig validates the bfd config of bgp peer.
+func ValidateBFDConfig(config *BFDConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.DesiredMinTxInterval != 0 && config.DesiredMinTxInterval < minBFDIntervalMilliseconds {
+		return fmt.Errorf("bfd desired min tx interval %vms is less than %vms, 0 means the default %vms",
+			config.DesiredMinTxInterval, minBFDIntervalMilliseconds, DefaultBFDIntervalMilliseconds)
+	}
+
+	if config.RequiredMinRxInterval != 0 && config.RequiredMinRxInterval < minBFDIntervalMilliseconds {
+		return fmt.Errorf("bfd required min rx interval %vms is less than %vms, 0 means the default %vms",
+			config.RequiredMinRxInterval, minBFDIntervalMilliseconds, DefaultBFDIntervalMilliseconds)
+	}
+
+	if config.DetectMultiplier < 0 || config.DetectMultiplier > 255 {
+		return fmt.Errorf("bfd detect multiplier %v is out of range [1, 255], 0 means the default %v",
+			config.DetectMultiplier, DefaultBFDDetectMultiplier)
+	}
+	return nil
+}
+
+// ValidateBGPImportPrefixes validates the prefixes to import bgp routes, which are only supported by bgp network.
+func ValidateBGPImportPrefixes(config *NetworkConfig, mode NetworkMode) error {
+	if config == nil || len(config.BGPImportPrefixes) == 0 {
+		return nil
+	}
+
+	if mode != NetworkModeBGP {
+		return fmt.Errorf("bgp import prefixes are only supported by bgp network")
+	}
+
+	for _, prefix := range config.BGPImportPrefixes {
+		ip, cidr, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid bgp import prefix %v: %v", prefix, err)
+		}
+
+		if !ip.Equal(cidr.IP) {
+			return fmt.Errorf("bgp import prefix %v is not a network address, should be %v", prefix, cidr.String())
+		}
+	}
+	return nil
+}
+
+// GetBGPServiceAdvertisement returns the service ips advertised by bgp network, nil means nothing is advertised.
+func GetBGPServiceAdvertisement(network *Network) *BGPServiceAdvertisement {
+	if network == nil || network.Spec.Config == nil || GetNetworkMode(network) != NetworkModeBGP {
+		return nil
+	}
+	return network.Spec.Config.BGPServiceAdvertisement
+}
+
+// ValidateBGPServiceAdvertisement validates the service advertisement, which is only supported by bgp network.
+func ValidateBGPServiceAdvertisement(config *NetworkConfig, mode NetworkMode) error {
+	if config == nil || config.BGPServiceAdvertisement == nil {
+		return nil
+	}
+
+	if mode != NetworkModeBGP {
+		return fmt.Errorf("service advertisement is only supported by bgp network")
+	}
+	return nil
+}
+
+// ParseBGPCommunities parses comma separated bgp communities in the format of "ASN:VALUE" or
+// well-known names, e.g., "65000:100,no-export".
+func ParseBGPCommunities(communities string) ([]uint32, error) {
+	return ParseBGPCommunityList(strings.Split(communities, ","))
+}
+
+// ParseBGPCommunityList parses bgp communities in the format of "ASN:VALUE" or well-known names,
+// empty ones are ignored.
+func ParseBGPCommunityList(communities []string) ([]uint32, error) {
+	var result []uint32
+	for _, community := range communities {
+		community = strings.TrimSpace(community)
+		if len(community) == 0 {
+			continue
+		}
+
+		if value, exist := wellKnownBGPCommunities[strings.ToLower(community)]; exist {
+			result = append(result, value)
+			continue
+		}
+
+		parts := strings.Split(community, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bgp community %s, should be in the format of ASN:VALUE", community)
+		}
+
+		asn, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid asn of bgp community %s: %v", community, err)
+		}
+
+		value, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of bgp community %s: %v", community, err)
+		}
+
+		result = append(result, uint32(asn)<<16|uint32(value))
+	}
+	return result, nil
+}
+
+// GetBGPPathConfig returns the bgp path config of subnet, fields not specified by subnet are
+// inherited from network.
+func GetBGPPathConfig(network *Network, subnet *Subnet) *BGPPathConfig {
+	result := &BGPPathConfig{}
+	if network != nil && network.Spec.Config != nil && network.Spec.Config.BGPPath != nil {
+		*result = *network.Spec.Config.BGPPath
+	}
+
+	if subnet == nil || subnet.Spec.Config == nil || subnet.Spec.Config.BGPPath == nil {
+		return result
+	}
+
+	subnetConfig := subnet.Spec.Config.BGPPath
+	if len(subnetConfig.Communities) != 0 {
+		result.Communities = subnetConfig.Communities
+	}
+	if subnetConfig.LocalPreference != nil {
+		result.LocalPreference = subnetConfig.LocalPreference
+	}
+	if subnetConfig.AggregateOnly != nil {
+		result.AggregateOnly = subnetConfig.AggregateOnly
+	}
+	return result
+}
+
+// IsBGPAggregateOnly checks whether only the aggregate subnet prefix should be advertised.
+func IsBGPAggregateOnly(config *BGPPathConfig) bool {
+	return config != nil && config.AggregateOnly != nil && *config.AggregateOnly
+}
+
+// ValidateBGPPathConfig validates the bgp path config, which is only supported by bgp network.
+func ValidateBGPPathConfig(config *BGPPathConfig, mode NetworkMode) error {
+	if config == nil {
+		return nil
+	}
+
+	if mode != NetworkModeBGP {
+		return fmt.Errorf("bgp path config is only supported by bgp network")
+	}
+
+	if _, err := ParseBGPCommunityList(config.Communities); err != nil {
+		return err
+	}
+
+	if config.LocalPreference != nil && *config.LocalPreference < 0 {
+		return fmt.Errorf("bgp local preference %d must not be negative", *config.LocalPreference)
+	}
+	return nil
+}
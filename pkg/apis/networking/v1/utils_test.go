@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestValidateAddressRange(t *testing.T) {
@@ -207,6 +208,692 @@ func TestValidateAddressRange(t *testing.T) {
 	}
 }
 
+func TestValidateSubnetRoutes(t *testing.T) {
+	addressRange := &AddressRange{
+		Version: IPv4,
+		CIDR:    "192.168.8.0/24",
+		Gateway: "192.168.8.1",
+	}
+
+	tests := []struct {
+		name         string
+		routes       []SubnetRoute
+		allowGateway bool
+		expectError  error
+	}{
+		{
+			"no routes",
+			nil,
+			true,
+			nil,
+		},
+		{
+			"wrong destination",
+			[]SubnetRoute{
+				{
+					Dst: "10.0.0.0",
+				},
+			},
+			true,
+			fmt.Errorf("invalid route destination 10.0.0.0"),
+		},
+		{
+			"destination family mismatch",
+			[]SubnetRoute{
+				{
+					Dst: "fe80::/64",
+				},
+			},
+			true,
+			fmt.Errorf("address families of ip version and route destination fe80::/64 mismatch"),
+		},
+		{
+			"gateway not allowed",
+			[]SubnetRoute{
+				{
+					Dst:     "10.0.0.0/8",
+					Gateway: "192.168.8.254",
+				},
+			},
+			false,
+			fmt.Errorf("route gateway is not supported for route 10.0.0.0/8"),
+		},
+		{
+			"wrong gateway",
+			[]SubnetRoute{
+				{
+					Dst:     "10.0.0.0/8",
+					Gateway: "192.168.8",
+				},
+			},
+			true,
+			fmt.Errorf("invalid route gateway 192.168.8"),
+		},
+		{
+			"gateway out of cidr",
+			[]SubnetRoute{
+				{
+					Dst:     "10.0.0.0/8",
+					Gateway: "192.168.9.1",
+				},
+			},
+			true,
+			fmt.Errorf("route gateway 192.168.9.1 is not in CIDR 192.168.8.0/24"),
+		},
+		{
+			"normal routes",
+			[]SubnetRoute{
+				{
+					Dst:     "10.0.0.0/8",
+					Gateway: "192.168.8.254",
+				},
+				{
+					Dst: "172.16.0.0/12",
+				},
+			},
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateSubnetRoutes(test.routes, addressRange, test.allowGateway)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		name        string
+		bandwidth   string
+		expectValue uint64
+		expectError bool
+	}{
+		{
+			"mega bits",
+			"10M",
+			10000000,
+			false,
+		},
+		{
+			"binary unit",
+			"1Ki",
+			1024,
+			false,
+		},
+		{
+			"invalid format",
+			"10Mbps",
+			0,
+			true,
+		},
+		{
+			"too small",
+			"100",
+			0,
+			true,
+		},
+		{
+			"too large",
+			"2P",
+			0,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := ParseBandwidth(test.bandwidth)
+			if test.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectValue, value)
+		})
+	}
+}
+
+func TestGetMTUOverhead(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         NetworkMode
+		ipv6Underlay bool
+		expected     int
+	}{
+		{
+			"vlan",
+			NetworkModeVlan,
+			false,
+			0,
+		},
+		{
+			"bgp",
+			NetworkModeBGP,
+			true,
+			0,
+		},
+		{
+			"vxlan over ipv4",
+			NetworkModeVxlan,
+			false,
+			VxlanMTUOverhead,
+		},
+		{
+			"vxlan over ipv6",
+			NetworkModeVxlan,
+			true,
+			VxlanIPv6MTUOverhead,
+		},
+		{
+			"geneve over ipv4",
+			NetworkModeGeneve,
+			false,
+			GeneveMTUOverhead,
+		},
+		{
+			"geneve over ipv6",
+			NetworkModeGeneve,
+			true,
+			GeneveIPv6MTUOverhead,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, GetMTUOverhead(test.mode, test.ipv6Underlay))
+		})
+	}
+}
+
+func TestValidateMTU(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+
+	tests := []struct {
+		name             string
+		mtu              *int32
+		mode             NetworkMode
+		isIPv6           bool
+		wireGuardEnabled bool
+		expectError      error
+	}{
+		{
+			"not specified",
+			nil,
+			NetworkModeVlan,
+			false,
+			false,
+			nil,
+		},
+		{
+			"jumbo frame",
+			int32Ptr(9000),
+			NetworkModeVlan,
+			false,
+			false,
+			nil,
+		},
+		{
+			"too small",
+			int32Ptr(500),
+			NetworkModeVlan,
+			false,
+			false,
+			fmt.Errorf("mtu 500 is out of range [576, 65535]"),
+		},
+		{
+			"too small for ipv6",
+			int32Ptr(1000),
+			NetworkModeBGP,
+			true,
+			false,
+			fmt.Errorf("mtu 1000 is out of range [1280, 65535]"),
+		},
+		{
+			"too large for vxlan",
+			int32Ptr(65535),
+			NetworkModeVxlan,
+			false,
+			false,
+			fmt.Errorf("mtu 65535 is out of range [576, 65465]"),
+		},
+		{
+			"too large for geneve",
+			int32Ptr(65535),
+			NetworkModeGeneve,
+			false,
+			false,
+			fmt.Errorf("mtu 65535 is out of range [576, 65465]"),
+		},
+		{
+			"too large for vxlan with wireguard",
+			int32Ptr(65465),
+			NetworkModeVxlan,
+			false,
+			true,
+			fmt.Errorf("mtu 65465 is out of range [576, 65385]"),
+		},
+		{
+			"jumbo frame for vxlan with wireguard",
+			int32Ptr(9000),
+			NetworkModeVxlan,
+			false,
+			true,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMTU(test.mtu, test.mode, test.isIPv6, test.wireGuardEnabled)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateVlanConfig(t *testing.T) {
+	int32Ptr := func(i int32) *int32 { return &i }
+
+	tests := []struct {
+		name        string
+		config      *NetworkConfig
+		mode        NetworkMode
+		expectError error
+	}{
+		{
+			"not specified",
+			nil,
+			NetworkModeVxlan,
+			nil,
+		},
+		{
+			"qinq with uplink",
+			&NetworkConfig{VlanUplinkInterfaces: "bond1,eth1", OuterVlanID: int32Ptr(100)},
+			NetworkModeVlan,
+			nil,
+		},
+		{
+			"outer vlan id out of range",
+			&NetworkConfig{OuterVlanID: int32Ptr(4095)},
+			NetworkModeVlan,
+			fmt.Errorf("outer vlan id 4095 is out of range [1, 4094]"),
+		},
+		{
+			"uplink for bgp network",
+			&NetworkConfig{VlanUplinkInterfaces: "bond1"},
+			NetworkModeBGP,
+			fmt.Errorf("vlan uplink interfaces and outer vlan id are only supported by vlan network"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateVlanConfig(test.config, test.mode)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateEncryptionConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *NetworkConfig
+		networkType NetworkType
+		expectError error
+	}{
+		{
+			"not specified",
+			&NetworkConfig{},
+			NetworkTypeUnderlay,
+			nil,
+		},
+		{
+			"wireguard for overlay network",
+			&NetworkConfig{Encryption: EncryptionModeWireGuard},
+			NetworkTypeOverlay,
+			nil,
+		},
+		{
+			"wireguard for underlay network",
+			&NetworkConfig{Encryption: EncryptionModeWireGuard},
+			NetworkTypeUnderlay,
+			fmt.Errorf("encryption is only supported by overlay network"),
+		},
+		{
+			"unknown encryption",
+			&NetworkConfig{Encryption: "IPSec"},
+			NetworkTypeOverlay,
+			fmt.Errorf("unknown encryption mode IPSec"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateEncryptionConfig(test.config, test.networkType)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateDirectRoutingConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *NetworkConfig
+		networkType NetworkType
+		expectError error
+	}{
+		{
+			"not specified",
+			nil,
+			NetworkTypeUnderlay,
+			nil,
+		},
+		{
+			"direct routing for overlay network",
+			&NetworkConfig{DirectRouting: true},
+			NetworkTypeOverlay,
+			nil,
+		},
+		{
+			"direct routing for underlay network",
+			&NetworkConfig{DirectRouting: true},
+			NetworkTypeUnderlay,
+			fmt.Errorf("direct routing is only supported by overlay network"),
+		},
+		{
+			"direct routing with encryption",
+			&NetworkConfig{DirectRouting: true, Encryption: EncryptionModeWireGuard},
+			NetworkTypeOverlay,
+			fmt.Errorf("direct routing can not be enabled with encryption"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateDirectRoutingConfig(test.config, test.networkType)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateBFDConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *BFDConfig
+		expectError error
+	}{
+		{
+			"not specified",
+			nil,
+			nil,
+		},
+		{
+			"default timers",
+			&BFDConfig{},
+			nil,
+		},
+		{
+			"valid timers",
+			&BFDConfig{DesiredMinTxInterval: 100, RequiredMinRxInterval: 200, DetectMultiplier: 5},
+			nil,
+		},
+		{
+			"too small tx interval",
+			&BFDConfig{DesiredMinTxInterval: 5},
+			fmt.Errorf("bfd desired min tx interval 5ms is less than 10ms, 0 means the default 300ms"),
+		},
+		{
+			"too small rx interval",
+			&BFDConfig{RequiredMinRxInterval: 1},
+			fmt.Errorf("bfd required min rx interval 1ms is less than 10ms, 0 means the default 300ms"),
+		},
+		{
+			"too large detect multiplier",
+			&BFDConfig{DetectMultiplier: 256},
+			fmt.Errorf("bfd detect multiplier 256 is out of range [1, 255], 0 means the default 3"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBFDConfig(test.config)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateBGPPeer(t *testing.T) {
+	tests := []struct {
+		name        string
+		peer        *BGPPeer
+		expectError error
+	}{
+		{
+			"peer with address",
+			&BGPPeer{ASN: 65000, Address: "192.168.0.1", BFD: &BFDConfig{}},
+			nil,
+		},
+		{
+			"unnumbered peer",
+			&BGPPeer{ASN: 65000, Unnumbered: true},
+			nil,
+		},
+		{
+			"neither address nor unnumbered",
+			&BGPPeer{ASN: 65000},
+			fmt.Errorf("invalid bgp peer ip address "),
+		},
+		{
+			"unnumbered peer with address",
+			&BGPPeer{ASN: 65000, Address: "192.168.0.1", Unnumbered: true},
+			fmt.Errorf("bgp peer address 192.168.0.1 must not be specified for unnumbered peer"),
+		},
+		{
+			"invalid address",
+			&BGPPeer{ASN: 65000, Address: "192.168.0"},
+			fmt.Errorf("invalid bgp peer ip address 192.168.0"),
+		},
+		{
+			"unnumbered peer with bfd",
+			&BGPPeer{ASN: 65000, Unnumbered: true, BFD: &BFDConfig{}},
+			fmt.Errorf("bfd is not supported by unnumbered bgp peer"),
+		},
+		{
+			"max graceful restart seconds",
+			&BGPPeer{ASN: 65000, Address: "192.168.0.1", GracefulRestartSeconds: 65535},
+			nil,
+		},
+		{
+			"too large graceful restart seconds",
+			&BGPPeer{ASN: 65000, Address: "192.168.0.1", GracefulRestartSeconds: 65536},
+			fmt.Errorf("bgp peer graceful restart seconds 65536 is out of range [0, 65535]"),
+		},
+		{
+			"negative graceful restart seconds",
+			&BGPPeer{ASN: 65000, Unnumbered: true, GracefulRestartSeconds: -1},
+			fmt.Errorf("bgp peer graceful restart seconds -1 is out of range [0, 65535]"),
+		},
+		{
+			"invalid bfd config",
+			&BGPPeer{ASN: 65000, Address: "192.168.0.1", BFD: &BFDConfig{DetectMultiplier: 256}},
+			fmt.Errorf("bfd detect multiplier 256 is out of range [1, 255], 0 means the default 3"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBGPPeer(test.peer)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateBGPImportPrefixes(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *NetworkConfig
+		mode        NetworkMode
+		expectError error
+	}{
+		{
+			"not specified",
+			nil,
+			NetworkModeVlan,
+			nil,
+		},
+		{
+			"valid prefixes for bgp network",
+			&NetworkConfig{BGPImportPrefixes: []string{"10.0.0.0/8", "fd00::/64"}},
+			NetworkModeBGP,
+			nil,
+		},
+		{
+			"prefixes for vlan network",
+			&NetworkConfig{BGPImportPrefixes: []string{"10.0.0.0/8"}},
+			NetworkModeVlan,
+			fmt.Errorf("bgp import prefixes are only supported by bgp network"),
+		},
+		{
+			"invalid prefix",
+			&NetworkConfig{BGPImportPrefixes: []string{"10.0.0.0"}},
+			NetworkModeBGP,
+			fmt.Errorf("invalid bgp import prefix 10.0.0.0: invalid CIDR address: 10.0.0.0"),
+		},
+		{
+			"prefix with host bits",
+			&NetworkConfig{BGPImportPrefixes: []string{"10.1.2.3/16"}},
+			NetworkModeBGP,
+			fmt.Errorf("bgp import prefix 10.1.2.3/16 is not a network address, should be 10.1.0.0/16"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBGPImportPrefixes(test.config, test.mode)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestValidateBGPServiceAdvertisement(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *NetworkConfig
+		mode        NetworkMode
+		expectError error
+	}{
+		{
+			"not specified",
+			&NetworkConfig{},
+			NetworkModeVxlan,
+			nil,
+		},
+		{
+			"advertisement for bgp network",
+			&NetworkConfig{BGPServiceAdvertisement: &BGPServiceAdvertisement{ClusterIPs: true}},
+			NetworkModeBGP,
+			nil,
+		},
+		{
+			"advertisement for vxlan network",
+			&NetworkConfig{BGPServiceAdvertisement: &BGPServiceAdvertisement{LoadBalancerIPs: true}},
+			NetworkModeVxlan,
+			fmt.Errorf("service advertisement is only supported by bgp network"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBGPServiceAdvertisement(test.config, test.mode)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
+
+func TestParseBGPCommunities(t *testing.T) {
+	tests := []struct {
+		name        string
+		communities string
+		expected    []uint32
+		expectError bool
+	}{
+		{
+			"empty",
+			"",
+			nil,
+			false,
+		},
+		{
+			"standard communities",
+			"65000:100, 1:2",
+			[]uint32{65000<<16 | 100, 1<<16 | 2},
+			false,
+		},
+		{
+			"well-known community",
+			"no-export,65000:100",
+			[]uint32{0xFFFFFF01, 65000<<16 | 100},
+			false,
+		},
+		{
+			"missing value",
+			"65000",
+			nil,
+			true,
+		},
+		{
+			"asn out of range",
+			"65536:100",
+			nil,
+			true,
+		},
+		{
+			"invalid value",
+			"65000:abc",
+			nil,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			communities, err := ParseBGPCommunities(test.communities)
+			if test.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, communities)
+		})
+	}
+}
+
 func TestCalculateCapacity(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -314,3 +1001,165 @@ func TestGetNetworkType(t *testing.T) {
 		})
 	}
 }
+
+func TestIsStatefulIPInstance(t *testing.T) {
+	isController := true
+	newIPInstance := func(kind string, controller *bool) *IPInstance {
+		return &IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						Kind:       kind,
+						Name:       "owner",
+						Controller: controller,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		ipInstance *IPInstance
+		stateful   bool
+	}{
+		{
+			"nil",
+			nil,
+			false,
+		},
+		{
+			"no owner",
+			&IPInstance{},
+			false,
+		},
+		{
+			"owned by pod",
+			newIPInstance("Pod", &isController),
+			false,
+		},
+		{
+			"owned by statefulset",
+			newIPInstance("StatefulSet", &isController),
+			true,
+		},
+		{
+			"not controlled by statefulset",
+			newIPInstance("StatefulSet", nil),
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.stateful, IsStatefulIPInstance(test.ipInstance))
+		})
+	}
+}
+
+func TestGetBGPPathConfig(t *testing.T) {
+	localPreference := int32(200)
+	subnetLocalPreference := int32(300)
+	aggregateOnly := true
+
+	tests := []struct {
+		name     string
+		network  *Network
+		subnet   *Subnet
+		expected *BGPPathConfig
+	}{
+		{
+			"not specified",
+			&Network{},
+			&Subnet{},
+			&BGPPathConfig{},
+		},
+		{
+			"inherited from network",
+			&Network{Spec: NetworkSpec{Config: &NetworkConfig{BGPPath: &BGPPathConfig{
+				Communities:     []string{"65000:100"},
+				LocalPreference: &localPreference,
+			}}}},
+			&Subnet{Spec: SubnetSpec{Config: &SubnetConfig{BGPPath: &BGPPathConfig{
+				AggregateOnly: &aggregateOnly,
+			}}}},
+			&BGPPathConfig{
+				Communities:     []string{"65000:100"},
+				LocalPreference: &localPreference,
+				AggregateOnly:   &aggregateOnly,
+			},
+		},
+		{
+			"overridden by subnet",
+			&Network{Spec: NetworkSpec{Config: &NetworkConfig{BGPPath: &BGPPathConfig{
+				Communities:     []string{"65000:100"},
+				LocalPreference: &localPreference,
+			}}}},
+			&Subnet{Spec: SubnetSpec{Config: &SubnetConfig{BGPPath: &BGPPathConfig{
+				Communities:     []string{"65000:200"},
+				LocalPreference: &subnetLocalPreference,
+			}}}},
+			&BGPPathConfig{
+				Communities:     []string{"65000:200"},
+				LocalPreference: &subnetLocalPreference,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, GetBGPPathConfig(test.network, test.subnet))
+		})
+	}
+}
+
+func TestValidateBGPPathConfig(t *testing.T) {
+	localPreference := int32(100)
+	negativeLocalPreference := int32(-1)
+
+	tests := []struct {
+		name        string
+		config      *BGPPathConfig
+		mode        NetworkMode
+		expectError error
+	}{
+		{
+			"not specified",
+			nil,
+			NetworkModeVlan,
+			nil,
+		},
+		{
+			"valid config",
+			&BGPPathConfig{Communities: []string{"65000:100", "no-export"}, LocalPreference: &localPreference},
+			NetworkModeBGP,
+			nil,
+		},
+		{
+			"config for vlan network",
+			&BGPPathConfig{Communities: []string{"65000:100"}},
+			NetworkModeVlan,
+			fmt.Errorf("bgp path config is only supported by bgp network"),
+		},
+		{
+			"invalid community",
+			&BGPPathConfig{Communities: []string{"65000"}},
+			NetworkModeBGP,
+			fmt.Errorf("invalid bgp community 65000, should be in the format of ASN:VALUE"),
+		},
+		{
+			"negative local preference",
+			&BGPPathConfig{LocalPreference: &negativeLocalPreference},
+			NetworkModeBGP,
+			fmt.Errorf("bgp local preference -1 must not be negative"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBGPPathConfig(test.config, test.mode)
+			if test.expectError == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectError.Error())
+			}
+		})
+	}
+}
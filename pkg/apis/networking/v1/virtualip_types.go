@@ -0,0 +1,79 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VirtualIPSpec defines the desired state of VirtualIP
+type VirtualIPSpec struct {
+	// Subnet is the subnet which the virtual ip is allocated from.
+	// +kubebuilder:validation:Required
+	Subnet string `json:"subnet"`
+	// Address is the expected virtual ip, it will be allocated automatically if empty.
+	// +kubebuilder:validation:Optional
+	Address string `json:"address,omitempty"`
+	// Selector selects pods in the same namespace, the virtual ip will be bound to
+	// one of the ready pods selected.
+	// +kubebuilder:validation:Required
+	Selector *metav1.LabelSelector `json:"selector"`
+}
+
+// VirtualIPStatus defines the observed state of VirtualIP
+type VirtualIPStatus struct {
+	// +kubebuilder:validation:Optional
+	Network string `json:"network,omitempty"`
+	// +kubebuilder:validation:Optional
+	Version IPVersion `json:"version,omitempty"`
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// +kubebuilder:validation:Optional
+	PodName string `json:"podName,omitempty"`
+	// +kubebuilder:validation:Optional
+	NodeName string `json:"nodeName,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.ip`
+// +kubebuilder:printcolumn:name="PodName",type=string,JSONPath=`.status.podName`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.nodeName`
+// +kubebuilder:printcolumn:name="Subnet",type=string,JSONPath=`.spec.subnet`
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.status.network`
+
+// VirtualIP is the Schema for the virtualips API
+type VirtualIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualIPSpec   `json:"spec,omitempty"`
+	Status VirtualIPStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VirtualIPList contains a list of VirtualIP
+type VirtualIPList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VirtualIP `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VirtualIP{}, &VirtualIPList{})
+}
@@ -22,13 +22,34 @@ const (
 	AnnotationIPFamily = "networking.alibaba.com/ip-family"
 
 	AnnotationIPRetain = "networking.alibaba.com/ip-retain"
+	// AnnotationIPConflict is set on an ip instance by daemon if its ip is found in use by another host
+	// on underlay network, the value is the hw addr of the conflicting host
+	AnnotationIPConflict = "networking.alibaba.com/ip-conflict"
 
 	AnnotationSpecifiedNetwork = "networking.alibaba.com/specified-network"
 	AnnotationSpecifiedSubnet  = "networking.alibaba.com/specified-subnet"
+	AnnotationSpecifiedIP      = "networking.alibaba.com/specified-ip"
 
 	AnnotationNetworkType = "networking.alibaba.com/network-type"
 
+	AnnotationIngressBandwidth = "kubernetes.io/ingress-bandwidth"
+	AnnotationEgressBandwidth  = "kubernetes.io/egress-bandwidth"
+
 	AnnotationNodeVtepIP           = "networking.alibaba.com/vtep-ip"
 	AnnotationNodeVtepMac          = "networking.alibaba.com/vtep-mac"
 	AnnotationNodeLocalVxlanIPList = "networking.alibaba.com/local-vxlan-ip-list"
+	// AnnotationNodeSecondaryVtepIP is the vtep ip in the other address family of a dual-stack node,
+	// which will be used by the nodes whose vtep ips are not in the same family with this node
+	AnnotationNodeSecondaryVtepIP = "networking.alibaba.com/secondary-vtep-ip"
+	// AnnotationNodeWireGuardPublicKey is the base64 encoded public key of wireguard device on node,
+	// it exists only if the overlay network is encrypted by wireguard
+	AnnotationNodeWireGuardPublicKey = "networking.alibaba.com/wireguard-public-key"
+
+	// AnnotationNodeVlanUplinkInterfaces specifies uplink interfaces of vlan networks on node,
+	// the value is a json map from network name to prefer string, e.g., {"storage":"bond1"}
+	AnnotationNodeVlanUplinkInterfaces = "networking.alibaba.com/vlan-uplink-interfaces"
+
+	// AnnotationBGPCommunities specifies the communities attached to bgp paths of service ips,
+	// e.g., "65000:100,no-export"
+	AnnotationBGPCommunities = "networking.alibaba.com/bgp-communities"
 )
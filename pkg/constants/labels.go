@@ -33,6 +33,10 @@ const (
 
 	LabelNetworkType = "networking.alibaba.com/network-type"
 
+	// LabelIPConflict is set on the ip reservations created for conflicting ips, which will
+	// expire after a while and make the ips allocatable again
+	LabelIPConflict = "networking.alibaba.com/ip-conflict"
+
 	LabelUnderlayNetworkAttachment = "networking.alibaba.com/underlay-network-attachment"
 	LabelOverlayNetworkAttachment  = "networking.alibaba.com/overlay-network-attachment"
 )
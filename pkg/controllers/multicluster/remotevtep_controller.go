@@ -125,8 +125,10 @@ func (r *RemoteVtepReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		remoteVTEP.Spec.ClusterName = r.ClusterName
 		remoteVTEP.Spec.NodeName = req.Name
 		remoteVTEP.Spec.VTEPInfo = multiclusterv1.VTEPInfo{
-			IP:  vtepIP,
-			MAC: vtepMac,
+			IP:                 vtepIP,
+			MAC:                vtepMac,
+			SecondaryIP:        node.Annotations[constants.AnnotationNodeSecondaryVtepIP],
+			WireGuardPublicKey: node.Annotations[constants.AnnotationNodeWireGuardPublicKey],
 		}
 		remoteVTEP.Spec.EndpointIPList = endpointIPList
 		return nil
@@ -206,6 +208,8 @@ func (r *RemoteVtepReconciler) SetupWithManager(mgr ctrl.Manager) (err error) {
 						constants.AnnotationNodeVtepIP,
 						constants.AnnotationNodeVtepMac,
 						constants.AnnotationNodeLocalVxlanIPList,
+						constants.AnnotationNodeSecondaryVtepIP,
+						constants.AnnotationNodeWireGuardPublicKey,
 					},
 				},
 			),
@@ -0,0 +1,358 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ControllerAnycastIP = "AnycastIP"
+
+const (
+	ReasonAnycastIPAllocationSucceed = "AnycastIPAllocationSucceed"
+	ReasonAnycastIPFail              = "AnycastIPFail"
+)
+
+// AnycastIPReconciler reconciles a AnycastIP object
+type AnycastIPReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	IPAMManager IPAMManager
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=anycastips,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=anycastips/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=anycastips/finalizers,verbs=update
+
+func (r *AnycastIPReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	var anycastIP = &networkingv1.AnycastIP{}
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+			if len(anycastIP.UID) > 0 {
+				r.Recorder.Event(anycastIP, corev1.EventTypeWarning, ReasonAnycastIPFail, err.Error())
+			}
+		}
+	}()
+
+	if err = r.Get(ctx, req.NamespacedName, anycastIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch AnycastIP", client.IgnoreNotFound(err))
+	}
+
+	if !anycastIP.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, wrapError("unable to release anycast ip", r.release(ctx, anycastIP))
+	}
+
+	if err = r.addFinalizer(ctx, anycastIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to add finalizer", err)
+	}
+
+	if len(anycastIP.Status.IP) == 0 {
+		if err = r.allocate(ctx, anycastIP); err != nil {
+			return ctrl.Result{}, wrapError("unable to allocate anycast ip", err)
+		}
+	}
+
+	return ctrl.Result{}, wrapError("unable to update pods of anycast ip", r.updatePods(ctx, anycastIP))
+}
+
+// allocate will allocate an address from the specified subnet for anycast ip, the anycast ip
+// is the only owner of address in IPAM, pods configured with it never take it up
+func (r *AnycastIPReconciler) allocate(ctx context.Context, anycastIP *networkingv1.AnycastIP) (err error) {
+	subnet, err := utils.GetSubnet(r, anycastIP.Spec.Subnet)
+	if err != nil {
+		return fmt.Errorf("unable to get subnet %s: %v", anycastIP.Spec.Subnet, err)
+	}
+
+	var (
+		networkName  = subnet.Spec.Network
+		ownerName    = transform.AnycastIPOwnerName(anycastIP.Name)
+		ipFamilyMode = utils.ToIPFamilyMode(networkingv1.IsIPv6Subnet(subnet))
+		ip           *types.IP
+	)
+
+	var specifiedIP string
+	if len(anycastIP.Spec.Address) > 0 {
+		if parsedIP := net.ParseIP(anycastIP.Spec.Address); parsedIP != nil {
+			specifiedIP = parsedIP.String()
+		} else {
+			return fmt.Errorf("invalid address %s", anycastIP.Spec.Address)
+		}
+	}
+
+	if feature.DualStackEnabled() {
+		var ips []*types.IP
+		if len(specifiedIP) > 0 {
+			ips, err = r.IPAMManager.DualStack().Assign(ipFamilyMode, networkName, []string{subnet.Name}, []string{specifiedIP},
+				ownerName, anycastIP.Namespace, false)
+		} else {
+			ips, err = r.IPAMManager.DualStack().Allocate(ipFamilyMode, networkName, []string{subnet.Name}, ownerName, anycastIP.Namespace)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to allocate %s ip: %v", ipFamilyMode, err)
+		}
+		ip = ips[0]
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(ips), squashIPSliceToIPs(ips))
+			}
+		}()
+	} else {
+		if len(specifiedIP) > 0 {
+			ip, err = r.IPAMManager.Assign(networkName, subnet.Name, ownerName, anycastIP.Namespace, specifiedIP, false)
+		} else {
+			ip, err = r.IPAMManager.Allocate(networkName, subnet.Name, ownerName, anycastIP.Namespace)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to allocate ip: %v", err)
+		}
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			}
+		}()
+	}
+
+	if err = r.patchLabels(ctx, anycastIP, map[string]string{
+		constants.LabelSubnet:  ip.Subnet,
+		constants.LabelNetwork: ip.Network,
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	var version = networkingv1.IPv4
+	if ip.IsIPv6() {
+		version = networkingv1.IPv6
+	}
+
+	if err = r.patchStatus(ctx, anycastIP, func(status *networkingv1.AnycastIPStatus) {
+		status.Network = ip.Network
+		status.Version = version
+		status.IP = ip.Address.IP.String()
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	r.Recorder.Eventf(anycastIP, corev1.EventTypeNormal, ReasonAnycastIPAllocationSucceed, "allocate IP %s successfully", ip.Address.IP.String())
+	return nil
+}
+
+// updatePods will record all the running pods selected and their readiness in status, which
+// the daemons of nodes configure and advertise the anycast ip according to
+func (r *AnycastIPReconciler) updatePods(ctx context.Context, anycastIP *networkingv1.AnycastIP) (err error) {
+	selector, err := metav1.LabelSelectorAsSelector(anycastIP.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %v", err)
+	}
+
+	podList := &corev1.PodList{}
+	if err = r.List(ctx, podList, client.InNamespace(anycastIP.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return fmt.Errorf("unable to list pods: %v", err)
+	}
+
+	var (
+		pods      []networkingv1.AnycastIPPod
+		readyPods int32
+	)
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !r.configurable(pod, anycastIP.Status.Network) {
+			continue
+		}
+
+		ready := utils.PodIsReady(pod)
+		if ready {
+			readyPods++
+		}
+		pods = append(pods, networkingv1.AnycastIPPod{
+			Name:     pod.Name,
+			NodeName: pod.Spec.NodeName,
+			Ready:    ready,
+		})
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+
+	if reflect.DeepEqual(pods, anycastIP.Status.Pods) && readyPods == anycastIP.Status.ReadyPods {
+		return nil
+	}
+
+	return r.patchStatus(ctx, anycastIP, func(status *networkingv1.AnycastIPStatus) {
+		status.Pods = pods
+		status.ReadyPods = readyPods
+	})
+}
+
+// configurable checks whether pod is running in the network and can be configured with an anycast ip
+func (r *AnycastIPReconciler) configurable(pod *corev1.Pod, networkName string) bool {
+	if pod.DeletionTimestamp != nil || pod.Spec.HostNetwork || pod.Status.Phase != corev1.PodRunning ||
+		len(pod.Spec.NodeName) == 0 || !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		return false
+	}
+
+	ips, err := utils.ListAllocatedIPInstancesOfPod(r, pod)
+	if err != nil {
+		return false
+	}
+
+	for _, ip := range ips {
+		if ip.Spec.Network == networkName {
+			return true
+		}
+	}
+	return false
+}
+
+// release will recycle the address of anycast ip and remove the finalizer
+func (r *AnycastIPReconciler) release(ctx context.Context, anycastIP *networkingv1.AnycastIP) (err error) {
+	if !controllerutil.ContainsFinalizer(anycastIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	if len(anycastIP.Status.IP) > 0 {
+		if feature.DualStackEnabled() {
+			err = r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(anycastIP.Status.Version == networkingv1.IPv6),
+				anycastIP.Status.Network,
+				[]string{
+					anycastIP.Spec.Subnet,
+				},
+				[]string{
+					anycastIP.Status.IP,
+				},
+			)
+		} else {
+			err = r.IPAMManager.Release(anycastIP.Status.Network, anycastIP.Spec.Subnet, anycastIP.Status.IP)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to release ip %s: %v", anycastIP.Status.IP, err)
+		}
+	}
+
+	patch := client.MergeFrom(anycastIP.DeepCopy())
+	controllerutil.RemoveFinalizer(anycastIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, anycastIP, patch)
+	})
+}
+
+func (r *AnycastIPReconciler) addFinalizer(ctx context.Context, anycastIP *networkingv1.AnycastIP) error {
+	if controllerutil.ContainsFinalizer(anycastIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	patch := client.MergeFrom(anycastIP.DeepCopy())
+	controllerutil.AddFinalizer(anycastIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, anycastIP, patch)
+	})
+}
+
+func (r *AnycastIPReconciler) patchLabels(ctx context.Context, anycastIP *networkingv1.AnycastIP, labels map[string]string) error {
+	patch := client.MergeFrom(anycastIP.DeepCopy())
+	if anycastIP.Labels == nil {
+		anycastIP.Labels = map[string]string{}
+	}
+	for key, value := range labels {
+		anycastIP.Labels[key] = value
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, anycastIP, patch)
+	})
+}
+
+// patchStatus will patch status of anycast ip with the mutation
+func (r *AnycastIPReconciler) patchStatus(ctx context.Context, anycastIP *networkingv1.AnycastIP, mutate func(status *networkingv1.AnycastIPStatus)) error {
+	patch := client.MergeFrom(anycastIP.DeepCopy())
+	mutate(&anycastIP.Status)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Status().Patch(ctx, anycastIP, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AnycastIPReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerAnycastIP).
+		For(&networkingv1.AnycastIP{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		Watches(&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				// selectors of anycast ips may match pod with labels before or after updating,
+				// so all the anycast ips in namespace will be checked
+				anycastIPList, err := utils.ListAnycastIPs(r, client.InNamespace(object.GetNamespace()))
+				if err != nil {
+					return nil
+				}
+
+				var requests []reconcile.Request
+				for i := range anycastIPList.Items {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: apitypes.NamespacedName{
+							Namespace: anycastIPList.Items[i].Namespace,
+							Name:      anycastIPList.Items[i].Name,
+						},
+					})
+				}
+				return requests
+			}),
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
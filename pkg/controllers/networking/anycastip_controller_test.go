@@ -0,0 +1,148 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestAnycastPod(name, nodeName string, phase corev1.PodPhase, ready bool) *corev1.Pod {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      map[string]string{"app": "dns"},
+			Annotations: map[string]string{constants.AnnotationIP: "192.168.0.10"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodReady,
+					Status: readyStatus,
+				},
+			},
+		},
+	}
+}
+
+func newTestPodIPInstance(podName, networkName string) *networkingv1.IPInstance {
+	return &networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      podName + "-ip",
+		},
+		Spec: networkingv1.IPInstanceSpec{
+			Network: networkName,
+		},
+		Status: networkingv1.IPInstanceStatus{
+			PodName: podName,
+		},
+	}
+}
+
+func TestUpdatePods(t *testing.T) {
+	hostNetworkPod := newTestAnycastPod("dns-hostnetwork", "node1", corev1.PodRunning, true)
+	hostNetworkPod.Spec.HostNetwork = true
+
+	noIPPod := newTestAnycastPod("dns-noip", "node1", corev1.PodRunning, true)
+	noIPPod.Annotations = nil
+
+	unselectedPod := newTestAnycastPod("other", "node1", corev1.PodRunning, true)
+	unselectedPod.Labels = nil
+
+	objects := []client.Object{
+		&networkingv1.AnycastIP{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "dns",
+			},
+			Spec: networkingv1.AnycastIPSpec{
+				Subnet:   "subnet1",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "dns"}},
+			},
+			Status: networkingv1.AnycastIPStatus{
+				Network: "network1",
+				IP:      "192.168.0.53",
+				Pods: []networkingv1.AnycastIPPod{
+					{Name: "dns-gone", NodeName: "node3", Ready: true},
+				},
+				ReadyPods: 1,
+			},
+		},
+		newTestAnycastPod("dns-b", "node2", corev1.PodRunning, false),
+		newTestAnycastPod("dns-a", "node1", corev1.PodRunning, true),
+		newTestAnycastPod("dns-pending", "node1", corev1.PodPending, false),
+		newTestAnycastPod("dns-other-network", "node1", corev1.PodRunning, true),
+		hostNetworkPod,
+		noIPPod,
+		unselectedPod,
+		newTestPodIPInstance("dns-a", "network1"),
+		newTestPodIPInstance("dns-b", "network1"),
+		newTestPodIPInstance("dns-pending", "network1"),
+		newTestPodIPInstance("dns-other-network", "network2"),
+		newTestPodIPInstance("dns-noip", "network1"),
+		newTestPodIPInstance("other", "network1"),
+	}
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	r := &AnycastIPReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+	}
+
+	anycastIP := &networkingv1.AnycastIP{}
+	assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "dns"}, anycastIP))
+	assert.NoError(t, r.updatePods(context.Background(), anycastIP))
+
+	updated := &networkingv1.AnycastIP{}
+	assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "dns"}, updated))
+	assert.Equal(t, []networkingv1.AnycastIPPod{
+		{Name: "dns-a", NodeName: "node1", Ready: true},
+		{Name: "dns-b", NodeName: "node2", Ready: false},
+	}, updated.Status.Pods)
+	assert.Equal(t, int32(1), updated.Status.ReadyPods)
+	assert.Equal(t, "192.168.0.53", updated.Status.IP)
+
+	// nothing changes if pods are up-to-date
+	resourceVersion := updated.ResourceVersion
+	assert.NoError(t, r.updatePods(context.Background(), updated))
+	assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "dns"}, updated))
+	assert.Equal(t, resourceVersion, updated.ResourceVersion)
+}
@@ -106,6 +106,67 @@ func IPSetGetter(c client.Reader) allocator.IPSetGetter {
 			ip := &ipList.Items[i]
 			ipSet.Add(utils.ToIPFormat(ip.Name), transform.TransferIPInstanceForIPAM(ip))
 		}
+
+		// virtual ips take up addresses of subnet as well
+		virtualIPList, err := utils.ListVirtualIPs(c, client.MatchingLabels{
+			constants.LabelSubnet: subnetName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range virtualIPList.Items {
+			virtualIP := &virtualIPList.Items[i]
+			if len(virtualIP.Status.IP) > 0 {
+				ipSet.Add(virtualIP.Status.IP, transform.TransferVirtualIPForIPAM(virtualIP))
+			}
+		}
+
+		// load balancer ips of services as well
+		loadBalancerIPList, err := utils.ListLoadBalancerIPs(c, client.MatchingLabels{
+			constants.LabelSubnet: subnetName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range loadBalancerIPList.Items {
+			loadBalancerIP := &loadBalancerIPList.Items[i]
+			if len(loadBalancerIP.Status.IP) > 0 {
+				ipSet.Add(loadBalancerIP.Status.IP, transform.TransferLoadBalancerIPForIPAM(loadBalancerIP))
+			}
+		}
+
+		// anycast ips as well
+		anycastIPList, err := utils.ListAnycastIPs(c, client.MatchingLabels{
+			constants.LabelSubnet: subnetName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range anycastIPList.Items {
+			anycastIP := &anycastIPList.Items[i]
+			if len(anycastIP.Status.IP) > 0 {
+				ipSet.Add(anycastIP.Status.IP, transform.TransferAnycastIPForIPAM(anycastIP))
+			}
+		}
+
+		// reserved ips are never allocated to anyone else
+		ipReservationList, err := utils.ListIPReservations(c, client.MatchingLabels{
+			constants.LabelSubnet: subnetName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range ipReservationList.Items {
+			ipReservation := &ipReservationList.Items[i]
+			if ipReservation.Status.Reserved {
+				ip := transform.TransferIPReservationForIPAM(ipReservation)
+				ipSet.Add(ip.Address.IP.String(), ip)
+			}
+		}
 		return ipSet, nil
 	}
 }
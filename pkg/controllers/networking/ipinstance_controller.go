@@ -18,7 +18,13 @@ package networking
 
 import (
 	"context"
+	"fmt"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -26,6 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
@@ -33,10 +40,14 @@ import (
 
 const ControllerIPInstance = "IPInstance"
 
+const ReasonIPConflict = "IPConflict"
+
 // IPInstanceReconciler reconciles a IPInstance object
 type IPInstanceReconciler struct {
 	client.Client
 
+	Recorder record.EventRecorder
+
 	// TODO: construct
 	IPAMManager IPAMManager
 	IPAMStore   IPAMStore
@@ -47,6 +58,8 @@ type IPInstanceReconciler struct {
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipinstances,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipinstances/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipinstances/finalizers,verbs=update
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
 
 func (r *IPInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrllog.FromContext(ctx)
@@ -58,16 +71,93 @@ func (r *IPInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// retained ips of stateful pods are never decoupled or reserved for conflicts
+	_, conflicted := ip.Annotations[constants.AnnotationIPConflict]
+	conflicted = conflicted && !networkingv1.IsStatefulIPInstance(&ip)
+
 	if !ip.DeletionTimestamp.IsZero() {
+		// conflicting ip should not be allocated again until its reservation expires
+		if conflicted {
+			if err = r.reserveConflictIP(ctx, &ip); err != nil {
+				log.Error(err, "unable to reserve conflicting ip")
+				return ctrl.Result{}, err
+			}
+		}
+
 		if err = r.releaseIP(&ip); err != nil {
 			log.Error(err, "unable to release IPInstance")
 			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, nil
+	}
+
+	if conflicted {
+		if err = r.decoupleConflictIP(ctx, &ip); err != nil {
+			log.Error(err, "unable to decouple conflicting ip")
+			return ctrl.Result{}, err
+		}
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// decoupleConflictIP unbinds all the ip instances of the pod whose ip is found in use by another host,
+// so that a different ip will be allocated for pod.
+func (r *IPInstanceReconciler) decoupleConflictIP(ctx context.Context, ipInstance *networkingv1.IPInstance) (err error) {
+	pod := &corev1.Pod{}
+	if err = r.Get(ctx, types.NamespacedName{Namespace: ipInstance.Namespace, Name: ipInstance.Status.PodName}, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return client.IgnoreNotFound(r.Delete(ctx, ipInstance))
+		}
+		return fmt.Errorf("unable to get pod %s/%s: %v", ipInstance.Namespace, ipInstance.Status.PodName, err)
+	}
+
+	if feature.DualStackEnabled() {
+		err = r.IPAMStore.DualStack().DeCouple(pod)
+	} else {
+		err = r.IPAMStore.DeCouple(pod)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to decouple ips for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+
+	r.Recorder.Eventf(pod, corev1.EventTypeWarning, ReasonIPConflict,
+		"ip %s conflicts with hw addr %s, a different ip will be allocated",
+		utils.ToIPFormat(ipInstance.Name), ipInstance.Annotations[constants.AnnotationIPConflict])
+	return nil
+}
+
+// reserveConflictIP creates an ip reservation for the conflicting ip of ip instance, which will be
+// deleted by IPReservation controller after ConflictIPReservationTTL.
+func (r *IPInstanceReconciler) reserveConflictIP(ctx context.Context, ipInstance *networkingv1.IPInstance) error {
+	var name = "ip-conflict-" + ipInstance.Name
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, &networkingv1.IPReservation{}); err == nil {
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get ip reservation %s: %v", name, err)
+	}
+
+	ipReservation := &networkingv1.IPReservation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constants.LabelIPConflict: "true",
+			},
+		},
+		Spec: networkingv1.IPReservationSpec{
+			Subnet: ipInstance.Spec.Subnet,
+			IP:     utils.ToIPFormat(ipInstance.Name),
+			Owner:  "conflict with " + ipInstance.Annotations[constants.AnnotationIPConflict],
+			Description: fmt.Sprintf("ip of pod %s/%s is found in use by another host",
+				ipInstance.Namespace, ipInstance.Status.PodName),
+		},
+	}
+	if err := r.Create(ctx, ipReservation); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to create ip reservation %s: %v", name, err)
+	}
+	return nil
+}
+
 func (r *IPInstanceReconciler) releaseIP(ipInstance *networkingv1.IPInstance) (err error) {
 	if feature.DualStackEnabled() {
 		if err = r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(networkingv1.IsIPv6IPInstance(ipInstance)),
@@ -0,0 +1,274 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ControllerIPReservation = "IPReservation"
+
+// ConflictIPReservationTTL is how long the ip reservation of a conflicting ip lives.
+const ConflictIPReservationTTL = 24 * time.Hour
+
+const (
+	ReasonIPReservationSucceed = "IPReservationSucceed"
+	ReasonIPReservationFail    = "IPReservationFail"
+)
+
+// IPReservationReconciler reconciles a IPReservation object
+type IPReservationReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	IPAMManager IPAMManager
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=ipreservations/finalizers,verbs=update
+
+func (r *IPReservationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	var ipReservation = &networkingv1.IPReservation{}
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+			if len(ipReservation.UID) > 0 {
+				r.Recorder.Event(ipReservation, corev1.EventTypeWarning, ReasonIPReservationFail, err.Error())
+			}
+		}
+	}()
+
+	if err = r.Get(ctx, req.NamespacedName, ipReservation); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch IPReservation", client.IgnoreNotFound(err))
+	}
+
+	if !ipReservation.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, wrapError("unable to release reserved ip", r.release(ctx, ipReservation))
+	}
+
+	if err = r.addFinalizer(ctx, ipReservation); err != nil {
+		return ctrl.Result{}, wrapError("unable to add finalizer", err)
+	}
+
+	if !ipReservation.Status.Reserved {
+		if err = r.reserve(ctx, ipReservation); err != nil {
+			return ctrl.Result{}, wrapError("unable to reserve ip", err)
+		}
+	}
+
+	return r.expire(ctx, ipReservation)
+}
+
+// expire deletes the ip reservation of a conflicting ip once it has existed for ConflictIPReservationTTL,
+// then the ip can be allocated and probed again in case the conflict has been resolved.
+func (r *IPReservationReconciler) expire(ctx context.Context, ipReservation *networkingv1.IPReservation) (ctrl.Result, error) {
+	if _, exist := ipReservation.Labels[constants.LabelIPConflict]; !exist {
+		return ctrl.Result{}, nil
+	}
+
+	if remaining := time.Until(ipReservation.CreationTimestamp.Add(ConflictIPReservationTTL)); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	return ctrl.Result{}, wrapError("unable to delete expired ip reservation", client.IgnoreNotFound(r.Delete(ctx, ipReservation)))
+}
+
+// reserve will take up the specified ip in IPAM
+func (r *IPReservationReconciler) reserve(ctx context.Context, ipReservation *networkingv1.IPReservation) (err error) {
+	subnet, err := utils.GetSubnet(r, ipReservation.Spec.Subnet)
+	if err != nil {
+		return fmt.Errorf("unable to get subnet %s: %v", ipReservation.Spec.Subnet, err)
+	}
+
+	parsedIP := net.ParseIP(ipReservation.Spec.IP)
+	if parsedIP == nil {
+		return fmt.Errorf("invalid ip %s", ipReservation.Spec.IP)
+	}
+
+	var (
+		networkName  = subnet.Spec.Network
+		ownerName    = transform.IPReservationOwnerName(ipReservation.Name)
+		ipFamilyMode = utils.ToIPFamilyMode(networkingv1.IsIPv6Subnet(subnet))
+		reservedIP   = parsedIP.String()
+		ip           *types.IP
+	)
+
+	if feature.DualStackEnabled() {
+		var ips []*types.IP
+		if ips, err = r.IPAMManager.DualStack().Assign(ipFamilyMode, networkName, []string{subnet.Name}, []string{reservedIP},
+			ownerName, "", false); err != nil {
+			return r.conflictError(reservedIP, subnet.Name, err)
+		}
+		ip = ips[0]
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(ips), squashIPSliceToIPs(ips))
+			}
+		}()
+	} else {
+		if ip, err = r.IPAMManager.Assign(networkName, subnet.Name, ownerName, "", reservedIP, false); err != nil {
+			return r.conflictError(reservedIP, subnet.Name, err)
+		}
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			}
+		}()
+	}
+
+	labelPatch := client.MergeFrom(ipReservation.DeepCopy())
+	if ipReservation.Labels == nil {
+		ipReservation.Labels = map[string]string{}
+	}
+	ipReservation.Labels[constants.LabelSubnet] = ip.Subnet
+	ipReservation.Labels[constants.LabelNetwork] = ip.Network
+	if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, ipReservation, labelPatch)
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	var version = networkingv1.IPv4
+	if ip.IsIPv6() {
+		version = networkingv1.IPv6
+	}
+
+	statusPatch := client.MergeFrom(ipReservation.DeepCopy())
+	ipReservation.Status.Network = ip.Network
+	ipReservation.Status.Version = version
+	ipReservation.Status.Reserved = true
+	if err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Status().Patch(ctx, ipReservation, statusPatch)
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	r.Recorder.Eventf(ipReservation, corev1.EventTypeNormal, ReasonIPReservationSucceed, "reserve IP %s successfully", reservedIP)
+	return nil
+}
+
+// conflictError figures out who is using the ip if it can not be reserved
+func (r *IPReservationReconciler) conflictError(ip, subnetName string, err error) error {
+	if !errors.Is(err, types.ErrNotAvailableAssignedIP) {
+		return fmt.Errorf("unable to reserve ip %s: %v", ip, err)
+	}
+
+	ipInstanceList, listErr := utils.ListIPInstances(r, client.MatchingLabels{
+		constants.LabelSubnet: subnetName,
+	})
+	if listErr != nil {
+		return fmt.Errorf("ip %s is unavailable: %v", ip, err)
+	}
+
+	for i := range ipInstanceList.Items {
+		ipInstance := &ipInstanceList.Items[i]
+		if utils.ToIPFormat(ipInstance.Name) == ip {
+			return fmt.Errorf("ip %s is in use by pod %s/%s: %v", ip, ipInstance.Namespace,
+				ipInstance.Status.PodName, err)
+		}
+	}
+	return fmt.Errorf("ip %s is unavailable, it may be reserved or in use by others: %v", ip, err)
+}
+
+// release will recycle the reserved ip and remove the finalizer
+func (r *IPReservationReconciler) release(ctx context.Context, ipReservation *networkingv1.IPReservation) (err error) {
+	if !controllerutil.ContainsFinalizer(ipReservation, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	if ipReservation.Status.Reserved {
+		reservedIP := net.ParseIP(ipReservation.Spec.IP).String()
+		if feature.DualStackEnabled() {
+			err = r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(ipReservation.Status.Version == networkingv1.IPv6),
+				ipReservation.Status.Network,
+				[]string{
+					ipReservation.Spec.Subnet,
+				},
+				[]string{
+					reservedIP,
+				},
+			)
+		} else {
+			err = r.IPAMManager.Release(ipReservation.Status.Network, ipReservation.Spec.Subnet, reservedIP)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to release ip %s: %v", reservedIP, err)
+		}
+	}
+
+	patch := client.MergeFrom(ipReservation.DeepCopy())
+	controllerutil.RemoveFinalizer(ipReservation, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, ipReservation, patch)
+	})
+}
+
+func (r *IPReservationReconciler) addFinalizer(ctx context.Context, ipReservation *networkingv1.IPReservation) error {
+	if controllerutil.ContainsFinalizer(ipReservation, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	patch := client.MergeFrom(ipReservation.DeepCopy())
+	controllerutil.AddFinalizer(ipReservation, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, ipReservation, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPReservationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerIPReservation).
+		For(&networkingv1.IPReservation{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,236 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestIPReservation(ip string) *networkingv1.IPReservation {
+	return &networkingv1.IPReservation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "reserved",
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: networkingv1.IPReservationSpec{
+			Subnet: "subnet1",
+			IP:     ip,
+		},
+	}
+}
+
+func newTestIPReservationReconciler(t *testing.T, objects ...client.Object) *IPReservationReconciler {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	objects = append(objects,
+		&networkingv1.Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "network1"},
+			Spec: networkingv1.NetworkSpec{
+				Type: networkingv1.NetworkTypeUnderlay,
+			},
+		},
+		&networkingv1.Subnet{
+			ObjectMeta: metav1.ObjectMeta{Name: "subnet1"},
+			Spec: networkingv1.SubnetSpec{
+				Network: "network1",
+				Range: networkingv1.AddressRange{
+					Version: networkingv1.IPv4,
+					CIDR:    "192.168.0.0/24",
+					Gateway: "192.168.0.1",
+				},
+			},
+		},
+		&networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "192-168-0-10",
+				Labels:    map[string]string{constants.LabelSubnet: "subnet1"},
+			},
+			Spec: networkingv1.IPInstanceSpec{
+				Network: "network1",
+				Subnet:  "subnet1",
+				Address: networkingv1.Address{
+					Version: networkingv1.IPv4,
+					IP:      "192.168.0.10/24",
+					Gateway: "192.168.0.1",
+				},
+			},
+			Status: networkingv1.IPInstanceStatus{
+				PodName:      "pod1",
+				PodNamespace: "default",
+			},
+		},
+	)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	ipamManager, err := NewIPAMManager(c)
+	assert.NoError(t, err)
+
+	return &IPReservationReconciler{
+		Client:      c,
+		Recorder:    record.NewFakeRecorder(10),
+		IPAMManager: ipamManager,
+	}
+}
+
+func TestIPReservationReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		reserved bool
+		errMsg   string
+	}{
+		{
+			name:     "free ip",
+			ip:       "192.168.0.20",
+			reserved: true,
+		},
+		{
+			name:     "ip allocated to pod",
+			ip:       "192.168.0.10",
+			reserved: false,
+			errMsg:   "ip 192.168.0.10 is in use by pod default/pod1",
+		},
+		{
+			name:     "ip out of subnet",
+			ip:       "192.168.1.20",
+			reserved: false,
+			errMsg:   "unable to reserve ip 192.168.1.20",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestIPReservationReconciler(t, newTestIPReservation(test.ip))
+
+			key := apitypes.NamespacedName{Name: "reserved"}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+			if len(test.errMsg) > 0 {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.errMsg)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+
+			ipReservation := &networkingv1.IPReservation{}
+			assert.NoError(t, r.Get(context.Background(), key, ipReservation))
+			assert.Equal(t, test.reserved, ipReservation.Status.Reserved)
+			assert.True(t, controllerutil.ContainsFinalizer(ipReservation, constants.FinalizerIPAllocated))
+			if test.reserved {
+				assert.Equal(t, "network1", ipReservation.Status.Network)
+				assert.Equal(t, networkingv1.IPv4, ipReservation.Status.Version)
+				assert.Equal(t, "subnet1", ipReservation.Labels[constants.LabelSubnet])
+				assert.Equal(t, "network1", ipReservation.Labels[constants.LabelNetwork])
+			}
+		})
+	}
+}
+
+func TestIPReservationRelease(t *testing.T) {
+	r := newTestIPReservationReconciler(t, newTestIPReservation("192.168.0.20"))
+
+	key := apitypes.NamespacedName{Name: "reserved"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.NoError(t, err)
+
+	// reserved ip can not be allocated to others
+	_, err = r.IPAMManager.Assign("network1", "subnet1", "pod2", "default", "192.168.0.20", false)
+	assert.Error(t, err)
+
+	ipReservation := &networkingv1.IPReservation{}
+	assert.NoError(t, r.Get(context.Background(), key, ipReservation))
+	deletionTime := metav1.Now()
+	ipReservation.DeletionTimestamp = &deletionTime
+	assert.NoError(t, r.release(context.Background(), ipReservation))
+
+	assert.NoError(t, r.Get(context.Background(), key, ipReservation))
+	assert.False(t, controllerutil.ContainsFinalizer(ipReservation, constants.FinalizerIPAllocated))
+
+	_, err = r.IPAMManager.Assign("network1", "subnet1", "pod2", "default", "192.168.0.20", false)
+	assert.NoError(t, err)
+}
+
+func TestIPReservationExpire(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict bool
+		age      time.Duration
+		deleted  bool
+	}{
+		{
+			name:     "reservation without conflict never expires",
+			conflict: false,
+			age:      2 * ConflictIPReservationTTL,
+			deleted:  false,
+		},
+		{
+			name:     "conflict reservation before ttl",
+			conflict: true,
+			age:      time.Hour,
+			deleted:  false,
+		},
+		{
+			name:     "conflict reservation after ttl",
+			conflict: true,
+			age:      ConflictIPReservationTTL + time.Hour,
+			deleted:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipReservation := newTestIPReservation("192.168.0.20")
+			ipReservation.CreationTimestamp = metav1.NewTime(time.Now().Add(-test.age))
+			if test.conflict {
+				ipReservation.Labels = map[string]string{constants.LabelIPConflict: "pod1"}
+			}
+			r := newTestIPReservationReconciler(t, ipReservation)
+
+			result, err := r.expire(context.Background(), ipReservation)
+			assert.NoError(t, err)
+
+			err = r.Get(context.Background(), apitypes.NamespacedName{Name: "reserved"}, &networkingv1.IPReservation{})
+			assert.Equal(t, test.deleted, errors.IsNotFound(err))
+
+			if test.conflict && !test.deleted {
+				assert.True(t, result.RequeueAfter > 0 && result.RequeueAfter <= ConflictIPReservationTTL-test.age)
+			} else {
+				assert.Zero(t, result.RequeueAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+)
+
+const ControllerLoadBalancer = "LoadBalancer"
+
+const (
+	ReasonLoadBalancerFail = "LoadBalancerFail"
+)
+
+// LoadBalancerReconciler reconciles LoadBalancer type services, one load balancer ip is
+// requested for each ip family of service, and the allocated ones are written back to
+// the load balancer status of service
+type LoadBalancerReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=loadbalancerips,verbs=get;list;watch;create;update;patch;delete
+
+func (r *LoadBalancerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	var service = &corev1.Service{}
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+			if len(service.UID) > 0 {
+				r.Recorder.Event(service, corev1.EventTypeWarning, ReasonLoadBalancerFail, err.Error())
+			}
+		}
+	}()
+
+	if err = r.Get(ctx, req.NamespacedName, service); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch Service", client.IgnoreNotFound(err))
+	}
+
+	// load balancer ips will be deleted along with service by garbage collector
+	if !service.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	loadBalancerIPList, err := utils.ListLoadBalancerIPs(r, client.InNamespace(service.Namespace))
+	if err != nil {
+		return ctrl.Result{}, wrapError("unable to list load balancer ips", err)
+	}
+
+	var (
+		expectedSpecs    = r.expectedLoadBalancerIPSpecs(service)
+		existIPs         = map[networkingv1.IPVersion]*networkingv1.LoadBalancerIP{}
+		obsoleteIPs      = map[string]bool{}
+		allocatedIngress []corev1.LoadBalancerIngress
+	)
+	for i := range loadBalancerIPList.Items {
+		loadBalancerIP := &loadBalancerIPList.Items[i]
+		if loadBalancerIP.Spec.Service != service.Name {
+			continue
+		}
+
+		// load balancer ips of the service with the same name before or with stale spec
+		// should be recycled
+		expectedSpec, expected := expectedSpecs[loadBalancerIP.Spec.Version]
+		if !metav1.IsControlledBy(loadBalancerIP, service) || !expected ||
+			!reflect.DeepEqual(loadBalancerIP.Spec, *expectedSpec) || !loadBalancerIP.DeletionTimestamp.IsZero() {
+			if loadBalancerIP.DeletionTimestamp.IsZero() {
+				if err = r.Delete(ctx, loadBalancerIP); client.IgnoreNotFound(err) != nil {
+					return ctrl.Result{}, wrapError("unable to delete obsolete load balancer ip", err)
+				}
+			}
+			if len(loadBalancerIP.Status.IP) > 0 {
+				obsoleteIPs[loadBalancerIP.Status.IP] = true
+			}
+			continue
+		}
+		existIPs[loadBalancerIP.Spec.Version] = loadBalancerIP
+	}
+
+	for _, version := range []networkingv1.IPVersion{networkingv1.IPv4, networkingv1.IPv6} {
+		expectedSpec, expected := expectedSpecs[version]
+		if !expected {
+			continue
+		}
+
+		loadBalancerIP, exist := existIPs[version]
+		if !exist {
+			if err = r.createLoadBalancerIP(ctx, service, expectedSpec); err != nil {
+				return ctrl.Result{}, wrapError("unable to create load balancer ip", err)
+			}
+			continue
+		}
+
+		if len(loadBalancerIP.Status.IP) > 0 {
+			allocatedIngress = append(allocatedIngress, corev1.LoadBalancerIngress{IP: loadBalancerIP.Status.IP})
+		}
+	}
+
+	return ctrl.Result{}, wrapError("unable to patch load balancer status", r.patchIngress(ctx, service, allocatedIngress, obsoleteIPs))
+}
+
+// expectedLoadBalancerIPSpecs returns the specs of load balancer ips which service should have,
+// indexed by ip versions
+func (r *LoadBalancerReconciler) expectedLoadBalancerIPSpecs(service *corev1.Service) map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec {
+	specs := map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{}
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return specs
+	}
+
+	var versions []networkingv1.IPVersion
+	for _, family := range service.Spec.IPFamilies {
+		switch family {
+		case corev1.IPv4Protocol:
+			versions = append(versions, networkingv1.IPv4)
+		case corev1.IPv6Protocol:
+			if feature.DualStackEnabled() {
+				versions = append(versions, networkingv1.IPv6)
+			}
+		}
+	}
+	if len(versions) == 0 {
+		versions = append(versions, networkingv1.IPv4)
+	}
+
+	for _, version := range versions {
+		specs[version] = &networkingv1.LoadBalancerIPSpec{
+			Service: service.Name,
+			Version: version,
+		}
+	}
+
+	// the ip specified by service is requested in its own family
+	if specifiedIP := net.ParseIP(service.Spec.LoadBalancerIP); specifiedIP != nil {
+		version := networkingv1.IPv4
+		if specifiedIP.To4() == nil {
+			version = networkingv1.IPv6
+		}
+		if spec, exist := specs[version]; exist {
+			spec.Address = specifiedIP.String()
+		}
+	}
+
+	return specs
+}
+
+func (r *LoadBalancerReconciler) createLoadBalancerIP(ctx context.Context, service *corev1.Service, spec *networkingv1.LoadBalancerIPSpec) error {
+	loadBalancerIP := &networkingv1.LoadBalancerIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: service.Namespace,
+			Name:      fmt.Sprintf("%s-v%s", service.Name, spec.Version),
+		},
+		Spec: *spec,
+	}
+
+	if err := controllerutil.SetControllerReference(service, loadBalancerIP, r.Scheme()); err != nil {
+		return err
+	}
+
+	// the obsolete one with the same name might be not released yet
+	if err := r.Create(ctx, loadBalancerIP); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// patchIngress will make ingress of service contain the allocated ips, and remove the obsolete ones
+// allocated by hybridnet, ingress set by others are kept unchanged
+func (r *LoadBalancerReconciler) patchIngress(ctx context.Context, service *corev1.Service,
+	allocatedIngress []corev1.LoadBalancerIngress, obsoleteIPs map[string]bool) error {
+	var managedIPs = map[string]bool{}
+	for ip := range obsoleteIPs {
+		managedIPs[ip] = true
+	}
+	for _, allocated := range allocatedIngress {
+		managedIPs[allocated.IP] = true
+	}
+
+	var ingress = allocatedIngress
+	for _, current := range service.Status.LoadBalancer.Ingress {
+		if !managedIPs[current.IP] {
+			ingress = append(ingress, current)
+		}
+	}
+
+	if reflect.DeepEqual(ingress, service.Status.LoadBalancer.Ingress) ||
+		(len(ingress) == 0 && len(service.Status.LoadBalancer.Ingress) == 0) {
+		return nil
+	}
+
+	patch := client.MergeFrom(service.DeepCopy())
+	service.Status.LoadBalancer.Ingress = ingress
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Status().Patch(ctx, service, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LoadBalancerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerLoadBalancer).
+		For(&corev1.Service{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		Owns(&networkingv1.LoadBalancerIP{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,244 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func newTestLoadBalancerService(serviceType corev1.ServiceType, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "lb",
+			UID:       "lb-uid",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:       serviceType,
+			IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol},
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress},
+		},
+	}
+}
+
+// newTestOwnedLoadBalancerIP returns an ipv4 load balancer ip controlled by service.
+func newTestOwnedLoadBalancerIP(service *corev1.Service, address, ip string) *networkingv1.LoadBalancerIP {
+	return &networkingv1.LoadBalancerIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: service.Namespace,
+			Name:      service.Name + "-v4",
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(service, corev1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: networkingv1.LoadBalancerIPSpec{
+			Service: service.Name,
+			Version: networkingv1.IPv4,
+			Address: address,
+		},
+		Status: networkingv1.LoadBalancerIPStatus{
+			IP: ip,
+		},
+	}
+}
+
+func newTestLoadBalancerReconciler(t *testing.T, objects ...client.Object) *LoadBalancerReconciler {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	return &LoadBalancerReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+}
+
+func TestExpectedLoadBalancerIPSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutate   func(service *corev1.Service)
+		expected map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec
+	}{
+		{
+			name: "not load balancer service",
+			mutate: func(service *corev1.Service) {
+				service.Spec.Type = corev1.ServiceTypeClusterIP
+			},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{},
+		},
+		{
+			name:   "ipv4 service",
+			mutate: func(service *corev1.Service) {},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{
+				networkingv1.IPv4: {Service: "lb", Version: networkingv1.IPv4},
+			},
+		},
+		{
+			name: "service without ip families",
+			mutate: func(service *corev1.Service) {
+				service.Spec.IPFamilies = nil
+			},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{
+				networkingv1.IPv4: {Service: "lb", Version: networkingv1.IPv4},
+			},
+		},
+		{
+			name: "ipv6 family ignored without dual stack",
+			mutate: func(service *corev1.Service) {
+				service.Spec.IPFamilies = []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+			},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{
+				networkingv1.IPv4: {Service: "lb", Version: networkingv1.IPv4},
+			},
+		},
+		{
+			name: "specified load balancer ip",
+			mutate: func(service *corev1.Service) {
+				service.Spec.LoadBalancerIP = "192.168.0.20"
+			},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{
+				networkingv1.IPv4: {Service: "lb", Version: networkingv1.IPv4, Address: "192.168.0.20"},
+			},
+		},
+		{
+			name: "specified load balancer ip of another family",
+			mutate: func(service *corev1.Service) {
+				service.Spec.LoadBalancerIP = "fd00::20"
+			},
+			expected: map[networkingv1.IPVersion]*networkingv1.LoadBalancerIPSpec{
+				networkingv1.IPv4: {Service: "lb", Version: networkingv1.IPv4},
+			},
+		},
+	}
+
+	r := &LoadBalancerReconciler{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestLoadBalancerService(corev1.ServiceTypeLoadBalancer)
+			test.mutate(service)
+			assert.Equal(t, test.expected, r.expectedLoadBalancerIPSpecs(service))
+		})
+	}
+}
+
+func TestLoadBalancerReconcile(t *testing.T) {
+	othersIngress := corev1.LoadBalancerIngress{Hostname: "lb.example.com"}
+
+	tests := []struct {
+		name            string
+		service         *corev1.Service
+		loadBalancerIPs func(service *corev1.Service) []client.Object
+		expectedSpec    *networkingv1.LoadBalancerIPSpec
+		expectedIngress []corev1.LoadBalancerIngress
+	}{
+		{
+			name:    "load balancer ip created",
+			service: newTestLoadBalancerService(corev1.ServiceTypeLoadBalancer, othersIngress),
+			loadBalancerIPs: func(service *corev1.Service) []client.Object {
+				return nil
+			},
+			expectedSpec:    &networkingv1.LoadBalancerIPSpec{Service: "lb", Version: networkingv1.IPv4},
+			expectedIngress: []corev1.LoadBalancerIngress{othersIngress},
+		},
+		{
+			name:    "allocated ip written back",
+			service: newTestLoadBalancerService(corev1.ServiceTypeLoadBalancer, othersIngress),
+			loadBalancerIPs: func(service *corev1.Service) []client.Object {
+				return []client.Object{newTestOwnedLoadBalancerIP(service, "", "192.168.0.10")}
+			},
+			expectedSpec: &networkingv1.LoadBalancerIPSpec{Service: "lb", Version: networkingv1.IPv4},
+			expectedIngress: []corev1.LoadBalancerIngress{
+				{IP: "192.168.0.10"},
+				othersIngress,
+			},
+		},
+		{
+			name: "obsolete ip removed from ingress",
+			service: newTestLoadBalancerService(corev1.ServiceTypeClusterIP,
+				corev1.LoadBalancerIngress{IP: "192.168.0.10"}, othersIngress),
+			loadBalancerIPs: func(service *corev1.Service) []client.Object {
+				return []client.Object{newTestOwnedLoadBalancerIP(service, "", "192.168.0.10")}
+			},
+			expectedSpec:    nil,
+			expectedIngress: []corev1.LoadBalancerIngress{othersIngress},
+		},
+		{
+			name: "load balancer ip of stale spec recycled",
+			service: func() *corev1.Service {
+				service := newTestLoadBalancerService(corev1.ServiceTypeLoadBalancer,
+					corev1.LoadBalancerIngress{IP: "192.168.0.10"})
+				service.Spec.LoadBalancerIP = "192.168.0.20"
+				return service
+			}(),
+			loadBalancerIPs: func(service *corev1.Service) []client.Object {
+				return []client.Object{newTestOwnedLoadBalancerIP(service, "", "192.168.0.10")}
+			},
+			expectedSpec:    &networkingv1.LoadBalancerIPSpec{Service: "lb", Version: networkingv1.IPv4, Address: "192.168.0.20"},
+			expectedIngress: nil,
+		},
+		{
+			name:    "load balancer ip of the same service name before recycled",
+			service: newTestLoadBalancerService(corev1.ServiceTypeLoadBalancer, corev1.LoadBalancerIngress{IP: "192.168.0.10"}),
+			loadBalancerIPs: func(service *corev1.Service) []client.Object {
+				previous := service.DeepCopy()
+				previous.UID = "previous-uid"
+				return []client.Object{newTestOwnedLoadBalancerIP(previous, "", "192.168.0.10")}
+			},
+			expectedSpec:    &networkingv1.LoadBalancerIPSpec{Service: "lb", Version: networkingv1.IPv4},
+			expectedIngress: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestLoadBalancerReconciler(t, append(test.loadBalancerIPs(test.service), test.service)...)
+
+			key := apitypes.NamespacedName{Namespace: "default", Name: "lb"}
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+			assert.NoError(t, err)
+
+			loadBalancerIP := &networkingv1.LoadBalancerIP{}
+			err = r.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "lb-v4"}, loadBalancerIP)
+			if test.expectedSpec == nil {
+				assert.True(t, errors.IsNotFound(err))
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, *test.expectedSpec, loadBalancerIP.Spec)
+				assert.Equal(t, apitypes.UID("lb-uid"), metav1.GetControllerOf(loadBalancerIP).UID)
+			}
+
+			service := &corev1.Service{}
+			assert.NoError(t, r.Get(context.Background(), key, service))
+			assert.Equal(t, test.expectedIngress, service.Status.LoadBalancer.Ingress)
+		})
+	}
+}
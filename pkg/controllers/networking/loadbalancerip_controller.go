@@ -0,0 +1,528 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ControllerLoadBalancerIP = "LoadBalancerIP"
+
+const (
+	ReasonLoadBalancerIPAllocationSucceed = "LoadBalancerIPAllocationSucceed"
+	ReasonLoadBalancerIPFail              = "LoadBalancerIPFail"
+	ReasonLoadBalancerIPElected           = "LoadBalancerIPElected"
+)
+
+// LoadBalancerIPReconciler reconciles a LoadBalancerIP object
+type LoadBalancerIPReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	IPAMManager IPAMManager
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=loadbalancerips,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=loadbalancerips/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=loadbalancerips/finalizers,verbs=update
+
+func (r *LoadBalancerIPReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	var loadBalancerIP = &networkingv1.LoadBalancerIP{}
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+			if len(loadBalancerIP.UID) > 0 {
+				r.Recorder.Event(loadBalancerIP, corev1.EventTypeWarning, ReasonLoadBalancerIPFail, err.Error())
+			}
+		}
+	}()
+
+	if err = r.Get(ctx, req.NamespacedName, loadBalancerIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch LoadBalancerIP", client.IgnoreNotFound(err))
+	}
+
+	if !loadBalancerIP.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, wrapError("unable to release load balancer ip", r.release(ctx, loadBalancerIP))
+	}
+
+	if err = r.addFinalizer(ctx, loadBalancerIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to add finalizer", err)
+	}
+
+	if len(loadBalancerIP.Status.IP) == 0 {
+		if err = r.allocate(ctx, loadBalancerIP); err != nil {
+			return ctrl.Result{}, wrapError("unable to allocate load balancer ip", err)
+		}
+	}
+
+	return ctrl.Result{}, wrapError("unable to elect node for load balancer ip", r.elect(ctx, loadBalancerIP))
+}
+
+// allocate will allocate an address for load balancer ip from the specified subnet, or from
+// the first available load balancer subnet of the same ip family
+func (r *LoadBalancerIPReconciler) allocate(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP) (err error) {
+	isIPv6 := loadBalancerIP.Spec.Version == networkingv1.IPv6
+	if isIPv6 && !feature.DualStackEnabled() {
+		return fmt.Errorf("ipv6 load balancer ip non-supported if dualstack not enabled")
+	}
+
+	var specifiedIP net.IP
+	if len(loadBalancerIP.Spec.Address) > 0 {
+		if specifiedIP = net.ParseIP(loadBalancerIP.Spec.Address); specifiedIP == nil || (specifiedIP.To4() == nil) != isIPv6 {
+			return fmt.Errorf("invalid %s address %s", ipVersionString(loadBalancerIP.Spec.Version), loadBalancerIP.Spec.Address)
+		}
+	}
+
+	subnets, err := r.candidateSubnets(loadBalancerIP, specifiedIP)
+	if err != nil {
+		return err
+	}
+
+	var (
+		ip       *types.IP
+		failures []string
+	)
+	for _, subnet := range subnets {
+		if ip, err = r.allocateFromSubnet(loadBalancerIP, subnet, specifiedIP); err == nil {
+			break
+		}
+		failures = append(failures, fmt.Sprintf("subnet %s: %v", subnet.Name, err))
+	}
+	if ip == nil {
+		return fmt.Errorf("no available load balancer subnet: [%s]", strings.Join(failures, "; "))
+	}
+
+	defer func() {
+		if err != nil {
+			_ = r.releaseIP(ip.Network, ip.Subnet, ip.Address.IP.String(), isIPv6)
+		}
+	}()
+
+	if err = r.patchLabels(ctx, loadBalancerIP, map[string]*string{
+		constants.LabelSubnet:  &ip.Subnet,
+		constants.LabelNetwork: &ip.Network,
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	if err = r.patchStatus(ctx, loadBalancerIP, func(status *networkingv1.LoadBalancerIPStatus) {
+		status.Network = ip.Network
+		status.Subnet = ip.Subnet
+		status.IP = ip.Address.IP.String()
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	r.Recorder.Eventf(loadBalancerIP, corev1.EventTypeNormal, ReasonLoadBalancerIPAllocationSucceed,
+		"allocate IP %s from subnet %s successfully", ip.Address.IP.String(), ip.Subnet)
+	return nil
+}
+
+// candidateSubnets returns the load balancer subnets which the ip could be allocated from, in
+// the order of names
+func (r *LoadBalancerIPReconciler) candidateSubnets(loadBalancerIP *networkingv1.LoadBalancerIP, specifiedIP net.IP) ([]*networkingv1.Subnet, error) {
+	var subnets []*networkingv1.Subnet
+	if len(loadBalancerIP.Spec.Subnet) > 0 {
+		subnet, err := utils.GetSubnet(r, loadBalancerIP.Spec.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get subnet %s: %v", loadBalancerIP.Spec.Subnet, err)
+		}
+		if !networkingv1.IsLoadBalancerSubnet(subnet) {
+			return nil, fmt.Errorf("subnet %s is not a load balancer subnet", subnet.Name)
+		}
+		subnets = append(subnets, subnet)
+	} else {
+		subnetList, err := utils.ListSubnets(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list subnets: %v", err)
+		}
+		for i := range subnetList.Items {
+			if networkingv1.IsLoadBalancerSubnet(&subnetList.Items[i]) {
+				subnets = append(subnets, &subnetList.Items[i])
+			}
+		}
+		sort.Slice(subnets, func(i, j int) bool {
+			return subnets[i].Name < subnets[j].Name
+		})
+	}
+
+	var candidates []*networkingv1.Subnet
+	for _, subnet := range subnets {
+		if networkingv1.IsIPv6Subnet(subnet) != (loadBalancerIP.Spec.Version == networkingv1.IPv6) {
+			continue
+		}
+		if specifiedIP != nil {
+			if _, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR); err != nil || !cidr.Contains(specifiedIP) {
+				continue
+			}
+		}
+		candidates = append(candidates, subnet)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no %s load balancer subnet matched", ipVersionString(loadBalancerIP.Spec.Version))
+	}
+	return candidates, nil
+}
+
+func (r *LoadBalancerIPReconciler) allocateFromSubnet(loadBalancerIP *networkingv1.LoadBalancerIP, subnet *networkingv1.Subnet,
+	specifiedIP net.IP) (*types.IP, error) {
+	var (
+		networkName  = subnet.Spec.Network
+		ownerName    = transform.LoadBalancerIPOwnerName(loadBalancerIP.Name)
+		ipFamilyMode = utils.ToIPFamilyMode(networkingv1.IsIPv6Subnet(subnet))
+	)
+
+	if feature.DualStackEnabled() {
+		var (
+			ips []*types.IP
+			err error
+		)
+		if specifiedIP != nil {
+			ips, err = r.IPAMManager.DualStack().Assign(ipFamilyMode, networkName, []string{subnet.Name}, []string{specifiedIP.String()},
+				ownerName, loadBalancerIP.Namespace, false)
+		} else {
+			ips, err = r.IPAMManager.DualStack().Allocate(ipFamilyMode, networkName, []string{subnet.Name}, ownerName, loadBalancerIP.Namespace)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ips[0], nil
+	}
+
+	if specifiedIP != nil {
+		return r.IPAMManager.Assign(networkName, subnet.Name, ownerName, loadBalancerIP.Namespace, specifiedIP.String(), false)
+	}
+	return r.IPAMManager.Allocate(networkName, subnet.Name, ownerName, loadBalancerIP.Namespace)
+}
+
+// elect will choose a node to announce the load balancer ip of vlan network, the node already
+// elected will be kept in priority to avoid unnecessary movement
+func (r *LoadBalancerIPReconciler) elect(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP) (err error) {
+	network := &networkingv1.Network{}
+	if err = r.Get(ctx, apitypes.NamespacedName{Name: loadBalancerIP.Status.Network}, network); err != nil {
+		return fmt.Errorf("unable to get network %s: %v", loadBalancerIP.Status.Network, err)
+	}
+
+	// load balancer ips of bgp network are advertised by all the nodes
+	var nodeName string
+	if networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeVlan {
+		candidates, err := r.candidateNodes(ctx, loadBalancerIP, network)
+		if err != nil {
+			return err
+		}
+
+		for _, candidate := range candidates {
+			if candidate == loadBalancerIP.Status.NodeName {
+				nodeName = candidate
+				break
+			}
+		}
+		if len(nodeName) == 0 && len(candidates) > 0 {
+			nodeName = candidates[0]
+		}
+	}
+
+	if nodeName == loadBalancerIP.Status.NodeName {
+		return nil
+	}
+
+	var nodeLabel *string
+	if len(nodeName) > 0 {
+		nodeLabel = &nodeName
+	}
+	if err = r.patchLabels(ctx, loadBalancerIP, map[string]*string{
+		constants.LabelNode: nodeLabel,
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	if err = r.patchStatus(ctx, loadBalancerIP, func(status *networkingv1.LoadBalancerIPStatus) {
+		status.NodeName = nodeName
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	if len(nodeName) > 0 {
+		r.Recorder.Eventf(loadBalancerIP, corev1.EventTypeNormal, ReasonLoadBalancerIPElected, "elect node %s to announce IP %s", nodeName, loadBalancerIP.Status.IP)
+	}
+	return nil
+}
+
+// candidateNodes returns the ready nodes of network in the order of names, only nodes with ready
+// endpoints are returned for service with "Local" external traffic policy
+func (r *LoadBalancerIPReconciler) candidateNodes(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP,
+	network *networkingv1.Network) ([]string, error) {
+	var endpointNodes map[string]bool
+
+	service := &corev1.Service{}
+	if err := r.Get(ctx, apitypes.NamespacedName{Namespace: loadBalancerIP.Namespace, Name: loadBalancerIP.Spec.Service}, service); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to get service %s: %v", loadBalancerIP.Spec.Service, err)
+		}
+	} else if service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+		endpointNodes = map[string]bool{}
+
+		endpoints := &corev1.Endpoints{}
+		if err = r.Get(ctx, apitypes.NamespacedName{Namespace: service.Namespace, Name: service.Name}, endpoints); client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("unable to get endpoints %s: %v", service.Name, err)
+		}
+		for _, subset := range endpoints.Subsets {
+			for _, address := range subset.Addresses {
+				if address.NodeName != nil {
+					endpointNodes[*address.NodeName] = true
+				}
+			}
+		}
+	}
+
+	var candidates []string
+	for _, nodeName := range network.Status.NodeList {
+		if endpointNodes != nil && !endpointNodes[nodeName] {
+			continue
+		}
+
+		node := &corev1.Node{}
+		if err := r.Get(ctx, apitypes.NamespacedName{Name: nodeName}, node); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("unable to get node %s: %v", nodeName, err)
+		}
+
+		if isNodeReady(node) {
+			candidates = append(candidates, nodeName)
+		}
+	}
+
+	sort.Strings(candidates)
+	return candidates, nil
+}
+
+// release will recycle the address of load balancer ip and remove the finalizer
+func (r *LoadBalancerIPReconciler) release(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP) (err error) {
+	if !controllerutil.ContainsFinalizer(loadBalancerIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	if len(loadBalancerIP.Status.IP) > 0 {
+		if err = r.releaseIP(loadBalancerIP.Status.Network, loadBalancerIP.Status.Subnet, loadBalancerIP.Status.IP,
+			loadBalancerIP.Spec.Version == networkingv1.IPv6); err != nil {
+			return fmt.Errorf("unable to release ip %s: %v", loadBalancerIP.Status.IP, err)
+		}
+	}
+
+	patch := client.MergeFrom(loadBalancerIP.DeepCopy())
+	controllerutil.RemoveFinalizer(loadBalancerIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, loadBalancerIP, patch)
+	})
+}
+
+func (r *LoadBalancerIPReconciler) releaseIP(networkName, subnetName, ip string, isIPv6 bool) error {
+	if feature.DualStackEnabled() {
+		return r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(isIPv6), networkName, []string{subnetName}, []string{ip})
+	}
+	return r.IPAMManager.Release(networkName, subnetName, ip)
+}
+
+func (r *LoadBalancerIPReconciler) addFinalizer(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP) error {
+	if controllerutil.ContainsFinalizer(loadBalancerIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	patch := client.MergeFrom(loadBalancerIP.DeepCopy())
+	controllerutil.AddFinalizer(loadBalancerIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, loadBalancerIP, patch)
+	})
+}
+
+// patchLabels will patch labels of load balancer ip, labels with nil value will be removed
+func (r *LoadBalancerIPReconciler) patchLabels(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP, labels map[string]*string) error {
+	patch := client.MergeFrom(loadBalancerIP.DeepCopy())
+	for key, value := range labels {
+		if value == nil {
+			delete(loadBalancerIP.Labels, key)
+			continue
+		}
+		if loadBalancerIP.Labels == nil {
+			loadBalancerIP.Labels = map[string]string{}
+		}
+		loadBalancerIP.Labels[key] = *value
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, loadBalancerIP, patch)
+	})
+}
+
+// patchStatus will patch status of load balancer ip with the mutation
+func (r *LoadBalancerIPReconciler) patchStatus(ctx context.Context, loadBalancerIP *networkingv1.LoadBalancerIP,
+	mutate func(status *networkingv1.LoadBalancerIPStatus)) error {
+	patch := client.MergeFrom(loadBalancerIP.DeepCopy())
+	mutate(&loadBalancerIP.Status)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Status().Patch(ctx, loadBalancerIP, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LoadBalancerIPReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerLoadBalancerIP).
+		For(&networkingv1.LoadBalancerIP{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		Watches(&source.Kind{Type: &corev1.Endpoints{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				return r.loadBalancerIPsToRequests(client.InNamespace(object.GetNamespace()), func(loadBalancerIP *networkingv1.LoadBalancerIP) bool {
+					return loadBalancerIP.Spec.Service == object.GetName()
+				})
+			}),
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		Watches(&source.Kind{Type: &corev1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				return r.loadBalancerIPsToRequests(nil, nil)
+			}),
+			builder.WithPredicates(
+				&predicate.Funcs{
+					UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+						oldNode, ok := updateEvent.ObjectOld.(*corev1.Node)
+						if !ok {
+							return false
+						}
+						newNode, ok := updateEvent.ObjectNew.(*corev1.Node)
+						if !ok {
+							return false
+						}
+						return isNodeReady(oldNode) != isNodeReady(newNode)
+					},
+				},
+			)).
+		Watches(&source.Kind{Type: &networkingv1.Network{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				return r.loadBalancerIPsToRequests(client.MatchingLabels{constants.LabelNetwork: object.GetName()}, nil)
+			}),
+			builder.WithPredicates(
+				&predicate.Funcs{
+					CreateFunc: func(createEvent event.CreateEvent) bool {
+						return false
+					},
+					DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+						return false
+					},
+					UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+						oldNetwork, ok := updateEvent.ObjectOld.(*networkingv1.Network)
+						if !ok {
+							return false
+						}
+						newNetwork, ok := updateEvent.ObjectNew.(*networkingv1.Network)
+						if !ok {
+							return false
+						}
+						return !reflect.DeepEqual(oldNetwork.Status.NodeList, newNetwork.Status.NodeList)
+					},
+				},
+			)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
+
+func (r *LoadBalancerIPReconciler) loadBalancerIPsToRequests(opt client.ListOption,
+	filter func(loadBalancerIP *networkingv1.LoadBalancerIP) bool) []reconcile.Request {
+	var opts []client.ListOption
+	if opt != nil {
+		opts = append(opts, opt)
+	}
+
+	loadBalancerIPList, err := utils.ListLoadBalancerIPs(r, opts...)
+	if err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range loadBalancerIPList.Items {
+		loadBalancerIP := &loadBalancerIPList.Items[i]
+		if filter != nil && !filter(loadBalancerIP) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: apitypes.NamespacedName{
+				Namespace: loadBalancerIP.Namespace,
+				Name:      loadBalancerIP.Name,
+			},
+		})
+	}
+	return requests
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func ipVersionString(version networkingv1.IPVersion) string {
+	if version == networkingv1.IPv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
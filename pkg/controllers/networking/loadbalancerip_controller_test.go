@@ -0,0 +1,410 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestLoadBalancerSubnet(name, network, cidr string, loadBalancer bool) *networkingv1.Subnet {
+	subnet := &networkingv1.Subnet{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: networkingv1.SubnetSpec{
+			Network: network,
+			Range: networkingv1.AddressRange{
+				Version: networkingv1.IPv4,
+				CIDR:    cidr,
+			},
+		},
+	}
+	if loadBalancer {
+		subnet.Spec.Config = &networkingv1.SubnetConfig{LoadBalancer: &loadBalancer}
+	}
+	return subnet
+}
+
+func newTestLoadBalancerNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{
+					Type:   corev1.NodeReady,
+					Status: status,
+				},
+			},
+		},
+	}
+}
+
+func newTestLoadBalancerIP(spec networkingv1.LoadBalancerIPSpec) *networkingv1.LoadBalancerIP {
+	return &networkingv1.LoadBalancerIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "lb-v4",
+		},
+		Spec: spec,
+	}
+}
+
+// newTestLoadBalancerIPReconciler prepares a vlan network with nodes node1, node2 and a not ready node3,
+// and a bgp network, both of which have load balancer subnets.
+func newTestLoadBalancerIPReconciler(t *testing.T, objects ...client.Object) *LoadBalancerIPReconciler {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	objects = append(objects,
+		&networkingv1.Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "vlan-network"},
+			Spec: networkingv1.NetworkSpec{
+				Type: networkingv1.NetworkTypeUnderlay,
+				Mode: networkingv1.NetworkModeVlan,
+			},
+			Status: networkingv1.NetworkStatus{
+				NodeList: []string{"node3", "node2", "node1"},
+			},
+		},
+		&networkingv1.Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "bgp-network"},
+			Spec: networkingv1.NetworkSpec{
+				Type: networkingv1.NetworkTypeUnderlay,
+				Mode: networkingv1.NetworkModeBGP,
+			},
+			Status: networkingv1.NetworkStatus{
+				NodeList: []string{"node1", "node2"},
+			},
+		},
+		newTestLoadBalancerSubnet("lb-b", "vlan-network", "192.168.1.0/24", true),
+		newTestLoadBalancerSubnet("lb-a", "vlan-network", "192.168.0.0/24", true),
+		newTestLoadBalancerSubnet("normal", "vlan-network", "10.0.0.0/24", false),
+		newTestLoadBalancerSubnet("lb-bgp", "bgp-network", "192.168.2.0/24", true),
+		newTestLoadBalancerNode("node1", true),
+		newTestLoadBalancerNode("node2", true),
+		newTestLoadBalancerNode("node3", false),
+	)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	ipamManager, err := NewIPAMManager(c)
+	assert.NoError(t, err)
+
+	return &LoadBalancerIPReconciler{
+		Client:      c,
+		Recorder:    record.NewFakeRecorder(10),
+		IPAMManager: ipamManager,
+	}
+}
+
+func TestLoadBalancerIPCandidateSubnets(t *testing.T) {
+	r := newTestLoadBalancerIPReconciler(t)
+
+	tests := []struct {
+		name        string
+		spec        networkingv1.LoadBalancerIPSpec
+		specifiedIP net.IP
+		expected    []string
+		errMsg      string
+	}{
+		{
+			name:     "all load balancer subnets in order of names",
+			spec:     networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4},
+			expected: []string{"lb-a", "lb-b", "lb-bgp"},
+		},
+		{
+			name:        "subnets containing specified ip",
+			spec:        networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4},
+			specifiedIP: net.ParseIP("192.168.1.10"),
+			expected:    []string{"lb-b"},
+		},
+		{
+			name:     "specified subnet",
+			spec:     networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4, Subnet: "lb-bgp"},
+			expected: []string{"lb-bgp"},
+		},
+		{
+			name:   "specified subnet not for load balancer",
+			spec:   networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4, Subnet: "normal"},
+			errMsg: "subnet normal is not a load balancer subnet",
+		},
+		{
+			name:   "specified subnet not found",
+			spec:   networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4, Subnet: "not-exist"},
+			errMsg: "unable to get subnet not-exist",
+		},
+		{
+			name:        "specified ip out of load balancer subnets",
+			spec:        networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv4},
+			specifiedIP: net.ParseIP("10.0.0.10"),
+			errMsg:      "no ipv4 load balancer subnet matched",
+		},
+		{
+			name:   "no load balancer subnet of ip family",
+			spec:   networkingv1.LoadBalancerIPSpec{Version: networkingv1.IPv6},
+			errMsg: "no ipv6 load balancer subnet matched",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subnets, err := r.candidateSubnets(newTestLoadBalancerIP(test.spec), test.specifiedIP)
+			if len(test.errMsg) > 0 {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.errMsg)
+				}
+				return
+			}
+			assert.NoError(t, err)
+
+			var names []string
+			for _, subnet := range subnets {
+				names = append(names, subnet.Name)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
+
+func TestLoadBalancerIPAllocateFromSubnet(t *testing.T) {
+	r := newTestLoadBalancerIPReconciler(t)
+	loadBalancerIP := newTestLoadBalancerIP(networkingv1.LoadBalancerIPSpec{
+		Service: "lb",
+		Version: networkingv1.IPv4,
+	})
+
+	subnet := &networkingv1.Subnet{}
+	assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Name: "lb-a"}, subnet))
+
+	specifiedIP := net.ParseIP("192.168.0.100")
+	ip, err := r.allocateFromSubnet(loadBalancerIP, subnet, specifiedIP)
+	if assert.NoError(t, err) {
+		assert.Equal(t, specifiedIP.String(), ip.Address.IP.String())
+		assert.Equal(t, "lb-a", ip.Subnet)
+		assert.Equal(t, "vlan-network", ip.Network)
+	}
+
+	// the specified ip is in use by another one
+	other := newTestLoadBalancerIP(networkingv1.LoadBalancerIPSpec{Service: "other", Version: networkingv1.IPv4})
+	other.Name = "other-v4"
+	_, err = r.allocateFromSubnet(other, subnet, specifiedIP)
+	assert.Error(t, err)
+
+	ip, err = r.allocateFromSubnet(loadBalancerIP, subnet, nil)
+	if assert.NoError(t, err) {
+		_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+		assert.True(t, cidr.Contains(ip.Address.IP))
+		assert.NotEqual(t, specifiedIP.String(), ip.Address.IP.String())
+	}
+}
+
+func TestLoadBalancerIPAllocateAndRelease(t *testing.T) {
+	loadBalancerIP := newTestLoadBalancerIP(networkingv1.LoadBalancerIPSpec{
+		Service: "lb",
+		Version: networkingv1.IPv4,
+		Subnet:  "lb-b",
+		Address: "192.168.1.10",
+	})
+	r := newTestLoadBalancerIPReconciler(t, loadBalancerIP)
+
+	key := apitypes.NamespacedName{Namespace: "default", Name: "lb-v4"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.NoError(t, err)
+
+	allocated := &networkingv1.LoadBalancerIP{}
+	assert.NoError(t, r.Get(context.Background(), key, allocated))
+	assert.True(t, controllerutil.ContainsFinalizer(allocated, constants.FinalizerIPAllocated))
+	assert.Equal(t, networkingv1.LoadBalancerIPStatus{
+		Network:  "vlan-network",
+		Subnet:   "lb-b",
+		IP:       "192.168.1.10",
+		NodeName: "node1",
+	}, allocated.Status)
+	assert.Equal(t, "lb-b", allocated.Labels[constants.LabelSubnet])
+	assert.Equal(t, "vlan-network", allocated.Labels[constants.LabelNetwork])
+	assert.Equal(t, "node1", allocated.Labels[constants.LabelNode])
+
+	// the allocated ip can not be assigned to others
+	subnet := &networkingv1.Subnet{}
+	assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Name: "lb-b"}, subnet))
+	other := newTestLoadBalancerIP(networkingv1.LoadBalancerIPSpec{Service: "other", Version: networkingv1.IPv4})
+	other.Name = "other-v4"
+	_, err = r.allocateFromSubnet(other, subnet, net.ParseIP("192.168.1.10"))
+	assert.Error(t, err)
+
+	deletionTime := metav1.Now()
+	allocated.DeletionTimestamp = &deletionTime
+	assert.NoError(t, r.release(context.Background(), allocated))
+
+	released := &networkingv1.LoadBalancerIP{}
+	assert.NoError(t, r.Get(context.Background(), key, released))
+	assert.False(t, controllerutil.ContainsFinalizer(released, constants.FinalizerIPAllocated))
+
+	// the released ip is available again
+	_, err = r.allocateFromSubnet(other, subnet, net.ParseIP("192.168.1.10"))
+	assert.NoError(t, err)
+}
+
+func TestLoadBalancerIPElect(t *testing.T) {
+	newService := func(policy corev1.ServiceExternalTrafficPolicyType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "lb",
+			},
+			Spec: corev1.ServiceSpec{
+				Type:                  corev1.ServiceTypeLoadBalancer,
+				ExternalTrafficPolicy: policy,
+			},
+		}
+	}
+
+	newEndpoints := func(nodeNames ...string) *corev1.Endpoints {
+		subset := corev1.EndpointSubset{}
+		for i := range nodeNames {
+			subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{
+				IP:       "10.0.0.1",
+				NodeName: &nodeNames[i],
+			})
+		}
+		return &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "lb",
+			},
+			Subsets: []corev1.EndpointSubset{subset},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		network     string
+		currentNode string
+		objects     []client.Object
+		candidates  []string
+		expected    string
+	}{
+		{
+			name:       "first ready node elected",
+			network:    "vlan-network",
+			objects:    []client.Object{newService(corev1.ServiceExternalTrafficPolicyTypeCluster)},
+			candidates: []string{"node1", "node2"},
+			expected:   "node1",
+		},
+		{
+			name:        "current node kept",
+			network:     "vlan-network",
+			currentNode: "node2",
+			objects:     []client.Object{newService(corev1.ServiceExternalTrafficPolicyTypeCluster)},
+			candidates:  []string{"node1", "node2"},
+			expected:    "node2",
+		},
+		{
+			name:        "not ready current node replaced",
+			network:     "vlan-network",
+			currentNode: "node3",
+			objects:     []client.Object{newService(corev1.ServiceExternalTrafficPolicyTypeCluster)},
+			candidates:  []string{"node1", "node2"},
+			expected:    "node1",
+		},
+		{
+			name:       "service not found",
+			network:    "vlan-network",
+			candidates: []string{"node1", "node2"},
+			expected:   "node1",
+		},
+		{
+			name:    "local policy with endpoints",
+			network: "vlan-network",
+			objects: []client.Object{
+				newService(corev1.ServiceExternalTrafficPolicyTypeLocal),
+				newEndpoints("node2", "node3"),
+			},
+			candidates: []string{"node2"},
+			expected:   "node2",
+		},
+		{
+			name:        "local policy without endpoints",
+			network:     "vlan-network",
+			currentNode: "node1",
+			objects:     []client.Object{newService(corev1.ServiceExternalTrafficPolicyTypeLocal)},
+			candidates:  nil,
+			expected:    "",
+		},
+		{
+			name:        "bgp network",
+			network:     "bgp-network",
+			currentNode: "node1",
+			objects:     []client.Object{newService(corev1.ServiceExternalTrafficPolicyTypeCluster)},
+			candidates:  []string{"node1", "node2"},
+			expected:    "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loadBalancerIP := newTestLoadBalancerIP(networkingv1.LoadBalancerIPSpec{
+				Service: "lb",
+				Version: networkingv1.IPv4,
+			})
+			loadBalancerIP.Status = networkingv1.LoadBalancerIPStatus{
+				Network:  test.network,
+				IP:       "192.168.0.10",
+				NodeName: test.currentNode,
+			}
+			if len(test.currentNode) > 0 {
+				loadBalancerIP.Labels = map[string]string{constants.LabelNode: test.currentNode}
+			}
+			r := newTestLoadBalancerIPReconciler(t, append(test.objects, loadBalancerIP)...)
+
+			network := &networkingv1.Network{}
+			assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Name: test.network}, network))
+			candidates, err := r.candidateNodes(context.Background(), loadBalancerIP, network)
+			assert.NoError(t, err)
+			assert.Equal(t, test.candidates, candidates)
+
+			assert.NoError(t, r.elect(context.Background(), loadBalancerIP))
+
+			elected := &networkingv1.LoadBalancerIP{}
+			assert.NoError(t, r.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "lb-v4"}, elected))
+			assert.Equal(t, test.expected, elected.Status.NodeName)
+			if len(test.expected) > 0 {
+				assert.Equal(t, test.expected, elected.Labels[constants.LabelNode])
+			} else {
+				assert.NotContains(t, elected.Labels, constants.LabelNode)
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"sort"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
@@ -62,6 +63,7 @@ type NetworkStatusReconciler struct {
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=networks,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=networks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=networking.alibaba.com,resources=networks/finalizers,verbs=update
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=nodenetworkstatuses,verbs=get;list;watch
 
 func (r *NetworkStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	log := ctrllog.FromContext(ctx)
@@ -78,6 +80,9 @@ func (r *NetworkStatusReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	}()
 
 	if err = r.Get(ctx, req.NamespacedName, network); err != nil {
+		if errors.IsNotFound(err) {
+			metrics.BGPNetworkDisconnectedNodesGauge.DeleteLabelValues(req.Name)
+		}
 		return ctrl.Result{}, wrapError("unable to fetch Network", client.IgnoreNotFound(err))
 	}
 
@@ -132,6 +137,13 @@ func (r *NetworkStatusReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 	}
 
+	// update bgp status
+	if networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeBGP {
+		if networkStatus.BGPStatus, err = r.aggregateBGPStatus(ctx, networkStatus.NodeList); err != nil {
+			return ctrl.Result{}, wrapError("unable to aggregate bgp status", err)
+		}
+	}
+
 	// diff for no-op
 	if reflect.DeepEqual(&network.Status, networkStatus) {
 		log.V(10).Info("network status is up-to-date, skip updating")
@@ -140,6 +152,12 @@ func (r *NetworkStatusReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 
 	// update metrics
 	updateUsageMetrics(network.Name, networkStatus)
+	if networkStatus.BGPStatus != nil {
+		metrics.BGPNetworkDisconnectedNodesGauge.WithLabelValues(network.Name).
+			Set(float64(len(networkStatus.BGPStatus.DisconnectedNodes)))
+	} else {
+		metrics.BGPNetworkDisconnectedNodesGauge.DeleteLabelValues(network.Name)
+	}
 
 	// patch network status
 	networkPatch := client.MergeFrom(network.DeepCopy())
@@ -156,6 +174,37 @@ func (r *NetworkStatusReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	return ctrl.Result{}, nil
 }
 
+// aggregateBGPStatus summarizes the bgp states reported by nodes in NodeNetworkStatus, nodes without
+// reports are regarded as disconnected.
+func (r *NetworkStatusReconciler) aggregateBGPStatus(ctx context.Context, nodeNames []string) (*networkingv1.NetworkBGPStatus, error) {
+	bgpStatus := &networkingv1.NetworkBGPStatus{}
+	for _, nodeName := range nodeNames {
+		nodeNetworkStatus := &networkingv1.NodeNetworkStatus{}
+		if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, nodeNetworkStatus); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("unable to get node network status %s: %v", nodeName, err)
+			}
+		}
+
+		var established bool
+		if nodeNetworkStatus.BGP != nil {
+			for _, peer := range nodeNetworkStatus.BGP.Peers {
+				bgpStatus.TotalSessions++
+				if peer.State == networkingv1.BGPSessionStateEstablished {
+					bgpStatus.EstablishedSessions++
+					established = true
+				}
+			}
+		}
+
+		if !established {
+			bgpStatus.DisconnectedNodes = append(bgpStatus.DisconnectedNodes, nodeName)
+		}
+	}
+
+	return bgpStatus, nil
+}
+
 func updateUsageMetrics(networkName string, networkStatus *networkingv1.NetworkStatus) {
 	if feature.DualStackEnabled() {
 		metrics.IPUsageGauge.WithLabelValues(networkName, metrics.IPv4, metrics.IPTotalUsageType).
@@ -201,12 +250,30 @@ func (r *NetworkStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	enqueueNetworkOfNode := handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+		node, ok := object.(*corev1.Node)
+		if !ok {
+			return nil
+		}
+		return r.requestsOfNodeNetwork(node)
+	})
+
+	// NodeNetworkStatus has the same name as its node
+	enqueueNetworkOfNodeNetworkStatus := handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+		node := &corev1.Node{}
+		if err := r.Get(context.TODO(), types.NamespacedName{Name: object.GetName()}, node); err != nil {
+			// TODO: handle error
+			return nil
+		}
+		return r.requestsOfNodeNetwork(node)
+	})
+
 	return ctrl.NewControllerManagedBy(mgr).
 		Named(ControllerNetworkStatus).
+		// deleted networks are reconciled to clean up their bgp metrics
 		For(&networkingv1.Network{},
 			builder.WithPredicates(
 				&predicate.GenerationChangedPredicate{},
-				&utils.IgnoreDeletePredicate{},
 				&utils.NetworkSpecChangePredicate{},
 			)).
 		Watches(&source.Kind{Type: &networkingv1.Subnet{}},
@@ -245,32 +312,18 @@ func (r *NetworkStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				&utils.IgnoreUpdatePredicate{},
 			)).
 		Watches(&source.Kind{Type: &corev1.Node{}},
-			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
-				node, ok := object.(*corev1.Node)
-				if !ok {
-					return nil
-				}
-				underlayNetworkName, err := utils.FindUnderlayNetworkForNode(r, node.GetLabels())
-				if err != nil {
-					// TODO: handle error
-					return nil
-				}
-				if len(underlayNetworkName) == 0 {
-					return nil
-				}
-				return []reconcile.Request{
-					{
-						NamespacedName: types.NamespacedName{
-							Name: underlayNetworkName,
-						},
-					},
-				}
-			}),
+			enqueueNetworkOfNode,
 			builder.WithPredicates(
 				&predicate.ResourceVersionChangedPredicate{},
 				&predicate.LabelChangedPredicate{},
 				&utils.NetworkOfNodeChangePredicate{Client: r},
 			)).
+		// bgp states are reported by daemon in NodeNetworkStatus
+		Watches(&source.Kind{Type: &networkingv1.NodeNetworkStatus{}},
+			enqueueNetworkOfNodeNetworkStatus,
+			builder.WithPredicates(
+				&utils.IgnoreDeletePredicate{},
+			)).
 		WithOptions(
 			controller.Options{
 				MaxConcurrentReconciles: r.Max(),
@@ -278,3 +331,21 @@ func (r *NetworkStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		).
 		Complete(r)
 }
+
+func (r *NetworkStatusReconciler) requestsOfNodeNetwork(node *corev1.Node) []reconcile.Request {
+	underlayNetworkName, err := utils.FindUnderlayNetworkForNode(r, node.GetLabels())
+	if err != nil {
+		// TODO: handle error
+		return nil
+	}
+	if len(underlayNetworkName) == 0 {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Name: underlayNetworkName,
+			},
+		},
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func newTestNodeNetworkStatus(nodeName string, states ...networkingv1.BGPSessionState) *networkingv1.NodeNetworkStatus {
+	nodeNetworkStatus := &networkingv1.NodeNetworkStatus{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: nodeName,
+		},
+	}
+	if len(states) != 0 {
+		nodeNetworkStatus.BGP = &networkingv1.NodeBGPStatus{}
+		for _, state := range states {
+			nodeNetworkStatus.BGP.Peers = append(nodeNetworkStatus.BGP.Peers, networkingv1.BGPPeerStatus{
+				Address: "192.168.0.1",
+				State:   state,
+			})
+		}
+	}
+	return nodeNetworkStatus
+}
+
+func TestAggregateBGPStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		nodes    []string
+		expected *networkingv1.NetworkBGPStatus
+	}{
+		{
+			name:     "no node",
+			expected: &networkingv1.NetworkBGPStatus{},
+		},
+		{
+			name: "all nodes established",
+			objects: []client.Object{
+				newTestNodeNetworkStatus("node1", networkingv1.BGPSessionStateEstablished,
+					networkingv1.BGPSessionStateEstablished),
+				newTestNodeNetworkStatus("node2", networkingv1.BGPSessionStateEstablished),
+			},
+			nodes: []string{"node1", "node2"},
+			expected: &networkingv1.NetworkBGPStatus{
+				EstablishedSessions: 3,
+				TotalSessions:       3,
+			},
+		},
+		{
+			name: "node with one established session is connected",
+			objects: []client.Object{
+				newTestNodeNetworkStatus("node1", networkingv1.BGPSessionStateEstablished,
+					networkingv1.BGPSessionStateActive),
+				newTestNodeNetworkStatus("node2", networkingv1.BGPSessionStateIdle,
+					networkingv1.BGPSessionStateConnect),
+			},
+			nodes: []string{"node1", "node2"},
+			expected: &networkingv1.NetworkBGPStatus{
+				EstablishedSessions: 1,
+				TotalSessions:       4,
+				DisconnectedNodes:   []string{"node2"},
+			},
+		},
+		{
+			name: "nodes without reports are disconnected",
+			objects: []client.Object{
+				newTestNodeNetworkStatus("node1", networkingv1.BGPSessionStateEstablished),
+				newTestNodeNetworkStatus("node2"),
+			},
+			nodes: []string{"node1", "node2", "node3"},
+			expected: &networkingv1.NetworkBGPStatus{
+				EstablishedSessions: 1,
+				TotalSessions:       1,
+				DisconnectedNodes:   []string{"node2", "node3"},
+			},
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &NetworkStatusReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).Build(),
+			}
+
+			bgpStatus, err := r.aggregateBGPStatus(context.Background(), test.nodes)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, bgpStatus)
+		})
+	}
+}
@@ -18,6 +18,7 @@ package networking
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -54,6 +55,7 @@ const (
 	ReasonIPAllocationFail    = "IPAllocationFail"
 	ReasonIPReleaseSucceed    = "IPReleaseSucceed"
 	ReasonIPReserveSucceed    = "IPReserveSucceed"
+	ReasonIPAssignConflict    = "IPAssignConflict"
 )
 
 // PodReconciler reconciles a Pod object
@@ -94,7 +96,7 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result
 		if err != nil {
 			log.Error(err, "reconciliation fails")
 			if len(pod.UID) > 0 {
-				r.Recorder.Event(pod, corev1.EventTypeWarning, ReasonIPAllocationFail, err.Error())
+				r.Recorder.Event(pod, corev1.EventTypeWarning, failureReason(err), err.Error())
 			}
 		}
 	}()
@@ -137,6 +139,11 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result
 		return ctrl.Result{}, wrapError("unable to stateful allocate", r.statefulAllocate(ctx, pod, networkName))
 	}
 
+	if len(pod.Annotations[constants.AnnotationSpecifiedIP]) > 0 {
+		log.V(4).Info("specified assignment for pod")
+		return ctrl.Result{}, wrapError("unable to assign specified ip", r.specifiedAssign(ctx, pod, networkName))
+	}
+
 	if strategy.OwnByStatelessWorkload(pod) {
 		log.V(10).Info("non support strategic IP allocation for stateless workloads")
 	}
@@ -344,6 +351,47 @@ func (r *PodReconciler) statefulAllocate(ctx context.Context, pod *corev1.Pod, n
 	return wrapError("unable to assign", r.assign(ctx, pod, networkName, ipCandidate, true))
 }
 
+// specifiedAssign will assign the IPs specified by annotation to pod, the IPs
+// must be available and will never be taken over from others
+func (r *PodReconciler) specifiedAssign(ctx context.Context, pod *corev1.Pod, networkName string) (err error) {
+	var (
+		specifiedIP = pod.Annotations[constants.AnnotationSpecifiedIP]
+		startTime   = time.Now()
+	)
+
+	defer func() {
+		metrics.IPAllocationPeriodSummary.
+			WithLabelValues(metrics.IPSpecifiedAssignType, strconv.FormatBool(err == nil)).
+			Observe(float64(time.Since(startTime).Nanoseconds()))
+
+		switch {
+		case errors.Is(err, types.ErrNotAvailableAssignedIP):
+			err = fmt.Errorf("specified ip %s is unavailable, it may be in use by others: %w", specifiedIP, err)
+		case errors.Is(err, types.ErrNotFoundAssignedIP), errors.Is(err, types.ErrNotFoundSubnet):
+			err = fmt.Errorf("specified ip %s is out of any subnet of network %s: %w", specifiedIP, networkName, err)
+		}
+	}()
+
+	if feature.DualStackEnabled() {
+		var (
+			ipCandidates = strings.Split(specifiedIP, "/")
+			ipFamilyMode = types.ParseIPFamilyFromString(pod.Annotations[constants.AnnotationIPFamily])
+		)
+		for i := range ipCandidates {
+			ipCandidates[i] = globalutils.NormalizedIP(ipCandidates[i])
+		}
+
+		return r.multiAssign(ctx, pod, networkName, ipFamilyMode, ipCandidates, false)
+	}
+
+	var ipCandidate = globalutils.NormalizedIP(specifiedIP)
+	if len(ipCandidate) == 0 {
+		return fmt.Errorf("invalid specified ip %s", specifiedIP)
+	}
+
+	return r.assign(ctx, pod, networkName, ipCandidate, false)
+}
+
 // release will release IP instances of pod
 func (r *PodReconciler) release(ctx context.Context, pod *corev1.Pod, allocatedIPs []*types.IP) (err error) {
 	var recycleFunc func(namespace string, ip *types.IP) (err error)
@@ -483,6 +531,16 @@ func (r *PodReconciler) removeFinalizer(ctx context.Context, pod *corev1.Pod) er
 	})
 }
 
+// failureReason picks the event reason for a failed reconciliation, conflicts
+// of specified IPs are distinguished from common allocation failures
+func failureReason(err error) string {
+	if errors.Is(err, types.ErrNotAvailableAssignedIP) || errors.Is(err, types.ErrNotFoundAssignedIP) ||
+		errors.Is(err, types.ErrNotFoundSubnet) {
+		return ReasonIPAssignConflict
+	}
+	return ReasonIPAllocationFail
+}
+
 func squashIPSliceToIPs(ips []*types.IP) (ret []string) {
 	for _, ip := range ips {
 		ret = append(ret, ip.Address.IP.String())
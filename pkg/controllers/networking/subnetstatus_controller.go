@@ -36,6 +36,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
 	"github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
@@ -146,6 +147,82 @@ func (r *SubnetStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				&utils.IgnoreUpdatePredicate{},
 			),
 		).
+		Watches(&source.Kind{Type: &networkingv1.VirtualIP{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				virtualIP, ok := object.(*networkingv1.VirtualIP)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: virtualIP.Spec.Subnet,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				// subnet label will be patched after allocation
+				&predicate.LabelChangedPredicate{},
+			),
+		).
+		Watches(&source.Kind{Type: &networkingv1.LoadBalancerIP{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				subnetName := object.GetLabels()[constants.LabelSubnet]
+				if len(subnetName) == 0 {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: subnetName,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				// subnet label will be patched after allocation
+				&predicate.LabelChangedPredicate{},
+			),
+		).
+		Watches(&source.Kind{Type: &networkingv1.AnycastIP{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				anycastIP, ok := object.(*networkingv1.AnycastIP)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: anycastIP.Spec.Subnet,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				// subnet label will be patched after allocation
+				&predicate.LabelChangedPredicate{},
+			),
+		).
+		Watches(&source.Kind{Type: &networkingv1.IPReservation{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				ipReservation, ok := object.(*networkingv1.IPReservation)
+				if !ok {
+					return nil
+				}
+				return []reconcile.Request{
+					{
+						NamespacedName: types.NamespacedName{
+							Name: ipReservation.Spec.Subnet,
+						},
+					},
+				}
+			}),
+			builder.WithPredicates(
+				// subnet label will be patched after reservation
+				&predicate.LabelChangedPredicate{},
+			),
+		).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: r.Max(),
 		}).
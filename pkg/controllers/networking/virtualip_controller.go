@@ -0,0 +1,386 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/controllers/concurrency"
+	"github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/types"
+	"github.com/alibaba/hybridnet/pkg/utils/transform"
+)
+
+const ControllerVirtualIP = "VirtualIP"
+
+const (
+	ReasonVirtualIPAllocationSucceed = "VirtualIPAllocationSucceed"
+	ReasonVirtualIPFail              = "VirtualIPFail"
+	ReasonVirtualIPBound             = "VirtualIPBound"
+	ReasonVirtualIPUnbound           = "VirtualIPUnbound"
+)
+
+// VirtualIPReconciler reconciles a VirtualIP object
+type VirtualIPReconciler struct {
+	client.Client
+
+	Recorder record.EventRecorder
+
+	IPAMManager IPAMManager
+
+	concurrency.ControllerConcurrency
+}
+
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=virtualips,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=virtualips/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=networking.alibaba.com,resources=virtualips/finalizers,verbs=update
+
+func (r *VirtualIPReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	log := ctrllog.FromContext(ctx)
+
+	var virtualIP = &networkingv1.VirtualIP{}
+
+	defer func() {
+		if err != nil {
+			log.Error(err, "reconciliation fails")
+			if len(virtualIP.UID) > 0 {
+				r.Recorder.Event(virtualIP, corev1.EventTypeWarning, ReasonVirtualIPFail, err.Error())
+			}
+		}
+	}()
+
+	if err = r.Get(ctx, req.NamespacedName, virtualIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to fetch VirtualIP", client.IgnoreNotFound(err))
+	}
+
+	if !virtualIP.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, wrapError("unable to release virtual ip", r.release(ctx, virtualIP))
+	}
+
+	if err = r.addFinalizer(ctx, virtualIP); err != nil {
+		return ctrl.Result{}, wrapError("unable to add finalizer", err)
+	}
+
+	if len(virtualIP.Status.IP) == 0 {
+		if err = r.allocate(ctx, virtualIP); err != nil {
+			return ctrl.Result{}, wrapError("unable to allocate virtual ip", err)
+		}
+	}
+
+	return ctrl.Result{}, wrapError("unable to bind virtual ip", r.bind(ctx, virtualIP))
+}
+
+// allocate will allocate an address from the specified subnet for virtual ip
+func (r *VirtualIPReconciler) allocate(ctx context.Context, virtualIP *networkingv1.VirtualIP) (err error) {
+	subnet, err := utils.GetSubnet(r, virtualIP.Spec.Subnet)
+	if err != nil {
+		return fmt.Errorf("unable to get subnet %s: %v", virtualIP.Spec.Subnet, err)
+	}
+
+	var (
+		networkName  = subnet.Spec.Network
+		ownerName    = transform.VirtualIPOwnerName(virtualIP.Name)
+		ipFamilyMode = utils.ToIPFamilyMode(networkingv1.IsIPv6Subnet(subnet))
+		ip           *types.IP
+	)
+
+	var specifiedIP string
+	if len(virtualIP.Spec.Address) > 0 {
+		if parsedIP := net.ParseIP(virtualIP.Spec.Address); parsedIP != nil {
+			specifiedIP = parsedIP.String()
+		} else {
+			return fmt.Errorf("invalid address %s", virtualIP.Spec.Address)
+		}
+	}
+
+	if feature.DualStackEnabled() {
+		var ips []*types.IP
+		if len(specifiedIP) > 0 {
+			ips, err = r.IPAMManager.DualStack().Assign(ipFamilyMode, networkName, []string{subnet.Name}, []string{specifiedIP},
+				ownerName, virtualIP.Namespace, false)
+		} else {
+			ips, err = r.IPAMManager.DualStack().Allocate(ipFamilyMode, networkName, []string{subnet.Name}, ownerName, virtualIP.Namespace)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to allocate %s ip: %v", ipFamilyMode, err)
+		}
+		ip = ips[0]
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.DualStack().Release(ipFamilyMode, networkName, squashIPSliceToSubnets(ips), squashIPSliceToIPs(ips))
+			}
+		}()
+	} else {
+		if len(specifiedIP) > 0 {
+			ip, err = r.IPAMManager.Assign(networkName, subnet.Name, ownerName, virtualIP.Namespace, specifiedIP, false)
+		} else {
+			ip, err = r.IPAMManager.Allocate(networkName, subnet.Name, ownerName, virtualIP.Namespace)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to allocate ip: %v", err)
+		}
+		defer func() {
+			if err != nil {
+				_ = r.IPAMManager.Release(ip.Network, ip.Subnet, ip.Address.IP.String())
+			}
+		}()
+	}
+
+	if err = r.patchLabels(ctx, virtualIP, map[string]*string{
+		constants.LabelSubnet:  &ip.Subnet,
+		constants.LabelNetwork: &ip.Network,
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	var version = networkingv1.IPv4
+	if ip.IsIPv6() {
+		version = networkingv1.IPv6
+	}
+
+	if err = r.patchStatus(ctx, virtualIP, func(status *networkingv1.VirtualIPStatus) {
+		status.Network = ip.Network
+		status.Version = version
+		status.IP = ip.Address.IP.String()
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	r.Recorder.Eventf(virtualIP, corev1.EventTypeNormal, ReasonVirtualIPAllocationSucceed, "allocate IP %s successfully", ip.Address.IP.String())
+	return nil
+}
+
+// bind will bind virtual ip to one of the selected pods, the pod already bound
+// will be kept in priority to avoid unnecessary movement
+func (r *VirtualIPReconciler) bind(ctx context.Context, virtualIP *networkingv1.VirtualIP) (err error) {
+	selector, err := metav1.LabelSelectorAsSelector(virtualIP.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %v", err)
+	}
+
+	podList := &corev1.PodList{}
+	if err = r.List(ctx, podList, client.InNamespace(virtualIP.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return fmt.Errorf("unable to list pods: %v", err)
+	}
+
+	var candidates []*corev1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if r.bindable(pod, virtualIP.Status.Network) {
+			candidates = append(candidates, pod)
+		}
+	}
+
+	var target *corev1.Pod
+	for _, pod := range candidates {
+		if pod.Name == virtualIP.Status.PodName {
+			target = pod
+			break
+		}
+	}
+
+	if target == nil && len(candidates) > 0 {
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Name < candidates[j].Name
+		})
+		target = candidates[0]
+	}
+
+	var podName, nodeName string
+	if target != nil {
+		podName, nodeName = target.Name, target.Spec.NodeName
+	}
+
+	if podName == virtualIP.Status.PodName && nodeName == virtualIP.Status.NodeName {
+		return nil
+	}
+
+	var nodeLabel *string
+	if len(nodeName) > 0 {
+		nodeLabel = &nodeName
+	}
+	if err = r.patchLabels(ctx, virtualIP, map[string]*string{
+		constants.LabelNode: nodeLabel,
+	}); err != nil {
+		return fmt.Errorf("unable to patch labels: %v", err)
+	}
+
+	if err = r.patchStatus(ctx, virtualIP, func(status *networkingv1.VirtualIPStatus) {
+		status.PodName = podName
+		status.NodeName = nodeName
+	}); err != nil {
+		return fmt.Errorf("unable to patch status: %v", err)
+	}
+
+	if target == nil {
+		r.Recorder.Eventf(virtualIP, corev1.EventTypeNormal, ReasonVirtualIPUnbound, "unbind IP %s since no pod available", virtualIP.Status.IP)
+		return nil
+	}
+
+	r.Recorder.Eventf(virtualIP, corev1.EventTypeNormal, ReasonVirtualIPBound, "bind IP %s to pod %s on node %s", virtualIP.Status.IP, podName, nodeName)
+	return nil
+}
+
+// bindable checks whether pod is ready for taking over a virtual ip of the network
+func (r *VirtualIPReconciler) bindable(pod *corev1.Pod, networkName string) bool {
+	if pod.DeletionTimestamp != nil || pod.Spec.HostNetwork || pod.Status.Phase != corev1.PodRunning ||
+		!utils.PodIsReady(pod) || len(pod.Spec.NodeName) == 0 || !metav1.HasAnnotation(pod.ObjectMeta, constants.AnnotationIP) {
+		return false
+	}
+
+	ips, err := utils.ListAllocatedIPInstancesOfPod(r, pod)
+	if err != nil {
+		return false
+	}
+
+	for _, ip := range ips {
+		if ip.Spec.Network == networkName {
+			return true
+		}
+	}
+	return false
+}
+
+// release will recycle the address of virtual ip and remove the finalizer
+func (r *VirtualIPReconciler) release(ctx context.Context, virtualIP *networkingv1.VirtualIP) (err error) {
+	if !controllerutil.ContainsFinalizer(virtualIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	if len(virtualIP.Status.IP) > 0 {
+		if feature.DualStackEnabled() {
+			err = r.IPAMManager.DualStack().Release(utils.ToIPFamilyMode(virtualIP.Status.Version == networkingv1.IPv6),
+				virtualIP.Status.Network,
+				[]string{
+					virtualIP.Spec.Subnet,
+				},
+				[]string{
+					virtualIP.Status.IP,
+				},
+			)
+		} else {
+			err = r.IPAMManager.Release(virtualIP.Status.Network, virtualIP.Spec.Subnet, virtualIP.Status.IP)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to release ip %s: %v", virtualIP.Status.IP, err)
+		}
+	}
+
+	patch := client.MergeFrom(virtualIP.DeepCopy())
+	controllerutil.RemoveFinalizer(virtualIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, virtualIP, patch)
+	})
+}
+
+func (r *VirtualIPReconciler) addFinalizer(ctx context.Context, virtualIP *networkingv1.VirtualIP) error {
+	if controllerutil.ContainsFinalizer(virtualIP, constants.FinalizerIPAllocated) {
+		return nil
+	}
+
+	patch := client.MergeFrom(virtualIP.DeepCopy())
+	controllerutil.AddFinalizer(virtualIP, constants.FinalizerIPAllocated)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, virtualIP, patch)
+	})
+}
+
+// patchLabels will patch labels of virtual ip, labels with nil value will be removed
+func (r *VirtualIPReconciler) patchLabels(ctx context.Context, virtualIP *networkingv1.VirtualIP, labels map[string]*string) error {
+	patch := client.MergeFrom(virtualIP.DeepCopy())
+	for key, value := range labels {
+		if value == nil {
+			delete(virtualIP.Labels, key)
+			continue
+		}
+		if virtualIP.Labels == nil {
+			virtualIP.Labels = map[string]string{}
+		}
+		virtualIP.Labels[key] = *value
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Patch(ctx, virtualIP, patch)
+	})
+}
+
+// patchStatus will patch status of virtual ip with the mutation
+func (r *VirtualIPReconciler) patchStatus(ctx context.Context, virtualIP *networkingv1.VirtualIP, mutate func(status *networkingv1.VirtualIPStatus)) error {
+	patch := client.MergeFrom(virtualIP.DeepCopy())
+	mutate(&virtualIP.Status)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return r.Status().Patch(ctx, virtualIP, patch)
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VirtualIPReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(ControllerVirtualIP).
+		For(&networkingv1.VirtualIP{},
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		Watches(&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+				// selectors of virtual ips may match pod with labels before or after updating,
+				// so all the virtual ips in namespace will be checked
+				virtualIPList, err := utils.ListVirtualIPs(r, client.InNamespace(object.GetNamespace()))
+				if err != nil {
+					return nil
+				}
+
+				var requests []reconcile.Request
+				for i := range virtualIPList.Items {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: apitypes.NamespacedName{
+							Namespace: virtualIPList.Items[i].Namespace,
+							Name:      virtualIPList.Items[i].Name,
+						},
+					})
+				}
+				return requests
+			}),
+			builder.WithPredicates(
+				&predicate.ResourceVersionChangedPredicate{},
+			)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Max(),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,254 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package networking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestVirtualIPPod(name, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{constants.AnnotationIP: "192.168.0.10"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodReady,
+					Status: corev1.ConditionTrue,
+				},
+			},
+		},
+	}
+}
+
+func newTestVirtualIP(podName, nodeName string) *networkingv1.VirtualIP {
+	virtualIP := &networkingv1.VirtualIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+		},
+		Spec: networkingv1.VirtualIPSpec{
+			Subnet:   "subnet1",
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+		Status: networkingv1.VirtualIPStatus{
+			Network:  "network1",
+			IP:       "192.168.0.100",
+			PodName:  podName,
+			NodeName: nodeName,
+		},
+	}
+	if len(nodeName) > 0 {
+		virtualIP.Labels = map[string]string{constants.LabelNode: nodeName}
+	}
+	return virtualIP
+}
+
+func newTestVirtualIPReconciler(t *testing.T, objects ...client.Object) *VirtualIPReconciler {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	return &VirtualIPReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+}
+
+func TestVirtualIPBindable(t *testing.T) {
+	deletionTime := metav1.Now()
+
+	tests := []struct {
+		name     string
+		mutate   func(pod *corev1.Pod)
+		expected bool
+	}{
+		{
+			name:     "ready pod of the network",
+			mutate:   func(pod *corev1.Pod) {},
+			expected: true,
+		},
+		{
+			name: "deleting pod",
+			mutate: func(pod *corev1.Pod) {
+				pod.DeletionTimestamp = &deletionTime
+			},
+			expected: false,
+		},
+		{
+			name: "host network pod",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.HostNetwork = true
+			},
+			expected: false,
+		},
+		{
+			name: "pending pod",
+			mutate: func(pod *corev1.Pod) {
+				pod.Status.Phase = corev1.PodPending
+			},
+			expected: false,
+		},
+		{
+			name: "not ready pod",
+			mutate: func(pod *corev1.Pod) {
+				pod.Status.Conditions[0].Status = corev1.ConditionFalse
+			},
+			expected: false,
+		},
+		{
+			name: "unscheduled pod",
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.NodeName = ""
+			},
+			expected: false,
+		},
+		{
+			name: "pod without ip annotation",
+			mutate: func(pod *corev1.Pod) {
+				pod.Annotations = nil
+			},
+			expected: false,
+		},
+		{
+			name: "pod without ip instance",
+			mutate: func(pod *corev1.Pod) {
+				pod.Name = "web-noip"
+			},
+			expected: false,
+		},
+		{
+			name: "pod of other network",
+			mutate: func(pod *corev1.Pod) {
+				pod.Name = "web-other"
+			},
+			expected: false,
+		},
+	}
+
+	r := newTestVirtualIPReconciler(t,
+		newTestPodIPInstance("web-1", "network1"),
+		newTestPodIPInstance("web-other", "network2"),
+	)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := newTestVirtualIPPod("web-1", "node1")
+			test.mutate(pod)
+			assert.Equal(t, test.expected, r.bindable(pod, "network1"))
+		})
+	}
+}
+
+func TestVirtualIPBind(t *testing.T) {
+	notReadyPod := newTestVirtualIPPod("web-0", "node1")
+	notReadyPod.Status.Conditions[0].Status = corev1.ConditionFalse
+
+	tests := []struct {
+		name         string
+		virtualIP    *networkingv1.VirtualIP
+		pods         []client.Object
+		expectedPod  string
+		expectedNode string
+	}{
+		{
+			name:      "bind to the first ready pod by name",
+			virtualIP: newTestVirtualIP("", ""),
+			pods: []client.Object{
+				notReadyPod,
+				newTestVirtualIPPod("web-2", "node2"),
+				newTestVirtualIPPod("web-1", "node1"),
+			},
+			expectedPod:  "web-1",
+			expectedNode: "node1",
+		},
+		{
+			name:      "keep the bound pod",
+			virtualIP: newTestVirtualIP("web-2", "node2"),
+			pods: []client.Object{
+				newTestVirtualIPPod("web-1", "node1"),
+				newTestVirtualIPPod("web-2", "node2"),
+			},
+			expectedPod:  "web-2",
+			expectedNode: "node2",
+		},
+		{
+			name:      "move away from the not ready pod",
+			virtualIP: newTestVirtualIP("web-0", "node1"),
+			pods: []client.Object{
+				notReadyPod,
+				newTestVirtualIPPod("web-2", "node2"),
+			},
+			expectedPod:  "web-2",
+			expectedNode: "node2",
+		},
+		{
+			name:      "unbind if no pod is ready",
+			virtualIP: newTestVirtualIP("web-0", "node1"),
+			pods: []client.Object{
+				notReadyPod,
+			},
+			expectedPod:  "",
+			expectedNode: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := append([]client.Object{
+				test.virtualIP,
+				newTestPodIPInstance("web-0", "network1"),
+				newTestPodIPInstance("web-1", "network1"),
+				newTestPodIPInstance("web-2", "network1"),
+			}, test.pods...)
+			r := newTestVirtualIPReconciler(t, objects...)
+
+			key := apitypes.NamespacedName{Namespace: "default", Name: "web"}
+			virtualIP := &networkingv1.VirtualIP{}
+			assert.NoError(t, r.Get(context.Background(), key, virtualIP))
+			assert.NoError(t, r.bind(context.Background(), virtualIP))
+
+			updated := &networkingv1.VirtualIP{}
+			assert.NoError(t, r.Get(context.Background(), key, updated))
+			assert.Equal(t, test.expectedPod, updated.Status.PodName)
+			assert.Equal(t, test.expectedNode, updated.Status.NodeName)
+			assert.Equal(t, test.expectedNode, updated.Labels[constants.LabelNode])
+			assert.Equal(t, "192.168.0.100", updated.Status.IP)
+		})
+	}
+}
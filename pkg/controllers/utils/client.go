@@ -69,6 +69,38 @@ func ListIPInstances(client client.Reader, opts ...client.ListOption) (*networki
 	return &ipList, nil
 }
 
+func ListVirtualIPs(client client.Reader, opts ...client.ListOption) (*networkingv1.VirtualIPList, error) {
+	var virtualIPList = networkingv1.VirtualIPList{}
+	if err := client.List(context.TODO(), &virtualIPList, opts...); err != nil {
+		return nil, err
+	}
+	return &virtualIPList, nil
+}
+
+func ListLoadBalancerIPs(client client.Reader, opts ...client.ListOption) (*networkingv1.LoadBalancerIPList, error) {
+	var loadBalancerIPList = networkingv1.LoadBalancerIPList{}
+	if err := client.List(context.TODO(), &loadBalancerIPList, opts...); err != nil {
+		return nil, err
+	}
+	return &loadBalancerIPList, nil
+}
+
+func ListAnycastIPs(client client.Reader, opts ...client.ListOption) (*networkingv1.AnycastIPList, error) {
+	var anycastIPList = networkingv1.AnycastIPList{}
+	if err := client.List(context.TODO(), &anycastIPList, opts...); err != nil {
+		return nil, err
+	}
+	return &anycastIPList, nil
+}
+
+func ListIPReservations(client client.Reader, opts ...client.ListOption) (*networkingv1.IPReservationList, error) {
+	var ipReservationList = networkingv1.IPReservationList{}
+	if err := client.List(context.TODO(), &ipReservationList, opts...); err != nil {
+		return nil, err
+	}
+	return &ipReservationList, nil
+}
+
 func ListNodesToNames(client client.Reader, opts ...client.ListOption) ([]string, error) {
 	var nodeList = corev1.NodeList{}
 	if err := client.List(context.TODO(), &nodeList, opts...); err != nil {
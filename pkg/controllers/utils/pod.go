@@ -42,3 +42,12 @@ func PodIsCompleted(pod *v1.Pod) bool {
 
 	return pod.Status.Phase == v1.PodSucceeded && unknownContainerCount == 0
 }
+
+func PodIsReady(pod *v1.Pod) bool {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == v1.PodReady {
+			return pod.Status.Conditions[i].Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -17,6 +17,7 @@
 package arp
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"time"
@@ -24,7 +25,10 @@ import (
 	"github.com/mdlayher/ethernet"
 )
 
-// CheckWithTimeout checks vlan network environment and duplicate ip problems,
+// probeNum is the number of arp probes sent for an ip, as PROBE_NUM in RFC 5227.
+const probeNum = 3
+
+// CheckWithTimeout checks vlan network environment and announces the pod ip,
 // timeout parameter determines how long this function will exactly last.
 func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.Duration) error {
 	// Resolve gateway ip for vlan check.
@@ -34,14 +38,6 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 			srcPod.String(), gateway.String(), err, ifi.Name)
 	}
 
-	// Resolve src pod ip for duplicate ip check and send gratuitous arp.
-	// Src ip should be 0.0.0.0 for arp probe.
-	if duplicatedHw, err := pingOverInterface(net.ParseIP("0.0.0.0"), srcPod, ifi, timeout); err == nil {
-		return fmt.Errorf("pod ip %v duplicated"+
-			", please check if ip %v is occupied by other machines or containers, another hw addr is %v",
-			srcPod.String(), srcPod.String(), duplicatedHw.String())
-	}
-
 	// Send gratuitous arp to ensure remote neigh cache flushed.
 	if err := gratuitousOverInterface(srcPod, ifi); err != nil {
 		return fmt.Errorf("failed to send gratuitous arp for pod %v: %v", srcPod.String(), err)
@@ -50,6 +46,79 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 	return nil
 }
 
+// SendGratuitous sends gratuitous arp of ip over interface, to make remote neigh
+// cache point to the interface.
+func SendGratuitous(ifi *net.Interface, ip net.IP) error {
+	return gratuitousOverInterface(ip, ifi)
+}
+
+// Probe sends arp probes (RFC 5227) of ip over interface in timeout, and returns the hw addr
+// of the conflicting host if ip is in use. A nil hw addr means no conflict is found. Packets
+// sent from the interface itself or any of the ignored hw addrs never indicate a conflict.
+func Probe(ifi *net.Interface, ip net.IP, timeout time.Duration, ignoredHwAddrs []net.HardwareAddr) (net.HardwareAddr, error) {
+	// Src ip should be 0.0.0.0 for arp probe, so that the neigh caches of others will not be polluted.
+	client, err := Dial(ifi, net.IPv4zero)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init client with interface %v: %v", ifi.Name, err)
+	}
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	probe, err := NewPacket(OperationRequest, ifi.HardwareAddr, net.IPv4zero,
+		make(net.HardwareAddr, len(ifi.HardwareAddr)), ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed create arp probe packet: %v", err)
+	}
+
+	ignoredHwAddrs = append([]net.HardwareAddr{ifi.HardwareAddr}, ignoredHwAddrs...)
+
+	interval := timeout / probeNum
+	for i := 0; i < probeNum; i++ {
+		if err := client.WriteTo(probe, ethernet.Broadcast); err != nil {
+			return nil, fmt.Errorf("failed to send arp probe: %v", err)
+		}
+
+		if err := client.SetReadDeadline(time.Now().Add(interval)); err != nil {
+			return nil, fmt.Errorf("set arp client read dead line error: %v", err)
+		}
+
+		for {
+			packet, _, err := client.Read()
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, fmt.Errorf("failed to read arp packet: %v", err)
+			}
+
+			if isProbeConflict(packet, ip, ignoredHwAddrs) {
+				return packet.SenderHardwareAddr, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// isProbeConflict checks whether an arp packet received in probing indicates that ip is used by
+// others, which is either an arp packet sent from ip or a probe for ip from another host.
+func isProbeConflict(packet *Packet, ip net.IP, ignoredHwAddrs []net.HardwareAddr) bool {
+	for _, hwAddr := range ignoredHwAddrs {
+		if bytes.Equal(packet.SenderHardwareAddr, hwAddr) {
+			return false
+		}
+	}
+
+	if packet.SenderIP.Equal(ip) {
+		return true
+	}
+
+	return packet.Operation == OperationRequest && packet.SenderIP.Equal(net.IPv4zero) &&
+		packet.TargetIP.Equal(ip)
+}
+
 func pingOverInterface(srcIP, dstIP net.IP, iif *net.Interface, timeout time.Duration) (net.HardwareAddr, error) {
 	client, err := Dial(iif, srcIP)
 	if err != nil {
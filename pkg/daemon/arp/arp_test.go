@@ -0,0 +1,115 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package arp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsProbeConflict(t *testing.T) {
+	localHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x01}
+	remoteHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x02}
+	nodeHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x03}
+	ip := net.ParseIP("192.168.0.10").To4()
+	otherIP := net.ParseIP("192.168.0.11").To4()
+
+	tests := []struct {
+		name     string
+		packet   *Packet
+		conflict bool
+	}{
+		{
+			name: "reply from ip",
+			packet: &Packet{
+				Operation:          OperationReply,
+				SenderHardwareAddr: remoteHW,
+				SenderIP:           ip,
+				TargetIP:           net.IPv4zero,
+			},
+			conflict: true,
+		},
+		{
+			name: "request from ip",
+			packet: &Packet{
+				Operation:          OperationRequest,
+				SenderHardwareAddr: remoteHW,
+				SenderIP:           ip,
+				TargetIP:           otherIP,
+			},
+			conflict: true,
+		},
+		{
+			name: "probe for ip from another host",
+			packet: &Packet{
+				Operation:          OperationRequest,
+				SenderHardwareAddr: remoteHW,
+				SenderIP:           net.IPv4zero,
+				TargetIP:           ip,
+			},
+			conflict: true,
+		},
+		{
+			name: "probe sent by self",
+			packet: &Packet{
+				Operation:          OperationRequest,
+				SenderHardwareAddr: localHW,
+				SenderIP:           net.IPv4zero,
+				TargetIP:           ip,
+			},
+			conflict: false,
+		},
+		{
+			name: "reply from another node",
+			packet: &Packet{
+				Operation:          OperationReply,
+				SenderHardwareAddr: nodeHW,
+				SenderIP:           ip,
+				TargetIP:           net.IPv4zero,
+			},
+			conflict: false,
+		},
+		{
+			name: "probe for other ip",
+			packet: &Packet{
+				Operation:          OperationRequest,
+				SenderHardwareAddr: remoteHW,
+				SenderIP:           net.IPv4zero,
+				TargetIP:           otherIP,
+			},
+			conflict: false,
+		},
+		{
+			name: "request for ip from other ip",
+			packet: &Packet{
+				Operation:          OperationRequest,
+				SenderHardwareAddr: remoteHW,
+				SenderIP:           otherIP,
+				TargetIP:           ip,
+			},
+			conflict: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if conflict := isProbeConflict(test.packet, ip, []net.HardwareAddr{localHW, nodeHW}); conflict != test.conflict {
+				t.Errorf("expected conflict %v, got %v", test.conflict, conflict)
+			}
+		})
+	}
+}
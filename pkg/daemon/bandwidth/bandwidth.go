@@ -0,0 +1,313 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bandwidth
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+)
+
+const (
+	IfbLinkPrefix = "ifb"
+
+	// latency of tbf qdisc, the same as the bandwidth plugin of cni
+	latencyInMillis = 25
+
+	// burst of tbf qdisc is the traffic allowed to be sent in burstDurationInMillis at the limited rate
+	burstDurationInMillis = 10
+	minBurstInBytes       = 64 * 1024
+)
+
+// Limits is the bandwidth limits of a pod in bits per second, zero means no limit.
+// Ingress and egress are from the view of pod.
+type Limits struct {
+	Ingress uint64
+	Egress  uint64
+}
+
+// GetPodBandwidthLimits returns the bandwidth limits of pod, bandwidth annotations of pod take
+// precedence over the defaults of subnets. If the subnets of a dual-stack pod have different
+// defaults, the smaller one will be used.
+func GetPodBandwidthLimits(pod *corev1.Pod, subnets []*networkingv1.Subnet) (*Limits, error) {
+	limits := &Limits{}
+
+	for _, subnet := range subnets {
+		ingress, egress := networkingv1.GetSubnetBandwidth(&subnet.Spec)
+		if err := mergeLimit(&limits.Ingress, ingress); err != nil {
+			return nil, fmt.Errorf("invalid ingress bandwidth of subnet %v: %v", subnet.Name, err)
+		}
+		if err := mergeLimit(&limits.Egress, egress); err != nil {
+			return nil, fmt.Errorf("invalid egress bandwidth of subnet %v: %v", subnet.Name, err)
+		}
+	}
+
+	var err error
+	if ingress, exist := pod.Annotations[constants.AnnotationIngressBandwidth]; exist {
+		if limits.Ingress, err = networkingv1.ParseBandwidth(ingress); err != nil {
+			return nil, fmt.Errorf("invalid annotation %v: %v", constants.AnnotationIngressBandwidth, err)
+		}
+	}
+	if egress, exist := pod.Annotations[constants.AnnotationEgressBandwidth]; exist {
+		if limits.Egress, err = networkingv1.ParseBandwidth(egress); err != nil {
+			return nil, fmt.Errorf("invalid annotation %v: %v", constants.AnnotationEgressBandwidth, err)
+		}
+	}
+
+	return limits, nil
+}
+
+// EnsurePodBandwidth configures tc rules on the host nic of pod to shape its traffic. Ingress traffic
+// of pod is shaped by a tbf qdisc on the host nic, and egress traffic of pod is redirected to an ifb
+// device which has a tbf qdisc on it. Rules of zero limits will be removed.
+func EnsurePodBandwidth(hostNicName string, limits *Limits) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	if limits.Ingress != 0 {
+		if err = ensureTbf(hostLink, limits.Ingress); err != nil {
+			return fmt.Errorf("failed to ensure tbf qdisc on %v: %v", hostNicName, err)
+		}
+	} else if err = deleteTbf(hostLink); err != nil {
+		return fmt.Errorf("failed to delete tbf qdisc on %v: %v", hostNicName, err)
+	}
+
+	ifbName := GenerateIfbName(hostNicName)
+	if limits.Egress != 0 {
+		ifbLink, err := ensureIfb(ifbName, hostLink.Attrs().MTU)
+		if err != nil {
+			return fmt.Errorf("failed to ensure ifb device %v: %v", ifbName, err)
+		}
+
+		if err = ensureTbf(ifbLink, limits.Egress); err != nil {
+			return fmt.Errorf("failed to ensure tbf qdisc on %v: %v", ifbName, err)
+		}
+
+		if err = ensureIngressRedirect(hostLink, ifbLink); err != nil {
+			return fmt.Errorf("failed to redirect ingress traffic of %v to %v: %v", hostNicName, ifbName, err)
+		}
+		return nil
+	}
+
+	if err = deleteIngressQdisc(hostLink); err != nil {
+		return fmt.Errorf("failed to delete ingress qdisc on %v: %v", hostNicName, err)
+	}
+	return deleteIfb(ifbName)
+}
+
+// ClearPodBandwidth removes the ifb device of pod, tc rules on host nic will be removed
+// along with the host nic itself.
+func ClearPodBandwidth(hostNicName string) error {
+	return deleteIfb(GenerateIfbName(hostNicName))
+}
+
+// GenerateIfbName generates ifb device name with the same hash of host nic name.
+func GenerateIfbName(hostNicName string) string {
+	return IfbLinkPrefix + strings.TrimPrefix(hostNicName, containernetwork.ContainerHostLinkPrefix)
+}
+
+// tbfParams returns the rate and burst of tbf qdisc in bytes, the limit of queue in bytes,
+// and the time to send burst at the rate in microseconds, the last two are clamped to uint32.
+func tbfParams(rateInBits uint64) (rateInBytes, burstInBytes uint64, limitInBytes, burstTimeInUsec uint32) {
+	rateInBytes = rateInBits / 8
+	if rateInBytes == 0 {
+		rateInBytes = 1
+	}
+
+	burstInBytes = rateInBytes * burstDurationInMillis / 1000
+	if burstInBytes < minBurstInBytes {
+		burstInBytes = minBurstInBytes
+	}
+
+	limitInBytes = clampUint32(rateInBytes*latencyInMillis/1000 + burstInBytes)
+	burstTimeInUsec = clampUint32(uint64(float64(burstInBytes) * float64(netlink.TIME_UNITS_PER_SEC) / float64(rateInBytes)))
+	return
+}
+
+func ensureTbf(link netlink.Link, rateInBits uint64) error {
+	rateInBytes, _, limitInBytes, burstTimeInUsec := tbfParams(rateInBits)
+	bufferInTicks := time2Tick(burstTimeInUsec)
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateInBytes,
+		Limit:  limitInBytes,
+		Buffer: bufferInTicks,
+	}
+
+	if err := netlink.QdiscReplace(qdisc); err != nil {
+		return fmt.Errorf("failed to replace qdisc %v: %v", qdisc.String(), err)
+	}
+	return nil
+}
+
+func deleteTbf(link netlink.Link) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs: %v", err)
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() == "tbf" && qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+			if err = netlink.QdiscDel(qdisc); err != nil {
+				return fmt.Errorf("failed to delete %v qdisc: %v", qdisc.Type(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func ensureIfb(ifbName string, mtu int) (netlink.Link, error) {
+	link, err := netlink.LinkByName(ifbName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return nil, fmt.Errorf("failed to get link: %v", err)
+		}
+
+		if err = netlink.LinkAdd(&netlink.Ifb{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:  ifbName,
+				Flags: net.FlagUp,
+				MTU:   mtu,
+			},
+		}); err != nil {
+			return nil, fmt.Errorf("failed to add link: %v", err)
+		}
+
+		if link, err = netlink.LinkByName(ifbName); err != nil {
+			return nil, fmt.Errorf("failed to get link: %v", err)
+		}
+	}
+
+	if link.Attrs().MTU != mtu {
+		if err = netlink.LinkSetMTU(link, mtu); err != nil {
+			return nil, fmt.Errorf("failed to set mtu: %v", err)
+		}
+	}
+
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err = netlink.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to set link up: %v", err)
+		}
+	}
+
+	return link, nil
+}
+
+func deleteIfb(ifbName string) error {
+	link, err := netlink.LinkByName(ifbName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return fmt.Errorf("failed to get ifb device %v: %v", ifbName, err)
+	}
+
+	if err = netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete ifb device %v: %v", ifbName, err)
+	}
+	return nil
+}
+
+func ensureIngressRedirect(hostLink, ifbLink netlink.Link) error {
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: hostLink.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+
+	if err := netlink.QdiscReplace(ingress); err != nil {
+		return fmt.Errorf("failed to replace ingress qdisc: %v", err)
+	}
+
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: hostLink.Attrs().Index,
+			Parent:    ingress.QdiscAttrs.Handle,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		// RedirIndex is not used since it will be converted to another mirred action
+		ClassId: netlink.MakeHandle(1, 1),
+		Actions: []netlink.Action{netlink.NewMirredAction(ifbLink.Attrs().Index)},
+	}
+
+	if err := netlink.FilterReplace(filter); err != nil {
+		return fmt.Errorf("failed to replace redirect filter: %v", err)
+	}
+	return nil
+}
+
+func deleteIngressQdisc(link netlink.Link) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs: %v", err)
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() == "ingress" {
+			if err = netlink.QdiscDel(qdisc); err != nil {
+				return fmt.Errorf("failed to delete %v qdisc: %v", qdisc.Type(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func mergeLimit(current *uint64, bandwidth string) error {
+	if len(bandwidth) == 0 {
+		return nil
+	}
+
+	limit, err := networkingv1.ParseBandwidth(bandwidth)
+	if err != nil {
+		return err
+	}
+
+	if *current == 0 || limit < *current {
+		*current = limit
+	}
+	return nil
+}
+
+func time2Tick(time uint32) uint32 {
+	return clampUint32(uint64(float64(time) * netlink.TickInUsec()))
+}
+
+func clampUint32(value uint64) uint32 {
+	if value > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(value)
+}
@@ -0,0 +1,134 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bandwidth
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTbfParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		rateInBits      uint64
+		rateInBytes     uint64
+		burstInBytes    uint64
+		limitInBytes    uint32
+		burstTimeInUsec uint32
+	}{
+		{
+			name:            "minimum burst",
+			rateInBits:      8 * 1000 * 1000,
+			rateInBytes:     1000 * 1000,
+			burstInBytes:    minBurstInBytes,
+			limitInBytes:    25*1000 + minBurstInBytes,
+			burstTimeInUsec: 65536,
+		},
+		{
+			name:            "burst of rate",
+			rateInBits:      8 * 1000 * 1000 * 1000,
+			rateInBytes:     1000 * 1000 * 1000,
+			burstInBytes:    10 * 1000 * 1000,
+			limitInBytes:    25*1000*1000 + 10*1000*1000,
+			burstTimeInUsec: 10 * 1000,
+		},
+		{
+			name:            "limit clamped for high rate",
+			rateInBits:      8 * 1000 * 1000 * 1000 * 1000,
+			rateInBytes:     1000 * 1000 * 1000 * 1000,
+			burstInBytes:    10 * 1000 * 1000 * 1000,
+			limitInBytes:    math.MaxUint32,
+			burstTimeInUsec: 10 * 1000,
+		},
+		{
+			name:            "burst time clamped for low rate",
+			rateInBits:      8,
+			rateInBytes:     1,
+			burstInBytes:    minBurstInBytes,
+			limitInBytes:    minBurstInBytes,
+			burstTimeInUsec: math.MaxUint32,
+		},
+		{
+			name:            "rate less than one byte",
+			rateInBits:      1,
+			rateInBytes:     1,
+			burstInBytes:    minBurstInBytes,
+			limitInBytes:    minBurstInBytes,
+			burstTimeInUsec: math.MaxUint32,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rateInBytes, burstInBytes, limitInBytes, burstTimeInUsec := tbfParams(test.rateInBits)
+			assert.Equal(t, test.rateInBytes, rateInBytes)
+			assert.Equal(t, test.burstInBytes, burstInBytes)
+			assert.Equal(t, test.limitInBytes, limitInBytes)
+			assert.Equal(t, test.burstTimeInUsec, burstTimeInUsec)
+		})
+	}
+}
+
+func TestMergeLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   uint64
+		bandwidth string
+		expected  uint64
+		wantErr   bool
+	}{
+		{
+			name:      "empty bandwidth",
+			current:   1000,
+			bandwidth: "",
+			expected:  1000,
+		},
+		{
+			name:      "no current limit",
+			current:   0,
+			bandwidth: "10M",
+			expected:  10 * 1000 * 1000,
+		},
+		{
+			name:      "smaller limit wins",
+			current:   1000 * 1000,
+			bandwidth: "10M",
+			expected:  1000 * 1000,
+		},
+		{
+			name:      "invalid bandwidth",
+			current:   0,
+			bandwidth: "abc",
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := test.current
+			err := mergeLimit(&current, test.bandwidth)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, current)
+		})
+	}
+}
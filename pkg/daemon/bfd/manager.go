@@ -0,0 +1,434 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bfd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/sys/unix"
+
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const (
+	// ControlPort is the udp destination port of single-hop bfd control packets (RFC 5881).
+	ControlPort = 3784
+
+	// source port of control packets must be in this range
+	minSourcePort = 49152
+	maxSourcePort = 65535
+
+	// single-hop control packets are sent and received with ttl/hop limit 255
+	ttl = 255
+
+	receiveBufferLen = 1024
+	sessionQueueLen  = 16
+)
+
+// StateChangeFunc is called when the state of bfd session with a peer changes.
+type StateChangeFunc func(peer string, state State, diagnostic Diagnostic)
+
+// Manager runs single-hop bfd sessions in asynchronous mode with peers.
+type Manager struct {
+	logger        logr.Logger
+	onStateChange StateChangeFunc
+
+	mutex     sync.Mutex
+	sessions  map[string]*sessionRunner
+	listeners map[string]*net.UDPConn
+}
+
+func NewManager(onStateChange StateChangeFunc, logger logr.Logger) *Manager {
+	return &Manager{
+		logger:        logger,
+		onStateChange: onStateChange,
+		sessions:      map[string]*sessionRunner{},
+		listeners:     map[string]*net.UDPConn{},
+	}
+}
+
+// SyncSessions makes bfd sessions the same as configs, which is a map from peer address to session config.
+// Session will be recreated if its config changes.
+func (m *Manager) SyncSessions(configs map[string]SessionConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for peer, runner := range m.sessions {
+		if config, exist := configs[peer]; !exist || config != runner.session.config {
+			runner.stop()
+			delete(m.sessions, peer)
+			metrics.BFDSessionStateGauge.DeleteLabelValues(peer)
+		}
+	}
+
+	for peer, config := range configs {
+		if _, exist := m.sessions[peer]; exist {
+			continue
+		}
+
+		peerIP := net.ParseIP(peer)
+		if peerIP == nil {
+			return fmt.Errorf("invalid bfd peer address %v", peer)
+		}
+
+		if err := m.ensureListener(peerIP); err != nil {
+			return err
+		}
+
+		runner, err := m.newSessionRunner(peerIP, config)
+		if err != nil {
+			return fmt.Errorf("failed to create bfd session with %v: %v", peer, err)
+		}
+
+		m.sessions[peer] = runner
+		metrics.BFDSessionStateGauge.WithLabelValues(peer).Set(float64(StateDown))
+		go runner.run()
+	}
+
+	if len(m.sessions) == 0 {
+		for network, listener := range m.listeners {
+			_ = listener.Close()
+			delete(m.listeners, network)
+		}
+	}
+
+	return nil
+}
+
+// GetState returns the state of bfd session with peer, and whether the session exists.
+func (m *Manager) GetState(peer string) (State, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	runner, exist := m.sessions[peer]
+	if !exist {
+		return StateAdminDown, false
+	}
+	return runner.getState(), true
+}
+
+func (m *Manager) ensureListener(peerIP net.IP) error {
+	network := udpNetwork(peerIP)
+	if _, exist := m.listeners[network]; exist {
+		return nil
+	}
+
+	listenConfig := net.ListenConfig{Control: socketControl(network, true)}
+	packetConn, err := listenConfig.ListenPacket(context.Background(), network, fmt.Sprintf(":%d", ControlPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on bfd control port: %v", err)
+	}
+
+	listener := packetConn.(*net.UDPConn)
+	m.listeners[network] = listener
+	go m.receiveLoop(listener)
+
+	return nil
+}
+
+func (m *Manager) receiveLoop(listener *net.UDPConn) {
+	buf := make([]byte, receiveBufferLen)
+	oob := make([]byte, unix.CmsgSpace(4))
+
+	for {
+		n, oobn, _, addr, err := listener.ReadMsgUDP(buf, oob)
+		if err != nil {
+			// listener is closed
+			return
+		}
+
+		// packets which might be forwarded by routers should be dropped (GTSM)
+		if receivedTTL, ok := parseTTL(oob[:oobn]); ok && receivedTTL != ttl {
+			continue
+		}
+
+		packet := &ControlPacket{}
+		if err := packet.UnmarshalBinary(buf[:n]); err != nil {
+			continue
+		}
+
+		if runner := m.selectSession(packet, addr.IP); runner != nil {
+			runner.receive(packet)
+		}
+	}
+}
+
+// selectSession selects session by your discriminator, or by source address if it's zero.
+func (m *Manager) selectSession(packet *ControlPacket, source net.IP) *sessionRunner {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, runner := range m.sessions {
+		if packet.YourDiscriminator != 0 {
+			if runner.session.localDiscriminator == packet.YourDiscriminator {
+				return runner
+			}
+		} else if runner.session.peer.Equal(source) {
+			return runner
+		}
+	}
+	return nil
+}
+
+func (m *Manager) newSessionRunner(peerIP net.IP, config SessionConfig) (*sessionRunner, error) {
+	discriminator, err := m.allocateDiscriminator()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialPeer(peerIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionRunner{
+		session: newSession(peerIP, config, discriminator),
+		conn:    conn,
+		rxCh:    make(chan *ControlPacket, sessionQueueLen),
+		stopCh:  make(chan struct{}),
+		onStateChange: func(state State, diagnostic Diagnostic) {
+			metrics.BFDSessionStateGauge.WithLabelValues(peerIP.String()).Set(float64(state))
+			m.logger.Info("bfd session state changed", "peer", peerIP.String(),
+				"state", state.String(), "diagnostic", diagnostic)
+			if m.onStateChange != nil {
+				m.onStateChange(peerIP.String(), state, diagnostic)
+			}
+		},
+		logger: m.logger.WithValues("peer", peerIP.String()),
+	}, nil
+}
+
+func (m *Manager) allocateDiscriminator() (uint32, error) {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return 0, fmt.Errorf("failed to generate discriminator: %v", err)
+		}
+
+		discriminator := binary.BigEndian.Uint32(b)
+		if discriminator == 0 {
+			continue
+		}
+
+		duplicated := false
+		for _, runner := range m.sessions {
+			if runner.session.localDiscriminator == discriminator {
+				duplicated = true
+				break
+			}
+		}
+
+		if !duplicated {
+			return discriminator, nil
+		}
+	}
+}
+
+// dialPeer creates the udp socket to send control packets to peer, from a random port in range of
+// 49152 to 65535.
+func dialPeer(peerIP net.IP) (*net.UDPConn, error) {
+	network := udpNetwork(peerIP)
+	var lastErr error
+	for i := 0; i < 10; i++ {
+		dialer := net.Dialer{
+			LocalAddr: &net.UDPAddr{Port: minSourcePort + mathrand.Intn(maxSourcePort-minSourcePort+1)},
+			Control:   socketControl(network, false),
+		}
+
+		conn, err := dialer.Dial(network, net.JoinHostPort(peerIP.String(), fmt.Sprint(ControlPort)))
+		if err == nil {
+			return conn.(*net.UDPConn), nil
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to dial bfd peer %v: %v", peerIP.String(), lastErr)
+}
+
+func udpNetwork(ip net.IP) string {
+	if ip.To4() == nil {
+		return "udp6"
+	}
+	return "udp4"
+}
+
+// socketControl sets ttl/hop limit of sent packets to 255, and enables receiving ttl/hop limit
+// of packets for listeners.
+func socketControl(network string, listen bool) func(string, string, syscall.RawConn) error {
+	return func(_, _ string, rawConn syscall.RawConn) error {
+		var sockErr error
+		if err := rawConn.Control(func(fd uintptr) {
+			if network == "udp6" {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl); sockErr != nil {
+					return
+				}
+				if listen {
+					sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+				}
+				return
+			}
+
+			if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, ttl); sockErr != nil {
+				return
+			}
+			if listen {
+				sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTTL, 1)
+			}
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// parseTTL gets ttl or hop limit from control messages of received packet.
+func parseTTL(oob []byte) (int, bool) {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+
+	for _, message := range messages {
+		if (message.Header.Level == unix.IPPROTO_IP && message.Header.Type == unix.IP_TTL) ||
+			(message.Header.Level == unix.IPPROTO_IPV6 && message.Header.Type == unix.IPV6_HOPLIMIT) {
+			if len(message.Data) < 4 {
+				return 0, false
+			}
+			return int(binary.LittleEndian.Uint32(message.Data[:4])), true
+		}
+	}
+	return 0, false
+}
+
+// sessionRunner sends and receives control packets for a session.
+type sessionRunner struct {
+	session *session
+	conn    *net.UDPConn
+
+	stateMutex sync.Mutex
+	state      State
+
+	rxCh     chan *ControlPacket
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	onStateChange func(state State, diagnostic Diagnostic)
+	logger        logr.Logger
+}
+
+func (r *sessionRunner) receive(packet *ControlPacket) {
+	select {
+	case r.rxCh <- packet:
+	default:
+		// drop the packet if session is too busy
+	}
+}
+
+func (r *sessionRunner) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+func (r *sessionRunner) getState() State {
+	r.stateMutex.Lock()
+	defer r.stateMutex.Unlock()
+	return r.state
+}
+
+func (r *sessionRunner) run() {
+	defer func() {
+		_ = r.conn.Close()
+	}()
+
+	r.stateMutex.Lock()
+	r.state = r.session.state
+	r.stateMutex.Unlock()
+
+	txTimer := time.NewTimer(0)
+	defer txTimer.Stop()
+
+	detectionTicker := time.NewTicker(detectionCheckInterval(r.session.config))
+	defer detectionTicker.Stop()
+
+	for {
+		var changed, final bool
+		select {
+		case <-r.stopCh:
+			return
+		case packet := <-r.rxCh:
+			changed = r.session.handlePacket(packet, time.Now())
+			final = packet.Poll
+		case <-txTimer.C:
+			r.send(false)
+			txTimer.Reset(jitter(r.session.txInterval(), r.session.config.DetectMultiplier))
+			continue
+		case now := <-detectionTicker.C:
+			changed = r.session.checkDetectionTime(now)
+		}
+
+		// respond to poll immediately
+		if final {
+			r.send(true)
+		}
+
+		if changed {
+			r.stateMutex.Lock()
+			r.state = r.session.state
+			r.stateMutex.Unlock()
+
+			r.onStateChange(r.session.state, r.session.diagnostic)
+			// inform peer of the state change as soon as possible
+			r.send(false)
+		}
+	}
+}
+
+func (r *sessionRunner) send(final bool) {
+	b, _ := r.session.controlPacket(final).MarshalBinary()
+	if _, err := r.conn.Write(b); err != nil {
+		r.logger.V(5).Info("failed to send bfd control packet", "error", err)
+	}
+}
+
+// jitter reduces interval by 0 to 25 percent, or 10 to 25 percent if detect multiplier is 1.
+func jitter(interval time.Duration, detectMultiplier uint8) time.Duration {
+	maxPercent, minPercent := 100, 75
+	if detectMultiplier == 1 {
+		maxPercent = 90
+	}
+	return interval * time.Duration(minPercent+mathrand.Intn(maxPercent-minPercent+1)) / 100
+}
+
+// detectionCheckInterval is the precision to check detection time, which is a quarter of
+// the required min rx interval.
+func detectionCheckInterval(config SessionConfig) time.Duration {
+	interval := config.RequiredMinRxInterval / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
@@ -0,0 +1,149 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Version is the version of bfd protocol (RFC 5880).
+	Version = 1
+
+	// ControlPacketLen is the length of bfd control packet without authentication section.
+	ControlPacketLen = 24
+)
+
+// State is the session state of bfd.
+type State uint8
+
+const (
+	StateAdminDown State = 0
+	StateDown      State = 1
+	StateInit      State = 2
+	StateUp        State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAdminDown:
+		return "AdminDown"
+	case StateDown:
+		return "Down"
+	case StateInit:
+		return "Init"
+	case StateUp:
+		return "Up"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+// Diagnostic is the reason of the last session state change of local system.
+type Diagnostic uint8
+
+const (
+	DiagnosticNone                 Diagnostic = 0
+	DiagnosticDetectionTimeExpired Diagnostic = 1
+	DiagnosticNeighborSignaledDown Diagnostic = 3
+	DiagnosticAdminDown            Diagnostic = 7
+)
+
+const (
+	flagPoll                  = 1 << 5
+	flagFinal                 = 1 << 4
+	flagAuthenticationPresent = 1 << 2
+	flagMultipoint            = 1 << 0
+)
+
+var errInvalidControlPacket = errors.New("invalid bfd control packet")
+
+// ControlPacket is the bfd control packet, authentication and demand mode are not supported.
+type ControlPacket struct {
+	Diagnostic Diagnostic
+	State      State
+	Poll       bool
+	Final      bool
+
+	DetectMultiplier      uint8
+	MyDiscriminator       uint32
+	YourDiscriminator     uint32
+	DesiredMinTxInterval  time.Duration
+	RequiredMinRxInterval time.Duration
+}
+
+// MarshalBinary encodes the control packet in network byte order.
+func (p *ControlPacket) MarshalBinary() ([]byte, error) {
+	b := make([]byte, ControlPacketLen)
+	b[0] = Version<<5 | uint8(p.Diagnostic)&0x1f
+	b[1] = uint8(p.State) << 6
+	if p.Poll {
+		b[1] |= flagPoll
+	}
+	if p.Final {
+		b[1] |= flagFinal
+	}
+	b[2] = p.DetectMultiplier
+	b[3] = ControlPacketLen
+	binary.BigEndian.PutUint32(b[4:8], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:12], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:16], uint32(p.DesiredMinTxInterval/time.Microsecond))
+	binary.BigEndian.PutUint32(b[16:20], uint32(p.RequiredMinRxInterval/time.Microsecond))
+	// required min echo rx interval is always zero because echo function is not supported
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes and validates the control packet as the section 6.8.6 of RFC 5880.
+func (p *ControlPacket) UnmarshalBinary(b []byte) error {
+	if len(b) < ControlPacketLen {
+		return errInvalidControlPacket
+	}
+
+	length := int(b[3])
+	if b[0]>>5 != Version || length < ControlPacketLen || length > len(b) {
+		return errInvalidControlPacket
+	}
+
+	// authentication is not supported, and multipoint bit must be zero
+	if b[1]&(flagAuthenticationPresent|flagMultipoint) != 0 {
+		return errInvalidControlPacket
+	}
+
+	p.Diagnostic = Diagnostic(b[0] & 0x1f)
+	p.State = State(b[1] >> 6)
+	p.Poll = b[1]&flagPoll != 0
+	p.Final = b[1]&flagFinal != 0
+	p.DetectMultiplier = b[2]
+	p.MyDiscriminator = binary.BigEndian.Uint32(b[4:8])
+	p.YourDiscriminator = binary.BigEndian.Uint32(b[8:12])
+	p.DesiredMinTxInterval = time.Duration(binary.BigEndian.Uint32(b[12:16])) * time.Microsecond
+	p.RequiredMinRxInterval = time.Duration(binary.BigEndian.Uint32(b[16:20])) * time.Microsecond
+
+	if p.DetectMultiplier == 0 || p.MyDiscriminator == 0 || (p.Poll && p.Final) {
+		return errInvalidControlPacket
+	}
+
+	if p.YourDiscriminator == 0 && p.State != StateDown && p.State != StateAdminDown {
+		return errInvalidControlPacket
+	}
+
+	return nil
+}
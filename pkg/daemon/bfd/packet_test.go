@@ -0,0 +1,108 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bfd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlPacket(t *testing.T) {
+	packet := &ControlPacket{
+		Diagnostic:            DiagnosticDetectionTimeExpired,
+		State:                 StateUp,
+		Poll:                  true,
+		DetectMultiplier:      3,
+		MyDiscriminator:       1,
+		YourDiscriminator:     2,
+		DesiredMinTxInterval:  300 * time.Millisecond,
+		RequiredMinRxInterval: 200 * time.Millisecond,
+	}
+
+	b, err := packet.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x21, 0xe0, 0x03, 0x18,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x04, 0x93, 0xe0,
+		0x00, 0x03, 0x0d, 0x40,
+		0x00, 0x00, 0x00, 0x00,
+	}, b)
+
+	parsed := &ControlPacket{}
+	assert.Nil(t, parsed.UnmarshalBinary(b))
+	assert.Equal(t, packet, parsed)
+}
+
+func TestControlPacketUnmarshalInvalid(t *testing.T) {
+	valid := func() []byte {
+		b, _ := (&ControlPacket{
+			State:             StateUp,
+			DetectMultiplier:  3,
+			MyDiscriminator:   1,
+			YourDiscriminator: 2,
+		}).MarshalBinary()
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{
+			name:   "short packet",
+			modify: func(b []byte) []byte { return b[:20] },
+		},
+		{
+			name:   "wrong version",
+			modify: func(b []byte) []byte { b[0] = 0x40; return b },
+		},
+		{
+			name:   "length larger than packet",
+			modify: func(b []byte) []byte { b[3] = 48; return b },
+		},
+		{
+			name:   "authentication present",
+			modify: func(b []byte) []byte { b[1] |= flagAuthenticationPresent; return b },
+		},
+		{
+			name:   "zero detect multiplier",
+			modify: func(b []byte) []byte { b[2] = 0; return b },
+		},
+		{
+			name:   "zero my discriminator",
+			modify: func(b []byte) []byte { copy(b[4:8], []byte{0, 0, 0, 0}); return b },
+		},
+		{
+			name:   "zero your discriminator in up state",
+			modify: func(b []byte) []byte { copy(b[8:12], []byte{0, 0, 0, 0}); return b },
+		},
+		{
+			name:   "poll and final",
+			modify: func(b []byte) []byte { b[1] |= flagPoll | flagFinal; return b },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, errInvalidControlPacket, (&ControlPacket{}).UnmarshalBinary(test.modify(valid())))
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bfd
+
+import (
+	"net"
+	"time"
+)
+
+// slowTxInterval is the min interval to send control packets while session is not up.
+const slowTxInterval = time.Second
+
+// SessionConfig is the timer parameters of a bfd session.
+type SessionConfig struct {
+	DesiredMinTxInterval  time.Duration
+	RequiredMinRxInterval time.Duration
+	DetectMultiplier      uint8
+}
+
+// session keeps the state variables of a bfd session as the section 6.8.1 of RFC 5880,
+// it's not concurrent safe and only accessed by the goroutine running it.
+type session struct {
+	peer   net.IP
+	config SessionConfig
+
+	state              State
+	diagnostic         Diagnostic
+	localDiscriminator uint32
+
+	remoteState                State
+	remoteDiscriminator        uint32
+	remoteDesiredMinTxInterval time.Duration
+	remoteMinRxInterval        time.Duration
+	remoteDetectMultiplier     uint8
+
+	// detection timer is only armed after a valid packet is received
+	detectionDeadline time.Time
+
+	// poll sequence is running to inform peer of the change of desired min tx interval
+	polling bool
+}
+
+func newSession(peer net.IP, config SessionConfig, localDiscriminator uint32) *session {
+	return &session{
+		peer:               peer,
+		config:             config,
+		state:              StateDown,
+		localDiscriminator: localDiscriminator,
+		remoteState:        StateDown,
+		// peer is assumed to accept packets at any rate until its min rx interval is known
+		remoteMinRxInterval: time.Microsecond,
+	}
+}
+
+// desiredMinTxInterval must not be less than one second if session is not up.
+func (s *session) desiredMinTxInterval() time.Duration {
+	if s.state != StateUp && s.config.DesiredMinTxInterval < slowTxInterval {
+		return slowTxInterval
+	}
+	return s.config.DesiredMinTxInterval
+}
+
+// txInterval returns the interval to send periodic control packets, jitter is not included.
+func (s *session) txInterval() time.Duration {
+	interval := s.desiredMinTxInterval()
+	if s.remoteMinRxInterval > interval {
+		interval = s.remoteMinRxInterval
+	}
+	return interval
+}
+
+// detectionTime returns how long the session will be down after the last valid packet is received.
+func (s *session) detectionTime() time.Duration {
+	interval := s.config.RequiredMinRxInterval
+	if s.remoteDesiredMinTxInterval > interval {
+		interval = s.remoteDesiredMinTxInterval
+	}
+	return time.Duration(s.remoteDetectMultiplier) * interval
+}
+
+func (s *session) setState(state State, diagnostic Diagnostic) bool {
+	if s.state == state {
+		return false
+	}
+
+	// desired min tx interval changes if session gets up or leaves up
+	if (s.state == StateUp || state == StateUp) && s.config.DesiredMinTxInterval < slowTxInterval {
+		s.polling = true
+	}
+
+	s.state = state
+	s.diagnostic = diagnostic
+	return true
+}
+
+// handlePacket processes a valid control packet from peer as the section 6.8.6 of RFC 5880,
+// and returns whether the session state is changed.
+func (s *session) handlePacket(p *ControlPacket, now time.Time) bool {
+	s.remoteDiscriminator = p.MyDiscriminator
+	s.remoteState = p.State
+	s.remoteDesiredMinTxInterval = p.DesiredMinTxInterval
+	s.remoteMinRxInterval = p.RequiredMinRxInterval
+	s.remoteDetectMultiplier = p.DetectMultiplier
+	s.detectionDeadline = now.Add(s.detectionTime())
+
+	if p.Final {
+		s.polling = false
+	}
+
+	if s.state == StateAdminDown {
+		return false
+	}
+
+	if p.State == StateAdminDown {
+		if s.state != StateDown {
+			return s.setState(StateDown, DiagnosticNeighborSignaledDown)
+		}
+		return false
+	}
+
+	switch s.state {
+	case StateDown:
+		switch p.State {
+		case StateDown:
+			return s.setState(StateInit, DiagnosticNone)
+		case StateInit:
+			return s.setState(StateUp, DiagnosticNone)
+		}
+	case StateInit:
+		if p.State == StateInit || p.State == StateUp {
+			return s.setState(StateUp, DiagnosticNone)
+		}
+	case StateUp:
+		if p.State == StateDown {
+			return s.setState(StateDown, DiagnosticNeighborSignaledDown)
+		}
+	}
+
+	return false
+}
+
+// checkDetectionTime takes session down if no valid packet is received in detection time,
+// and returns whether the session state is changed.
+func (s *session) checkDetectionTime(now time.Time) bool {
+	if s.detectionDeadline.IsZero() || now.Before(s.detectionDeadline) {
+		return false
+	}
+
+	s.detectionDeadline = time.Time{}
+	s.remoteDiscriminator = 0
+	if s.state == StateInit || s.state == StateUp {
+		return s.setState(StateDown, DiagnosticDetectionTimeExpired)
+	}
+	return false
+}
+
+// controlPacket generates the control packet to send, final should be set only in response
+// to a poll from peer.
+func (s *session) controlPacket(final bool) *ControlPacket {
+	return &ControlPacket{
+		Diagnostic:            s.diagnostic,
+		State:                 s.state,
+		Poll:                  s.polling && !final,
+		Final:                 final,
+		DetectMultiplier:      s.config.DetectMultiplier,
+		MyDiscriminator:       s.localDiscriminator,
+		YourDiscriminator:     s.remoteDiscriminator,
+		DesiredMinTxInterval:  s.desiredMinTxInterval(),
+		RequiredMinRxInterval: s.config.RequiredMinRxInterval,
+	}
+}
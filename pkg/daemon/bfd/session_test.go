@@ -0,0 +1,93 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bfd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStateMachine(t *testing.T) {
+	config := SessionConfig{
+		DesiredMinTxInterval:  300 * time.Millisecond,
+		RequiredMinRxInterval: 300 * time.Millisecond,
+		DetectMultiplier:      3,
+	}
+	s := newSession(net.ParseIP("192.168.0.1"), config, 100)
+	now := time.Now()
+
+	remote := func(state State) *ControlPacket {
+		return &ControlPacket{
+			State:                 state,
+			DetectMultiplier:      3,
+			MyDiscriminator:       200,
+			YourDiscriminator:     100,
+			DesiredMinTxInterval:  500 * time.Millisecond,
+			RequiredMinRxInterval: 400 * time.Millisecond,
+		}
+	}
+
+	// slow tx interval is used before session gets up
+	assert.Equal(t, time.Second, s.txInterval())
+	assert.False(t, s.checkDetectionTime(now))
+
+	assert.True(t, s.handlePacket(remote(StateDown), now))
+	assert.Equal(t, StateInit, s.state)
+	assert.Equal(t, uint32(200), s.controlPacket(false).YourDiscriminator)
+
+	assert.True(t, s.handlePacket(remote(StateInit), now))
+	assert.Equal(t, StateUp, s.state)
+	assert.Equal(t, 400*time.Millisecond, s.txInterval())
+	assert.Equal(t, 1500*time.Millisecond, s.detectionTime())
+
+	// poll sequence for the change of desired min tx interval
+	assert.True(t, s.controlPacket(false).Poll)
+	assert.False(t, s.controlPacket(true).Poll)
+	finalPacket := remote(StateUp)
+	finalPacket.Final = true
+	assert.False(t, s.handlePacket(finalPacket, now))
+	assert.False(t, s.controlPacket(false).Poll)
+
+	// session goes down if no packet is received in detection time
+	assert.False(t, s.checkDetectionTime(now.Add(time.Second)))
+	assert.True(t, s.checkDetectionTime(now.Add(1500*time.Millisecond)))
+	assert.Equal(t, StateDown, s.state)
+	assert.Equal(t, DiagnosticDetectionTimeExpired, s.diagnostic)
+	assert.Equal(t, uint32(0), s.controlPacket(false).YourDiscriminator)
+
+	// peer signals down
+	assert.True(t, s.handlePacket(remote(StateInit), now))
+	assert.Equal(t, StateUp, s.state)
+	assert.True(t, s.handlePacket(remote(StateAdminDown), now))
+	assert.Equal(t, StateDown, s.state)
+	assert.Equal(t, DiagnosticNeighborSignaledDown, s.diagnostic)
+	assert.False(t, s.handlePacket(remote(StateUp), now))
+	assert.Equal(t, StateDown, s.state)
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		interval := jitter(time.Second, 3)
+		assert.True(t, interval >= 750*time.Millisecond && interval <= time.Second)
+
+		interval = jitter(time.Second, 1)
+		assert.True(t, interval >= 750*time.Millisecond && interval <= 900*time.Millisecond)
+	}
+}
@@ -21,11 +21,14 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
 
 	"github.com/vishvananda/netlink"
 
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/bfd"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 
 	"github.com/go-logr/logr"
@@ -45,19 +48,67 @@ type Manager struct {
 	routerV4Address net.IP
 	// choose next hop address when advertise ipv6 address
 	routerV6Address net.IP
+	// next hop address of unnumbered peering, used if there is no global address
+	routerLinkLocalAddress net.IP
 
 	bgpServer *server.BgpServer
 
 	logger logr.Logger
 
 	peerMap   map[string]*peerInfo
-	subnetMap map[string]*net.IPNet
-	ipMap     map[string]net.IP
+	subnetMap map[string]*subnetInfo
+	ipMap     map[string]*ipInfo
+
+	// attributes of the subnet and ip paths added to bgp server, paths will be re-added if attributes change
+	advertisedSubnetAttributeMap map[string]*PathAttributes
+	advertisedIPAttributeMap     map[string]*PathAttributes
+
+	// session of a peer will be reset at most once in this interval to apply configuration changes
+	peerResetMinInterval time.Duration
+	lastPeerResetTimeMap map[string]time.Time
+
+	bfdManager *bfd.Manager
+	// peers which are disabled because bfd sessions with them go down
+	bfdDownPeerMap   map[string]bool
+	bfdDownPeerMutex *sync.Mutex
+
+	// routes learned from bgp peers are installed into import table if allowed by import prefixes
+	importTableNum        int
+	importPrefixMap       map[string]*net.IPNet
+	allowedImportPrefixes []*net.IPNet
+	receivedRouteMap      map[string]*receivedRoute
+	// dsts of the routes installed into import table by the last sync
+	installedImportRoutes map[string]bool
+	// importTampered is set if installed routes are deleted by others
+	importTampered int32
+	importMutex    *sync.Mutex
+
+	// service ips to advertise, indexed by namespaced names of services
+	serviceMap map[string]*serviceInfo
+	// paths of service ips added to bgp server, which should be ignored while syncing ip instance paths
+	advertisedServiceIPMap map[string]*serviceIPInfo
+	serviceIPMutex         *sync.RWMutex
+
+	// labels of the peers whose metrics are reported by the last ListPeerStatus
+	reportedPeerLabels map[string]bool
 
 	startMutex *sync.RWMutex
 }
 
-func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logger) (*Manager, error) {
+// PeerUpdate describes the configuration changes applied to an exist bgp peer.
+type PeerUpdate struct {
+	Address       string
+	ChangedFields []string
+
+	// SessionReset means the bgp session has been re-established to apply the changes.
+	SessionReset bool
+	// RetryAfter is not zero if the session reset is postponed because of rate limit,
+	// the changes are not applied yet in that case.
+	RetryAfter time.Duration
+}
+
+func NewManager(peeringInterfaceName, grpcListenAddress string, peerResetMinInterval time.Duration,
+	importTableNum int, logger logr.Logger) (*Manager, error) {
 	manager := &Manager{
 		// For using gobgp cmd to debug
 		bgpServer: server.NewBgpServer(
@@ -69,12 +120,34 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 		peeringInterfaceName: peeringInterfaceName,
 
 		peerMap:   map[string]*peerInfo{},
-		subnetMap: map[string]*net.IPNet{},
-		ipMap:     map[string]net.IP{},
+		subnetMap: map[string]*subnetInfo{},
+		ipMap:     map[string]*ipInfo{},
+
+		advertisedSubnetAttributeMap: map[string]*PathAttributes{},
+		advertisedIPAttributeMap:     map[string]*PathAttributes{},
+
+		peerResetMinInterval: peerResetMinInterval,
+		lastPeerResetTimeMap: map[string]time.Time{},
+
+		bfdDownPeerMap:   map[string]bool{},
+		bfdDownPeerMutex: &sync.Mutex{},
+
+		importTableNum:        importTableNum,
+		importPrefixMap:       map[string]*net.IPNet{},
+		receivedRouteMap:      map[string]*receivedRoute{},
+		installedImportRoutes: map[string]bool{},
+		reportedPeerLabels:    map[string]bool{},
+		importMutex:           &sync.Mutex{},
+
+		serviceMap:             map[string]*serviceInfo{},
+		advertisedServiceIPMap: map[string]*serviceIPInfo{},
+		serviceIPMutex:         &sync.RWMutex{},
 
 		startMutex: &sync.RWMutex{},
 	}
 
+	manager.bfdManager = bfd.NewManager(manager.handleBFDStateChange, logger.WithName("bfd"))
+
 	peeringLink, err := netlink.LinkByName(peeringInterfaceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get bgp peering link %v: %v", peeringInterfaceName, err)
@@ -85,11 +158,18 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 		return nil, fmt.Errorf("failed to get link address for bgp peering interface %v: %v", peeringInterfaceName, err)
 	}
 
+	manager.routerLinkLocalAddress, err = getLinkLocalAddress(peeringLink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link-local address for bgp peering interface %v: %v", peeringInterfaceName, err)
+	}
+
 	switch len(existLinkAddress) {
 	case 0:
-		return nil, fmt.Errorf("there is no valid address on bpg peering interface")
+		// unnumbered peering only needs the link-local address
+		if manager.routerLinkLocalAddress == nil {
+			return nil, fmt.Errorf("there is no valid address on bpg peering interface")
+		}
 	case 1:
-		manager.routerID = existLinkAddress[0].IP.String()
 		if existLinkAddress[0].IP.To4() == nil {
 			manager.routerV6Address = existLinkAddress[0].IP
 		} else {
@@ -100,12 +180,10 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 			for _, addr := range existLinkAddress {
 				if addr.IP.To4() == nil {
 					manager.routerV6Address = addr.IP
+				} else {
+					manager.routerV4Address = addr.IP
 				}
-				manager.routerV4Address = addr.IP
 			}
-
-			// Use v4 address as routerID by default if v4/v6 addresses exist at the same time.
-			manager.routerID = manager.routerV4Address.String()
 			break
 		}
 		fallthrough
@@ -137,12 +215,19 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 		if manager.routerV4Address == nil && manager.routerV6Address == nil {
 			return nil, fmt.Errorf("failed to find valid address for bgp router")
 		}
+	}
 
-		if manager.routerV4Address != nil {
-			// Use v4 address as routerID by default if v4/v6 addresses exist at the same time.
-			manager.routerID = manager.routerV4Address.String()
-		} else {
-			manager.routerID = manager.routerV6Address.String()
+	// router id must be in ipv4 format, any ipv4 address of node will be used if there is
+	// no one on peering interface, which is common for unnumbered peering
+	if manager.routerV4Address != nil {
+		manager.routerID = manager.routerV4Address.String()
+	} else {
+		nodeV4Address, err := getGlobalUnicastV4Address()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ipv4 address for bgp router id: %v", err)
+		}
+		if nodeV4Address != nil {
+			manager.routerID = nodeV4Address.String()
 		}
 	}
 
@@ -150,29 +235,44 @@ func NewManager(peeringInterfaceName, grpcListenAddress string, logger logr.Logg
 	return manager, nil
 }
 
-func (m *Manager) RecordPeer(address, password string, asn int, gracefulRestartTime int32) {
+// RecordPeer records a bgp peer, which is an unnumbered one if neighborInterface is not empty.
+func (m *Manager) RecordPeer(address, neighborInterface, password string, asn int, gracefulRestartTime int32,
+	bfdConfig *networkingv1.BFDConfig) {
 	if gracefulRestartTime == 0 {
 		gracefulRestartTime = 300
 	}
 
-	m.peerMap[address] = &peerInfo{
+	peer := &peerInfo{
 		address:                address,
+		neighborInterface:      neighborInterface,
 		asn:                    asn,
 		gracefulRestartSeconds: uint32(gracefulRestartTime),
 		password:               password,
 	}
+
+	// bfd is not supported by unnumbered peers
+	if len(neighborInterface) == 0 {
+		peer.bfdConfig = generateBFDSessionConfig(bfdConfig)
+	}
+	m.peerMap[peer.key()] = peer
 }
 
-func (m *Manager) RecordSubnet(cidr *net.IPNet) {
-	m.subnetMap[cidr.String()] = cidr
+func (m *Manager) RecordSubnet(cidr *net.IPNet, attributes *PathAttributes) {
+	m.subnetMap[cidr.String()] = &subnetInfo{
+		cidr:       cidr,
+		attributes: attributes,
+	}
 }
 
-func (m *Manager) RecordIP(ip net.IP) {
-	m.ipMap[ip.String()] = ip
+func (m *Manager) RecordIP(ip net.IP, attributes *PathAttributes) {
+	m.ipMap[ip.String()] = &ipInfo{
+		ip:         ip,
+		attributes: attributes,
+	}
 }
 
 func (m *Manager) ResetSubnetInfos() {
-	m.subnetMap = map[string]*net.IPNet{}
+	m.subnetMap = map[string]*subnetInfo{}
 }
 
 func (m *Manager) ResetPeerInfos() {
@@ -182,10 +282,11 @@ func (m *Manager) ResetPeerInfos() {
 func (m *Manager) ResetPeerAndSubnetInfos() {
 	m.ResetPeerInfos()
 	m.ResetSubnetInfos()
+	m.ResetImportPrefixes()
 }
 
 func (m *Manager) ResetIPInfos() {
-	m.ipMap = map[string]net.IP{}
+	m.ipMap = map[string]*ipInfo{}
 }
 
 func (m *Manager) TryStart(asn uint32) error {
@@ -200,13 +301,24 @@ func (m *Manager) TryStart(asn uint32) error {
 		return nil
 	}
 
+	if len(m.routerID) == 0 {
+		return fmt.Errorf("no ipv4 address on node can be used as bgp router id")
+	}
+
 	m.localASN = asn
-	return m.bgpServer.StartBgp(context.Background(), &api.StartBgpRequest{
+	if err := m.bgpServer.StartBgp(context.Background(), &api.StartBgpRequest{
 		Global: &api.Global{
 			Asn:      m.localASN,
 			RouterId: m.routerID,
 		},
-	})
+	}); err != nil {
+		return err
+	}
+
+	if err := m.watchReceivedRoutes(); err != nil {
+		return fmt.Errorf("failed to watch routes received from bgp peers: %v", err)
+	}
+	return nil
 }
 
 func (m *Manager) CheckIfStart() bool {
@@ -216,50 +328,207 @@ func (m *Manager) CheckIfStart() bool {
 	return m.localASN != 0
 }
 
-func (m *Manager) SyncPeerInfos() error {
+// SyncPeerInfos adds, deletes and updates bgp peers, and returns the updates of exist peers.
+func (m *Manager) SyncPeerInfos() ([]*PeerUpdate, error) {
 	// If bgp manager is not started, do nothing.
 	if !m.CheckIfStart() {
-		return nil
+		return nil, nil
 	}
 
-	// Sync peers configuration.
-	// Because now UpdatePeer will reset bgp session causing a network fluctuation, we will never update an exist bgp peer.
-	existPeerMap := map[string]struct{}{}
+	existPeerMap := map[string]*api.Peer{}
 	if err := m.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{EnableAdvertised: true},
 		func(peer *api.Peer) {
-			existPeerMap[peer.Conf.NeighborAddress] = struct{}{}
+			existPeerMap[getPeerKey(peer)] = peer
 		}); err != nil {
-		return fmt.Errorf("failed to list bgp peers: %v", err)
+		return nil, fmt.Errorf("failed to list bgp peers: %v", err)
 	}
 
 	// Don't do any thing if local AS number has not been set.
 	if m.localASN == 0 {
-		return nil
+		return nil, nil
 	}
 
-	for _, peer := range m.peerMap {
-		if _, exist := existPeerMap[peer.address]; !exist {
+	// neigh of unnumbered next hop should be ready before routes are imported
+	if err := m.syncUnnumberedNextHopNeigh(); err != nil {
+		return nil, err
+	}
+
+	var peerUpdates []*PeerUpdate
+	for key, peer := range m.peerMap {
+		existPeer, exist := existPeerMap[key]
+		if !exist {
 			if err := m.bgpServer.AddPeer(context.Background(), &api.AddPeerRequest{
 				Peer: generatePeerConfig(peer),
 			}); err != nil {
-				return fmt.Errorf("failed to add bgp peer %v: %v", peer.address, err)
+				return nil, fmt.Errorf("failed to add bgp peer %v: %v", key, err)
 			}
+			continue
 		}
+
+		changedFields := diffPeer(peer, existPeer)
+		if len(changedFields) == 0 {
+			continue
+		}
+
+		peerUpdate, err := m.updatePeer(peer, existPeer, changedFields)
+		if err != nil {
+			return nil, err
+		}
+		peerUpdates = append(peerUpdates, peerUpdate)
 	}
 
-	for addr := range existPeerMap {
-		if _, exist := m.peerMap[addr]; !exist {
+	for key, existPeer := range existPeerMap {
+		if _, exist := m.peerMap[key]; !exist {
+			// delete by the resolved address, which is the key of peer in bgp server, because the
+			// neighbor on interface of unnumbered peer might be gone
 			if err := m.bgpServer.DeletePeer(context.Background(), &api.DeletePeerRequest{
+				Address: existPeer.GetState().GetNeighborAddress(),
+			}); err != nil {
+				return nil, fmt.Errorf("failed to delete bgp peer %v: %v", key, err)
+			}
+			delete(m.lastPeerResetTimeMap, key)
+		}
+	}
+
+	if err := m.syncBFDSessions(); err != nil {
+		return nil, err
+	}
+
+	return peerUpdates, nil
+}
+
+// syncBFDSessions ensures bfd sessions with the peers which enable bfd, and enables
+// the peers which have been disabled by bfd but not enable bfd any more.
+func (m *Manager) syncBFDSessions() error {
+	bfdConfigs := map[string]bfd.SessionConfig{}
+	for _, peer := range m.peerMap {
+		if peer.bfdConfig != nil {
+			bfdConfigs[peer.address] = *peer.bfdConfig
+		}
+	}
+
+	if err := m.bfdManager.SyncSessions(bfdConfigs); err != nil {
+		return fmt.Errorf("failed to sync bfd sessions: %v", err)
+	}
+
+	m.bfdDownPeerMutex.Lock()
+	defer m.bfdDownPeerMutex.Unlock()
+
+	for addr := range m.bfdDownPeerMap {
+		if _, exist := bfdConfigs[addr]; exist {
+			continue
+		}
+
+		if _, exist := m.peerMap[addr]; exist {
+			if err := m.bgpServer.EnablePeer(context.Background(), &api.EnablePeerRequest{
 				Address: addr,
 			}); err != nil {
-				return fmt.Errorf("failed to add bgp peer %v: %v", addr, err)
+				return fmt.Errorf("failed to enable bgp peer %v: %v", addr, err)
 			}
 		}
+		delete(m.bfdDownPeerMap, addr)
 	}
 
 	return nil
 }
 
+// handleBFDStateChange tears down bgp session immediately once bfd session goes down from up,
+// and the peer will be enabled again after bfd session gets up.
+func (m *Manager) handleBFDStateChange(peer string, state bfd.State, diagnostic bfd.Diagnostic) {
+	m.bfdDownPeerMutex.Lock()
+	defer m.bfdDownPeerMutex.Unlock()
+
+	switch {
+	case state == bfd.StateDown && !m.bfdDownPeerMap[peer]:
+		if err := m.bgpServer.DisablePeer(context.Background(), &api.DisablePeerRequest{
+			Address:       peer,
+			Communication: "bfd session down",
+		}); err != nil {
+			m.logger.Error(err, "failed to disable bgp peer after bfd session goes down", "peer", peer)
+			return
+		}
+		m.bfdDownPeerMap[peer] = true
+		m.logger.Info("bgp peer is disabled because bfd session goes down", "peer", peer,
+			"diagnostic", diagnostic)
+	case state == bfd.StateUp && m.bfdDownPeerMap[peer]:
+		if err := m.bgpServer.EnablePeer(context.Background(), &api.EnablePeerRequest{
+			Address: peer,
+		}); err != nil {
+			m.logger.Error(err, "failed to enable bgp peer after bfd session gets up", "peer", peer)
+			return
+		}
+		delete(m.bfdDownPeerMap, peer)
+		m.logger.Info("bgp peer is enabled because bfd session gets up", "peer", peer)
+	}
+}
+
+func (m *Manager) isDisabledByBFD(peer string) bool {
+	m.bfdDownPeerMutex.Lock()
+	defer m.bfdDownPeerMutex.Unlock()
+
+	return m.bfdDownPeerMap[peer]
+}
+
+// updatePeer applies the changed fields of an exist peer. Session of the peer will be re-established if
+// any field can not be changed online, which happens at most once in peerResetMinInterval to avoid flapping;
+// otherwise, the changes will be applied through soft reset, which re-evaluates the received paths and
+// re-advertises paths to peer without tearing down the session.
+func (m *Manager) updatePeer(peer *peerInfo, existPeer *api.Peer, changedFields []string) (*PeerUpdate, error) {
+	key := peer.key()
+	peerUpdate := &PeerUpdate{
+		Address:       key,
+		ChangedFields: changedFields,
+	}
+
+	sessionReset := needsSessionReset(changedFields)
+	if sessionReset {
+		if lastResetTime, exist := m.lastPeerResetTimeMap[key]; exist {
+			if retryAfter := m.peerResetMinInterval - time.Since(lastResetTime); retryAfter > 0 {
+				peerUpdate.RetryAfter = retryAfter
+				return peerUpdate, nil
+			}
+		}
+	}
+
+	// Unnumbered peers are indexed by the discovered link-local addresses in gobgp.
+	neighborAddress := peer.address
+	if len(peer.neighborInterface) != 0 {
+		neighborAddress = existPeer.GetState().GetNeighborAddress()
+	}
+
+	// UpdatePeer of gobgp will re-establish session by itself if the OPEN message needs to be resent.
+	peerConfig := generatePeerConfig(peer)
+	peerConfig.Conf.AdminDown = m.isDisabledByBFD(key)
+	peerConfig.State = &api.PeerState{NeighborAddress: neighborAddress}
+	resp, err := m.bgpServer.UpdatePeer(context.Background(), &api.UpdatePeerRequest{
+		Peer: peerConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update bgp peer %v: %v", key, err)
+	}
+
+	if sessionReset {
+		m.lastPeerResetTimeMap[key] = time.Now()
+		peerUpdate.SessionReset = true
+		return peerUpdate, nil
+	}
+
+	direction := api.ResetPeerRequest_OUT
+	if resp.NeedsSoftResetIn {
+		direction = api.ResetPeerRequest_BOTH
+	}
+
+	if err := m.bgpServer.ResetPeer(context.Background(), &api.ResetPeerRequest{
+		Address:   neighborAddress,
+		Soft:      true,
+		Direction: direction,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to soft reset bgp peer %v: %v", key, err)
+	}
+
+	return peerUpdate, nil
+}
+
 func (m *Manager) SyncSubnetInfos() error {
 	// If bgp manager is not started, do nothing.
 	if !m.CheckIfStart() {
@@ -280,21 +549,23 @@ func (m *Manager) SyncSubnetInfos() error {
 		return fmt.Errorf("failed to list ipv6 path: %v", err)
 	}
 
-	// Ensure paths for subnets
-	for _, subnet := range m.subnetMap {
-		nextHop, err := m.getNextHopAddressByIP(subnet.IP)
+	// Ensure paths for subnets, paths with changed attributes are updated in place
+	for prefix, subnet := range m.subnetMap {
+		nextHop, err := m.getNextHopAddressByIP(subnet.cidr.IP)
 		if err != nil {
 			m.logger.Error(err, "failed to get next hop address to add path for subnet, it will be ignore",
-				"subnet", subnet.String())
+				"subnet", prefix)
 			continue
 		}
 
-		if _, exist := existSubnetPathMap[subnet.String()]; !exist {
+		if _, exist := existSubnetPathMap[prefix]; !exist ||
+			!m.advertisedSubnetAttributeMap[prefix].equal(subnet.attributes) {
 			if _, err := m.bgpServer.AddPath(context.Background(), &api.AddPathRequest{
-				Path: generatePathForSubnet(subnet, nextHop),
+				Path: generatePathForSubnet(subnet.cidr, nextHop, subnet.attributes),
 			}); err != nil {
-				return fmt.Errorf("failed to add path for subnet %v: %v", subnet.String(), err)
+				return fmt.Errorf("failed to add path for subnet %v: %v", prefix, err)
 			}
+			m.advertisedSubnetAttributeMap[prefix] = subnet.attributes
 		}
 	}
 
@@ -308,22 +579,28 @@ func (m *Manager) SyncSubnetInfos() error {
 
 		if _, exist := m.subnetMap[prefix]; !exist {
 			if err := m.bgpServer.DeletePath(context.Background(), &api.DeletePathRequest{
-				Path: generatePathForSubnet(cidr, nextHop),
+				Path: generatePathForSubnet(cidr, nextHop, nil),
 			}); err != nil {
 				return fmt.Errorf("failed to delete path for subnet %v: %v", prefix, err)
 			}
+			delete(m.advertisedSubnetAttributeMap, prefix)
 		}
 	}
 
 	return nil
 }
 
-func (m *Manager) SyncPeerAndSubnetInfos() error {
-	if err := m.SyncPeerInfos(); err != nil {
-		return err
+func (m *Manager) SyncPeerAndSubnetInfos() ([]*PeerUpdate, error) {
+	peerUpdates, err := m.SyncPeerInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.SyncSubnetInfos(); err != nil {
+		return nil, err
 	}
 
-	return m.SyncSubnetInfos()
+	return peerUpdates, m.SyncImportedRoutes()
 }
 
 func (m *Manager) SyncIPInfos() error {
@@ -340,21 +617,23 @@ func (m *Manager) SyncIPInfos() error {
 		return fmt.Errorf("failed to list ipv4 path: %v", err)
 	}
 
-	// Ensure paths for ip instances
-	for _, ipInstance := range m.ipMap {
-		nextHop, err := m.getNextHopAddressByIP(ipInstance)
+	// Ensure paths for ip instances, paths with changed attributes are updated in place
+	for key, ipInstance := range m.ipMap {
+		nextHop, err := m.getNextHopAddressByIP(ipInstance.ip)
 		if err != nil {
 			m.logger.Error(err, "failed to get next hop address to add path for ip instance, it will be ignore",
-				"ip", ipInstance.String())
+				"ip", key)
 			continue
 		}
 
-		if _, exist := existIPPathMap[ipInstance.String()]; !exist {
+		if _, exist := existIPPathMap[key]; !exist ||
+			!m.advertisedIPAttributeMap[key].equal(ipInstance.attributes) {
 			if _, err := m.bgpServer.AddPath(context.Background(), &api.AddPathRequest{
-				Path: generatePathForIP(ipInstance, nextHop),
+				Path: generatePathForIP(ipInstance.ip, nextHop, ipInstance.attributes),
 			}); err != nil {
-				return fmt.Errorf("failed to add path for ip instance %v: %v", ipInstance.String(), err)
+				return fmt.Errorf("failed to add path for ip instance %v: %v", key, err)
 			}
+			m.advertisedIPAttributeMap[key] = ipInstance.attributes
 		}
 	}
 
@@ -366,28 +645,38 @@ func (m *Manager) SyncIPInfos() error {
 			continue
 		}
 
-		if _, exist := m.ipMap[ipAddr.String()]; !exist {
+		// host paths of service ips are maintained by SyncServiceIPs
+		if _, exist := m.ipMap[ipAddr.String()]; !exist && !m.isAdvertisedServiceIP(ipAddr) {
 			if err := m.bgpServer.DeletePath(context.Background(), &api.DeletePathRequest{
-				Path: generatePathForIP(ipAddr, nextHop),
+				Path: generatePathForIP(ipAddr, nextHop, nil),
 			}); err != nil {
 				return fmt.Errorf("failed to delete path for ip instance %v: %v", ipAddr.String(), err)
 			}
+			delete(m.advertisedIPAttributeMap, ipAddr.String())
 		}
 	}
 
 	return nil
 }
 
+// getNextHopAddressByIP returns the next hop address to advertise the ip, ipv6 next hop will be
+// used for ipv4 address if there is no ipv4 address for router, which is supported by unnumbered
+// peering (RFC 5549).
 func (m *Manager) getNextHopAddressByIP(ipAddr net.IP) (net.IP, error) {
-	if ipAddr.To4() == nil {
-		if m.routerV6Address == nil {
-			return nil, fmt.Errorf("router has no valid v6 nexthop address")
-		}
+	if ipAddr.To4() != nil && m.routerV4Address != nil {
+		return m.routerV4Address, nil
+	}
+
+	if m.routerV6Address != nil {
 		return m.routerV6Address, nil
 	}
 
-	if m.routerV4Address == nil {
+	if m.routerLinkLocalAddress != nil {
+		return m.routerLinkLocalAddress, nil
+	}
+
+	if ipAddr.To4() != nil {
 		return nil, fmt.Errorf("router has no valid v4 nexthop address")
 	}
-	return m.routerV4Address, nil
+	return nil, fmt.Errorf("router has no valid v6 nexthop address")
 }
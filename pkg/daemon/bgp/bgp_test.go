@@ -0,0 +1,120 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNextHopAddressByIP(t *testing.T) {
+	v4Address := net.ParseIP("192.168.0.10")
+	v6Address := net.ParseIP("fd00::10")
+	linkLocalAddress := net.ParseIP("fe80::10")
+
+	v4IP := net.ParseIP("10.0.0.1")
+	v6IP := net.ParseIP("fd01::1")
+
+	tests := []struct {
+		name             string
+		v4Address        net.IP
+		v6Address        net.IP
+		linkLocalAddress net.IP
+		ip               net.IP
+		expected         net.IP
+		expectError      error
+	}{
+		{
+			name:      "v4 ip on v4 only router",
+			v4Address: v4Address,
+			ip:        v4IP,
+			expected:  v4Address,
+		},
+		{
+			name:        "v6 ip on v4 only router",
+			v4Address:   v4Address,
+			ip:          v6IP,
+			expectError: fmt.Errorf("router has no valid v6 nexthop address"),
+		},
+		{
+			name:      "v4 ip on v6 only router",
+			v6Address: v6Address,
+			ip:        v4IP,
+			expected:  v6Address,
+		},
+		{
+			name:      "v6 ip on v6 only router",
+			v6Address: v6Address,
+			ip:        v6IP,
+			expected:  v6Address,
+		},
+		{
+			name:             "v4 ip on link-local only router",
+			linkLocalAddress: linkLocalAddress,
+			ip:               v4IP,
+			expected:         linkLocalAddress,
+		},
+		{
+			name:             "v6 ip on link-local only router",
+			linkLocalAddress: linkLocalAddress,
+			ip:               v6IP,
+			expected:         linkLocalAddress,
+		},
+		{
+			name:             "v4 ip on dual stack router",
+			v4Address:        v4Address,
+			v6Address:        v6Address,
+			linkLocalAddress: linkLocalAddress,
+			ip:               v4IP,
+			expected:         v4Address,
+		},
+		{
+			name:             "v6 ip on dual stack router",
+			v4Address:        v4Address,
+			v6Address:        v6Address,
+			linkLocalAddress: linkLocalAddress,
+			ip:               v6IP,
+			expected:         v6Address,
+		},
+		{
+			name:        "v4 ip on router without address",
+			ip:          v4IP,
+			expectError: fmt.Errorf("router has no valid v4 nexthop address"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Manager{
+				routerV4Address:        test.v4Address,
+				routerV6Address:        test.v6Address,
+				routerLinkLocalAddress: test.linkLocalAddress,
+			}
+
+			nextHop, err := m.getNextHopAddressByIP(test.ip)
+			if test.expectError != nil {
+				assert.Equal(t, test.expectError, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, nextHop)
+		})
+	}
+}
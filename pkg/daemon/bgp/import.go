@@ -0,0 +1,250 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	api "github.com/osrg/gobgp/v3/api"
+
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// receivedRoute is the best path of a prefix learned from bgp peers.
+type receivedRoute struct {
+	dst     *net.IPNet
+	nextHop net.IP
+}
+
+func (m *Manager) RecordImportPrefix(cidr *net.IPNet) {
+	m.importPrefixMap[cidr.String()] = cidr
+}
+
+func (m *Manager) ResetImportPrefixes() {
+	m.importPrefixMap = map[string]*net.IPNet{}
+}
+
+// SyncImportedRoutes applies the recorded import prefixes, and installs the allowed routes learned
+// from bgp peers into import table.
+func (m *Manager) SyncImportedRoutes() error {
+	// If bgp manager is not started, do nothing.
+	if !m.CheckIfStart() {
+		return nil
+	}
+
+	m.importMutex.Lock()
+	defer m.importMutex.Unlock()
+
+	m.allowedImportPrefixes = make([]*net.IPNet, 0, len(m.importPrefixMap))
+	for _, cidr := range m.importPrefixMap {
+		m.allowedImportPrefixes = append(m.allowedImportPrefixes, cidr)
+	}
+
+	return m.installImportedRoutes()
+}
+
+// watchReceivedRoutes watches the best paths of global rib, which is supposed to be called once bgp
+// server is started. Current best paths will be received at first.
+func (m *Manager) watchReceivedRoutes() error {
+	return m.bgpServer.WatchEvent(context.Background(), &api.WatchEventRequest{
+		Table: &api.WatchEventRequest_Table{
+			Filters: []*api.WatchEventRequest_Table_Filter{
+				{
+					Type: api.WatchEventRequest_Table_Filter_BEST,
+					Init: true,
+				},
+			},
+		},
+	}, m.handleTableEvent)
+}
+
+func (m *Manager) handleTableEvent(resp *api.WatchEventResponse) {
+	table := resp.GetTable()
+	if table == nil {
+		return
+	}
+
+	m.importMutex.Lock()
+	defer m.importMutex.Unlock()
+
+	for _, path := range table.Paths {
+		dst, nextHop, err := parseReceivedPath(path)
+		if err != nil {
+			m.logger.Error(err, "failed to parse best path, it will be ignored")
+			continue
+		}
+
+		// Best path of prefix might be withdrawn or replaced by a local one, which has no source peer.
+		if path.IsWithdraw || path.SourceAsn == 0 || path.IsFromExternal || path.IsNexthopInvalid || nextHop == nil {
+			delete(m.receivedRouteMap, dst.String())
+			continue
+		}
+
+		m.receivedRouteMap[dst.String()] = &receivedRoute{
+			dst:     dst,
+			nextHop: nextHop,
+		}
+	}
+
+	if err := m.installImportedRoutes(); err != nil {
+		m.logger.Error(err, "failed to install imported routes")
+	}
+}
+
+// installImportedRoutes makes import table only contain the received routes allowed by import prefixes,
+// which should be called with importMutex held. Routes failed to be installed are skipped and will be
+// retried in the next sync.
+func (m *Manager) installImportedRoutes() error {
+	// Reset tampered flag and installed routes before syncing, deletions of this manager itself
+	// should not be considered as tampered.
+	atomic.StoreInt32(&m.importTampered, 0)
+	m.installedImportRoutes = map[string]bool{}
+
+	var unnumberedNextHopUsed bool
+	installedRoutes := map[string]bool{}
+	expectedRouteMap := map[string]*netlink.Route{}
+	for prefix, route := range m.receivedRouteMap {
+		if !isPrefixAllowed(route.dst, m.allowedImportPrefixes) {
+			continue
+		}
+
+		expectedRoute := &netlink.Route{
+			Dst:      route.dst,
+			Gw:       route.nextHop,
+			Table:    m.importTableNum,
+			Scope:    netlink.SCOPE_UNIVERSE,
+			Protocol: unix.RTPROT_BGP,
+		}
+
+		// Ipv4 routes with ipv6 next hops are learned from unnumbered peers.
+		unnumbered := route.dst.IP.To4() != nil && route.nextHop.To4() == nil
+		if unnumbered {
+			expectedRoute.Gw = UnnumberedIPv4NextHop
+			expectedRoute.Flags = int(netlink.FLAG_ONLINK)
+			unnumberedNextHopUsed = true
+		}
+
+		linkIndex, err := m.getNextHopLinkIndex(route.nextHop, unnumbered)
+		if err != nil {
+			m.logger.Error(err, "failed to resolve link of next hop, imported route is skipped",
+				"prefix", prefix, "nextHop", route.nextHop)
+			continue
+		}
+		expectedRoute.LinkIndex = linkIndex
+
+		expectedRouteMap[prefix] = expectedRoute
+		installedRoutes[prefix] = true
+	}
+
+	if unnumberedNextHopUsed {
+		peeringLink, err := netlink.LinkByName(m.peeringInterfaceName)
+		if err != nil {
+			return fmt.Errorf("failed to get bgp peering link %v: %v", m.peeringInterfaceName, err)
+		}
+
+		if err := m.ensureUnnumberedNextHopNeigh(peeringLink); err != nil {
+			return err
+		}
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routeList, err := netlink.RouteListFiltered(family, &netlink.Route{
+			Table: m.importTableNum,
+		}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return fmt.Errorf("failed to list route for table %v: %v", m.importTableNum, err)
+		}
+
+		for _, route := range routeList {
+			if route.Dst == nil {
+				route.Dst = daemonutils.DefaultRouteDstByFamily(family)
+			}
+
+			// Routes with different next hops will be replaced later.
+			if expectedRoute, exist := expectedRouteMap[route.Dst.String()]; exist {
+				if expectedRoute.Gw.Equal(route.Gw) && expectedRoute.LinkIndex == route.LinkIndex {
+					delete(expectedRouteMap, route.Dst.String())
+				}
+				continue
+			}
+
+			if err := netlink.RouteDel(&route); err != nil {
+				m.logger.Error(err, "failed to delete imported route", "route", route.String())
+			}
+		}
+	}
+
+	for prefix, route := range expectedRouteMap {
+		if err := netlink.RouteReplace(route); err != nil {
+			m.logger.Error(err, "failed to add imported route", "route", route.String())
+			delete(installedRoutes, prefix)
+		}
+	}
+
+	m.installedImportRoutes = installedRoutes
+	return nil
+}
+
+// getNextHopLinkIndex returns the index of link through which the next hop is reachable. Next hops of
+// unnumbered peers and link-local ones can only be reached through the peering link.
+func (m *Manager) getNextHopLinkIndex(nextHop net.IP, unnumbered bool) (int, error) {
+	if unnumbered || nextHop.IsLinkLocalUnicast() {
+		peeringLink, err := netlink.LinkByName(m.peeringInterfaceName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get bgp peering link %v: %v", m.peeringInterfaceName, err)
+		}
+		return peeringLink.Attrs().Index, nil
+	}
+
+	routes, err := netlink.RouteGet(nextHop)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get route to next hop %v: %v", nextHop, err)
+	}
+	if len(routes) == 0 || routes[0].LinkIndex == 0 {
+		return 0, fmt.Errorf("no route to next hop %v", nextHop)
+	}
+	return routes[0].LinkIndex, nil
+}
+
+// IsImportedRoute checks if a route is installed into import table by this manager.
+func (m *Manager) IsImportedRoute(route *netlink.Route) bool {
+	if route.Table != m.importTableNum {
+		return false
+	}
+
+	m.importMutex.Lock()
+	defer m.importMutex.Unlock()
+
+	// Default routes of both families are taken into account if dst is not specified.
+	if route.Dst == nil {
+		return m.installedImportRoutes[daemonutils.DefaultRouteDstByFamily(netlink.FAMILY_V4).String()] ||
+			m.installedImportRoutes[daemonutils.DefaultRouteDstByFamily(netlink.FAMILY_V6).String()]
+	}
+	return m.installedImportRoutes[route.Dst.String()]
+}
+
+// MarkImportedRoutesTampered records that installed routes are deleted by others, returns false
+// if it has been marked since the last sync.
+func (m *Manager) MarkImportedRoutesTampered() bool {
+	return atomic.CompareAndSwapInt32(&m.importTampered, 0, 1)
+}
@@ -0,0 +1,80 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNextHopLinkIndex(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("loopback interface is not available: %v", err)
+	}
+
+	tests := []struct {
+		name                 string
+		peeringInterfaceName string
+		nextHop              net.IP
+		unnumbered           bool
+		linkIndex            int
+		expectError          bool
+	}{
+		{
+			name:                 "next hop resolved by route",
+			peeringInterfaceName: "not-exist",
+			nextHop:              net.ParseIP("127.0.0.1"),
+			linkIndex:            lo.Index,
+		},
+		{
+			name:                 "link-local next hop on peering link",
+			peeringInterfaceName: "lo",
+			nextHop:              net.ParseIP("fe80::1"),
+			linkIndex:            lo.Index,
+		},
+		{
+			name:                 "unnumbered next hop on peering link",
+			peeringInterfaceName: "lo",
+			nextHop:              net.ParseIP("fd00::1"),
+			unnumbered:           true,
+			linkIndex:            lo.Index,
+		},
+		{
+			name:                 "peering link not found",
+			peeringInterfaceName: "not-exist",
+			nextHop:              net.ParseIP("fe80::1"),
+			expectError:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Manager{peeringInterfaceName: test.peeringInterfaceName}
+
+			linkIndex, err := m.getNextHopLinkIndex(test.nextHop, test.unnumbered)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.linkIndex, linkIndex)
+		})
+	}
+}
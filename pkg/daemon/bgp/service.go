@@ -0,0 +1,164 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	api "github.com/osrg/gobgp/v3/api"
+)
+
+// serviceIPInfo is a service ip advertised to bgp peers with the communities attached.
+type serviceIPInfo struct {
+	ip          net.IP
+	communities []uint32
+}
+
+func (info *serviceIPInfo) equal(other *serviceIPInfo) bool {
+	if !info.ip.Equal(other.ip) || len(info.communities) != len(other.communities) {
+		return false
+	}
+
+	for i := range info.communities {
+		if info.communities[i] != other.communities[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceInfo is the ips of a service to advertise with the communities attached.
+type serviceInfo struct {
+	ips         []net.IP
+	communities []uint32
+}
+
+// SyncServiceIPs records the ips of a service to advertise, and adds, updates and deletes the paths of
+// the ips related to this service only. Communities of the same ip shared by different services will be
+// merged. The ips of service are withdrawn if ips is empty. Only the paths advertised by this function
+// will be deleted, so that paths of ip instances are never touched.
+func (m *Manager) SyncServiceIPs(service string, ips []net.IP, communities []uint32) error {
+	m.serviceIPMutex.Lock()
+	defer m.serviceIPMutex.Unlock()
+
+	relatedIPs := map[string]net.IP{}
+	if exist, ok := m.serviceMap[service]; ok {
+		for _, ip := range exist.ips {
+			relatedIPs[ip.String()] = ip
+		}
+	}
+	for _, ip := range ips {
+		relatedIPs[ip.String()] = ip
+	}
+
+	if len(ips) == 0 {
+		delete(m.serviceMap, service)
+	} else {
+		m.serviceMap[service] = &serviceInfo{
+			ips:         ips,
+			communities: communities,
+		}
+	}
+
+	// If bgp manager is not started, do nothing.
+	if !m.CheckIfStart() {
+		return nil
+	}
+
+	for key, ip := range relatedIPs {
+		if err := m.syncServiceIPPath(key, m.getServiceIPInfo(ip)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getServiceIPInfo merges the communities of all the services which advertise the ip, returns nil if
+// the ip is not advertised by any service. It should be called with serviceIPMutex held.
+func (m *Manager) getServiceIPInfo(ip net.IP) *serviceIPInfo {
+	var info *serviceIPInfo
+	for _, service := range m.serviceMap {
+		for _, serviceIP := range service.ips {
+			if !serviceIP.Equal(ip) {
+				continue
+			}
+
+			if info == nil {
+				info = &serviceIPInfo{ip: ip}
+			}
+			info.communities = sortCommunities(append(info.communities, service.communities...))
+			break
+		}
+	}
+	return info
+}
+
+// syncServiceIPPath makes the advertised path of service ip consistent with info, the path will be
+// withdrawn if info is nil. It should be called with serviceIPMutex held.
+func (m *Manager) syncServiceIPPath(key string, info *serviceIPInfo) error {
+	advertised, exist := m.advertisedServiceIPMap[key]
+	if info == nil {
+		if !exist {
+			return nil
+		}
+
+		nextHop, err := m.getNextHopAddressByIP(advertised.ip)
+		if err != nil {
+			m.logger.Error(err, "failed to get next hop address to delete path for service ip, it will be ignore",
+				"ip", advertised.ip.String())
+			return nil
+		}
+
+		if err := m.bgpServer.DeletePath(context.Background(), &api.DeletePathRequest{
+			Path: generatePathForServiceIP(advertised.ip, nextHop, advertised.communities),
+		}); err != nil {
+			return fmt.Errorf("failed to delete path for service ip %v: %v", advertised.ip.String(), err)
+		}
+		delete(m.advertisedServiceIPMap, key)
+		return nil
+	}
+
+	if exist && advertised.equal(info) {
+		return nil
+	}
+
+	nextHop, err := m.getNextHopAddressByIP(info.ip)
+	if err != nil {
+		m.logger.Error(err, "failed to get next hop address to add path for service ip, it will be ignore",
+			"ip", info.ip.String())
+		return nil
+	}
+
+	// The exist path with different communities will be replaced.
+	if _, err := m.bgpServer.AddPath(context.Background(), &api.AddPathRequest{
+		Path: generatePathForServiceIP(info.ip, nextHop, info.communities),
+	}); err != nil {
+		return fmt.Errorf("failed to add path for service ip %v: %v", info.ip.String(), err)
+	}
+	m.advertisedServiceIPMap[key] = info
+	return nil
+}
+
+func (m *Manager) isAdvertisedServiceIP(ip net.IP) bool {
+	m.serviceIPMutex.RLock()
+	defer m.serviceIPMutex.RUnlock()
+
+	_, exist := m.advertisedServiceIPMap[ip.String()]
+	return exist
+}
@@ -0,0 +1,98 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncServiceIPs(t *testing.T) {
+	// paths are not advertised before bgp manager is started, only the service ips are recorded
+	m := &Manager{
+		serviceMap:     map[string]*serviceInfo{},
+		serviceIPMutex: &sync.RWMutex{},
+		startMutex:     &sync.RWMutex{},
+	}
+
+	sharedIP := net.ParseIP("192.168.0.1")
+	ip := net.ParseIP("192.168.0.2")
+
+	tests := []struct {
+		name        string
+		service     string
+		ips         []net.IP
+		communities []uint32
+		expected    map[string][]uint32
+	}{
+		{
+			name:        "first service",
+			service:     "default/a",
+			ips:         []net.IP{sharedIP},
+			communities: []uint32{2},
+			expected:    map[string][]uint32{sharedIP.String(): {2}},
+		},
+		{
+			name:        "second service sharing ip",
+			service:     "default/b",
+			ips:         []net.IP{sharedIP, ip},
+			communities: []uint32{1, 2},
+			expected:    map[string][]uint32{sharedIP.String(): {1, 2}, ip.String(): {1, 2}},
+		},
+		{
+			name:        "communities of first service changed",
+			service:     "default/a",
+			ips:         []net.IP{sharedIP},
+			communities: []uint32{3},
+			expected:    map[string][]uint32{sharedIP.String(): {1, 2, 3}, ip.String(): {1, 2}},
+		},
+		{
+			name:     "second service withdrawn",
+			service:  "default/b",
+			ips:      nil,
+			expected: map[string][]uint32{sharedIP.String(): {3}, ip.String(): nil},
+		},
+		{
+			name:     "first service withdrawn",
+			service:  "default/a",
+			ips:      nil,
+			expected: map[string][]uint32{sharedIP.String(): nil, ip.String(): nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, m.SyncServiceIPs(test.service, test.ips, test.communities))
+
+			for ipString, communities := range test.expected {
+				info := m.getServiceIPInfo(net.ParseIP(ipString))
+				if communities == nil {
+					assert.Nil(t, info)
+					continue
+				}
+				if assert.NotNil(t, info) {
+					assert.Equal(t, communities, info.communities)
+				}
+			}
+		})
+	}
+
+	assert.Empty(t, m.serviceMap)
+}
@@ -0,0 +1,142 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/osrg/gobgp/v3/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+var sessionStateMap = map[api.PeerState_SessionState]networkingv1.BGPSessionState{
+	api.PeerState_UNKNOWN:     networkingv1.BGPSessionStateUnknown,
+	api.PeerState_IDLE:        networkingv1.BGPSessionStateIdle,
+	api.PeerState_CONNECT:     networkingv1.BGPSessionStateConnect,
+	api.PeerState_ACTIVE:      networkingv1.BGPSessionStateActive,
+	api.PeerState_OPENSENT:    networkingv1.BGPSessionStateOpenSent,
+	api.PeerState_OPENCONFIRM: networkingv1.BGPSessionStateOpenConfirm,
+	api.PeerState_ESTABLISHED: networkingv1.BGPSessionStateEstablished,
+}
+
+// ListPeerStatus returns the session states and prefix counts of bgp peers sorted by address,
+// metrics of bgp peers are refreshed at the same time. It is not safe for concurrent use.
+func (m *Manager) ListPeerStatus() ([]networkingv1.BGPPeerStatus, error) {
+	var peerStatuses []networkingv1.BGPPeerStatus
+	currentPeerLabels := map[string]bool{}
+
+	// If bgp manager is not started, no peer exists.
+	if !m.CheckIfStart() {
+		m.cleanStalePeerMetrics(currentPeerLabels)
+		return nil, nil
+	}
+
+	if err := m.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{EnableAdvertised: true},
+		func(peer *api.Peer) {
+			peerStatus := generatePeerStatus(peer)
+			peerStatuses = append(peerStatuses, peerStatus)
+
+			// address of unnumbered peer might change, so the interface is used instead
+			peerLabel := getPeerKey(peer)
+			currentPeerLabels[peerLabel] = true
+
+			metrics.BGPPeerSessionStateGauge.WithLabelValues(peerLabel).
+				Set(float64(peer.GetState().GetSessionState()))
+
+			var uptime float64
+			if peerStatus.EstablishedTime != nil {
+				uptime = time.Since(peerStatus.EstablishedTime.Time).Seconds()
+			}
+			metrics.BGPPeerUptimeGauge.WithLabelValues(peerLabel).Set(uptime)
+
+			metrics.BGPPeerPrefixesGauge.WithLabelValues(peerLabel, metrics.BGPReceivedPrefixesType).
+				Set(float64(peerStatus.ReceivedPrefixes))
+			metrics.BGPPeerPrefixesGauge.WithLabelValues(peerLabel, metrics.BGPAdvertisedPrefixesType).
+				Set(float64(peerStatus.AdvertisedPrefixes))
+		}); err != nil {
+		// keep tracking the peers reported before the failure
+		for peerLabel := range currentPeerLabels {
+			m.reportedPeerLabels[peerLabel] = true
+		}
+		return nil, fmt.Errorf("failed to list bgp peers: %v", err)
+	}
+
+	m.cleanStalePeerMetrics(currentPeerLabels)
+
+	sort.Slice(peerStatuses, func(i, j int) bool {
+		if peerStatuses[i].Address != peerStatuses[j].Address {
+			return peerStatuses[i].Address < peerStatuses[j].Address
+		}
+		return peerStatuses[i].Interface < peerStatuses[j].Interface
+	})
+
+	return peerStatuses, nil
+}
+
+// cleanStalePeerMetrics deletes the metrics of peers which do not exist any more, metrics of
+// current peers are kept to avoid gaps in scraping.
+func (m *Manager) cleanStalePeerMetrics(currentPeerLabels map[string]bool) {
+	for peerLabel := range m.reportedPeerLabels {
+		if currentPeerLabels[peerLabel] {
+			continue
+		}
+		metrics.BGPPeerSessionStateGauge.DeleteLabelValues(peerLabel)
+		metrics.BGPPeerUptimeGauge.DeleteLabelValues(peerLabel)
+		metrics.BGPPeerPrefixesGauge.DeleteLabelValues(peerLabel, metrics.BGPReceivedPrefixesType)
+		metrics.BGPPeerPrefixesGauge.DeleteLabelValues(peerLabel, metrics.BGPAdvertisedPrefixesType)
+	}
+	m.reportedPeerLabels = currentPeerLabels
+}
+
+func generatePeerStatus(peer *api.Peer) networkingv1.BGPPeerStatus {
+	peerStatus := networkingv1.BGPPeerStatus{
+		Address: peer.GetConf().GetNeighborAddress(),
+		State:   networkingv1.BGPSessionStateUnknown,
+	}
+
+	// address of unnumbered peer is resolved from neighbors, with the zone of interface
+	if neighborInterface := peer.GetConf().GetNeighborInterface(); len(neighborInterface) != 0 {
+		peerStatus.Interface = neighborInterface
+		peerStatus.Address = strings.SplitN(peer.GetState().GetNeighborAddress(), "%", 2)[0]
+	}
+
+	if state, exist := sessionStateMap[peer.GetState().GetSessionState()]; exist {
+		peerStatus.State = state
+	}
+
+	// uptime is kept after the session goes down, so it is meaningful only if established
+	if peerStatus.State == networkingv1.BGPSessionStateEstablished {
+		if uptime := peer.GetTimers().GetState().GetUptime(); uptime != nil {
+			establishedTime := metav1.NewTime(uptime.AsTime())
+			peerStatus.EstablishedTime = &establishedTime
+		}
+	}
+
+	for _, afiSafi := range peer.GetAfiSafis() {
+		peerStatus.ReceivedPrefixes += int64(afiSafi.GetState().GetReceived())
+		peerStatus.AdvertisedPrefixes += int64(afiSafi.GetState().GetAdvertised())
+	}
+
+	return peerStatus
+}
@@ -0,0 +1,129 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/osrg/gobgp/v3/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+func TestGeneratePeerStatus(t *testing.T) {
+	uptime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	establishedTime := metav1.NewTime(uptime)
+
+	tests := []struct {
+		name     string
+		peer     *api.Peer
+		expected networkingv1.BGPPeerStatus
+	}{
+		{
+			name: "established peer",
+			peer: &api.Peer{
+				Conf: &api.PeerConf{NeighborAddress: "192.168.0.1"},
+				State: &api.PeerState{
+					NeighborAddress: "192.168.0.1",
+					SessionState:    api.PeerState_ESTABLISHED,
+				},
+				Timers: &api.Timers{State: &api.TimersState{Uptime: timestamppb.New(uptime)}},
+				AfiSafis: []*api.AfiSafi{
+					{State: &api.AfiSafiState{Received: 3, Advertised: 2}},
+					{State: &api.AfiSafiState{Received: 1, Advertised: 1}},
+				},
+			},
+			expected: networkingv1.BGPPeerStatus{
+				Address:            "192.168.0.1",
+				State:              networkingv1.BGPSessionStateEstablished,
+				EstablishedTime:    &establishedTime,
+				ReceivedPrefixes:   4,
+				AdvertisedPrefixes: 3,
+			},
+		},
+		{
+			name: "uptime is ignored if not established",
+			peer: &api.Peer{
+				Conf: &api.PeerConf{NeighborAddress: "192.168.0.1"},
+				State: &api.PeerState{
+					NeighborAddress: "192.168.0.1",
+					SessionState:    api.PeerState_ACTIVE,
+				},
+				Timers: &api.Timers{State: &api.TimersState{Uptime: timestamppb.New(uptime)}},
+			},
+			expected: networkingv1.BGPPeerStatus{
+				Address: "192.168.0.1",
+				State:   networkingv1.BGPSessionStateActive,
+			},
+		},
+		{
+			name: "unnumbered peer",
+			peer: &api.Peer{
+				Conf: &api.PeerConf{NeighborInterface: "eth0"},
+				State: &api.PeerState{
+					NeighborAddress: "fe80::1%eth0",
+					SessionState:    api.PeerState_OPENSENT,
+				},
+			},
+			expected: networkingv1.BGPPeerStatus{
+				Address:   "fe80::1",
+				Interface: "eth0",
+				State:     networkingv1.BGPSessionStateOpenSent,
+			},
+		},
+		{
+			name: "undiscovered unnumbered peer",
+			peer: &api.Peer{
+				Conf: &api.PeerConf{NeighborInterface: "eth0"},
+			},
+			expected: networkingv1.BGPPeerStatus{
+				Interface: "eth0",
+				State:     networkingv1.BGPSessionStateUnknown,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, generatePeerStatus(test.peer))
+		})
+	}
+}
+
+func TestCleanStalePeerMetrics(t *testing.T) {
+	defer metrics.BGPPeerSessionStateGauge.Reset()
+
+	m := &Manager{reportedPeerLabels: map[string]bool{}}
+
+	metrics.BGPPeerSessionStateGauge.WithLabelValues("192.168.0.1").Set(6)
+	metrics.BGPPeerSessionStateGauge.WithLabelValues("eth0").Set(6)
+	m.cleanStalePeerMetrics(map[string]bool{"192.168.0.1": true, "eth0": true})
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.BGPPeerSessionStateGauge))
+
+	m.cleanStalePeerMetrics(map[string]bool{"eth0": true})
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.BGPPeerSessionStateGauge))
+	assert.Equal(t, float64(6), testutil.ToFloat64(metrics.BGPPeerSessionStateGauge.WithLabelValues("eth0")))
+
+	m.cleanStalePeerMetrics(map[string]bool{})
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.BGPPeerSessionStateGauge))
+}
@@ -0,0 +1,172 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+)
+
+// UnnumberedIPv4NextHop is the pseudo next hop of ipv4 routes through unnumbered peers. Ipv4 routes
+// can not take ipv6 next hops in kernel directly, so they are installed as onlink routes via this
+// address, which is resolved to the mac address of unnumbered peer by a permanent neigh entry.
+var UnnumberedIPv4NextHop = net.IPv4(169, 254, 0, 1)
+
+// GetUnnumberedPeerAddress returns the link-local address of the unnumbered peer on interface,
+// which is discovered from ipv6 neighbors the same as gobgp does.
+func GetUnnumberedPeerAddress(ifName string) (net.IP, error) {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link %v: %v", ifName, err)
+	}
+
+	neigh, err := getUnnumberedPeerNeigh(link)
+	if err != nil {
+		return nil, err
+	}
+	if neigh == nil {
+		return nil, fmt.Errorf("no ipv6 link-local neighbor found on link %v", ifName)
+	}
+	return neigh.IP, nil
+}
+
+// syncUnnumberedNextHopNeigh makes the neigh of UnnumberedIPv4NextHop exist on peering interface
+// only if there are unnumbered peers.
+func (m *Manager) syncUnnumberedNextHopNeigh() error {
+	peeringLink, err := netlink.LinkByName(m.peeringInterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get bgp peering link %v: %v", m.peeringInterfaceName, err)
+	}
+
+	for _, peer := range m.peerMap {
+		if len(peer.neighborInterface) != 0 {
+			return m.ensureUnnumberedNextHopNeigh(peeringLink)
+		}
+	}
+
+	neighList, err := netlink.NeighList(peeringLink.Attrs().Index, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list ipv4 neigh on link %v: %v", m.peeringInterfaceName, err)
+	}
+
+	for _, neigh := range neighList {
+		if neigh.IP.Equal(UnnumberedIPv4NextHop) {
+			if err := netlink.NeighDel(&neigh); err != nil {
+				return fmt.Errorf("failed to delete neigh of unnumbered next hop %v: %v", UnnumberedIPv4NextHop, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureUnnumberedNextHopNeigh resolves UnnumberedIPv4NextHop to the mac address of unnumbered peer,
+// nothing will be done if the peer or its mac address is not discovered yet.
+func (m *Manager) ensureUnnumberedNextHopNeigh(link netlink.Link) error {
+	peerNeigh, err := getUnnumberedPeerNeigh(link)
+	if err != nil {
+		return err
+	}
+
+	if peerNeigh == nil || len(peerNeigh.HardwareAddr) == 0 {
+		m.logger.Info("unnumbered bgp peer is not discovered yet, skip resolving next hop",
+			"interface", link.Attrs().Name, "nextHop", UnnumberedIPv4NextHop)
+		return nil
+	}
+
+	if err := netlink.NeighSet(&netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       netlink.FAMILY_V4,
+		State:        netlink.NUD_PERMANENT,
+		IP:           UnnumberedIPv4NextHop,
+		HardwareAddr: peerNeigh.HardwareAddr,
+	}); err != nil {
+		return fmt.Errorf("failed to set neigh of unnumbered next hop %v: %v", UnnumberedIPv4NextHop, err)
+	}
+	return nil
+}
+
+// getUnnumberedPeerNeigh returns the only ipv6 link-local neighbor on link which is not failed and
+// not a local address, the same as gobgp does, or nil if there is no such neighbor.
+func getUnnumberedPeerNeigh(link netlink.Link) (*netlink.Neigh, error) {
+	neighList, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ipv6 neigh on link %v: %v", link.Attrs().Name, err)
+	}
+
+	addrList, err := netlink.AddrList(link, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ipv6 address for link %v: %v", link.Attrs().Name, err)
+	}
+
+	var peerNeigh *netlink.Neigh
+	for i := range neighList {
+		neigh := &neighList[i]
+		if !neigh.IP.IsLinkLocalUnicast() || neigh.State&netlink.NUD_FAILED != 0 ||
+			isLocalAddress(neigh.IP, addrList) {
+			continue
+		}
+
+		if peerNeigh != nil {
+			return nil, fmt.Errorf("more than one link-local neighbor found on link %v", link.Attrs().Name)
+		}
+		peerNeigh = neigh
+	}
+
+	return peerNeigh, nil
+}
+
+func isLocalAddress(ip net.IP, addrList []netlink.Addr) bool {
+	for _, addr := range addrList {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func getLinkLocalAddress(link netlink.Link) (net.IP, error) {
+	addrList, err := netlink.AddrList(link, netlink.FAMILY_V6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ipv6 address for link %v: %v", link.Attrs().Name, err)
+	}
+
+	for _, addr := range addrList {
+		if addr.IP.IsLinkLocalUnicast() {
+			return addr.IP, nil
+		}
+	}
+	return nil, nil
+}
+
+// getGlobalUnicastV4Address returns the first global unicast ipv4 address of node.
+func getGlobalUnicastV4Address() (net.IP, error) {
+	addrList, err := netlink.AddrList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ipv4 address: %v", err)
+	}
+
+	for _, addr := range addrList {
+		if containernetwork.CheckIPIsGlobalUnicast(addr.IP) {
+			return addr.IP, nil
+		}
+	}
+	return nil, nil
+}
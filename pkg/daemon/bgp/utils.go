@@ -17,7 +17,10 @@
 package bgp
 
 import (
+	"fmt"
 	"net"
+	"sort"
+	"time"
 
 	"github.com/go-logr/logr"
 
@@ -26,6 +29,9 @@ import (
 	apb "google.golang.org/protobuf/types/known/anypb"
 
 	api "github.com/osrg/gobgp/v3/api"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/bfd"
 )
 
 var (
@@ -42,27 +48,117 @@ var (
 	originAttr, _ = apb.New(&api.OriginAttribute{
 		Origin: 0,
 	})
-
-	noExportCommunityAttr, _ = apb.New(&api.CommunitiesAttribute{
-		Communities: []uint32{
-			uint32(bgp.COMMUNITY_NO_EXPORT),
-		},
-	})
 )
 
 type peerInfo struct {
-	address                string
+	address string
+	// neighborInterface is not empty only for unnumbered peers, of which address is empty
+	neighborInterface      string
 	asn                    int
 	gracefulRestartSeconds uint32
 	password               string
+
+	// bfd is disabled if nil
+	bfdConfig *bfd.SessionConfig
+}
+
+type subnetInfo struct {
+	cidr       *net.IPNet
+	attributes *PathAttributes
+}
+
+type ipInfo struct {
+	ip         net.IP
+	attributes *PathAttributes
+}
+
+const (
+	PeerFieldASN                    = "asn"
+	PeerFieldPassword               = "password"
+	PeerFieldGracefulRestartSeconds = "gracefulRestartSeconds"
+	PeerFieldApplyPolicy            = "applyPolicy"
+)
+
+// sessionResetPeerFields are carried by OPEN message or tcp md5 option, changes of which
+// can only take effect after the bgp session is re-established. Changes of the other fields are
+// applied through soft reset.
+var sessionResetPeerFields = map[string]bool{
+	PeerFieldASN:                    true,
+	PeerFieldPassword:               true,
+	PeerFieldGracefulRestartSeconds: true,
+}
+
+// diffPeer returns the fields of peer configuration which are different from the exist one.
+func diffPeer(p *peerInfo, existPeer *api.Peer) []string {
+	var changedFields []string
+	if existPeer.Conf == nil || existPeer.Conf.PeerAsn != uint32(p.asn) {
+		changedFields = append(changedFields, PeerFieldASN)
+	}
+
+	if existPeer.Conf == nil || existPeer.Conf.AuthPassword != p.password {
+		changedFields = append(changedFields, PeerFieldPassword)
+	}
+
+	if existPeer.GracefulRestart == nil || existPeer.GracefulRestart.RestartTime != p.gracefulRestartSeconds {
+		changedFields = append(changedFields, PeerFieldGracefulRestartSeconds)
+	}
+
+	// No import or export policy is attached to peers by daemon, the ones attached through gobgp
+	// cli for debugging will be removed.
+	if isPolicyAssigned(existPeer.GetApplyPolicy().GetImportPolicy()) ||
+		isPolicyAssigned(existPeer.GetApplyPolicy().GetExportPolicy()) {
+		changedFields = append(changedFields, PeerFieldApplyPolicy)
+	}
+
+	return changedFields
+}
+
+// isPolicyAssigned returns true if any policy or a non-accept default action is assigned.
+func isPolicyAssigned(assignment *api.PolicyAssignment) bool {
+	return len(assignment.GetPolicies()) != 0 || assignment.GetDefaultAction() == api.RouteAction_REJECT
+}
+
+func generateBFDSessionConfig(config *networkingv1.BFDConfig) *bfd.SessionConfig {
+	if config == nil {
+		return nil
+	}
+
+	sessionConfig := &bfd.SessionConfig{
+		DesiredMinTxInterval:  networkingv1.DefaultBFDIntervalMilliseconds * time.Millisecond,
+		RequiredMinRxInterval: networkingv1.DefaultBFDIntervalMilliseconds * time.Millisecond,
+		DetectMultiplier:      networkingv1.DefaultBFDDetectMultiplier,
+	}
+
+	if config.DesiredMinTxInterval != 0 {
+		sessionConfig.DesiredMinTxInterval = time.Duration(config.DesiredMinTxInterval) * time.Millisecond
+	}
+	if config.RequiredMinRxInterval != 0 {
+		sessionConfig.RequiredMinRxInterval = time.Duration(config.RequiredMinRxInterval) * time.Millisecond
+	}
+	if config.DetectMultiplier != 0 {
+		sessionConfig.DetectMultiplier = uint8(config.DetectMultiplier)
+	}
+
+	return sessionConfig
+}
+
+// needsSessionReset returns true if any of the changed fields can only take effect after session reset.
+func needsSessionReset(changedFields []string) bool {
+	for _, field := range changedFields {
+		if sessionResetPeerFields[field] {
+			return true
+		}
+	}
+	return false
 }
 
 func generatePeerConfig(p *peerInfo) *api.Peer {
 	return &api.Peer{
 		Conf: &api.PeerConf{
-			NeighborAddress: p.address,
-			PeerAsn:         uint32(p.asn),
-			AuthPassword:    p.password,
+			NeighborAddress:   p.address,
+			NeighborInterface: p.neighborInterface,
+			PeerAsn:           uint32(p.asn),
+			AuthPassword:      p.password,
 		},
 		GracefulRestart: &api.GracefulRestart{
 			Enabled:         true,
@@ -97,6 +193,22 @@ func generatePeerConfig(p *peerInfo) *api.Peer {
 	}
 }
 
+// key is the address of peer, or the interface if it is an unnumbered peer.
+func (p *peerInfo) key() string {
+	if len(p.neighborInterface) != 0 {
+		return p.neighborInterface
+	}
+	return p.address
+}
+
+// getPeerKey returns the key of exist peer in the same way as peerInfo.
+func getPeerKey(peer *api.Peer) string {
+	if len(peer.GetConf().GetNeighborInterface()) != 0 {
+		return peer.GetConf().GetNeighborInterface()
+	}
+	return peer.GetConf().GetNeighborAddress()
+}
+
 func getIPFamilyFromIP(ip net.IP) *api.Family {
 	if ip.To4() == nil {
 		return v6Family
@@ -104,14 +216,105 @@ func getIPFamilyFromIP(ip net.IP) *api.Family {
 	return v4Family
 }
 
-func generatePathForIP(ip, nextHop net.IP) *api.Path {
+// PathAttributes are the optional attributes attached to paths of subnets and ips.
+type PathAttributes struct {
+	Communities []uint32
+	// LocalPreference is not attached if nil
+	LocalPreference *uint32
+}
+
+// GeneratePathAttributes converts the bgp path config of subnet to path attributes, communities
+// are sorted and deduplicated.
+func GeneratePathAttributes(config *networkingv1.BGPPathConfig) (*PathAttributes, error) {
+	attributes := &PathAttributes{}
+	if config == nil {
+		return attributes, nil
+	}
+
+	communities, err := networkingv1.ParseBGPCommunityList(config.Communities)
+	if err != nil {
+		return nil, err
+	}
+	attributes.Communities = sortCommunities(communities)
+
+	if config.LocalPreference != nil {
+		localPreference := uint32(*config.LocalPreference)
+		attributes.LocalPreference = &localPreference
+	}
+	return attributes, nil
+}
+
+func (a *PathAttributes) equal(other *PathAttributes) bool {
+	if a == nil || other == nil {
+		return a == other
+	}
+
+	if len(a.Communities) != len(other.Communities) {
+		return false
+	}
+	for i := range a.Communities {
+		if a.Communities[i] != other.Communities[i] {
+			return false
+		}
+	}
+
+	if a.LocalPreference == nil || other.LocalPreference == nil {
+		return a.LocalPreference == other.LocalPreference
+	}
+	return *a.LocalPreference == *other.LocalPreference
+}
+
+func sortCommunities(communities []uint32) []uint32 {
+	communitySet := map[uint32]bool{}
+	for _, community := range communities {
+		communitySet[community] = true
+	}
+
+	result := make([]uint32, 0, len(communitySet))
+	for community := range communitySet {
+		result = append(result, community)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// generateOptionalAttrs returns the communities and local preference attributes, extra communities
+// are attached besides the ones of attributes.
+func generateOptionalAttrs(attributes *PathAttributes, extraCommunities ...uint32) []*apb.Any {
+	var (
+		pattrs      []*apb.Any
+		communities = extraCommunities
+	)
+
+	if attributes != nil {
+		communities = sortCommunities(append(communities, attributes.Communities...))
+
+		if attributes.LocalPreference != nil {
+			localPrefAttr, _ := apb.New(&api.LocalPrefAttribute{
+				LocalPref: *attributes.LocalPreference,
+			})
+			pattrs = append(pattrs, localPrefAttr)
+		}
+	}
+
+	if len(communities) != 0 {
+		communitiesAttr, _ := apb.New(&api.CommunitiesAttribute{
+			Communities: communities,
+		})
+		pattrs = append(pattrs, communitiesAttr)
+	}
+	return pattrs
+}
+
+func generatePathForIP(ip, nextHop net.IP, attributes *PathAttributes) *api.Path {
 	if len(ip) == 0 {
 		return nil
 	}
 
-	isIPv6 := ip.To4() == nil
 	prefixBytesLen := uint32(net.IPv4len)
-	if isIPv6 {
+	if ip.To4() == nil {
 		prefixBytesLen = net.IPv6len
 	}
 
@@ -120,14 +323,18 @@ func generatePathForIP(ip, nextHop net.IP) *api.Path {
 		PrefixLen: prefixBytesLen * 8,
 	})
 
+	// host routes of pods are never exported out of the peers
+	pattrs := []*apb.Any{generateNextHopAttr(getIPFamilyFromIP(ip), nextHop, nlri), originAttr}
+	pattrs = append(pattrs, generateOptionalAttrs(attributes, uint32(bgp.COMMUNITY_NO_EXPORT))...)
+
 	return &api.Path{
 		Family: getIPFamilyFromIP(ip),
 		Nlri:   nlri,
-		Pattrs: []*apb.Any{generateNextHopAttr(isIPv6, nextHop.String(), nlri), originAttr, noExportCommunityAttr},
+		Pattrs: pattrs,
 	}
 }
 
-func generatePathForSubnet(subnet *net.IPNet, nextHop net.IP) *api.Path {
+func generatePathForSubnet(subnet *net.IPNet, nextHop net.IP, attributes *PathAttributes) *api.Path {
 	if subnet == nil {
 		return nil
 	}
@@ -139,25 +346,56 @@ func generatePathForSubnet(subnet *net.IPNet, nextHop net.IP) *api.Path {
 		PrefixLen: uint32(prefixLen),
 	})
 
+	pattrs := []*apb.Any{generateNextHopAttr(getIPFamilyFromIP(subnet.IP), nextHop, nlri), originAttr}
+	pattrs = append(pattrs, generateOptionalAttrs(attributes)...)
+
 	return &api.Path{
 		Family: getIPFamilyFromIP(subnet.IP),
 		Nlri:   nlri,
-		Pattrs: []*apb.Any{generateNextHopAttr(subnet.IP.To4() == nil, nextHop.String(), nlri), originAttr},
+		Pattrs: pattrs,
+	}
+}
+
+func generatePathForServiceIP(ip, nextHop net.IP, communities []uint32) *api.Path {
+	if len(ip) == 0 {
+		return nil
+	}
+
+	prefixBytesLen := uint32(net.IPv4len)
+	if ip.To4() == nil {
+		prefixBytesLen = net.IPv6len
+	}
+
+	nlri, _ := apb.New(&api.IPAddressPrefix{
+		Prefix:    ip.String(),
+		PrefixLen: prefixBytesLen * 8,
+	})
+
+	// paths of service ips are supposed to be exported, only the specified communities are attached
+	pattrs := []*apb.Any{generateNextHopAttr(getIPFamilyFromIP(ip), nextHop, nlri), originAttr}
+	pattrs = append(pattrs, generateOptionalAttrs(nil, communities...)...)
+
+	return &api.Path{
+		Family: getIPFamilyFromIP(ip),
+		Nlri:   nlri,
+		Pattrs: pattrs,
 	}
 }
 
-func generateNextHopAttr(isIPv6 bool, nextHop string, nlri *apb.Any) *apb.Any {
-	if isIPv6 {
-		v6NextHopAttr, _ := apb.New(&api.MpReachNLRIAttribute{
-			Family:   v6Family,
-			NextHops: []string{nextHop},
+// generateNextHopAttr generates the next hop attribute for nlri, ipv6 next hop is carried by
+// MP_REACH_NLRI attribute, including the one of ipv4 nlri (RFC 5549).
+func generateNextHopAttr(family *api.Family, nextHop net.IP, nlri *apb.Any) *apb.Any {
+	if nextHop.To4() == nil {
+		mpNextHopAttr, _ := apb.New(&api.MpReachNLRIAttribute{
+			Family:   family,
+			NextHops: []string{nextHop.String()},
 			Nlris:    []*apb.Any{nlri},
 		})
-		return v6NextHopAttr
+		return mpNextHopAttr
 	}
 
 	v4NextHopAttr, _ := apb.New(&api.NextHopAttribute{
-		NextHop: nextHop,
+		NextHop: nextHop.String(),
 	})
 	return v4NextHopAttr
 }
@@ -192,3 +430,51 @@ func generatePathListFunc(existSubnetPathMap map[string]*net.IPNet, existIPPathM
 		}
 	}
 }
+
+// parseReceivedPath returns the prefix and next hop of path, next hop is nil if not found.
+func parseReceivedPath(path *api.Path) (*net.IPNet, net.IP, error) {
+	if path.Nlri == nil {
+		return nil, nil, fmt.Errorf("nil nlri of path")
+	}
+
+	prefix := &api.IPAddressPrefix{}
+	if err := path.Nlri.UnmarshalTo(prefix); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal nlri: %v", err)
+	}
+
+	_, dst, err := net.ParseCIDR(fmt.Sprintf("%s/%d", prefix.Prefix, prefix.PrefixLen))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse prefix %v/%v: %v", prefix.Prefix, prefix.PrefixLen, err)
+	}
+
+	for _, pattr := range path.Pattrs {
+		attr, err := pattr.UnmarshalNew()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal path attribute: %v", err)
+		}
+
+		switch a := attr.(type) {
+		case *api.NextHopAttribute:
+			return dst, net.ParseIP(a.NextHop), nil
+		case *api.MpReachNLRIAttribute:
+			// the first one is the global address if a link-local one is also carried
+			if len(a.NextHops) > 0 {
+				return dst, net.ParseIP(a.NextHops[0]), nil
+			}
+		}
+	}
+
+	return dst, nil, nil
+}
+
+// isPrefixAllowed checks if the prefix is contained by one of the allowed prefixes.
+func isPrefixAllowed(prefix *net.IPNet, allowedPrefixes []*net.IPNet) bool {
+	ones, bits := prefix.Mask.Size()
+	for _, allowed := range allowedPrefixes {
+		allowedOnes, allowedBits := allowed.Mask.Size()
+		if allowedBits == bits && allowedOnes <= ones && allowed.Contains(prefix.IP) {
+			return true
+		}
+	}
+	return false
+}
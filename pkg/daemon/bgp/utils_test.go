@@ -0,0 +1,477 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package bgp
+
+import (
+	"net"
+	"testing"
+
+	api "github.com/osrg/gobgp/v3/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	apb "google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestDiffPeer(t *testing.T) {
+	peer := &peerInfo{
+		address:                "192.168.0.1",
+		asn:                    65001,
+		gracefulRestartSeconds: 300,
+		password:               "secret",
+	}
+
+	tests := []struct {
+		name          string
+		existPeer     *api.Peer
+		changedFields []string
+	}{
+		{
+			name:          "no change",
+			existPeer:     generatePeerConfig(peer),
+			changedFields: nil,
+		},
+		{
+			name: "asn changed",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65002, AuthPassword: "secret"},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 300},
+			},
+			changedFields: []string{PeerFieldASN},
+		},
+		{
+			name: "password changed",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65001},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 300},
+			},
+			changedFields: []string{PeerFieldPassword},
+		},
+		{
+			name: "graceful restart seconds changed",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65001, AuthPassword: "secret"},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 120},
+			},
+			changedFields: []string{PeerFieldGracefulRestartSeconds},
+		},
+		{
+			name: "import policy attached",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65001, AuthPassword: "secret"},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 300},
+				ApplyPolicy: &api.ApplyPolicy{
+					ImportPolicy: &api.PolicyAssignment{
+						Policies:      []*api.Policy{{Name: "debug"}},
+						DefaultAction: api.RouteAction_ACCEPT,
+					},
+				},
+			},
+			changedFields: []string{PeerFieldApplyPolicy},
+		},
+		{
+			name: "export rejected by default",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65001, AuthPassword: "secret"},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 300},
+				ApplyPolicy: &api.ApplyPolicy{
+					ImportPolicy: &api.PolicyAssignment{DefaultAction: api.RouteAction_ACCEPT},
+					ExportPolicy: &api.PolicyAssignment{DefaultAction: api.RouteAction_REJECT},
+				},
+			},
+			changedFields: []string{PeerFieldApplyPolicy},
+		},
+		{
+			name: "default policy with asn changed",
+			existPeer: &api.Peer{
+				Conf:            &api.PeerConf{PeerAsn: 65002, AuthPassword: "secret"},
+				GracefulRestart: &api.GracefulRestart{RestartTime: 300},
+				ApplyPolicy: &api.ApplyPolicy{
+					ImportPolicy: &api.PolicyAssignment{DefaultAction: api.RouteAction_ACCEPT},
+					ExportPolicy: &api.PolicyAssignment{DefaultAction: api.RouteAction_ACCEPT},
+				},
+			},
+			changedFields: []string{PeerFieldASN},
+		},
+		{
+			name:          "empty exist peer",
+			existPeer:     &api.Peer{},
+			changedFields: []string{PeerFieldASN, PeerFieldPassword, PeerFieldGracefulRestartSeconds},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.changedFields, diffPeer(peer, test.existPeer))
+		})
+	}
+}
+
+func TestNeedsSessionReset(t *testing.T) {
+	tests := []struct {
+		name          string
+		changedFields []string
+		sessionReset  bool
+	}{
+		{
+			name:          "no change",
+			changedFields: nil,
+			sessionReset:  false,
+		},
+		{
+			name:          "asn changed",
+			changedFields: []string{PeerFieldASN},
+			sessionReset:  true,
+		},
+		{
+			name:          "password changed",
+			changedFields: []string{PeerFieldPassword},
+			sessionReset:  true,
+		},
+		{
+			name:          "graceful restart seconds changed",
+			changedFields: []string{PeerFieldGracefulRestartSeconds},
+			sessionReset:  true,
+		},
+		{
+			name:          "apply policy changed",
+			changedFields: []string{PeerFieldApplyPolicy},
+			sessionReset:  false,
+		},
+		{
+			name:          "apply policy changed with password",
+			changedFields: []string{PeerFieldApplyPolicy, PeerFieldPassword},
+			sessionReset:  true,
+		},
+		{
+			name:          "unknown field changed",
+			changedFields: []string{"unknown"},
+			sessionReset:  false,
+		},
+		{
+			name:          "unknown field changed with asn",
+			changedFields: []string{"unknown", PeerFieldASN},
+			sessionReset:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.sessionReset, needsSessionReset(test.changedFields))
+		})
+	}
+}
+
+func TestParseReceivedPath(t *testing.T) {
+	newAny := func(m proto.Message) *apb.Any {
+		a, err := apb.New(m)
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %v", m, err)
+		}
+		return a
+	}
+
+	v4Nlri := newAny(&api.IPAddressPrefix{Prefix: "10.0.0.0", PrefixLen: 24})
+	v6Nlri := newAny(&api.IPAddressPrefix{Prefix: "fd00::", PrefixLen: 64})
+
+	tests := []struct {
+		name    string
+		path    *api.Path
+		dst     string
+		nextHop net.IP
+		wantErr bool
+	}{
+		{
+			name: "ipv4 path with next hop",
+			path: &api.Path{
+				Nlri:   v4Nlri,
+				Pattrs: []*apb.Any{originAttr, newAny(&api.NextHopAttribute{NextHop: "192.168.0.1"})},
+			},
+			dst:     "10.0.0.0/24",
+			nextHop: net.ParseIP("192.168.0.1"),
+		},
+		{
+			name: "ipv6 path with global and link-local next hops",
+			path: &api.Path{
+				Nlri: v6Nlri,
+				Pattrs: []*apb.Any{originAttr, newAny(&api.MpReachNLRIAttribute{
+					Family:   v6Family,
+					NextHops: []string{"fd00::1", "fe80::1"},
+				})},
+			},
+			dst:     "fd00::/64",
+			nextHop: net.ParseIP("fd00::1"),
+		},
+		{
+			name: "ipv4 path with ipv6 next hop",
+			path: &api.Path{
+				Nlri: v4Nlri,
+				Pattrs: []*apb.Any{newAny(&api.MpReachNLRIAttribute{
+					Family:   v4Family,
+					NextHops: []string{"fe80::1"},
+				})},
+			},
+			dst:     "10.0.0.0/24",
+			nextHop: net.ParseIP("fe80::1"),
+		},
+		{
+			name: "path without next hop",
+			path: &api.Path{
+				Nlri:   v4Nlri,
+				Pattrs: []*apb.Any{originAttr},
+			},
+			dst:     "10.0.0.0/24",
+			nextHop: nil,
+		},
+		{
+			name:    "nil nlri",
+			path:    &api.Path{},
+			wantErr: true,
+		},
+		{
+			name: "invalid prefix",
+			path: &api.Path{
+				Nlri: newAny(&api.IPAddressPrefix{Prefix: "10.0.0", PrefixLen: 24}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown nlri",
+			path: &api.Path{
+				Nlri: newAny(&api.NextHopAttribute{NextHop: "192.168.0.1"}),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst, nextHop, err := parseReceivedPath(test.path)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.dst, dst.String())
+			assert.True(t, test.nextHop.Equal(nextHop), "expected next hop %v, got %v", test.nextHop, nextHop)
+		})
+	}
+}
+
+func TestIsPrefixAllowed(t *testing.T) {
+	parseCIDR := func(cidr string) *net.IPNet {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		return ipNet
+	}
+
+	allowedPrefixes := []*net.IPNet{
+		parseCIDR("10.0.0.0/16"),
+		parseCIDR("0.0.0.0/0"),
+		parseCIDR("fd00::/48"),
+	}
+
+	tests := []struct {
+		name            string
+		prefix          string
+		allowedPrefixes []*net.IPNet
+		allowed         bool
+	}{
+		{
+			name:            "same prefix",
+			prefix:          "10.0.0.0/16",
+			allowedPrefixes: allowedPrefixes[:1],
+			allowed:         true,
+		},
+		{
+			name:            "more specific prefix",
+			prefix:          "10.0.1.0/24",
+			allowedPrefixes: allowedPrefixes[:1],
+			allowed:         true,
+		},
+		{
+			name:            "less specific prefix",
+			prefix:          "10.0.0.0/8",
+			allowedPrefixes: allowedPrefixes[:1],
+			allowed:         false,
+		},
+		{
+			name:            "prefix out of range",
+			prefix:          "10.1.0.0/24",
+			allowedPrefixes: allowedPrefixes[:1],
+			allowed:         false,
+		},
+		{
+			name:            "any ipv4 prefix by default route",
+			prefix:          "172.16.0.0/12",
+			allowedPrefixes: allowedPrefixes,
+			allowed:         true,
+		},
+		{
+			name:            "ipv6 prefix",
+			prefix:          "fd00:0:0:1::/64",
+			allowedPrefixes: allowedPrefixes,
+			allowed:         true,
+		},
+		{
+			name:            "ipv6 prefix not matched by ipv4 default route",
+			prefix:          "fd01::/64",
+			allowedPrefixes: allowedPrefixes,
+			allowed:         false,
+		},
+		{
+			name:            "no allowed prefix",
+			prefix:          "10.0.0.0/24",
+			allowedPrefixes: nil,
+			allowed:         false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.allowed, isPrefixAllowed(parseCIDR(test.prefix), test.allowedPrefixes))
+		})
+	}
+}
+
+func TestPathAttributesEqual(t *testing.T) {
+	localPreference := uint32(100)
+	sameLocalPreference := uint32(100)
+	otherLocalPreference := uint32(200)
+
+	tests := []struct {
+		name     string
+		a        *PathAttributes
+		b        *PathAttributes
+		expected bool
+	}{
+		{
+			name:     "both nil",
+			a:        nil,
+			b:        nil,
+			expected: true,
+		},
+		{
+			name:     "nil and empty",
+			a:        nil,
+			b:        &PathAttributes{},
+			expected: false,
+		},
+		{
+			name:     "both empty",
+			a:        &PathAttributes{},
+			b:        &PathAttributes{Communities: []uint32{}},
+			expected: true,
+		},
+		{
+			name:     "same attributes",
+			a:        &PathAttributes{Communities: []uint32{1, 2}, LocalPreference: &localPreference},
+			b:        &PathAttributes{Communities: []uint32{1, 2}, LocalPreference: &sameLocalPreference},
+			expected: true,
+		},
+		{
+			name:     "different community count",
+			a:        &PathAttributes{Communities: []uint32{1, 2}},
+			b:        &PathAttributes{Communities: []uint32{1}},
+			expected: false,
+		},
+		{
+			name:     "different communities",
+			a:        &PathAttributes{Communities: []uint32{1, 2}},
+			b:        &PathAttributes{Communities: []uint32{1, 3}},
+			expected: false,
+		},
+		{
+			name:     "local preference not set on one side",
+			a:        &PathAttributes{LocalPreference: &localPreference},
+			b:        &PathAttributes{},
+			expected: false,
+		},
+		{
+			name:     "different local preferences",
+			a:        &PathAttributes{LocalPreference: &localPreference},
+			b:        &PathAttributes{LocalPreference: &otherLocalPreference},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.a.equal(test.b))
+			assert.Equal(t, test.expected, test.b.equal(test.a))
+		})
+	}
+}
+
+func TestGenerateOptionalAttrs(t *testing.T) {
+	localPreference := uint32(100)
+
+	tests := []struct {
+		name             string
+		attributes       *PathAttributes
+		extraCommunities []uint32
+		expected         []proto.Message
+	}{
+		{
+			name:       "nil attributes",
+			attributes: nil,
+			expected:   nil,
+		},
+		{
+			name:       "empty attributes",
+			attributes: &PathAttributes{},
+			expected:   nil,
+		},
+		{
+			name:             "extra communities only",
+			attributes:       nil,
+			extraCommunities: []uint32{3, 1},
+			expected: []proto.Message{
+				&api.CommunitiesAttribute{Communities: []uint32{3, 1}},
+			},
+		},
+		{
+			name:       "local preference only",
+			attributes: &PathAttributes{LocalPreference: &localPreference},
+			expected: []proto.Message{
+				&api.LocalPrefAttribute{LocalPref: 100},
+			},
+		},
+		{
+			name:             "communities merged with extra ones",
+			attributes:       &PathAttributes{Communities: []uint32{2, 5}, LocalPreference: &localPreference},
+			extraCommunities: []uint32{5, 1},
+			expected: []proto.Message{
+				&api.LocalPrefAttribute{LocalPref: 100},
+				&api.CommunitiesAttribute{Communities: []uint32{1, 2, 5}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pattrs := generateOptionalAttrs(test.attributes, test.extraCommunities...)
+			if !assert.Equal(t, len(test.expected), len(pattrs)) {
+				return
+			}
+
+			for i := range pattrs {
+				attr, err := pattrs[i].UnmarshalNew()
+				assert.NoError(t, err)
+				assert.True(t, proto.Equal(test.expected[i], attr), "expected %v, got %v", test.expected[i], attr)
+			}
+		})
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 	"time"
 
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
 	"github.com/alibaba/hybridnet/pkg/utils"
@@ -38,12 +39,18 @@ const (
 	DefaultMetricsServerBindAddress = ":8091"
 	DefaultBGPgRPCServerBindAddress = ":50051"
 
-	DefaultVxlanUDPPort = 8472
+	DefaultVxlanUDPPort  = 8472
+	DefaultGeneveUDPPort = 6081
+
+	DefaultWireGuardListenPort = 51820
 
 	DefaultVlanCheckTimeout                     = 3 * time.Second
 	DefaultIPtablesCheckDuration                = 5 * time.Second
+	DefaultRuleBackend                          = "auto"
+	DefaultVtepAddressFamily                    = "ipv4"
 	DefaultVxlanBaseReachableTime               = 5 * time.Second
 	DefaultVxlanExpiredNeighCachesClearInterval = 1 * time.Hour
+	DefaultBGPPeerResetMinInterval              = 1 * time.Minute
 
 	DefaultNeighGCThresh1 = 1024
 	DefaultNeighGCThresh2 = 2048
@@ -52,6 +59,8 @@ const (
 	DefaultLocalDirectTableNum     = 39999
 	DefaultToOverlaySubnetTableNum = 40000
 	DefaultOverlayMarkTableNum     = 40001
+	DefaultWireGuardTableNum       = 40002
+	DefaultBGPImportTableNum       = 40003
 )
 
 // Configuration is the daemon conf
@@ -69,16 +78,26 @@ type Configuration struct {
 
 	ExtraNodeLocalVxlanIPCidrs []*net.IPNet
 
+	// Preferred address family of vtep ip, netlink.FAMILY_V4 or netlink.FAMILY_V6
+	VtepAddressFamily int
+
 	HealthyServerAddress string
 	MetricsServerAddress string
 	BGPgRPCServerAddress string
 
-	VxlanUDPPort int
+	VxlanUDPPort  int
+	GeneveUDPPort int
+
+	WireGuardListenPort int
 
 	VlanCheckTimeout                     time.Duration
 	IptablesCheckDuration                time.Duration
 	VxlanBaseReachableTime               time.Duration
 	VxlanExpiredNeighCachesClearInterval time.Duration
+	BGPPeerResetMinInterval              time.Duration
+
+	// Backend of host rules, iptables, nftables or auto
+	RuleBackend string
 
 	// Use fixed table num to mark "local-pod-direct rule"
 	LocalDirectTableNum int
@@ -89,6 +108,12 @@ type Configuration struct {
 	// Use fixed table num to mark "overlay-mark-table rule"
 	OverlayMarkTableNum int
 
+	// Use fixed table num to route overlay traffic to wireguard device
+	WireGuardTableNum int
+
+	// Use fixed table num to install routes learned from bgp peers
+	BGPImportTableNum int
+
 	NeighGCThresh1 int
 	NeighGCThresh2 int
 	NeighGCThresh3 int
@@ -107,16 +132,23 @@ func ParseFlags() (*Configuration, error) {
 		argBGPgRPCServerAddress                 = pflag.String("bgp-grpc-server-addr", DefaultBGPgRPCServerBindAddress, "The address which daemon bgp grpc server bind, for using gobgp command to debug")
 		argLocalDirectTableNum                  = pflag.Int("local-direct-table", DefaultLocalDirectTableNum, "The number of local-pod-direct route table")
 		argIPtablesCheckDuration                = pflag.Duration("iptables-check-duration", DefaultIPtablesCheckDuration, "The time period for iptables manager to check iptables rules")
+		argRuleBackend                          = pflag.String("rule-backend", DefaultRuleBackend, "The backend of host rules, one of iptables, nftables and auto. If auto, nftables will be used only if iptables command is not found")
 		argToOverlaySubnetTableNum              = pflag.Int("to-overlay-table", DefaultToOverlaySubnetTableNum, "The number of to-overlay-pod-subnet route table")
 		argOverlayMarkTableNum                  = pflag.Int("overlay-mark-table", DefaultOverlayMarkTableNum, "The number of overlay-mark routing table")
 		argVlanCheckTimeout                     = pflag.Duration("vlan-check-timeout", DefaultVlanCheckTimeout, "The timeout of vlan network environment check while pod creating")
 		argVxlanUDPPort                         = pflag.Int("vxlan-udp-port", DefaultVxlanUDPPort, "The local udp port which vxlan tunnel use")
+		argGeneveUDPPort                        = pflag.Int("geneve-udp-port", DefaultGeneveUDPPort, "The udp port which geneve tunnel use")
+		argWireGuardListenPort                  = pflag.Int("wireguard-listen-port", DefaultWireGuardListenPort, "The udp port which wireguard device listens on if overlay traffic is encrypted")
+		argWireGuardTableNum                    = pflag.Int("wireguard-table", DefaultWireGuardTableNum, "The number of routing table to route overlay traffic to wireguard device")
+		argBGPImportTableNum                    = pflag.Int("bgp-import-table", DefaultBGPImportTableNum, "The number of routing table to install routes learned from bgp peers")
 		argVxlanBaseReachableTime               = pflag.Duration("vxlan-base-reachable-time", DefaultVxlanBaseReachableTime, "The time for neigh caches of vxlan device to get STALE from REACHABLE")
 		argVxlanExpiredNeighCachesClearInterval = pflag.Duration("vxlan-expired-neigh-caches-clear-interval", DefaultVxlanExpiredNeighCachesClearInterval, "The interval for daemon to clear STALE and FAILED neigh caches of vxlan device")
 		argNeighGCThresh1                       = pflag.Int("neigh-gc-thresh1", DefaultNeighGCThresh1, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh1")
 		argNeighGCThresh2                       = pflag.Int("neigh-gc-thresh2", DefaultNeighGCThresh2, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh2")
 		argNeighGCThresh3                       = pflag.Int("neigh-gc-thresh3", DefaultNeighGCThresh3, "Value to set net.ipv4/ipv6.neigh.default.gc_thresh3")
 		argExtraNodeLocalVxlanIPCidrs           = pflag.String("extra-node-local-vxlan-ip-cidrs", "", "Cidrs to select node extra local vxlan ip, e.g., \"192.168.10.0/24,10.2.3.0/24\"")
+		argBGPPeerResetMinInterval              = pflag.Duration("bgp-peer-reset-min-interval", DefaultBGPPeerResetMinInterval, "The min interval between two bgp session resets of a peer, which are caused by configuration changes")
+		argVtepAddressFamily                    = pflag.String("vtep-address-family", DefaultVtepAddressFamily, "The preferred address family of vtep ip, ipv4 or ipv6. The other family will be used if no address of preferred family exists on vxlan interface")
 	)
 
 	// mute info log for ipset lib
@@ -144,12 +176,18 @@ func ParseFlags() (*Configuration, error) {
 		OverlayMarkTableNum:                  *argOverlayMarkTableNum,
 		VlanCheckTimeout:                     *argVlanCheckTimeout,
 		VxlanUDPPort:                         *argVxlanUDPPort,
+		GeneveUDPPort:                        *argGeneveUDPPort,
+		WireGuardListenPort:                  *argWireGuardListenPort,
+		WireGuardTableNum:                    *argWireGuardTableNum,
+		BGPImportTableNum:                    *argBGPImportTableNum,
 		IptablesCheckDuration:                *argIPtablesCheckDuration,
+		RuleBackend:                          *argRuleBackend,
 		VxlanBaseReachableTime:               *argVxlanBaseReachableTime,
 		NeighGCThresh1:                       *argNeighGCThresh1,
 		NeighGCThresh2:                       *argNeighGCThresh2,
 		NeighGCThresh3:                       *argNeighGCThresh3,
 		VxlanExpiredNeighCachesClearInterval: *argVxlanExpiredNeighCachesClearInterval,
+		BGPPeerResetMinInterval:              *argBGPPeerResetMinInterval,
 	}
 
 	if *argPreferVlanInterfaces == "" {
@@ -164,6 +202,15 @@ func ParseFlags() (*Configuration, error) {
 		}
 	}
 
+	switch *argVtepAddressFamily {
+	case "ipv4":
+		config.VtepAddressFamily = netlink.FAMILY_V4
+	case "ipv6":
+		config.VtepAddressFamily = netlink.FAMILY_V6
+	default:
+		return nil, fmt.Errorf("unsupported vtep address family %v, should be ipv4 or ipv6", *argVtepAddressFamily)
+	}
+
 	if err := config.initNicConfig(); err != nil {
 		return nil, err
 	}
@@ -221,9 +268,15 @@ func (config *Configuration) initNicConfig() error {
 		config.BGPMTU = bgpNodeInterface.MTU
 	}
 
-	// VXLAN uses a 50-byte header
-	if config.VxlanMTU == 0 || config.VxlanMTU > vxlanNodeInterface.MTU-50 {
-		config.VxlanMTU = vxlanNodeInterface.MTU - 50
+	// VXLAN uses a 50-byte header, and 70-byte if vtep ip selected is ipv6
+	vxlanMaxMTU, err := containernetwork.GetMaxMTU(vxlanNodeInterface.Name, networkingv1.NetworkModeVxlan,
+		config.VtepAddressFamily == netlink.FAMILY_V6)
+	if err != nil {
+		return fmt.Errorf("failed to get max mtu of vxlan node interface: %v", err)
+	}
+
+	if config.VxlanMTU == 0 || config.VxlanMTU > vxlanMaxMTU {
+		config.VxlanMTU = vxlanMaxMTU
 	}
 
 	return nil
@@ -0,0 +1,70 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package conntrack
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ipFilter matches conntrack flows with any of the ips as source or destination in both directions,
+// which also covers the NAT entries of which the reply tuple is changed.
+type ipFilter struct {
+	ips []net.IP
+}
+
+func (f *ipFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	for _, ip := range f.ips {
+		if ip.Equal(flow.Forward.SrcIP) || ip.Equal(flow.Forward.DstIP) ||
+			ip.Equal(flow.Reverse.SrcIP) || ip.Equal(flow.Reverse.DstIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlushIPs deletes all the conntrack entries related to the ips, it should be called once
+// the ips are released or reassigned, or stale entries might misroute the flows of new pods.
+func FlushIPs(ips ...net.IP) (uint, error) {
+	familyIPs := map[netlink.InetFamily][]net.IP{}
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+
+		family := netlink.InetFamily(unix.AF_INET6)
+		if ip.To4() != nil {
+			family = unix.AF_INET
+		}
+		familyIPs[family] = append(familyIPs[family], ip)
+	}
+
+	var deleted uint
+	// one dump for each family
+	for family, ips := range familyIPs {
+		count, err := netlink.ConntrackDeleteFilter(netlink.ConntrackTable, family, &ipFilter{ips: ips})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete conntrack entries of %v: %v", ips, err)
+		}
+		deleted += count
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,74 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIPFilter(t *testing.T) {
+	newFlow := func(forwardSrc, forwardDst, reverseSrc, reverseDst string) *netlink.ConntrackFlow {
+		flow := &netlink.ConntrackFlow{}
+		flow.Forward.SrcIP, flow.Forward.DstIP = net.ParseIP(forwardSrc), net.ParseIP(forwardDst)
+		flow.Reverse.SrcIP, flow.Reverse.DstIP = net.ParseIP(reverseSrc), net.ParseIP(reverseDst)
+		return flow
+	}
+
+	filter := &ipFilter{ips: []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")}}
+
+	tests := []struct {
+		name    string
+		flow    *netlink.ConntrackFlow
+		matched bool
+	}{
+		{
+			name:    "pod as source",
+			flow:    newFlow("10.0.0.1", "8.8.8.8", "8.8.8.8", "10.0.0.1"),
+			matched: true,
+		},
+		{
+			name:    "pod as destination",
+			flow:    newFlow("10.0.0.2", "10.0.0.1", "10.0.0.1", "10.0.0.2"),
+			matched: true,
+		},
+		{
+			name:    "masqueraded pod",
+			flow:    newFlow("10.0.0.3", "8.8.8.8", "8.8.8.8", "10.0.0.1"),
+			matched: true,
+		},
+		{
+			name:    "ipv6 pod",
+			flow:    newFlow("fd00::1", "fd00::2", "fd00::2", "fd00::1"),
+			matched: true,
+		},
+		{
+			name:    "unrelated",
+			flow:    newFlow("10.0.0.2", "8.8.8.8", "8.8.8.8", "10.0.0.2"),
+			matched: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.matched, filter.MatchConntrackFlow(test.flow))
+		})
+	}
+}
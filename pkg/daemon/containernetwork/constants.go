@@ -24,6 +24,7 @@ const (
 	ContainerHostLinkMac    = "ee:ee:ee:ee:ee:ee"
 	ContainerInitLinkSuffix = "_c"
 	VxlanLinkInfix          = ".vxlan"
+	GeneveLinkInfix         = ".geneve"
 	ContainerNicName        = "eth0"
 
 	ProxyArpSysctl       = "/proc/sys/net/ipv4/conf/%s/proxy_arp"
@@ -155,7 +155,7 @@ func ConfigureHostNic(nicName string, allocatedIPs map[networkingv1.IPVersion]*I
 }
 
 func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, allocatedIPs map[networkingv1.IPVersion]*IPInfo,
-	macAddr net.HardwareAddr, netID *int32, netns ns.NetNS, mtu int, vlanCheckTimeout time.Duration,
+	extraRouteDsts []*net.IPNet, macAddr net.HardwareAddr, outerVlanID, netID *int32, netns ns.NetNS, mtu int, vlanCheckTimeout time.Duration,
 	networkMode networkingv1.NetworkMode, neighGCThresh1, neighGCThresh2, neighGCThresh3 int) error {
 
 	var defaultRouteNets []*types.Route
@@ -167,14 +167,14 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 
 	switch networkMode {
 	case networkingv1.NetworkModeVlan:
-		forwardNodeIfName, err = GenerateVlanNetIfName(nodeIfName, netID)
+		forwardNodeIfName, err = GenerateVlanNetIfName(nodeIfName, outerVlanID, netID)
 		if err != nil {
 			return fmt.Errorf("failed to generate vlan forward node interface name: %v", err)
 		}
-	case networkingv1.NetworkModeVxlan:
-		forwardNodeIfName, err = GenerateVxlanNetIfName(nodeIfName, netID)
+	case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
+		forwardNodeIfName, err = GenerateOverlayNetIfName(nodeIfName, networkMode, netID)
 		if err != nil {
-			return fmt.Errorf("failed to generate vxlan forward node interface name: %v", err)
+			return fmt.Errorf("failed to generate %v forward node interface name: %v", networkMode, err)
 		}
 	case networkingv1.NetworkModeBGP:
 		forwardNodeIfName = nodeIfName
@@ -279,6 +279,24 @@ func ConfigureContainerNic(containerNicName, hostNicName, nodeIfName string, all
 		}
 	}
 
+	// All the traffic of pod goes through host, so the extra routes always point to the virtual default gateway,
+	// next hops of them are taken care of by policy routes on host.
+	for _, dst := range extraRouteDsts {
+		var gateway net.IP
+		if dst.IP.To4() != nil && allocatedIPs[networkingv1.IPv4] != nil {
+			gateway = net.ParseIP(PodVirtualV4DefaultGateway)
+		} else if dst.IP.To4() == nil && allocatedIPs[networkingv1.IPv6] != nil {
+			gateway = net.ParseIP(PodVirtualV6DefaultGateway)
+		} else {
+			continue
+		}
+
+		defaultRouteNets = append(defaultRouteNets, &types.Route{
+			Dst: *dst,
+			GW:  gateway,
+		})
+	}
+
 	if err := ns.WithNetNSPath(netns.Path(), func(_ ns.NetNS) error {
 		containerLink, err := netlink.LinkByName(containerNicName)
 		if err != nil {
@@ -353,3 +371,54 @@ func CheckIfContainerNetworkLink(linkName string) bool {
 		strings.HasPrefix(linkName, "veth") ||
 		strings.HasPrefix(linkName, "docker")
 }
+
+// IPConflictError means a pod ip is already used by another host on the forward interface.
+type IPConflictError struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	Interface    string
+}
+
+func (e *IPConflictError) Error() string {
+	return fmt.Sprintf("pod ip %v conflicts with hw addr %v on interface %v"+
+		", please check if ip %v is occupied by other machines or containers",
+		e.IP.String(), e.HardwareAddr.String(), e.Interface, e.IP.String())
+}
+
+// DetectIPConflict runs arp probe or ipv6 duplicate address detection for the allocated ips over the
+// forward interface of an underlay pod, and returns an *IPConflictError once any ip is found in use.
+// Replies from the ignored hw addrs, e.g., the ones of cluster nodes, are never taken as conflicts.
+func DetectIPConflict(forwardNodeIfName string, allocatedIPs map[networkingv1.IPVersion]*IPInfo,
+	timeout time.Duration, ignoredHwAddrs []net.HardwareAddr) error {
+	forwardNodeIf, err := net.InterfaceByName(forwardNodeIfName)
+	if err != nil {
+		return fmt.Errorf("failed get forward node interface %v: %v; if not exist, waiting for daemon to create it", forwardNodeIfName, err)
+	}
+
+	for version, ipInfo := range allocatedIPs {
+		if ipInfo == nil {
+			continue
+		}
+
+		var conflictHw net.HardwareAddr
+		if version == networkingv1.IPv6 {
+			conflictHw, err = ndp.DetectDuplicate(forwardNodeIf, ipInfo.Addr, timeout, ignoredHwAddrs)
+		} else {
+			conflictHw, err = arp.Probe(forwardNodeIf, ipInfo.Addr, timeout, ignoredHwAddrs)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to detect conflict of pod ip %v: %v", ipInfo.Addr.String(), err)
+		}
+
+		if conflictHw != nil {
+			return &IPConflictError{
+				IP:           ipInfo.Addr,
+				HardwareAddr: conflictHw,
+				Interface:    forwardNodeIfName,
+			}
+		}
+	}
+
+	return nil
+}
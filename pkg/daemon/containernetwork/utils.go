@@ -17,6 +17,7 @@
 package containernetwork
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -30,19 +31,24 @@ import (
 	"golang.org/x/sys/unix"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 
 	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
 
 	"github.com/vishvananda/netlink"
 )
 
+var zeroVlanID int32
+
 type IPInfo struct {
 	Addr net.IP
 	Gw   net.IP
 	Cidr *net.IPNet
 }
 
-func GenerateVlanNetIfName(parentName string, vlanID *int32) (string, error) {
+// GenerateVlanNetIfName generates the name of vlan interface, which will be "<parent>.<s-tag>.<c-tag>"
+// if outer vlan id is specified for 802.1ad.
+func GenerateVlanNetIfName(parentName string, outerVlanID, vlanID *int32) (string, error) {
 	if vlanID == nil {
 		return "", fmt.Errorf("vlan id should not be nil")
 	}
@@ -51,11 +57,23 @@ func GenerateVlanNetIfName(parentName string, vlanID *int32) (string, error) {
 		return "", fmt.Errorf("vlan id's value range is from 0 to 4094")
 	}
 
-	if *vlanID == 0 {
-		return parentName, nil
+	if outerVlanID != nil {
+		if *outerVlanID < 1 || *outerVlanID > 4094 {
+			return "", fmt.Errorf("outer vlan id's value range is from 1 to 4094")
+		}
+		parentName = fmt.Sprintf("%s.%v", parentName, *outerVlanID)
+	}
+
+	vlanIfName := parentName
+	if *vlanID != 0 {
+		vlanIfName = fmt.Sprintf("%s.%v", parentName, *vlanID)
+	}
+
+	if len(vlanIfName) >= unix.IFNAMSIZ {
+		return "", fmt.Errorf("vlan interface name %v is longer than %v", vlanIfName, unix.IFNAMSIZ-1)
 	}
 
-	return fmt.Sprintf("%s.%v", parentName, *vlanID), nil
+	return vlanIfName, nil
 }
 
 func GenerateVxlanNetIfName(parentName string, vlanID *int32) (string, error) {
@@ -71,49 +89,120 @@ func GenerateVxlanNetIfName(parentName string, vlanID *int32) (string, error) {
 	return fmt.Sprintf("%s%s%v", parentName, VxlanLinkInfix, *vlanID), nil
 }
 
-func EnsureVlanIf(nodeIfName string, vlanID *int32) (string, error) {
-	nodeIf, err := netlink.LinkByName(nodeIfName)
-	if err != nil {
-		return "", err
+func GenerateGeneveNetIfName(parentName string, vni *int32) (string, error) {
+	if vni == nil || *vni == 0 {
+		return "", fmt.Errorf("geneve vni should not be nil or zero")
+	}
+
+	maxVNI := int32(1<<24 - 1)
+	if *vni > maxVNI {
+		return "", fmt.Errorf("geneve vni's value range is from 1 to %d", maxVNI)
 	}
 
-	vlanIfName, err := GenerateVlanNetIfName(nodeIfName, vlanID)
+	return fmt.Sprintf("%s%s%v", parentName, GeneveLinkInfix, *vni), nil
+}
+
+// GenerateOverlayNetIfName generates the name of overlay interface for the specified overlay network mode.
+func GenerateOverlayNetIfName(parentName string, mode networkingv1.NetworkMode, netID *int32) (string, error) {
+	if mode == networkingv1.NetworkModeGeneve {
+		return GenerateGeneveNetIfName(parentName, netID)
+	}
+	return GenerateVxlanNetIfName(parentName, netID)
+}
+
+// IsOverlayLinkName checks if the interface is an overlay interface created by hybridnet.
+func IsOverlayLinkName(name string) bool {
+	return strings.Contains(name, VxlanLinkInfix) || strings.Contains(name, GeneveLinkInfix)
+}
+
+// EnsureVlanIf ensures the vlan interface on node interface, an 802.1ad interface will be
+// created first as the parent if outer vlan id is specified.
+func EnsureVlanIf(nodeIfName string, outerVlanID, vlanID *int32) (string, error) {
+	parentIfName := nodeIfName
+	if outerVlanID != nil {
+		var err error
+		if parentIfName, err = GenerateVlanNetIfName(nodeIfName, outerVlanID, &zeroVlanID); err != nil {
+			return "", fmt.Errorf("failed to generate outer vlan interface name: %v", err)
+		}
+
+		if err = ensureVlanLink(nodeIfName, parentIfName, int(*outerVlanID), netlink.VLAN_PROTOCOL_8021AD); err != nil {
+			return "", fmt.Errorf("failed to ensure outer vlan interface %v: %v", parentIfName, err)
+		}
+	}
+
+	vlanIfName, err := GenerateVlanNetIfName(nodeIfName, outerVlanID, vlanID)
 	if err != nil {
 		return "", fmt.Errorf("failed to ensure bridge: %v", err)
 	}
 
-	// create the vlan interface if not exist
-	var vlanIf netlink.Link
-	if vlanIf, err = netlink.LinkByName(vlanIfName); err != nil {
-		if vlanIfName == nodeIfName {
-			// Pod in the same vlan with node.
-			return vlanIfName, nil
+	// Pod in the same vlan with node, or with the outer vlan interface.
+	if vlanIfName == parentIfName {
+		return vlanIfName, ensureLinkUp(vlanIfName)
+	}
+
+	return vlanIfName, ensureVlanLink(parentIfName, vlanIfName, int(*vlanID), netlink.VLAN_PROTOCOL_8021Q)
+}
+
+// GetVlanUplinkIfName returns the uplink interface of vlan network on this node. Node annotation takes
+// precedence over network config, and the default vlan interface of node will be used if neither is specified.
+func GetVlanUplinkIfName(defaultIfName string, network *networkingv1.Network, nodeAnnotations map[string]string) (string, error) {
+	preferString := networkingv1.GetVlanUplinkInterfaces(network)
+
+	if uplinkAnnotation, exist := nodeAnnotations[constants.AnnotationNodeVlanUplinkInterfaces]; exist {
+		uplinks := map[string]string{}
+		if err := json.Unmarshal([]byte(uplinkAnnotation), &uplinks); err != nil {
+			return "", fmt.Errorf("failed to parse annotation %v: %v", constants.AnnotationNodeVlanUplinkInterfaces, err)
 		}
 
-		vif := &netlink.Vlan{
-			VlanId:    int(*vlanID),
-			LinkAttrs: netlink.NewLinkAttrs(),
+		if uplink, exist := uplinks[network.Name]; exist && len(uplink) != 0 {
+			preferString = uplink
 		}
-		vif.ParentIndex = nodeIf.Attrs().Index
-		vif.Name = vlanIfName
+	}
+
+	if len(preferString) == 0 {
+		return defaultIfName, nil
+	}
 
-		err = netlink.LinkAdd(vif)
+	uplinkIf, err := GetInterfaceByPreferString(preferString)
+	if err != nil {
+		return "", fmt.Errorf("failed to get vlan uplink interface of network %v: %v", network.Name, err)
+	}
+
+	return uplinkIf.Name, nil
+}
+
+func ensureVlanLink(parentIfName, vlanIfName string, vlanID int, protocol netlink.VlanProtocol) error {
+	// create the vlan interface if not exist
+	if _, err := netlink.LinkByName(vlanIfName); err != nil {
+		parentIf, err := netlink.LinkByName(parentIfName)
 		if err != nil {
-			return vlanIfName, err
+			return err
 		}
 
-		vlanIf, err = netlink.LinkByName(vlanIfName)
-		if err != nil {
-			return vlanIfName, err
+		vif := &netlink.Vlan{
+			VlanId:       vlanID,
+			VlanProtocol: protocol,
+			LinkAttrs:    netlink.NewLinkAttrs(),
+		}
+		vif.ParentIndex = parentIf.Attrs().Index
+		vif.Name = vlanIfName
+
+		if err = netlink.LinkAdd(vif); err != nil {
+			return err
 		}
 	}
 
-	// setup the vlan (or node interface) if it's not UP
-	if err = netlink.LinkSetUp(vlanIf); err != nil {
-		return vlanIfName, err
+	return ensureLinkUp(vlanIfName)
+}
+
+// ensureLinkUp sets up the vlan (or node interface) if it's not UP
+func ensureLinkUp(ifName string) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return err
 	}
 
-	return vlanIfName, nil
+	return netlink.LinkSetUp(link)
 }
 
 func GetDefaultInterface(family int) (*net.Interface, error) {
@@ -179,6 +268,32 @@ func GetInterfaceByPreferString(preferString string) (*net.Interface, error) {
 	return nil, fmt.Errorf("no valid interface found by prefer string %v", preferString)
 }
 
+// GetMaxMTU returns the max mtu of pods forwarded by node interface in network mode,
+// which is the mtu of node interface minus the encapsulation overhead. For overlay
+// network, the overhead depends on the family of vtep ip selected from node interface.
+func GetMaxMTU(nodeIfName string, mode networkingv1.NetworkMode, preferIPv6Vtep bool) (int, error) {
+	link, err := netlink.LinkByName(nodeIfName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node interface %v: %v", nodeIfName, err)
+	}
+
+	ipv6Underlay := false
+	if mode == networkingv1.NetworkModeVxlan || mode == networkingv1.NetworkModeGeneve {
+		addrList, err := ListAllAddress(link)
+		if err != nil {
+			return 0, err
+		}
+
+		var candidates []net.IP
+		for _, addr := range addrList {
+			candidates = append(candidates, addr.IP)
+		}
+		ipv6Underlay = daemonutils.IsIPv6VtepSelected(candidates, preferIPv6Vtep)
+	}
+
+	return link.Attrs().MTU - networkingv1.GetMTUOverhead(mode, ipv6Underlay), nil
+}
+
 func GenerateIPListString(addrList []netlink.Addr) string {
 	ipListString := ""
 	for _, addr := range addrList {
@@ -304,7 +419,7 @@ func checkPodNetConfigReady(podIP net.IP, podCidr *net.IPNet, forwardNodeIfIndex
 
 	for i := 0; i < retries; i++ {
 		switch networkMode {
-		case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeVlan:
+		case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve, networkingv1.NetworkModeVlan:
 			neighExist, err := checkPodNeighExist(podIP, forwardNodeIfIndex, family)
 			if err != nil {
 				return fmt.Errorf("failed to check pod ip %v neigh exist: %v", podIP, err)
@@ -496,6 +611,43 @@ func CheckIPv6GlobalDisabled() (bool, error) {
 	return false, nil
 }
 
+// EnsureOverlayLinkSysctls makes neigh entries on overlay interface resolved by daemon in userspace.
+func EnsureOverlayLinkSysctls(linkName string, baseReachableTime time.Duration) error {
+	sysctlPath := fmt.Sprintf(IPv4AppSolicitSysctl, linkName)
+	if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, 1); err != nil {
+		return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+	}
+
+	sysctlPath = fmt.Sprintf(IPv4BaseReachableTimeMSSysctl, linkName)
+	if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, int(1000*baseReachableTime.Seconds())); err != nil {
+		return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+	}
+
+	ipv6Disabled, err := CheckIPv6Disabled(linkName)
+	if err != nil {
+		return fmt.Errorf("failed to check ipv6 disables for link %v: %v", linkName, err)
+	}
+
+	if !ipv6Disabled {
+		sysctlPath = fmt.Sprintf(IPv6AppSolicitSysctl, linkName)
+		if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, 1); err != nil {
+			return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+		}
+
+		sysctlPath = fmt.Sprintf(IPv6BaseReachableTimeMSSysctl, linkName)
+		if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, int(1000*baseReachableTime.Seconds())); err != nil {
+			return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+		}
+
+		sysctlPath = fmt.Sprintf(AcceptRASysctl, linkName)
+		if err := daemonutils.SetSysctl(sysctlPath, 0); err != nil {
+			return fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+		}
+	}
+
+	return nil
+}
+
 func CheckIPv6Disabled(nicName string) (bool, error) {
 	globalDisabled, err := CheckIPv6GlobalDisabled()
 	if err != nil {
@@ -0,0 +1,215 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestGenerateVlanNetIfName(t *testing.T) {
+	tests := []struct {
+		name        string
+		parentName  string
+		outerVlanID *int32
+		vlanID      *int32
+		expected    string
+		expectError bool
+	}{
+		{
+			name:       "vlan",
+			parentName: "eth0",
+			vlanID:     int32Ptr(100),
+			expected:   "eth0.100",
+		},
+		{
+			name:       "no vlan",
+			parentName: "eth0",
+			vlanID:     int32Ptr(0),
+			expected:   "eth0",
+		},
+		{
+			name:        "qinq",
+			parentName:  "eth0",
+			outerVlanID: int32Ptr(200),
+			vlanID:      int32Ptr(100),
+			expected:    "eth0.200.100",
+		},
+		{
+			name:        "qinq without inner vlan",
+			parentName:  "eth0",
+			outerVlanID: int32Ptr(200),
+			vlanID:      int32Ptr(0),
+			expected:    "eth0.200",
+		},
+		{
+			name:        "nil vlan id",
+			parentName:  "eth0",
+			expectError: true,
+		},
+		{
+			name:        "vlan id out of range",
+			parentName:  "eth0",
+			vlanID:      int32Ptr(4097),
+			expectError: true,
+		},
+		{
+			name:        "zero outer vlan id",
+			parentName:  "eth0",
+			outerVlanID: int32Ptr(0),
+			vlanID:      int32Ptr(100),
+			expectError: true,
+		},
+		{
+			name:        "outer vlan id out of range",
+			parentName:  "eth0",
+			outerVlanID: int32Ptr(4095),
+			vlanID:      int32Ptr(100),
+			expectError: true,
+		},
+		{
+			name:       "longest name",
+			parentName: "enp59s0f1",
+			vlanID:     int32Ptr(4094),
+			expected:   "enp59s0f1.4094",
+		},
+		{
+			name:        "qinq name longer than IFNAMSIZ",
+			parentName:  "enp59s0f1",
+			outerVlanID: int32Ptr(4094),
+			vlanID:      int32Ptr(4094),
+			expectError: true,
+		},
+		{
+			name:        "name of IFNAMSIZ",
+			parentName:  "enp59s0f1np1",
+			vlanID:      int32Ptr(100),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, err := GenerateVlanNetIfName(test.parentName, test.outerVlanID, test.vlanID)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, name)
+		})
+	}
+}
+
+func TestGetVlanUplinkIfName(t *testing.T) {
+	newNetwork := func(uplinks string) *networkingv1.Network {
+		network := &networkingv1.Network{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "network1",
+			},
+		}
+		if len(uplinks) != 0 {
+			network.Spec.Config = &networkingv1.NetworkConfig{VlanUplinkInterfaces: uplinks}
+		}
+		return network
+	}
+
+	tests := []struct {
+		name        string
+		network     *networkingv1.Network
+		annotations map[string]string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "default interface",
+			network:  newNetwork(""),
+			expected: "eth-default",
+		},
+		{
+			name:     "network preference",
+			network:  newNetwork("not-exist,lo"),
+			expected: "lo",
+		},
+		{
+			name:    "node annotation takes precedence",
+			network: newNetwork("not-exist"),
+			annotations: map[string]string{
+				constants.AnnotationNodeVlanUplinkInterfaces: `{"network1":"lo"}`,
+			},
+			expected: "lo",
+		},
+		{
+			name:    "network missing in node annotation",
+			network: newNetwork("lo"),
+			annotations: map[string]string{
+				constants.AnnotationNodeVlanUplinkInterfaces: `{"network2":"not-exist"}`,
+			},
+			expected: "lo",
+		},
+		{
+			name:    "network missing in node annotation without preference",
+			network: newNetwork(""),
+			annotations: map[string]string{
+				constants.AnnotationNodeVlanUplinkInterfaces: `{"network2":"not-exist"}`,
+			},
+			expected: "eth-default",
+		},
+		{
+			name:    "empty uplink in node annotation",
+			network: newNetwork("lo"),
+			annotations: map[string]string{
+				constants.AnnotationNodeVlanUplinkInterfaces: `{"network1":""}`,
+			},
+			expected: "lo",
+		},
+		{
+			name:    "bad json in node annotation",
+			network: newNetwork("lo"),
+			annotations: map[string]string{
+				constants.AnnotationNodeVlanUplinkInterfaces: `{"network1":`,
+			},
+			expectError: true,
+		},
+		{
+			name:        "no valid interface",
+			network:     newNetwork("not-exist"),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, err := GetVlanUplinkIfName("eth-default", test.network, test.annotations)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, name)
+		})
+	}
+}
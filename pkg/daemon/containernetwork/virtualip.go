@@ -0,0 +1,289 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+)
+
+// ConfigureVirtualIP adds virtual ip as a secondary address of the pod behind host nic,
+// and routes it to the host nic in local direct table.
+func ConfigureVirtualIP(hostNicName string, virtualIP net.IP, localDirectTableNum int) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	if err = addVirtualIPToPod(hostLink, virtualIP); err != nil {
+		return err
+	}
+
+	route := &netlink.Route{
+		LinkIndex: hostLink.Attrs().Index,
+		Dst:       virtualIPNet(virtualIP),
+		Table:     localDirectTableNum,
+	}
+	if err = netlink.RouteReplace(route); err != nil {
+		return fmt.Errorf("failed to add route %v: %v", route.String(), err)
+	}
+
+	return nil
+}
+
+// IsVirtualIPRouted checks whether virtual ip is routed to the host nic in local direct table. The route
+// is removed along with the host nic if the pod sandbox is recreated, which means the virtual ip needs to
+// be configured again.
+func IsVirtualIPRouted(hostNicName string, virtualIP net.IP, localDirectTableNum int) (bool, error) {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	family := netlink.FAMILY_V4
+	if virtualIP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	routeList, err := netlink.RouteListFiltered(family, &netlink.Route{
+		Dst:   virtualIPNet(virtualIP),
+		Table: localDirectTableNum,
+	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return false, fmt.Errorf("failed to list route for virtual ip %v: %v", virtualIP, err)
+	}
+
+	for _, route := range routeList {
+		if route.LinkIndex == hostLink.Attrs().Index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemoveVirtualIP removes the route of virtual ip in local direct table, and removes the address
+// from the pod behind host nic if it still exists. Empty host nic name means that only the route
+// will be removed.
+func RemoveVirtualIP(hostNicName string, virtualIP net.IP, localDirectTableNum int) error {
+	if err := netlink.RouteDel(&netlink.Route{
+		Dst:   virtualIPNet(virtualIP),
+		Table: localDirectTableNum,
+	}); err != nil && err != unix.ESRCH {
+		return fmt.Errorf("failed to delete route for virtual ip %v: %v", virtualIP, err)
+	}
+
+	if len(hostNicName) == 0 {
+		return nil
+	}
+
+	return removeVirtualIPFromPod(hostNicName, virtualIP)
+}
+
+// AddAnycastIP adds anycast ip as a secondary address of the pod behind host nic, the route
+// of anycast ip is configured by RouteAnycastIP.
+func AddAnycastIP(hostNicName string, anycastIP net.IP) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	return addVirtualIPToPod(hostLink, anycastIP)
+}
+
+// RouteAnycastIP routes anycast ip to the pods behind host nics in local direct table, traffic is
+// balanced among the pods by a multipath route. Next hops are the addresses of pods in the same family
+// as anycast ip, which are required by ipv6 multipath routes. The route can be removed by RemoveVirtualIP.
+func RouteAnycastIP(podIPs map[string]net.IP, anycastIP net.IP, localDirectTableNum int) error {
+	hostNicNames := make([]string, 0, len(podIPs))
+	for hostNicName := range podIPs {
+		hostNicNames = append(hostNicNames, hostNicName)
+	}
+	sort.Strings(hostNicNames)
+
+	var nextHops []*netlink.NexthopInfo
+	for _, hostNicName := range hostNicNames {
+		hostLink, err := netlink.LinkByName(hostNicName)
+		if err != nil {
+			return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+		}
+
+		nextHops = append(nextHops, &netlink.NexthopInfo{
+			LinkIndex: hostLink.Attrs().Index,
+			Gw:        podIPs[hostNicName],
+			Flags:     int(netlink.FLAG_ONLINK),
+		})
+	}
+
+	if len(nextHops) == 0 {
+		return fmt.Errorf("no next hop for anycast ip %v", anycastIP)
+	}
+
+	route := &netlink.Route{
+		Dst:   virtualIPNet(anycastIP),
+		Table: localDirectTableNum,
+	}
+	if len(nextHops) == 1 {
+		route.LinkIndex = nextHops[0].LinkIndex
+		route.Gw = nextHops[0].Gw
+		route.Flags = nextHops[0].Flags
+	} else {
+		route.MultiPath = nextHops
+	}
+
+	if err := netlink.RouteReplace(route); err != nil {
+		return fmt.Errorf("failed to add route %v: %v", route.String(), err)
+	}
+	return nil
+}
+
+// RemoveAnycastIP removes anycast ip from the pod behind host nic if it still exists.
+func RemoveAnycastIP(hostNicName string, anycastIP net.IP) error {
+	return removeVirtualIPFromPod(hostNicName, anycastIP)
+}
+
+func addVirtualIPToPod(hostLink netlink.Link, virtualIP net.IP) error {
+	netnsPath, err := findNetnsPathOfHostLink(hostLink)
+	if err != nil {
+		return fmt.Errorf("failed to find netns of host nic %v: %v", hostLink.Attrs().Name, err)
+	}
+
+	addr := &netlink.Addr{IPNet: virtualIPNet(virtualIP)}
+	if virtualIP.To4() == nil {
+		addr.Flags = unix.IFA_F_NODAD
+	}
+
+	if err = ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ContainerNicName)
+		if err != nil {
+			return fmt.Errorf("failed to get container nic: %v", err)
+		}
+		return netlink.AddrReplace(link, addr)
+	}); err != nil {
+		return fmt.Errorf("failed to add virtual ip %v to pod: %v", virtualIP, err)
+	}
+
+	return nil
+}
+
+func removeVirtualIPFromPod(hostNicName string, virtualIP net.IP) error {
+	hostLink, err := netlink.LinkByName(hostNicName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			// pod has gone away
+			return nil
+		}
+		return fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	netnsPath, err := findNetnsPathOfHostLink(hostLink)
+	if err != nil {
+		if err == daemonutils.NotExist {
+			return nil
+		}
+		return fmt.Errorf("failed to find netns of host nic %v: %v", hostNicName, err)
+	}
+
+	return ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ContainerNicName)
+		if err != nil {
+			return fmt.Errorf("failed to get container nic: %v", err)
+		}
+		if err = netlink.AddrDel(link, &netlink.Addr{IPNet: virtualIPNet(virtualIP)}); err != nil && err != unix.EADDRNOTAVAIL {
+			return fmt.Errorf("failed to delete virtual ip %v from pod: %v", virtualIP, err)
+		}
+		return nil
+	})
+}
+
+// findNetnsPathOfHostLink walks through the netns of pods to find the one whose
+// container nic is peer of host link.
+func findNetnsPathOfHostLink(hostLink netlink.Link) (string, error) {
+	netnsDir := ContainerdNetnsDir
+	if daemonutils.ValidDockerNetnsDir(DockerNetnsDir) {
+		netnsDir = DockerNetnsDir
+	}
+
+	files, err := ioutil.ReadDir(netnsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	for _, f := range files {
+		if f.Name() == "default" {
+			continue
+		}
+
+		netnsPath := filepath.Join(netnsDir, f.Name())
+		if !daemonutils.IsProcFS(netnsPath) && !daemonutils.IsNsFS(netnsPath) {
+			continue
+		}
+
+		var peerIndex int
+		if err = ns.WithNetNSPath(netnsPath, func(_ ns.NetNS) error {
+			_, peerIndex, err = ip.GetVethPeerIfindex(ContainerNicName)
+			return err
+		}); err != nil {
+			// not a netns of hybridnet pod
+			continue
+		}
+
+		if peerIndex == hostLink.Attrs().Index {
+			return netnsPath, nil
+		}
+	}
+
+	return "", daemonutils.NotExist
+}
+
+func virtualIPNet(virtualIP net.IP) *net.IPNet {
+	if virtualIP.To4() != nil {
+		return &net.IPNet{IP: virtualIP, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+	}
+	return &net.IPNet{IP: virtualIP, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+// FindPodAddress returns the first address of container nic which belongs to the pod itself. Virtual ips
+// and anycast ips are configured as host addresses with full-length masks, and link-local ipv6 addresses
+// are generated by kernel, none of them is the ip allocated for pod.
+func FindPodAddress(addrs []netlink.Addr) *netlink.Addr {
+	for i := range addrs {
+		if !CheckIPIsGlobalUnicast(addrs[i].IP) {
+			continue
+		}
+		if addrs[i].IPNet != nil {
+			if ones, bits := addrs[i].Mask.Size(); ones == bits {
+				continue
+			}
+		}
+		return &addrs[i]
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package containernetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func newTestAddr(cidr string) netlink.Addr {
+	ip, ipNet, _ := net.ParseCIDR(cidr)
+	ipNet.IP = ip
+	return netlink.Addr{IPNet: ipNet}
+}
+
+func TestFindPodAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []netlink.Addr
+		expected string
+	}{
+		{
+			name:     "no address",
+			expected: "",
+		},
+		{
+			name:     "pod ip only",
+			addrs:    []netlink.Addr{newTestAddr("192.168.0.10/24")},
+			expected: "192.168.0.10",
+		},
+		{
+			name: "virtual ips before and after pod ip",
+			addrs: []netlink.Addr{
+				newTestAddr("192.168.0.100/32"),
+				newTestAddr("192.168.0.10/24"),
+				newTestAddr("192.168.0.53/32"),
+			},
+			expected: "192.168.0.10",
+		},
+		{
+			name: "virtual ips only",
+			addrs: []netlink.Addr{
+				newTestAddr("192.168.0.100/32"),
+			},
+			expected: "",
+		},
+		{
+			name: "ipv6 link-local and anycast ip",
+			addrs: []netlink.Addr{
+				newTestAddr("fe80::1/64"),
+				newTestAddr("fd00::53/128"),
+				newTestAddr("fd00::10/64"),
+			},
+			expected: "fd00::10",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := FindPodAddress(test.addrs)
+			if len(test.expected) == 0 {
+				assert.Nil(t, addr)
+				return
+			}
+			if assert.NotNil(t, addr) {
+				assert.Equal(t, test.expected, addr.IP.String())
+			}
+		})
+	}
+}
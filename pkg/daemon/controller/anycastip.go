@@ -0,0 +1,320 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	controllerutils "github.com/alibaba/hybridnet/pkg/controllers/utils"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+)
+
+// anycastIPBinding records the host nics of local pods configured with an anycast ip, and whether
+// the anycast ip is routed to the ready ones.
+type anycastIPBinding struct {
+	hostNicNames map[string]bool
+	routed       bool
+}
+
+// syncAnycastIPs configures anycast ips on the pods of this node, and routes each anycast ip to all
+// the ready pods through a multipath route. Anycast ips are advertised through bgp only if there are
+// ready pods on this node, so that traffic is load-balanced among nodes by ecmp of the bgp fabric. It
+// returns true if some anycast ips failed to be configured and need to be retried.
+func (r *ipInstanceReconciler) syncAnycastIPs(ctx context.Context, overlayExist bool,
+	overlayForwardNodeIfName string, logger logr.Logger) (bool, error) {
+	anycastIPList := &networkingv1.AnycastIPList{}
+	if err := r.List(ctx, anycastIPList); err != nil {
+		return false, fmt.Errorf("failed to list anycast ips: %v", err)
+	}
+
+	podIPs, err := r.listLocalPodIPs(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		needRetry        bool
+		tableNum         = r.ctrlHubRef.config.LocalDirectTableNum
+		podLister        = r.ctrlHubRef.localPodInformer.Lister()
+		currentBindings  = r.ctrlHubRef.anycastIPBindings
+		expectedBindings = map[string]*anycastIPBinding{}
+	)
+
+	for i := range anycastIPList.Items {
+		anycastIP := &anycastIPList.Items[i]
+		if !anycastIP.DeletionTimestamp.IsZero() || len(anycastIP.Status.IP) == 0 ||
+			!isAnycastIPOfThisNode(anycastIP, r.ctrlHubRef.config.NodeName) {
+			continue
+		}
+
+		ip := net.ParseIP(anycastIP.Status.IP)
+		if ip == nil {
+			return false, fmt.Errorf("invalid ip %v of anycast ip %v", anycastIP.Status.IP, anycastIP.Name)
+		}
+
+		localPods, readyPods, err := selectLocalAnycastPods(anycastIP, r.ctrlHubRef.config.NodeName, podLister)
+		if err != nil {
+			return false, err
+		}
+
+		currentBinding := currentBindings[anycastIP.Status.IP]
+		binding := &anycastIPBinding{hostNicNames: map[string]bool{}}
+		for _, podName := range localPods {
+			hostNicName, _ := containernetwork.GenerateContainerVethPair(anycastIP.Namespace, podName)
+			if err := containernetwork.AddAnycastIP(hostNicName, ip); err != nil {
+				// pod may be not ready yet, retry later
+				logger.Error(err, "failed to configure anycast ip", "anycast-ip", anycastIP.Name, "pod", podName)
+				if currentBinding != nil && currentBinding.hostNicNames[hostNicName] {
+					binding.hostNicNames[hostNicName] = true
+				}
+				needRetry = true
+				continue
+			}
+			binding.hostNicNames[hostNicName] = true
+		}
+
+		// ready pods configured with anycast ip take the route
+		nextHops := map[string]net.IP{}
+		for _, podName := range readyPods {
+			hostNicName, _ := containernetwork.GenerateContainerVethPair(anycastIP.Namespace, podName)
+			podIP := podIPs[podIPKey(anycastIP.Namespace, podName, anycastIP.Status.Version)]
+			if !binding.hostNicNames[hostNicName] || podIP == nil {
+				continue
+			}
+			nextHops[hostNicName] = podIP
+		}
+
+		if len(nextHops) != 0 {
+			if err := containernetwork.RouteAnycastIP(nextHops, ip, tableNum); err != nil {
+				logger.Error(err, "failed to route anycast ip", "anycast-ip", anycastIP.Name)
+				needRetry = true
+			} else {
+				binding.routed = true
+			}
+		}
+
+		if len(binding.hostNicNames) != 0 {
+			expectedBindings[anycastIP.Status.IP] = binding
+		}
+
+		// the route is removed along with binding if no pod on this node is ready
+		if !binding.routed {
+			if currentBinding != nil && currentBinding.routed {
+				if err := containernetwork.RemoveVirtualIP("", ip, tableNum); err != nil {
+					return false, fmt.Errorf("failed to remove route of anycast ip %v: %v", anycastIP.Status.IP, err)
+				}
+			}
+			continue
+		}
+
+		network := &networkingv1.Network{}
+		if err := r.Get(ctx, types.NamespacedName{Name: anycastIP.Status.Network}, network); err != nil {
+			return false, fmt.Errorf("failed to get network for anycast ip %v: %v", anycastIP.Name, err)
+		}
+
+		if networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeBGP {
+			attributes, _, err := r.ctrlHubRef.getBGPPathAttributes(ctx, network, anycastIP.Spec.Subnet)
+			if err != nil {
+				return false, fmt.Errorf("failed to get bgp path attributes for anycast ip %v: %v", anycastIP.Name, err)
+			}
+
+			// anycast ips are always advertised as host routes even if the subnet is aggregate-only,
+			// because only the nodes with ready pods should attract traffic
+			r.ctrlHubRef.bgpManager.RecordIP(ip, attributes)
+		}
+
+		if overlayExist {
+			ipVersion := networkingv1.IPv4
+			if ip.To4() == nil {
+				ipVersion = networkingv1.IPv6
+			}
+			r.ctrlHubRef.getNeighManager(ipVersion).AddPodInfo(ip, overlayForwardNodeIfName)
+		}
+	}
+
+	// routes and addresses of anycast ips may be left over by daemon restarting
+	if currentBindings == nil {
+		if err := cleanLeftoverAnycastIPs(anycastIPList, expectedBindings, podLister, tableNum); err != nil {
+			return false, err
+		}
+	}
+
+	for ipString, currentBinding := range currentBindings {
+		expectedBinding := expectedBindings[ipString]
+		if expectedBinding == nil && currentBinding.routed {
+			if err := containernetwork.RemoveVirtualIP("", net.ParseIP(ipString), tableNum); err != nil {
+				return false, fmt.Errorf("failed to remove route of anycast ip %v: %v", ipString, err)
+			}
+		}
+
+		for hostNicName := range currentBinding.hostNicNames {
+			if expectedBinding == nil || !expectedBinding.hostNicNames[hostNicName] {
+				if err := containernetwork.RemoveAnycastIP(hostNicName, net.ParseIP(ipString)); err != nil {
+					return false, fmt.Errorf("failed to remove anycast ip %v from %v: %v", ipString, hostNicName, err)
+				}
+			}
+		}
+	}
+
+	r.ctrlHubRef.anycastIPBindings = expectedBindings
+
+	return needRetry, nil
+}
+
+// cleanLeftoverAnycastIPs removes the routes of anycast ips not routed on this node, and removes anycast
+// ips from the local pods which are not configured with them any more.
+func cleanLeftoverAnycastIPs(anycastIPList *networkingv1.AnycastIPList, expectedBindings map[string]*anycastIPBinding,
+	podLister corelisters.PodLister, tableNum int) error {
+	for i := range anycastIPList.Items {
+		anycastIP := &anycastIPList.Items[i]
+		if len(anycastIP.Status.IP) == 0 {
+			continue
+		}
+
+		ip := net.ParseIP(anycastIP.Status.IP)
+		expectedBinding := expectedBindings[anycastIP.Status.IP]
+		if expectedBinding == nil || !expectedBinding.routed {
+			if err := containernetwork.RemoveVirtualIP("", ip, tableNum); err != nil {
+				return fmt.Errorf("failed to clean route of anycast ip %v: %v", anycastIP.Status.IP, err)
+			}
+		}
+
+		pods, err := podLister.Pods(anycastIP.Namespace).List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("failed to list local pods in namespace %v: %v", anycastIP.Namespace, err)
+		}
+
+		for _, pod := range pods {
+			if pod.Spec.HostNetwork {
+				continue
+			}
+
+			hostNicName, _ := containernetwork.GenerateContainerVethPair(pod.Namespace, pod.Name)
+			if expectedBinding != nil && expectedBinding.hostNicNames[hostNicName] {
+				continue
+			}
+
+			if err := containernetwork.RemoveAnycastIP(hostNicName, ip); err != nil {
+				return fmt.Errorf("failed to clean anycast ip %v from %v: %v", anycastIP.Status.IP, hostNicName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// selectLocalAnycastPods returns the names of pods on this node which the anycast ip should be configured
+// on, and the ready ones among them. Readiness is read from the local pod cache rather than the status of
+// anycast ip, which might be stale.
+func selectLocalAnycastPods(anycastIP *networkingv1.AnycastIP, nodeName string,
+	podLister corelisters.PodLister) (localPods, readyPods []string, err error) {
+	for _, anycastIPPod := range anycastIP.Status.Pods {
+		if anycastIPPod.NodeName != nodeName {
+			continue
+		}
+
+		pod, err := podLister.Pods(anycastIP.Namespace).Get(anycastIPPod.Name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to get pod %v/%v: %v", anycastIP.Namespace, anycastIPPod.Name, err)
+		}
+
+		if !pod.DeletionTimestamp.IsZero() || pod.Spec.NodeName != nodeName {
+			continue
+		}
+
+		localPods = append(localPods, pod.Name)
+		if controllerutils.PodIsReady(pod) {
+			readyPods = append(readyPods, pod.Name)
+		}
+	}
+	return localPods, readyPods, nil
+}
+
+// listLocalPodIPs returns the ips of pods on this node, which are used as the next hops of anycast ips.
+func (r *ipInstanceReconciler) listLocalPodIPs(ctx context.Context) (map[string]net.IP, error) {
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := r.List(ctx, ipInstanceList,
+		client.MatchingLabels{constants.LabelNode: r.ctrlHubRef.config.NodeName}); err != nil {
+		return nil, fmt.Errorf("failed to list ip instances for node %v: %v", r.ctrlHubRef.config.NodeName, err)
+	}
+
+	podIPs := map[string]net.IP{}
+	for _, ipInstance := range ipInstanceList.Items {
+		podName := ipInstance.Labels[constants.LabelPod]
+		if len(podName) == 0 || !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		podIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ip %v of ip instance %v: %v",
+				ipInstance.Spec.Address.IP, ipInstance.Name, err)
+		}
+		podIPs[podIPKey(ipInstance.Namespace, podName, ipInstance.Spec.Address.Version)] = podIP
+	}
+	return podIPs, nil
+}
+
+func podIPKey(namespace, podName string, version networkingv1.IPVersion) string {
+	return namespace + "/" + podName + "/" + string(version)
+}
+
+// isPodOfAnycastIP checks whether the local pod is configured with some anycast ip.
+func (c *CtrlHub) isPodOfAnycastIP(obj client.Object) bool {
+	anycastIPList := &networkingv1.AnycastIPList{}
+	if err := c.mgr.GetClient().List(context.TODO(), anycastIPList, client.InNamespace(obj.GetNamespace())); err != nil {
+		c.logger.Error(err, "failed to list anycast ips", "namespace", obj.GetNamespace())
+		return false
+	}
+
+	for _, anycastIP := range anycastIPList.Items {
+		for _, pod := range anycastIP.Status.Pods {
+			if pod.Name == obj.GetName() && pod.NodeName == c.config.NodeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAnycastIPOfThisNode checks whether anycast ip is configured on pods of this node
+func isAnycastIPOfThisNode(obj client.Object, nodeName string) bool {
+	anycastIP, ok := obj.(*networkingv1.AnycastIP)
+	if !ok {
+		return false
+	}
+
+	for _, pod := range anycastIP.Status.Pods {
+		if pod.NodeName == nodeName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func newTestAnycastIP(pods ...networkingv1.AnycastIPPod) *networkingv1.AnycastIP {
+	return &networkingv1.AnycastIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "dns",
+		},
+		Status: networkingv1.AnycastIPStatus{
+			IP:   "192.168.0.53",
+			Pods: pods,
+		},
+	}
+}
+
+func newTestLocalPod(name, nodeName string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodReady,
+					Status: status,
+				},
+			},
+		},
+	}
+}
+
+func TestIsAnycastIPOfThisNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		obj      client.Object
+		expected bool
+	}{
+		{
+			name:     "no pod",
+			obj:      newTestAnycastIP(),
+			expected: false,
+		},
+		{
+			name: "pods on other nodes",
+			obj: newTestAnycastIP(
+				networkingv1.AnycastIPPod{Name: "dns-1", NodeName: "node2", Ready: true},
+			),
+			expected: false,
+		},
+		{
+			name: "pod on this node",
+			obj: newTestAnycastIP(
+				networkingv1.AnycastIPPod{Name: "dns-1", NodeName: "node2", Ready: true},
+				networkingv1.AnycastIPPod{Name: "dns-2", NodeName: "node1"},
+			),
+			expected: true,
+		},
+		{
+			name:     "not anycast ip",
+			obj:      newTestLocalPod("dns-1", "node1", true),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isAnycastIPOfThisNode(test.obj, "node1"))
+		})
+	}
+}
+
+func TestSelectLocalAnycastPods(t *testing.T) {
+	deletingPod := newTestLocalPod("dns-4", "node1", true)
+	deletionTime := metav1.Now()
+	deletingPod.DeletionTimestamp = &deletionTime
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []*corev1.Pod{
+		newTestLocalPod("dns-1", "node1", true),
+		newTestLocalPod("dns-2", "node1", false),
+		newTestLocalPod("dns-3", "node1", true),
+		deletingPod,
+		newTestLocalPod("dns-5", "node2", true),
+	} {
+		assert.NoError(t, indexer.Add(pod))
+	}
+	podLister := corelisters.NewPodLister(indexer)
+
+	tests := []struct {
+		name      string
+		anycastIP *networkingv1.AnycastIP
+		localPods []string
+		readyPods []string
+	}{
+		{
+			name:      "no pod",
+			anycastIP: newTestAnycastIP(),
+		},
+		{
+			name: "readiness is read from local pods",
+			anycastIP: newTestAnycastIP(
+				networkingv1.AnycastIPPod{Name: "dns-1", NodeName: "node1", Ready: false},
+				networkingv1.AnycastIPPod{Name: "dns-2", NodeName: "node1", Ready: true},
+				networkingv1.AnycastIPPod{Name: "dns-3", NodeName: "node1", Ready: true},
+			),
+			localPods: []string{"dns-1", "dns-2", "dns-3"},
+			readyPods: []string{"dns-1", "dns-3"},
+		},
+		{
+			name: "pods of other nodes, absent and deleting pods are ignored",
+			anycastIP: newTestAnycastIP(
+				networkingv1.AnycastIPPod{Name: "dns-1", NodeName: "node1", Ready: true},
+				networkingv1.AnycastIPPod{Name: "dns-4", NodeName: "node1", Ready: true},
+				networkingv1.AnycastIPPod{Name: "dns-5", NodeName: "node2", Ready: true},
+				networkingv1.AnycastIPPod{Name: "dns-6", NodeName: "node1", Ready: true},
+			),
+			localPods: []string{"dns-1"},
+			readyPods: []string{"dns-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			localPods, readyPods, err := selectLocalAnycastPods(test.anycastIP, "node1", podLister)
+			assert.NoError(t, err)
+			assert.Equal(t, test.localPods, localPods)
+			assert.Equal(t, test.readyPods, readyPods)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+const BGPStatusReportInterval = 30 * time.Second
+
+// bgpStatusReportLoop reports the states of bgp peers in the NodeNetworkStatus of this node periodically,
+// which will be aggregated into status of bgp network by manager.
+func (c *CtrlHub) bgpStatusReportLoop() {
+	go func() {
+		ticker := time.NewTicker(BGPStatusReportInterval)
+		defer ticker.Stop()
+
+		// the status is not updated unless bgp status changes, nil status means no bgp peer
+		var reported bool
+		var reportedStatus *networkingv1.NodeBGPStatus
+		for range ticker.C {
+			peerStatuses, err := c.bgpManager.ListPeerStatus()
+			if err != nil {
+				c.logger.Error(err, "failed to collect bgp status")
+				continue
+			}
+
+			var status *networkingv1.NodeBGPStatus
+			if len(peerStatuses) != 0 {
+				status = &networkingv1.NodeBGPStatus{Peers: peerStatuses}
+			}
+
+			if reported && reflect.DeepEqual(reportedStatus, status) {
+				continue
+			}
+
+			if err := c.updateNodeBGPStatus(status); err != nil {
+				c.logger.Error(err, "failed to report bgp status")
+				continue
+			}
+			reported, reportedStatus = true, status
+		}
+	}()
+}
+
+func (c *CtrlHub) updateNodeBGPStatus(status *networkingv1.NodeBGPStatus) error {
+	// read from api server directly, so that NodeNetworkStatus of other nodes is not cached
+	nodeNetworkStatus := &networkingv1.NodeNetworkStatus{}
+	if err := c.mgr.GetAPIReader().Get(context.TODO(), types.NamespacedName{Name: c.config.NodeName},
+		nodeNetworkStatus); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get node network status %v: %v", c.config.NodeName, err)
+		}
+
+		if status == nil {
+			return nil
+		}
+
+		node := &corev1.Node{}
+		if err := c.mgr.GetClient().Get(context.TODO(), types.NamespacedName{Name: c.config.NodeName}, node); err != nil {
+			return fmt.Errorf("failed to get node %v: %v", c.config.NodeName, err)
+		}
+
+		nodeNetworkStatus = &networkingv1.NodeNetworkStatus{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.config.NodeName,
+				// garbage collected along with the node
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(node, corev1.SchemeGroupVersion.WithKind("Node")),
+				},
+			},
+			BGP: status,
+		}
+		if err := c.mgr.GetClient().Create(context.TODO(), nodeNetworkStatus); err != nil {
+			return fmt.Errorf("failed to create node network status %v: %v", c.config.NodeName, err)
+		}
+		return nil
+	}
+
+	nodeNetworkStatus.BGP = status
+	if err := c.mgr.GetClient().Update(context.TODO(), nodeNetworkStatus); err != nil {
+		return fmt.Errorf("failed to update node network status %v: %v", c.config.NodeName, err)
+	}
+	return nil
+}
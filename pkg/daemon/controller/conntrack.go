@@ -0,0 +1,91 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/conntrack"
+)
+
+// conntrackCleanupHandler flushes conntrack entries of overlay ips which are released from or reassigned
+// to other nodes, because traffic of overlay pods is masqueraded on this node and stale NAT entries
+// will misroute the flows once the ips are reused.
+func (c *CtrlHub) conntrackCleanupHandler() handler.EventHandler {
+	return &handler.Funcs{
+		UpdateFunc: func(updateEvent event.UpdateEvent, _ workqueue.RateLimitingInterface) {
+			oldIPInstance, ok := updateEvent.ObjectOld.(*networkingv1.IPInstance)
+			if !ok {
+				return
+			}
+			newIPInstance, ok := updateEvent.ObjectNew.(*networkingv1.IPInstance)
+			if !ok {
+				return
+			}
+
+			if oldIPInstance.Labels[constants.LabelNode] == c.config.NodeName &&
+				(newIPInstance.Labels[constants.LabelNode] != c.config.NodeName ||
+					newIPInstance.Labels[constants.LabelPod] != oldIPInstance.Labels[constants.LabelPod]) {
+				c.flushOverlayIPConntrack(oldIPInstance)
+			}
+		},
+		DeleteFunc: func(deleteEvent event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+			ipInstance, ok := deleteEvent.Object.(*networkingv1.IPInstance)
+			if !ok {
+				return
+			}
+
+			if ipInstance.Labels[constants.LabelNode] == c.config.NodeName {
+				c.flushOverlayIPConntrack(ipInstance)
+			}
+		},
+	}
+}
+
+func (c *CtrlHub) flushOverlayIPConntrack(ipInstance *networkingv1.IPInstance) {
+	network := &networkingv1.Network{}
+	if err := c.mgr.GetClient().Get(context.TODO(), types.NamespacedName{Name: ipInstance.Spec.Network}, network); err != nil {
+		c.logger.Error(err, "failed to get network of ip instance", "ipInstance", ipInstance.Name)
+		return
+	}
+
+	if networkingv1.GetNetworkType(network) != networkingv1.NetworkTypeOverlay {
+		return
+	}
+
+	ip, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+	if err != nil {
+		c.logger.Error(err, "failed to parse ip of ip instance", "ipInstance", ipInstance.Name)
+		return
+	}
+
+	deleted, err := conntrack.FlushIPs(ip)
+	if err != nil {
+		c.logger.Error(err, "failed to flush conntrack entries of ip instance", "ipInstance", ipInstance.Name)
+		return
+	}
+
+	c.logger.V(5).Info("flush conntrack entries of overlay ip", "ip", ip.String(), "deleted", deleted)
+}
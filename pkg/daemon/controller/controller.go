@@ -23,6 +23,8 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -37,18 +39,27 @@ import (
 	"github.com/alibaba/hybridnet/pkg/daemon/bgp"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
+	controllerutils "github.com/alibaba/hybridnet/pkg/controllers/utils"
 	"github.com/alibaba/hybridnet/pkg/daemon/addr"
 	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
@@ -63,14 +74,26 @@ const (
 	ActionReconcileIPInstance = "AllIPInstancesRelatedToThisNode"
 	ActionReconcileNode       = "AllNodes"
 
-	InstanceIPIndex = "instanceIP"
-	EndpointIPIndex = "endpointIP"
+	InstanceIPIndex       = "instanceIP"
+	EndpointIPIndex       = "endpointIP"
+	IPInstanceSubnetIndex = "ipInstanceSubnet"
 
 	NeighUpdateChanSize = 2000
 	LinkUpdateChainSize = 200
 	AddrUpdateChainSize = 200
 
 	NetlinkSubscribeRetryInterval = 10 * time.Second
+
+	VirtualIPResyncPeriod = 30 * time.Second
+
+	ReasonMTUMismatch = "MTUMismatch"
+
+	ReasonWireGuardPeerUnencrypted = "WireGuardPeerUnencrypted"
+
+	ReasonBGPPeerUpdated   = "BGPPeerUpdated"
+	ReasonBGPPeerPostponed = "BGPPeerUpdatePostponed"
+
+	ReasonInvalidBGPCommunities = "InvalidBGPCommunities"
 )
 
 type CtrlHub struct {
@@ -91,8 +114,8 @@ type CtrlHub struct {
 
 	bgpManager *bgp.Manager
 
-	iptablesV4Manager  *iptables.Manager
-	iptablesV6Manager  *iptables.Manager
+	iptablesV4Manager  iptables.Interface
+	iptablesV6Manager  iptables.Interface
 	iptablesSyncCh     chan struct{}
 	iptablesSyncTicker *time.Ticker
 
@@ -100,6 +123,37 @@ type CtrlHub struct {
 
 	upgradeWorkDone bool
 
+	recorder record.EventRecorder
+
+	// virtualIPBindings records virtual ips configured on this node, and the host nics of pods they are bound to
+	virtualIPBindings map[string]string
+
+	// loadBalancerIPAnnouncements records load balancer ips of vlan networks announced by this node
+	loadBalancerIPAnnouncements map[string]bool
+
+	// vlanUplinkInterfaces caches the vlan uplink interfaces annotation of this node, which is read on every
+	// pod creation and kept up to date by the node update predicates
+	vlanUplinkInterfaces atomic.Value
+
+	// subnetMTUMismatches records the mtu mismatches of subnets which have been warned
+	subnetMTUMismatches map[string]string
+
+	// unencryptedWireGuardPeers records the peers without valid wireguard public key which have been warned
+	unencryptedWireGuardPeers map[string]bool
+
+	// anycastIPBindings records anycast ips configured on this node
+	anycastIPBindings map[string]*anycastIPBinding
+
+	// localPodInformer caches the pods of this node only, it is shared by pod controller and ip instance controller
+	localPodInformerFactory informers.SharedInformerFactory
+	localPodInformer        coreinformers.PodInformer
+
+	// serviceControllerSetUp is set once service controller is set up, which is postponed until this node
+	// belongs to a bgp network with service ips to advertise, to avoid caching all the services and endpoints
+	// on every node
+	serviceControllerSetUp bool
+	serviceControllerMutex *sync.Mutex
+
 	logger logr.Logger
 }
 
@@ -107,6 +161,7 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 	routeV4Manager, err := route.CreateRouteManager(config.LocalDirectTableNum,
 		config.ToOverlaySubnetTableNum,
 		config.OverlayMarkTableNum,
+		config.BGPImportTableNum,
 		netlink.FAMILY_V4,
 	)
 	if err != nil {
@@ -116,6 +171,7 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 	routeV6Manager, err := route.CreateRouteManager(config.LocalDirectTableNum,
 		config.ToOverlaySubnetTableNum,
 		config.OverlayMarkTableNum,
+		config.BGPImportTableNum,
 		netlink.FAMILY_V6,
 	)
 	if err != nil {
@@ -125,27 +181,42 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 	neighV4Manager := neigh.CreateNeighManager(netlink.FAMILY_V4)
 	neighV6Manager := neigh.CreateNeighManager(netlink.FAMILY_V6)
 
-	iptablesV4Manager, err := iptables.CreateIPtablesManager(iptables.ProtocolIpv4)
+	iptablesV4Manager, err := iptables.CreateIPtablesManager(iptables.ProtocolIpv4, iptables.Backend(config.RuleBackend))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ipv4 iptables manager: %v", err)
 	}
 
-	iptablesV6Manager, err := iptables.CreateIPtablesManager(iptables.ProtocolIpv6)
+	iptablesV6Manager, err := iptables.CreateIPtablesManager(iptables.ProtocolIpv6, iptables.Backend(config.RuleBackend))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ipv6 iptables manager: %v", err)
 	}
 
 	addrV4Manager := addr.CreateAddrManager(netlink.FAMILY_V4, config.NodeName)
 
-	bgpManager, err := bgp.NewManager(config.NodeBGPIfName, config.BGPgRPCServerAddress, logger.WithName("bgp-server"))
+	bgpManager, err := bgp.NewManager(config.NodeBGPIfName, config.BGPgRPCServerAddress,
+		config.BGPPeerResetMinInterval, config.BGPImportTableNum, logger.WithName("bgp-server"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bgp manager: %v", err)
 	}
 
+	clientSet, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client set: %v", err)
+	}
+
+	// a dedicated informer with field selector is used to avoid caching all the pods of cluster
+	localPodInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", config.NodeName).String()
+		}))
+
 	ctrlHub := &CtrlHub{
 		config: config,
 		mgr:    mgr,
 
+		localPodInformerFactory: localPodInformerFactory,
+		localPodInformer:        localPodInformerFactory.Core().V1().Pods(),
+
 		subnetControllerTriggerSource:     &simpleTriggerSource{key: ActionReconcileSubnet},
 		ipInstanceControllerTriggerSource: &simpleTriggerSource{key: ActionReconcileIPInstance},
 		nodeControllerTriggerSource:       &simpleTriggerSource{key: ActionReconcileNode},
@@ -167,6 +238,13 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 
 		nodeIPCache: NewNodeIPCache(),
 
+		recorder: mgr.GetEventRecorderFor("hybridnet-daemon"),
+
+		subnetMTUMismatches:       map[string]string{},
+		unencryptedWireGuardPeers: map[string]bool{},
+
+		serviceControllerMutex: &sync.Mutex{},
+
 		logger: logger,
 	}
 
@@ -174,6 +252,7 @@ func NewCtrlHub(config *daemonconfig.Configuration, mgr ctrl.Manager, logger log
 	if err = mgr.GetAPIReader().Get(context.TODO(), types.NamespacedName{Name: config.NodeName}, thisNode); err != nil {
 		return nil, fmt.Errorf("failed to get node %s info %v", config.NodeName, err)
 	}
+	ctrlHub.vlanUplinkInterfaces.Store(thisNode.Annotations[constants.AnnotationNodeVlanUplinkInterfaces])
 
 	return ctrlHub, nil
 }
@@ -186,6 +265,11 @@ func (c *CtrlHub) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to add instance ip indexer to manager: %v", err)
 	}
 
+	if err := c.mgr.GetFieldIndexer().IndexField(context.TODO(), &networkingv1.IPInstance{},
+		IPInstanceSubnetIndex, ipInstanceSubnetIndexer); err != nil {
+		return fmt.Errorf("failed to add ip instance subnet indexer to manager: %v", err)
+	}
+
 	if feature.MultiClusterEnabled() {
 		if err := c.mgr.GetFieldIndexer().IndexField(context.TODO(), &multiclusterv1.RemoteVtep{},
 			EndpointIPIndex, endpointIPIndexer); err != nil {
@@ -205,6 +289,10 @@ func (c *CtrlHub) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to setup node controller: %v", err)
 	}
 
+	if err := c.setupPodController(); err != nil {
+		return fmt.Errorf("failed to setup pod controller: %v", err)
+	}
+
 	if err := c.handleLocalNetworkDeviceEvent(); err != nil {
 		return fmt.Errorf("failed to handle local network device event: %v", err)
 	}
@@ -213,8 +301,16 @@ func (c *CtrlHub) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to handle vxlan interface neigh event: %v", err)
 	}
 
+	if err := c.handleHostNetworkTamperEvent(); err != nil {
+		return fmt.Errorf("failed to handle host network tamper event: %v", err)
+	}
+
 	c.iptablesSyncLoop()
 
+	c.wireGuardMetricsLoop()
+
+	c.bgpStatusReportLoop()
+
 	if err := c.mgr.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start controller manager: %v", err)
 	}
@@ -259,7 +355,10 @@ func (c *CtrlHub) setupSubnetController() error {
 					(oldSubnetNetID != nil && newSubnetNetID != nil && *oldSubnetNetID != *newSubnetNetID) ||
 					oldSubnet.Spec.Network != newSubnet.Spec.Network ||
 					!reflect.DeepEqual(oldSubnet.Spec.Range, newSubnet.Spec.Range) ||
-					networkingv1.IsSubnetAutoNatOutgoing(&oldSubnet.Spec) != networkingv1.IsSubnetAutoNatOutgoing(&newSubnet.Spec) {
+					!reflect.DeepEqual(networkingv1.GetSubnetRoutes(&oldSubnet.Spec), networkingv1.GetSubnetRoutes(&newSubnet.Spec)) ||
+					networkingv1.GetMTU(nil, oldSubnet) != networkingv1.GetMTU(nil, newSubnet) ||
+					networkingv1.IsSubnetAutoNatOutgoing(&oldSubnet.Spec) != networkingv1.IsSubnetAutoNatOutgoing(&newSubnet.Spec) ||
+					!reflect.DeepEqual(networkingv1.GetBGPPathConfig(nil, oldSubnet), networkingv1.GetBGPPathConfig(nil, newSubnet)) {
 					return true
 				}
 				return false
@@ -280,7 +379,8 @@ func (c *CtrlHub) setupSubnetController() error {
 					return true
 				}
 
-				if !reflect.DeepEqual(oldNetwork.Spec.Config, newNetwork.Spec.Config) {
+				if !reflect.DeepEqual(oldNetwork.Spec.Config, newNetwork.Spec.Config) ||
+					!reflect.DeepEqual(oldNetwork.Spec.MTU, newNetwork.Spec.MTU) {
 					return true
 				}
 
@@ -298,7 +398,7 @@ func (c *CtrlHub) setupSubnetController() error {
 				return false
 			},
 			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
-				return checkNodeUpdate(updateEvent)
+				return checkNodeUpdate(updateEvent) || c.checkVlanUplinkUpdate(updateEvent)
 			},
 			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
 				return false
@@ -310,10 +410,53 @@ func (c *CtrlHub) setupSubnetController() error {
 		return fmt.Errorf("failed to watch corev1.Node for subnet controller: %v", err)
 	}
 
+	// overlay pods on other nodes are needed by direct routes
+	if err := subnetController.Watch(&source.Kind{Type: &networkingv1.IPInstance{}},
+		&fixedKeyHandler{key: ActionReconcileSubnet},
+		predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return c.isRemoteDirectRoutingIPInstance(createEvent.Object.(*networkingv1.IPInstance))
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldIPInstance := updateEvent.ObjectOld.(*networkingv1.IPInstance)
+				newIPInstance := updateEvent.ObjectNew.(*networkingv1.IPInstance)
+				return oldIPInstance.Labels[constants.LabelNode] != newIPInstance.Labels[constants.LabelNode] &&
+					(c.isRemoteDirectRoutingIPInstance(oldIPInstance) || c.isRemoteDirectRoutingIPInstance(newIPInstance))
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return c.isRemoteDirectRoutingIPInstance(deleteEvent.Object.(*networkingv1.IPInstance))
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.IPInstance for subnet controller: %v", err)
+	}
+
 	if err := subnetController.Watch(c.subnetControllerTriggerSource, &handler.Funcs{}); err != nil {
 		return fmt.Errorf("failed to watch subnetControllerTriggerSource for subnet controller: %v", err)
 	}
 
+	// the first load balancer ip allocated from bgp network needs service controller to advertise it
+	if err := subnetController.Watch(&source.Kind{Type: &networkingv1.LoadBalancerIP{}},
+		&fixedKeyHandler{key: ActionReconcileSubnet},
+		predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return !c.isServiceControllerSetUp()
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				return false
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.LoadBalancerIP for subnet controller: %v", err)
+	}
+
 	// enable multicluster feature
 	if feature.MultiClusterEnabled() {
 		if err := subnetController.Watch(&source.Kind{
@@ -385,6 +528,172 @@ func (c *CtrlHub) setupIPInstanceController() error {
 		return fmt.Errorf("failed to watch networkingv1.IPInstance for ip instance controller: %v", err)
 	}
 
+	// nothing will be enqueued, just for cleaning conntrack entries
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.IPInstance{}},
+		c.conntrackCleanupHandler()); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.IPInstance for conntrack cleanup: %v", err)
+	}
+
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.VirtualIP{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.ResourceVersionChangedPredicate{},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return isLabeledWithThisNode(createEvent.Object, c.config.NodeName)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return isLabeledWithThisNode(deleteEvent.Object, c.config.NodeName)
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				// both binding to and unbinding from this node should be handled
+				return isLabeledWithThisNode(updateEvent.ObjectOld, c.config.NodeName) ||
+					isLabeledWithThisNode(updateEvent.ObjectNew, c.config.NodeName)
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return isLabeledWithThisNode(genericEvent.Object, c.config.NodeName)
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.VirtualIP for ip instance controller: %v", err)
+	}
+
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.LoadBalancerIP{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.ResourceVersionChangedPredicate{},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return isLabeledWithThisNode(createEvent.Object, c.config.NodeName)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return isLabeledWithThisNode(deleteEvent.Object, c.config.NodeName)
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				// both electing to and leaving from this node should be handled
+				return isLabeledWithThisNode(updateEvent.ObjectOld, c.config.NodeName) ||
+					isLabeledWithThisNode(updateEvent.ObjectNew, c.config.NodeName)
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return isLabeledWithThisNode(genericEvent.Object, c.config.NodeName)
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.LoadBalancerIP for ip instance controller: %v", err)
+	}
+
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.AnycastIP{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.ResourceVersionChangedPredicate{},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return isAnycastIPOfThisNode(createEvent.Object, c.config.NodeName)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return isAnycastIPOfThisNode(deleteEvent.Object, c.config.NodeName)
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				// pods of this node both added to and removed from anycast ip should be handled
+				return isAnycastIPOfThisNode(updateEvent.ObjectOld, c.config.NodeName) ||
+					isAnycastIPOfThisNode(updateEvent.ObjectNew, c.config.NodeName)
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return isAnycastIPOfThisNode(genericEvent.Object, c.config.NodeName)
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.AnycastIP for ip instance controller: %v", err)
+	}
+
+	// anycast ips are routed to the ready pods of this node
+	if err := ipInstanceController.Watch(&source.Informer{Informer: c.localPodInformer.Informer()},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return c.isPodOfAnycastIP(createEvent.Object)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldPod, ok := updateEvent.ObjectOld.(*corev1.Pod)
+				if !ok {
+					return false
+				}
+				newPod, ok := updateEvent.ObjectNew.(*corev1.Pod)
+				if !ok {
+					return false
+				}
+				return controllerutils.PodIsReady(oldPod) != controllerutils.PodIsReady(newPod) &&
+					c.isPodOfAnycastIP(newPod)
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch corev1.Pod for ip instance controller: %v", err)
+	}
+
+	// bgp path attributes of ips are inherited from subnets
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.Subnet{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldSubnet := updateEvent.ObjectOld.(*networkingv1.Subnet)
+				newSubnet := updateEvent.ObjectNew.(*networkingv1.Subnet)
+
+				return !reflect.DeepEqual(networkingv1.GetBGPPathConfig(nil, oldSubnet), networkingv1.GetBGPPathConfig(nil, newSubnet))
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.Subnet for ip instance controller: %v", err)
+	}
+
+	// forward node interfaces of vlan networks and bgp path attributes of ips might be changed
+	if err := ipInstanceController.Watch(&source.Kind{Type: &networkingv1.Network{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldNetwork := updateEvent.ObjectOld.(*networkingv1.Network)
+				newNetwork := updateEvent.ObjectNew.(*networkingv1.Network)
+
+				return !reflect.DeepEqual(networkingv1.GetOuterVlanID(oldNetwork), networkingv1.GetOuterVlanID(newNetwork)) ||
+					networkingv1.GetVlanUplinkInterfaces(oldNetwork) != networkingv1.GetVlanUplinkInterfaces(newNetwork) ||
+					!reflect.DeepEqual(networkingv1.GetBGPPathConfig(oldNetwork, nil), networkingv1.GetBGPPathConfig(newNetwork, nil))
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.Network for ip instance controller: %v", err)
+	}
+
+	if err := ipInstanceController.Watch(&source.Kind{Type: &corev1.Node{}},
+		&fixedKeyHandler{key: ActionReconcileIPInstance},
+		predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: c.checkVlanUplinkUpdate,
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch corev1.Node for ip instance controller: %v", err)
+	}
+
 	if err := ipInstanceController.Watch(c.ipInstanceControllerTriggerSource, &handler.Funcs{}); err != nil {
 		return fmt.Errorf("failed to watch ipInstanceControllerTriggerSource for ip instance controller: %v", err)
 	}
@@ -417,7 +726,10 @@ func (c *CtrlHub) setupNodeController() error {
 		&fixedKeyHandler{key: ActionReconcileNode},
 		predicate.Funcs{
 			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
-				return false
+				oldNetwork := updateEvent.ObjectOld.(*networkingv1.Network)
+				newNetwork := updateEvent.ObjectNew.(*networkingv1.Network)
+				return networkingv1.GetNetworkType(newNetwork) == networkingv1.NetworkTypeOverlay &&
+					networkingv1.IsWireGuardEnabled(oldNetwork) != networkingv1.IsWireGuardEnabled(newNetwork)
 			},
 			CreateFunc: func(createEvent event.CreateEvent) bool {
 				network := createEvent.Object.(*networkingv1.Network)
@@ -449,6 +761,8 @@ func (c *CtrlHub) setupNodeController() error {
 
 					if oldRemoteVtep.Spec.VTEPInfo.IP != newRemoteVtep.Spec.VTEPInfo.IP ||
 						oldRemoteVtep.Spec.VTEPInfo.MAC != newRemoteVtep.Spec.VTEPInfo.MAC ||
+						oldRemoteVtep.Spec.VTEPInfo.SecondaryIP != newRemoteVtep.Spec.VTEPInfo.SecondaryIP ||
+						oldRemoteVtep.Spec.VTEPInfo.WireGuardPublicKey != newRemoteVtep.Spec.VTEPInfo.WireGuardPublicKey ||
 						oldRemoteVtep.Annotations[constants.AnnotationNodeLocalVxlanIPList] != newRemoteVtep.Annotations[constants.AnnotationNodeLocalVxlanIPList] ||
 						!isIPListEqual(oldRemoteVtep.Spec.EndpointIPList, newRemoteVtep.Spec.EndpointIPList) {
 						return true
@@ -464,6 +778,217 @@ func (c *CtrlHub) setupNodeController() error {
 	return nil
 }
 
+// ensureServiceController sets up service controller if bgp network of this node advertises service ips
+// or has load balancer ips allocated, it will never be removed once set up.
+func (c *CtrlHub) ensureServiceController(ctx context.Context, network *networkingv1.Network) error {
+	if c.isServiceControllerSetUp() {
+		return nil
+	}
+
+	if networkingv1.GetBGPServiceAdvertisement(network) == nil {
+		loadBalancerIPList := &networkingv1.LoadBalancerIPList{}
+		if err := c.mgr.GetClient().List(ctx, loadBalancerIPList, client.MatchingLabels{
+			constants.LabelNetwork: network.Name,
+		}); err != nil {
+			return fmt.Errorf("failed to list load balancer ips: %v", err)
+		}
+
+		if len(loadBalancerIPList.Items) == 0 {
+			return nil
+		}
+	}
+
+	c.serviceControllerMutex.Lock()
+	defer c.serviceControllerMutex.Unlock()
+
+	if c.serviceControllerSetUp {
+		return nil
+	}
+
+	if err := c.setupServiceController(); err != nil {
+		return fmt.Errorf("failed to setup service controller: %v", err)
+	}
+
+	c.serviceControllerSetUp = true
+	return nil
+}
+
+func (c *CtrlHub) isServiceControllerSetUp() bool {
+	c.serviceControllerMutex.Lock()
+	defer c.serviceControllerMutex.Unlock()
+
+	return c.serviceControllerSetUp
+}
+
+func (c *CtrlHub) setupServiceController() error {
+	serviceController, err := controller.New("service", c.mgr, controller.Options{
+		Reconciler: &serviceReconciler{
+			Client:     c.mgr.GetClient(),
+			ctrlHubRef: c,
+		}})
+	if err != nil {
+		return fmt.Errorf("failed to create service controller: %v", err)
+	}
+
+	if err := serviceController.Watch(&source.Kind{Type: &corev1.Service{}},
+		&handler.EnqueueRequestForObject{},
+		&predicate.ResourceVersionChangedPredicate{},
+	); err != nil {
+		return fmt.Errorf("failed to watch corev1.Service for service controller: %v", err)
+	}
+
+	// only the existence of ready endpoints on this node matters, endpoints share the name of service
+	if err := serviceController.Watch(&source.Kind{Type: &corev1.Endpoints{}},
+		&handler.EnqueueRequestForObject{},
+		predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return hasLocalReadyEndpoints(createEvent.Object.(*corev1.Endpoints), c.config.NodeName)
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				return hasLocalReadyEndpoints(updateEvent.ObjectOld.(*corev1.Endpoints), c.config.NodeName) !=
+					hasLocalReadyEndpoints(updateEvent.ObjectNew.(*corev1.Endpoints), c.config.NodeName)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return hasLocalReadyEndpoints(deleteEvent.Object.(*corev1.Endpoints), c.config.NodeName)
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch corev1.Endpoints for service controller: %v", err)
+	}
+
+	// only allocation and release of load balancer ips matter
+	if err := serviceController.Watch(&source.Kind{Type: &networkingv1.LoadBalancerIP{}},
+		handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: object.GetNamespace(),
+				Name:      object.(*networkingv1.LoadBalancerIP).Spec.Service,
+			}}}
+		}),
+		predicate.Funcs{
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldLoadBalancerIP := updateEvent.ObjectOld.(*networkingv1.LoadBalancerIP)
+				newLoadBalancerIP := updateEvent.ObjectNew.(*networkingv1.LoadBalancerIP)
+				return oldLoadBalancerIP.Status.IP != newLoadBalancerIP.Status.IP ||
+					oldLoadBalancerIP.Labels[constants.LabelNetwork] != newLoadBalancerIP.Labels[constants.LabelNetwork] ||
+					oldLoadBalancerIP.DeletionTimestamp.IsZero() != newLoadBalancerIP.DeletionTimestamp.IsZero()
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.LoadBalancerIP for service controller: %v", err)
+	}
+
+	// all the services need to be advertised or withdrawn again
+	if err := serviceController.Watch(&source.Kind{Type: &networkingv1.Network{}},
+		handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+			return c.servicesToRequests()
+		}),
+		predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return networkingv1.GetBGPServiceAdvertisement(createEvent.Object.(*networkingv1.Network)) != nil
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldNetwork := updateEvent.ObjectOld.(*networkingv1.Network)
+				newNetwork := updateEvent.ObjectNew.(*networkingv1.Network)
+				return !reflect.DeepEqual(networkingv1.GetBGPServiceAdvertisement(oldNetwork),
+					networkingv1.GetBGPServiceAdvertisement(newNetwork)) ||
+					nodeBelongsToNetwork(c.config.NodeName, oldNetwork) != nodeBelongsToNetwork(c.config.NodeName, newNetwork)
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return networkingv1.GetBGPServiceAdvertisement(deleteEvent.Object.(*networkingv1.Network)) != nil
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.Network for service controller: %v", err)
+	}
+
+	return nil
+}
+
+// servicesToRequests returns the requests of all services for service controller.
+func (c *CtrlHub) servicesToRequests() []reconcile.Request {
+	serviceList := &corev1.ServiceList{}
+	if err := c.mgr.GetClient().List(context.Background(), serviceList); err != nil {
+		c.logger.Error(err, "failed to list service")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(serviceList.Items))
+	for i := range serviceList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: serviceList.Items[i].Namespace,
+			Name:      serviceList.Items[i].Name,
+		}})
+	}
+	return requests
+}
+
+// setupPodController watches pods of this node only.
+func (c *CtrlHub) setupPodController() error {
+	podController, err := controller.New("pod", c.mgr, controller.Options{
+		Reconciler: &podReconciler{
+			Client:     c.mgr.GetClient(),
+			podLister:  c.localPodInformer.Lister(),
+			ctrlHubRef: c,
+		}})
+	if err != nil {
+		return fmt.Errorf("failed to create pod controller: %v", err)
+	}
+
+	if err := podController.Watch(&source.Informer{Informer: c.localPodInformer.Informer()},
+		&handler.EnqueueRequestForObject{},
+		&predicate.Funcs{
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldAnnotations := updateEvent.ObjectOld.GetAnnotations()
+				newAnnotations := updateEvent.ObjectNew.GetAnnotations()
+
+				return oldAnnotations[constants.AnnotationIngressBandwidth] != newAnnotations[constants.AnnotationIngressBandwidth] ||
+					oldAnnotations[constants.AnnotationEgressBandwidth] != newAnnotations[constants.AnnotationEgressBandwidth]
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch corev1.Pod for pod controller: %v", err)
+	}
+
+	// pods without bandwidth annotations are limited by default bandwidth of subnets
+	if err := podController.Watch(&source.Kind{Type: &networkingv1.Subnet{}},
+		handler.EnqueueRequestsFromMapFunc(c.localPodRequestsOfSubnet),
+		&predicate.Funcs{
+			CreateFunc: func(createEvent event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+				oldSubnet := updateEvent.ObjectOld.(*networkingv1.Subnet)
+				newSubnet := updateEvent.ObjectNew.(*networkingv1.Subnet)
+
+				oldIngress, oldEgress := networkingv1.GetSubnetBandwidth(&oldSubnet.Spec)
+				newIngress, newEgress := networkingv1.GetSubnetBandwidth(&newSubnet.Spec)
+				return oldIngress != newIngress || oldEgress != newEgress
+			},
+			DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
+				return false
+			},
+			GenericFunc: func(genericEvent event.GenericEvent) bool {
+				return false
+			},
+		}); err != nil {
+		return fmt.Errorf("failed to watch networkingv1.Subnet for pod controller: %v", err)
+	}
+
+	return c.mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		c.localPodInformerFactory.Start(ctx.Done())
+		<-ctx.Done()
+		return nil
+	}))
+}
+
 // Once node network interface is set from down to up for some reasons, the routes and neigh caches for this interface
 // will be cleaned, which should cause unrecoverable problems. Listening "UP" netlink events for interfaces and
 // triggering subnet and ip instance reconcile loop will be the best way to recover routes and neigh caches.
@@ -501,6 +1026,12 @@ func (c *CtrlHub) handleLocalNetworkDeviceEvent() error {
 					if (update.IfInfomsg.Flags&unix.IFF_UP != 0) &&
 						!containernetwork.CheckIfContainerNetworkLink(update.Link.Attrs().Name) {
 
+						// Routes and proxy neighs might be removed with the link down, never skip the next sync.
+						c.routeV4Manager.MarkTampered()
+						c.routeV6Manager.MarkTampered()
+						c.neighV4Manager.MarkTampered()
+						c.neighV6Manager.MarkTampered()
+
 						// Create event to flush routes and neigh caches.
 						c.subnetControllerTriggerSource.Trigger()
 						c.ipInstanceControllerTriggerSource.Trigger()
@@ -674,7 +1205,7 @@ func (c *CtrlHub) handleVxlanInterfaceNeighEvent() error {
 							continue
 						}
 
-						if strings.Contains(link.Attrs().Name, containernetwork.VxlanLinkInfix) {
+						if containernetwork.IsOverlayLinkName(link.Attrs().Name) {
 							go ipSearchExecWrapper(update.IP, link)
 						}
 					}
@@ -704,16 +1235,20 @@ func (c *CtrlHub) iptablesSyncLoop() {
 
 		for _, network := range networkList.Items {
 			switch networkingv1.GetNetworkMode(&network) {
-			case networkingv1.NetworkModeVxlan:
+			case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
 				netID := network.Spec.NetID
 
-				overlayIfName, err := containernetwork.GenerateVxlanNetIfName(c.config.NodeVxlanIfName, netID)
+				overlayIfName, err := containernetwork.GenerateOverlayNetIfName(c.config.NodeVxlanIfName,
+					networkingv1.GetNetworkMode(&network), netID)
 				if err != nil {
-					return fmt.Errorf("failed to generate vxlan forward node if name: %v", err)
+					return fmt.Errorf("failed to generate overlay forward node if name: %v", err)
 				}
 
 				c.iptablesV4Manager.SetOverlayIfName(overlayIfName)
 				c.iptablesV6Manager.SetOverlayIfName(overlayIfName)
+
+				c.iptablesV4Manager.SetOverlayDirectRouting(networkingv1.IsDirectRoutingEnabled(&network))
+				c.iptablesV6Manager.SetOverlayDirectRouting(networkingv1.IsDirectRoutingEnabled(&network))
 			case networkingv1.NetworkModeBGP:
 				if nodeBelongsToNetwork(c.config.NodeName, &network) {
 					c.iptablesV4Manager.SetBgpIfName(c.config.NodeBGPIfName)
@@ -872,6 +1407,9 @@ func (c *CtrlHub) iptablesSyncLoop() {
 	}
 
 	go func() {
+		tamperCheckTicker := time.NewTicker(RulesTamperCheckInterval)
+		defer tamperCheckTicker.Stop()
+
 		for {
 			select {
 			case <-c.iptablesSyncCh:
@@ -880,6 +1418,8 @@ func (c *CtrlHub) iptablesSyncLoop() {
 				}
 			case <-c.iptablesSyncTicker.C:
 				c.iptablesSyncTrigger()
+			case <-tamperCheckTicker.C:
+				c.checkRulesTampered()
 			}
 		}
 	}()
@@ -920,6 +1460,14 @@ func instanceIPIndexer(obj client.Object) []string {
 	return []string{}
 }
 
+func ipInstanceSubnetIndexer(obj client.Object) []string {
+	instance, ok := obj.(*networkingv1.IPInstance)
+	if ok {
+		return []string{instance.Spec.Subnet}
+	}
+	return []string{}
+}
+
 func endpointIPIndexer(obj client.Object) []string {
 	vtep, ok := obj.(*multiclusterv1.RemoteVtep)
 	if ok {
@@ -938,7 +1486,7 @@ func clearVxlanExpiredNeighCaches() error {
 	}
 
 	for _, link := range linkList {
-		if strings.Contains(link.Attrs().Name, containernetwork.VxlanLinkInfix) {
+		if containernetwork.IsOverlayLinkName(link.Attrs().Name) {
 			if err := neigh.ClearStaleAddFailedNeighEntries(link.Attrs().Index, netlink.FAMILY_V4); err != nil {
 				return fmt.Errorf("failed to clear v4 expired neigh entries for link %v: %v",
 					link.Attrs().Name, err)
@@ -68,11 +68,12 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 
 	for _, network := range networkList.Items {
 		switch networkingv1.GetNetworkMode(&network) {
-		case networkingv1.NetworkModeVxlan:
+		case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
 			netID := network.Spec.NetID
-			overlayForwardNodeIfName, err = containernetwork.GenerateVxlanNetIfName(r.ctrlHubRef.config.NodeVxlanIfName, netID)
+			overlayForwardNodeIfName, err = containernetwork.GenerateOverlayNetIfName(r.ctrlHubRef.config.NodeVxlanIfName,
+				networkingv1.GetNetworkMode(&network), netID)
 			if err != nil {
-				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vxlan forward node if name: %v", err)
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate overlay forward node if name: %v", err)
 			}
 			overlayExist = true
 		case networkingv1.NetworkModeBGP:
@@ -134,7 +135,7 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 		var forwardNodeIfName string
 		switch networkingv1.GetNetworkMode(network) {
 		case networkingv1.NetworkModeVlan:
-			forwardNodeIfName, err = containernetwork.GenerateVlanNetIfName(r.ctrlHubRef.config.NodeVlanIfName, netID)
+			forwardNodeIfName, err = r.ctrlHubRef.generateVlanForwardNodeIfName(ctx, network, netID)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vlan forward node interface name: %v", err)
 			}
@@ -142,13 +143,22 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 			if ipInstance.Spec.Address.Version == networkingv1.IPv4 {
 				r.ctrlHubRef.addrV4Manager.TryAddPodInfo(forwardNodeIfName, subnetCidr, podIP)
 			}
-		case networkingv1.NetworkModeVxlan:
-			forwardNodeIfName, err = containernetwork.GenerateVxlanNetIfName(r.ctrlHubRef.config.NodeVxlanIfName, netID)
+		case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
+			forwardNodeIfName, err = containernetwork.GenerateOverlayNetIfName(r.ctrlHubRef.config.NodeVxlanIfName,
+				networkingv1.GetNetworkMode(network), netID)
 			if err != nil {
-				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vxlan forward node interface name: %v", err)
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate overlay forward node interface name: %v", err)
 			}
 		case networkingv1.NetworkModeBGP:
-			r.ctrlHubRef.bgpManager.RecordIP(podIP)
+			attributes, aggregateOnly, err := r.ctrlHubRef.getBGPPathAttributes(ctx, network, ipInstance.Spec.Subnet)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get bgp path attributes for ip instance %v: %v",
+					ipInstance.Name, err)
+			}
+
+			if !aggregateOnly {
+				r.ctrlHubRef.bgpManager.RecordIP(podIP, attributes)
+			}
 		}
 
 		// create proxy neigh
@@ -166,6 +176,20 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 		}
 	}
 
+	virtualIPNeedRetry, err := r.syncVirtualIPs(ctx, overlayExist, overlayForwardNodeIfName, logger)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync virtual ips: %v", err)
+	}
+
+	anycastIPNeedRetry, err := r.syncAnycastIPs(ctx, overlayExist, overlayForwardNodeIfName, logger)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync anycast ips: %v", err)
+	}
+
+	if err := r.syncLoadBalancerIPs(ctx, logger); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync load balancer ips: %v", err)
+	}
+
 	if err := r.ctrlHubRef.neighV4Manager.SyncNeighs(); err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync ipv4 neighs: %v", err)
 	}
@@ -191,6 +215,15 @@ func (r *ipInstanceReconciler) Reconcile(ctx context.Context, request reconcile.
 
 	r.ctrlHubRef.iptablesSyncTrigger()
 
+	if virtualIPNeedRetry || anycastIPNeedRetry {
+		return reconcile.Result{Requeue: true}, nil
+	}
+
+	// addresses and routes of virtual ips and anycast ips are lost if pod sandboxes are recreated
+	if len(r.ctrlHubRef.virtualIPBindings) != 0 || len(r.ctrlHubRef.anycastIPBindings) != 0 {
+		return reconcile.Result{RequeueAfter: VirtualIPResyncPeriod}, nil
+	}
+
 	return reconcile.Result{}, nil
 }
 
@@ -245,21 +278,18 @@ func ensureExistPodConfigs(localDirectTableNum int, logger logr.Logger) error {
 				return fmt.Errorf("failed to get v4 container interface addr: %v", err)
 			}
 
-			var v4GatewayIP net.IP
-			if len(v4Addrs) == 0 {
+			// virtual ips and anycast ips are also addresses of container nic, only the pod ip is recovered
+			v4Addr := containernetwork.FindPodAddress(v4Addrs)
+			if v4Addr == nil {
 				allocatedIPs[networkingv1.IPv4] = nil
 			} else {
 				defaultRoute, err := containernetwork.GetDefaultRoute(netlink.FAMILY_V4)
 				if err != nil {
 					return fmt.Errorf("failed to get ipv4 default route: %v", err)
 				}
-				v4GatewayIP = defaultRoute.Gw
-			}
-
-			for _, addr := range v4Addrs {
 				allocatedIPs[networkingv1.IPv4] = &containernetwork.IPInfo{
-					Addr: addr.IP,
-					Gw:   v4GatewayIP,
+					Addr: v4Addr.IP,
+					Gw:   defaultRoute.Gw,
 				}
 			}
 
@@ -268,21 +298,17 @@ func ensureExistPodConfigs(localDirectTableNum int, logger logr.Logger) error {
 				return fmt.Errorf("failed to get v6 container interface addr: %v", err)
 			}
 
-			var v6GatewayIP net.IP
-			if len(v6Addrs) == 0 {
+			v6Addr := containernetwork.FindPodAddress(v6Addrs)
+			if v6Addr == nil {
 				allocatedIPs[networkingv1.IPv6] = nil
 			} else {
 				defaultRoute, err := containernetwork.GetDefaultRoute(netlink.FAMILY_V6)
 				if err != nil {
 					return fmt.Errorf("failed to get ipv6 default route: %v", err)
 				}
-				v6GatewayIP = defaultRoute.Gw
-			}
-
-			for _, addr := range v6Addrs {
 				allocatedIPs[networkingv1.IPv6] = &containernetwork.IPInfo{
-					Addr: addr.IP,
-					Gw:   v6GatewayIP,
+					Addr: v6Addr.IP,
+					Gw:   defaultRoute.Gw,
 				}
 			}
 
@@ -0,0 +1,102 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// syncLoadBalancerIPs records neigh proxies for load balancer ips of vlan networks which this node
+// is elected to announce, and sends gratuitous packets for the newly elected ones, so that traffic
+// of load balancer ips is attracted to this node and then forwarded by kube-proxy.
+func (r *ipInstanceReconciler) syncLoadBalancerIPs(ctx context.Context, logger logr.Logger) error {
+	loadBalancerIPList := &networkingv1.LoadBalancerIPList{}
+	if err := r.List(ctx, loadBalancerIPList,
+		client.MatchingLabels{constants.LabelNode: r.ctrlHubRef.config.NodeName}); err != nil {
+		return fmt.Errorf("failed to list load balancer ips: %v", err)
+	}
+
+	var (
+		currentAnnouncements  = r.ctrlHubRef.loadBalancerIPAnnouncements
+		expectedAnnouncements = map[string]bool{}
+	)
+
+	for i := range loadBalancerIPList.Items {
+		loadBalancerIP := &loadBalancerIPList.Items[i]
+		if !loadBalancerIP.DeletionTimestamp.IsZero() || len(loadBalancerIP.Status.IP) == 0 ||
+			loadBalancerIP.Status.NodeName != r.ctrlHubRef.config.NodeName {
+			continue
+		}
+
+		ip := net.ParseIP(loadBalancerIP.Status.IP)
+		if ip == nil {
+			return fmt.Errorf("invalid ip %v of load balancer ip %v", loadBalancerIP.Status.IP, loadBalancerIP.Name)
+		}
+
+		network := &networkingv1.Network{}
+		if err := r.Get(ctx, types.NamespacedName{Name: loadBalancerIP.Status.Network}, network); err != nil {
+			return fmt.Errorf("failed to get network for load balancer ip %v: %v", loadBalancerIP.Name, err)
+		}
+
+		if networkingv1.GetNetworkMode(network) != networkingv1.NetworkModeVlan {
+			continue
+		}
+
+		subnet := &networkingv1.Subnet{}
+		if err := r.Get(ctx, types.NamespacedName{Name: loadBalancerIP.Status.Subnet}, subnet); err != nil {
+			return fmt.Errorf("failed to get subnet for load balancer ip %v: %v", loadBalancerIP.Name, err)
+		}
+
+		netID := subnet.Spec.NetID
+		if netID == nil {
+			netID = network.Spec.NetID
+		}
+
+		forwardNodeIfName, err := r.ctrlHubRef.generateVlanForwardNodeIfName(ctx, network, netID)
+		if err != nil {
+			return fmt.Errorf("failed to generate vlan forward node interface name: %v", err)
+		}
+
+		ipVersion := networkingv1.IPv4
+		if ip.To4() == nil {
+			ipVersion = networkingv1.IPv6
+		}
+		r.ctrlHubRef.getNeighManager(ipVersion).AddPodInfo(ip, forwardNodeIfName)
+		expectedAnnouncements[loadBalancerIP.Status.IP] = true
+
+		// flush neigh caches of other hosts if the load balancer ip is newly elected to this node
+		if !currentAnnouncements[loadBalancerIP.Status.IP] {
+			if err := sendGratuitous(forwardNodeIfName, ip); err != nil {
+				logger.Error(err, "failed to send gratuitous packets for load balancer ip",
+					"load-balancer-ip", loadBalancerIP.Namespace+"/"+loadBalancerIP.Name)
+			}
+		}
+	}
+
+	r.ctrlHubRef.loadBalancerIPAnnouncements = expectedAnnouncements
+
+	return nil
+}
@@ -0,0 +1,268 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/arp"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+	"github.com/alibaba/hybridnet/pkg/daemon/neigh"
+)
+
+func newTestDaemonLoadBalancerIP(name, network, ip, nodeName string) *networkingv1.LoadBalancerIP {
+	return &networkingv1.LoadBalancerIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{constants.LabelNode: nodeName},
+		},
+		Spec: networkingv1.LoadBalancerIPSpec{
+			Service: name,
+			Version: networkingv1.IPv4,
+		},
+		Status: networkingv1.LoadBalancerIPStatus{
+			Network:  network,
+			Subnet:   network + "-lb",
+			IP:       ip,
+			NodeName: nodeName,
+		},
+	}
+}
+
+// receiveGratuitousARPs returns the sender ips of gratuitous arp packets received before timeout.
+func receiveGratuitousARPs(client *arp.Client, timeout time.Duration) ([]string, error) {
+	if err := client.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var senderIPs []string
+	for {
+		packet, _, err := client.Read()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return senderIPs, nil
+			}
+			return nil, err
+		}
+
+		if packet.SenderIP.Equal(packet.TargetIP) {
+			senderIPs = append(senderIPs, packet.SenderIP.String())
+		}
+	}
+}
+
+func TestSyncLoadBalancerIPs(t *testing.T) {
+	if unix.Geteuid() != 0 {
+		t.Skip("syncing neigh proxies requires root")
+	}
+
+	testNS, err := testutils.NewNS()
+	if err != nil {
+		t.Skipf("failed to create test netns: %v", err)
+	}
+	defer func() {
+		_ = testNS.Close()
+		_ = testutils.UnmountNS(testNS)
+	}()
+
+	// veth0 is the uplink of vlan network, and veth1 receives the gratuitous arps
+	if err := testNS.Do(func(_ ns.NetNS) error {
+		if err := netlink.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+			PeerName:  "veth1",
+		}); err != nil {
+			return err
+		}
+
+		for _, name := range []string{"veth0", "veth1"} {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return err
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Skipf("failed to set up test interface: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	netID := int32(0)
+	newNetwork := func(name string, mode networkingv1.NetworkMode) *networkingv1.Network {
+		return &networkingv1.Network{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: networkingv1.NetworkSpec{
+				Type:  networkingv1.NetworkTypeUnderlay,
+				Mode:  mode,
+				NetID: &netID,
+			},
+		}
+	}
+	newSubnet := func(network string) *networkingv1.Subnet {
+		return &networkingv1.Subnet{
+			ObjectMeta: metav1.ObjectMeta{Name: network + "-lb"},
+			Spec: networkingv1.SubnetSpec{
+				Network: network,
+				Range: networkingv1.AddressRange{
+					Version: networkingv1.IPv4,
+					CIDR:    "192.168.0.0/24",
+				},
+			},
+		}
+	}
+
+	deleting := newTestDaemonLoadBalancerIP("deleting", "vlan-network", "192.168.0.13", "node1")
+	deletionTime := metav1.Now()
+	deleting.DeletionTimestamp = &deletionTime
+	deleting.Finalizers = []string{constants.FinalizerIPAllocated}
+
+	baseObjects := []client.Object{
+		newNetwork("vlan-network", networkingv1.NetworkModeVlan),
+		newNetwork("bgp-network", networkingv1.NetworkModeBGP),
+		newSubnet("vlan-network"),
+		newSubnet("bgp-network"),
+		newTestDaemonLoadBalancerIP("bgp", "bgp-network", "192.168.0.20", "node1"),
+		newTestDaemonLoadBalancerIP("other-node", "vlan-network", "192.168.0.12", "node2"),
+		deleting,
+	}
+
+	tests := []struct {
+		name              string
+		loadBalancerIPs   []client.Object
+		expectedProxies   []string
+		expectedGARPs     []string
+		announcementsLeft map[string]bool
+	}{
+		{
+			name: "newly elected load balancer ip",
+			loadBalancerIPs: []client.Object{
+				newTestDaemonLoadBalancerIP("lb1", "vlan-network", "192.168.0.10", "node1"),
+			},
+			expectedProxies:   []string{"192.168.0.10"},
+			expectedGARPs:     []string{"192.168.0.10"},
+			announcementsLeft: map[string]bool{"192.168.0.10": true},
+		},
+		{
+			name: "announced load balancer ip is not flushed again",
+			loadBalancerIPs: []client.Object{
+				newTestDaemonLoadBalancerIP("lb1", "vlan-network", "192.168.0.10", "node1"),
+				newTestDaemonLoadBalancerIP("lb2", "vlan-network", "192.168.0.11", "node1"),
+			},
+			expectedProxies:   []string{"192.168.0.10", "192.168.0.11"},
+			expectedGARPs:     []string{"192.168.0.11"},
+			announcementsLeft: map[string]bool{"192.168.0.10": true, "192.168.0.11": true},
+		},
+		{
+			name: "load balancer ip elected to another node",
+			loadBalancerIPs: []client.Object{
+				newTestDaemonLoadBalancerIP("lb2", "vlan-network", "192.168.0.11", "node1"),
+			},
+			expectedProxies:   []string{"192.168.0.11"},
+			expectedGARPs:     nil,
+			announcementsLeft: map[string]bool{"192.168.0.11": true},
+		},
+	}
+
+	ctrlHub := &CtrlHub{
+		config: &daemonconfig.Configuration{
+			NodeName:       "node1",
+			NodeVlanIfName: "veth0",
+		},
+		neighV4Manager:              neigh.CreateNeighManager(netlink.FAMILY_V4),
+		loadBalancerIPAnnouncements: map[string]bool{},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &ipInstanceReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).
+					WithObjects(append(test.loadBalancerIPs, baseObjects...)...).Build(),
+				ctrlHubRef: ctrlHub,
+			}
+
+			// subtests run in other goroutines, which should enter the test netns again
+			assert.NoError(t, testNS.Do(func(_ ns.NetNS) error {
+				peer, err := net.InterfaceByName("veth1")
+				if err != nil {
+					return err
+				}
+				arpClient, err := arp.Dial(peer, net.ParseIP("192.168.0.2"))
+				if err != nil {
+					return err
+				}
+				defer arpClient.Close()
+
+				ctrlHub.neighV4Manager.ResetInfos()
+				assert.NoError(t, r.syncLoadBalancerIPs(context.Background(), logr.Discard()))
+				assert.NoError(t, ctrlHub.neighV4Manager.SyncNeighs())
+
+				uplink, err := netlink.LinkByName("veth0")
+				if err != nil {
+					return err
+				}
+				neighList, err := netlink.NeighProxyList(uplink.Attrs().Index, netlink.FAMILY_V4)
+				if err != nil {
+					return err
+				}
+				var proxies []string
+				for _, neigh := range neighList {
+					proxies = append(proxies, neigh.IP.String())
+				}
+				assert.ElementsMatch(t, test.expectedProxies, proxies)
+
+				garps, err := receiveGratuitousARPs(arpClient, 200*time.Millisecond)
+				if err != nil {
+					return err
+				}
+				// a gratuitous request and a gratuitous reply are sent for each ip
+				var garpIPs []string
+				for _, ip := range garps {
+					if len(garpIPs) == 0 || garpIPs[len(garpIPs)-1] != ip {
+						garpIPs = append(garpIPs, ip)
+					}
+				}
+				assert.Equal(t, test.expectedGARPs, garpIPs)
+
+				assert.Equal(t, test.announcementsLeft, ctrlHub.loadBalancerIPAnnouncements)
+				return nil
+			}))
+		})
+	}
+}
@@ -18,6 +18,7 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 
@@ -31,6 +32,8 @@ import (
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/geneve"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
 	"github.com/alibaba/hybridnet/pkg/daemon/vxlan"
 	"github.com/alibaba/hybridnet/pkg/feature"
 
@@ -40,8 +43,16 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
 )
 
+// overlayDevice forwards frames to remote vteps by their mac addresses.
+type overlayDevice interface {
+	Link() netlink.Link
+	RecordVtepInfo(vtepMac net.HardwareAddr, vtepIP net.IP)
+	SyncVtepInfo() error
+}
+
 type nodeReconciler struct {
 	client.Client
 	ctrlHubRef *CtrlHub
@@ -57,15 +68,22 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 	}
 
 	var overlayNetID *int32
+	var overlayNetworkMode networkingv1.NetworkMode
+	var wireGuardEnabled bool
 	for _, network := range networkList.Items {
 		if networkingv1.GetNetworkType(&network) == networkingv1.NetworkTypeOverlay {
 			overlayNetID = network.Spec.NetID
+			overlayNetworkMode = networkingv1.GetNetworkMode(&network)
+			wireGuardEnabled = networkingv1.IsWireGuardEnabled(&network)
 			break
 		}
 	}
 
-	// overlay network not exist, do nothing
+	// overlay network not exist, only clean wireguard
 	if overlayNetID == nil {
+		if err := r.ctrlHubRef.cleanWireGuard(); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to clean wireguard: %v", err)
+		}
 		return reconcile.Result{}, nil
 	}
 
@@ -87,43 +105,35 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 			link.Attrs().Name)
 	}
 
-	var vtepIP net.IP
-	for _, addr := range existParentAddrList {
-		if addr.IP.IsGlobalUnicast() {
-			vtepIP = addr.IP
-			break
-		}
-	}
-
 	thisNode := &corev1.Node{}
 	if err := r.Get(ctx, types.NamespacedName{Name: r.ctrlHubRef.config.NodeName}, thisNode); err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get this node %v object: %v",
 			r.ctrlHubRef.config.NodeName, err)
 	}
 
-	preVtepIP, exist := thisNode.Annotations[constants.AnnotationNodeVtepIP]
-	if exist {
-		// if vtep ip has been set and still exist on parent interface
-		for _, address := range existParentAddrList {
-			if address.IP.String() == preVtepIP {
-				vtepIP = address.IP
-				break
-			}
-		}
+	var parentIPs []net.IP
+	for _, addr := range existParentAddrList {
+		parentIPs = append(parentIPs, addr.IP)
 	}
 
+	// if vtep ip has been set and still exist on parent interface, it will be kept
+	vtepIP, secondaryVtepIP := daemonutils.SelectVtepIPs(parentIPs,
+		r.ctrlHubRef.config.VtepAddressFamily == netlink.FAMILY_V6,
+		net.ParseIP(thisNode.Annotations[constants.AnnotationNodeVtepIP]))
+
 	if vtepIP == nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("no availuable vtep ip can be used for link %v",
 			link.Attrs().Name)
 	}
 	vtepMac := link.Attrs().HardwareAddr
 
-	vxlanLinkName, err := containernetwork.GenerateVxlanNetIfName(r.ctrlHubRef.config.NodeVxlanIfName, overlayNetID)
+	overlayLinkName, err := containernetwork.GenerateOverlayNetIfName(r.ctrlHubRef.config.NodeVxlanIfName,
+		overlayNetworkMode, overlayNetID)
 	if err != nil {
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vxlan interface name: %v", err)
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate overlay interface name: %v", err)
 	}
 
-	existAllAddrList, err := containernetwork.ListLocalAddressExceptLink(vxlanLinkName)
+	existAllAddrList, err := containernetwork.ListLocalAddressExceptLink(overlayLinkName)
 	if err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to list address for all interfaces: %v", err)
 	}
@@ -152,13 +162,41 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 		}
 	}
 
-	patchData := fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s","%s":"%s","%s":"%s"}}}`,
-		constants.AnnotationNodeVtepIP, vtepIP.String(),
-		constants.AnnotationNodeVtepMac, vtepMac.String(),
-		constants.AnnotationNodeLocalVxlanIPList, containernetwork.GenerateIPListString(nodeLocalVxlanAddr))
+	// null will remove the annotation
+	var secondaryVtepIPString *string
+	if secondaryVtepIP != nil {
+		secondaryVtepIPString = pointer.StringPtr(secondaryVtepIP.String())
+	}
+
+	// null will remove the annotation
+	var wireGuardPublicKey *string
+	if wireGuardEnabled {
+		publicKey, err := r.ctrlHubRef.ensureWireGuardDevice(link.Attrs().MTU)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to ensure wireguard device: %v", err)
+		}
+		wireGuardPublicKey = pointer.StringPtr(publicKey)
+	} else if err := r.ctrlHubRef.cleanWireGuard(); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to clean wireguard: %v", err)
+	}
+
+	patchData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				constants.AnnotationNodeVtepIP:             vtepIP.String(),
+				constants.AnnotationNodeVtepMac:            vtepMac.String(),
+				constants.AnnotationNodeLocalVxlanIPList:   containernetwork.GenerateIPListString(nodeLocalVxlanAddr),
+				constants.AnnotationNodeSecondaryVtepIP:    secondaryVtepIPString,
+				constants.AnnotationNodeWireGuardPublicKey: wireGuardPublicKey,
+			},
+		},
+	})
+	if err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate patch data: %v", err)
+	}
 
 	if err := r.ctrlHubRef.mgr.GetClient().Patch(context.TODO(),
-		thisNode, client.RawPatch(types.StrategicMergePatchType, []byte(patchData))); err != nil {
+		thisNode, client.RawPatch(types.StrategicMergePatchType, patchData)); err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("update node label error: %v", err)
 	}
 
@@ -167,11 +205,19 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed list node: %v", err)
 	}
 
-	vxlanDev, err := vxlan.NewVxlanDevice(vxlanLinkName, int(*overlayNetID),
-		r.ctrlHubRef.config.NodeVxlanIfName, vtepIP, r.ctrlHubRef.config.VxlanUDPPort,
-		r.ctrlHubRef.config.VxlanBaseReachableTime, true)
+	var overlayDev overlayDevice
+	if overlayNetworkMode == networkingv1.NetworkModeGeneve {
+		overlayDev, err = geneve.NewGeneveDevice(overlayLinkName, int(*overlayNetID),
+			r.ctrlHubRef.config.NodeVxlanIfName, vtepIP, r.ctrlHubRef.config.GeneveUDPPort,
+			r.ctrlHubRef.config.VxlanBaseReachableTime)
+	} else {
+		overlayDev, err = vxlan.NewVxlanDevice(overlayLinkName, int(*overlayNetID),
+			r.ctrlHubRef.config.NodeVxlanIfName, vtepIP, r.ctrlHubRef.config.VxlanUDPPort,
+			r.ctrlHubRef.config.VxlanBaseReachableTime, true)
+	}
 	if err != nil {
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to create vxlan device %v: %v", vxlanLinkName, err)
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to create %v device %v: %v",
+			overlayNetworkMode, overlayLinkName, err)
 	}
 
 	nodeLocalVxlanAddrMap := map[string]bool{}
@@ -179,40 +225,42 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 		nodeLocalVxlanAddrMap[addr.IP.String()] = true
 	}
 
-	vxlanDevAddrList, err := containernetwork.ListAllAddress(vxlanDev.Link())
+	overlayDevAddrList, err := containernetwork.ListAllAddress(overlayDev.Link())
 	if err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to list address for vxlan interface %v: %v",
-			vxlanDev.Link().Name, err)
+			overlayDev.Link().Attrs().Name, err)
 	}
 
 	existVxlanDevAddrMap := map[string]bool{}
-	for _, addr := range vxlanDevAddrList {
+	for _, addr := range overlayDevAddrList {
 		existVxlanDevAddrMap[addr.IP.String()] = true
 	}
 
 	// Add all node local vxlan ip address to vxlan interface.
 	for _, addr := range nodeLocalVxlanAddr {
 		if _, exist := existVxlanDevAddrMap[addr.IP.String()]; !exist {
-			if err := netlink.AddrAdd(vxlanDev.Link(), &netlink.Addr{
+			if err := netlink.AddrAdd(overlayDev.Link(), &netlink.Addr{
 				IPNet: addr.IPNet,
 				Label: "",
 				Flags: unix.IFA_F_NOPREFIXROUTE,
 			}); err != nil {
 				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to set addr %v to link %v: %v",
-					addr.IP.String(), vxlanDev.Link().Name, err)
+					addr.IP.String(), overlayDev.Link().Attrs().Name, err)
 			}
 		}
 	}
 
 	// Delete invalid address.
-	for _, addr := range vxlanDevAddrList {
+	for _, addr := range overlayDevAddrList {
 		if _, exist := nodeLocalVxlanAddrMap[addr.IP.String()]; !exist {
-			if err := netlink.AddrDel(vxlanDev.Link(), &addr); err != nil {
-				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to del addr %v for link %v: %v", addr.IP.String(), vxlanDev.Link().Name, err)
+			if err := netlink.AddrDel(overlayDev.Link(), &addr); err != nil {
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to del addr %v for link %v: %v", addr.IP.String(), overlayDev.Link().Attrs().Name, err)
 			}
 		}
 	}
 
+	var wireGuardPeers []wireGuardPeer
+	var unencryptedPeers []string
 	for _, node := range nodeList.Items {
 		if node.Annotations[constants.AnnotationNodeVtepMac] == "" ||
 			node.Annotations[constants.AnnotationNodeVtepIP] == "" ||
@@ -226,13 +274,32 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 				node.Annotations[constants.AnnotationNodeVtepMac], err)
 		}
 
-		vtepIP := net.ParseIP(node.Annotations[constants.AnnotationNodeVtepIP])
-		if vtepIP == nil {
+		remoteVtepIP := net.ParseIP(node.Annotations[constants.AnnotationNodeVtepIP])
+		if remoteVtepIP == nil {
 			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to parse node vtep ip string %v",
 				node.Annotations[constants.AnnotationNodeVtepIP])
 		}
 
-		vxlanDev.RecordVtepInfo(vtepMac, vtepIP)
+		// vxlan device can only reach vtep ips in the same family with local one
+		if remoteVtepIP = daemonutils.PickIPOfSameFamily(vtepIP, remoteVtepIP,
+			net.ParseIP(node.Annotations[constants.AnnotationNodeSecondaryVtepIP])); remoteVtepIP == nil {
+			logger.Info("node has no vtep ip in the same family with local vtep ip, skip it",
+				"node", node.Name, "localVtepIP", vtepIP.String())
+			continue
+		}
+
+		overlayDev.RecordVtepInfo(vtepMac, remoteVtepIP)
+
+		if wireGuardEnabled && node.Name != r.ctrlHubRef.config.NodeName {
+			if peer, ok := newWireGuardPeer(node.Annotations[constants.AnnotationNodeWireGuardPublicKey],
+				remoteVtepIP); ok {
+				wireGuardPeers = append(wireGuardPeers, peer)
+			} else {
+				logger.Info("node has no valid wireguard public key, traffic to it will not be encrypted",
+					"node", node.Name)
+				unencryptedPeers = append(unencryptedPeers, "node "+node.Name)
+			}
+		}
 	}
 
 	var remoteVtepList []*multiclusterv1.RemoteVtep
@@ -250,13 +317,30 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 					remoteVtep.Spec.VTEPInfo.MAC, err)
 			}
 
-			vtepIP := net.ParseIP(remoteVtep.Spec.VTEPInfo.IP)
-			if vtepIP == nil {
+			remoteVtepIP := net.ParseIP(remoteVtep.Spec.VTEPInfo.IP)
+			if remoteVtepIP == nil {
 				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to parse remote vtep ip string %v",
 					remoteVtep.Spec.VTEPInfo.IP)
 			}
 
-			vxlanDev.RecordVtepInfo(vtepMac, vtepIP)
+			if remoteVtepIP = daemonutils.PickIPOfSameFamily(vtepIP, remoteVtepIP,
+				net.ParseIP(remoteVtep.Spec.VTEPInfo.SecondaryIP)); remoteVtepIP == nil {
+				logger.Info("remote vtep has no ip in the same family with local vtep ip, skip it",
+					"remoteVtep", remoteVtep.Name, "localVtepIP", vtepIP.String())
+				continue
+			}
+
+			overlayDev.RecordVtepInfo(vtepMac, remoteVtepIP)
+
+			if wireGuardEnabled {
+				if peer, ok := newWireGuardPeer(remoteVtep.Spec.VTEPInfo.WireGuardPublicKey, remoteVtepIP); ok {
+					wireGuardPeers = append(wireGuardPeers, peer)
+				} else {
+					logger.Info("remote vtep has no valid wireguard public key, traffic to it will not be encrypted",
+						"remoteVtep", remoteVtep.Name)
+					unencryptedPeers = append(unencryptedPeers, "remote vtep "+remoteVtep.Name)
+				}
+			}
 		}
 	}
 
@@ -264,10 +348,22 @@ func (r *nodeReconciler) Reconcile(ctx context.Context, request reconcile.Reques
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to update node ip cache: %v", err)
 	}
 
-	if err := vxlanDev.SyncVtepInfo(); err != nil {
-		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync vtep info for vxlan device %v: %v",
-			vxlanDev.Link().Name, err)
+	if err := overlayDev.SyncVtepInfo(); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync vtep info for overlay device %v: %v",
+			overlayDev.Link().Attrs().Name, err)
+	}
+
+	if wireGuardEnabled {
+		overlayPort := r.ctrlHubRef.config.VxlanUDPPort
+		if overlayNetworkMode == networkingv1.NetworkModeGeneve {
+			overlayPort = r.ctrlHubRef.config.GeneveUDPPort
+		}
+
+		if err := r.ctrlHubRef.syncWireGuardPeers(vtepIP, overlayPort, wireGuardPeers); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync wireguard peers: %v", err)
+		}
 	}
+	r.ctrlHubRef.warnUnencryptedWireGuardPeers(thisNode, unencryptedPeers)
 
 	r.ctrlHubRef.iptablesSyncTrigger()
 
@@ -280,7 +376,9 @@ func checkNodeUpdate(updateEvent event.UpdateEvent) bool {
 
 	if oldNode.Annotations[constants.AnnotationNodeVtepIP] != newNode.Annotations[constants.AnnotationNodeVtepIP] ||
 		oldNode.Annotations[constants.AnnotationNodeVtepMac] != newNode.Annotations[constants.AnnotationNodeVtepMac] ||
-		oldNode.Annotations[constants.AnnotationNodeLocalVxlanIPList] != newNode.Annotations[constants.AnnotationNodeLocalVxlanIPList] {
+		oldNode.Annotations[constants.AnnotationNodeLocalVxlanIPList] != newNode.Annotations[constants.AnnotationNodeLocalVxlanIPList] ||
+		oldNode.Annotations[constants.AnnotationNodeSecondaryVtepIP] != newNode.Annotations[constants.AnnotationNodeSecondaryVtepIP] ||
+		oldNode.Annotations[constants.AnnotationNodeWireGuardPublicKey] != newNode.Annotations[constants.AnnotationNodeWireGuardPublicKey] {
 		return true
 	}
 	return false
@@ -0,0 +1,139 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/bandwidth"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+)
+
+// podReconciler keeps bandwidth limits of pods on this node up to date with their annotations and
+// default bandwidth of their subnets, the initial limits are configured by cni while creating container network.
+type podReconciler struct {
+	client.Client
+	podLister  corelisters.PodLister
+	ctrlHubRef *CtrlHub
+}
+
+func (r *podReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	logger := log.FromContext(ctx)
+
+	hostNicName, _ := containernetwork.GenerateContainerVethPair(request.Namespace, request.Name)
+
+	pod, err := r.podLister.Pods(request.Namespace).Get(request.Name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// ifb device may be left over if cni del is not called
+			if err = bandwidth.ClearPodBandwidth(hostNicName); err != nil {
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to clear bandwidth for pod %v: %v", request.String(), err)
+			}
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get pod %v: %v", request.String(), err)
+	}
+
+	if pod.Spec.HostNetwork || !pod.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	if _, err = netlink.LinkByName(hostNicName); err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			// container network is not created yet, bandwidth will be configured by cni
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get host nic %v: %v", hostNicName, err)
+	}
+
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err = r.List(ctx, ipInstanceList, client.InNamespace(pod.Namespace), client.MatchingLabels{
+		constants.LabelNode: r.ctrlHubRef.config.NodeName,
+		constants.LabelPod:  pod.Name,
+	}); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to list ip instances for pod %v: %v", request.String(), err)
+	}
+
+	var subnets []*networkingv1.Subnet
+	for _, ipInstance := range ipInstanceList.Items {
+		subnet := &networkingv1.Subnet{}
+		if err = r.Get(ctx, types.NamespacedName{Name: ipInstance.Spec.Subnet}, subnet); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get subnet %v: %v", ipInstance.Spec.Subnet, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	limits, err := bandwidth.GetPodBandwidthLimits(pod, subnets)
+	if err != nil {
+		// invalid annotations will not be fixed by retrying
+		logger.Error(err, "failed to get bandwidth limits", "pod", request.String())
+		return reconcile.Result{}, nil
+	}
+
+	if err = bandwidth.EnsurePodBandwidth(hostNicName, limits); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to ensure bandwidth for pod %v: %v", request.String(), err)
+	}
+
+	logger.V(2).Info("pod bandwidth reconciled", "pod", request.String(),
+		"ingress", limits.Ingress, "egress", limits.Egress)
+	return reconcile.Result{}, nil
+}
+
+// localPodRequestsOfSubnet returns the requests of pods on this node which have ips in the subnet.
+func (c *CtrlHub) localPodRequestsOfSubnet(obj client.Object) []reconcile.Request {
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err := c.mgr.GetClient().List(context.TODO(), ipInstanceList, client.MatchingLabels{
+		constants.LabelNode:   c.config.NodeName,
+		constants.LabelSubnet: obj.GetName(),
+	}); err != nil {
+		c.logger.Error(err, "failed to list ip instances of subnet", "subnet", obj.GetName())
+		return nil
+	}
+
+	return podRequestsOfIPInstances(ipInstanceList.Items)
+}
+
+// podRequestsOfIPInstances returns the requests of pods which ip instances belong to, pods with
+// multiple ip instances are only requested once.
+func podRequestsOfIPInstances(ipInstances []networkingv1.IPInstance) []reconcile.Request {
+	var requests []reconcile.Request
+	requested := map[types.NamespacedName]bool{}
+	for i := range ipInstances {
+		podName := ipInstances[i].Labels[constants.LabelPod]
+		if len(podName) == 0 {
+			continue
+		}
+
+		key := types.NamespacedName{Namespace: ipInstances[i].Namespace, Name: podName}
+		if !requested[key] {
+			requested[key] = true
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,89 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestPodIPInstance(namespace, name, podName string) networkingv1.IPInstance {
+	ipInstance := networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+	if len(podName) > 0 {
+		ipInstance.Labels = map[string]string{constants.LabelPod: podName}
+	}
+	return ipInstance
+}
+
+func TestPodRequestsOfIPInstances(t *testing.T) {
+	tests := []struct {
+		name        string
+		ipInstances []networkingv1.IPInstance
+		expected    []reconcile.Request
+	}{
+		{
+			name:     "no ip instance",
+			expected: nil,
+		},
+		{
+			name: "pods in different namespaces",
+			ipInstances: []networkingv1.IPInstance{
+				newTestPodIPInstance("ns1", "192-168-0-10", "pod1"),
+				newTestPodIPInstance("ns2", "192-168-0-11", "pod1"),
+			},
+			expected: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "ns1", Name: "pod1"}},
+				{NamespacedName: types.NamespacedName{Namespace: "ns2", Name: "pod1"}},
+			},
+		},
+		{
+			name: "dual stack pod is requested once",
+			ipInstances: []networkingv1.IPInstance{
+				newTestPodIPInstance("ns1", "192-168-0-10", "pod1"),
+				newTestPodIPInstance("ns1", "fd00-0-0-0-0-0-0-10", "pod1"),
+			},
+			expected: []reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "ns1", Name: "pod1"}},
+			},
+		},
+		{
+			name: "ip instance without pod label",
+			ipInstances: []networkingv1.IPInstance{
+				newTestPodIPInstance("ns1", "192-168-0-10", ""),
+			},
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, podRequestsOfIPInstances(test.ipInstances))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+// serviceReconciler advertises service ips to bgp peers if the bgp network of this node enables
+// service advertisement, load balancer ips allocated from the bgp network are always advertised.
+type serviceReconciler struct {
+	client.Client
+	ctrlHubRef *CtrlHub
+}
+
+// Reconcile advertises the ips of a service only, paths of the other services are not touched.
+func (r *serviceReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	logger := log.FromContext(ctx)
+
+	start := time.Now()
+	defer func() {
+		endTime := time.Since(start)
+		logger.V(2).Info("Service information reconciled", "time", endTime)
+	}()
+
+	serviceIPs, communities, err := r.getServiceAdvertisement(ctx, request.NamespacedName)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	if err := r.ctrlHubRef.bgpManager.SyncServiceIPs(request.String(), serviceIPs, communities); err != nil {
+		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp paths of service %v: %v",
+			request.String(), err)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// getServiceAdvertisement returns the ips of service to advertise with the communities attached, nothing
+// is returned if the service does not exist or this node does not belong to any bgp network.
+func (r *serviceReconciler) getServiceAdvertisement(ctx context.Context, name types.NamespacedName) ([]net.IP, []uint32, error) {
+	network, err := r.getBGPNetworkOfThisNode(ctx)
+	if err != nil || network == nil {
+		return nil, nil, err
+	}
+
+	service := &corev1.Service{}
+	if err := r.Get(ctx, name, service); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get service %v: %v", name.String(), err)
+	}
+
+	allocatedIPs, err := r.getAllocatedLoadBalancerIPs(ctx, network.Name, service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	advertisement := networkingv1.GetBGPServiceAdvertisement(network)
+	if advertisement == nil {
+		advertisement = &networkingv1.BGPServiceAdvertisement{}
+	}
+
+	serviceIPs, err := r.getServiceIPsToAdvertise(ctx, service, advertisement, allocatedIPs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get ips of service %v: %v", name.String(), err)
+	}
+
+	if len(serviceIPs) == 0 {
+		return nil, nil, nil
+	}
+
+	return serviceIPs, r.getServiceCommunities(ctx, service), nil
+}
+
+// getServiceCommunities parses the communities annotation of service, a warning event is reported on
+// service if it is invalid, and service ips are advertised without communities in that case.
+func (r *serviceReconciler) getServiceCommunities(ctx context.Context, service *corev1.Service) []uint32 {
+	value, exist := service.Annotations[constants.AnnotationBGPCommunities]
+	if !exist {
+		return nil
+	}
+
+	communities, err := networkingv1.ParseBGPCommunities(value)
+	if err != nil {
+		// invalid annotations will not be fixed by retrying
+		log.FromContext(ctx).Error(err, "failed to parse bgp communities, service ips are advertised without communities",
+			"service", service.Namespace+"/"+service.Name)
+		r.ctrlHubRef.recorder.Eventf(service, corev1.EventTypeWarning, ReasonInvalidBGPCommunities,
+			"invalid annotation %s: %v, service ips are advertised without communities from node %s",
+			constants.AnnotationBGPCommunities, err, r.ctrlHubRef.config.NodeName)
+		return nil
+	}
+	return communities
+}
+
+// getBGPNetworkOfThisNode returns the bgp network which this node belongs to, and bgp manager is started
+// with the AS number of it. Nil is returned if this node does not belong to any bgp network.
+func (r *serviceReconciler) getBGPNetworkOfThisNode(ctx context.Context) (*networkingv1.Network, error) {
+	networkList := &networkingv1.NetworkList{}
+	if err := r.List(ctx, networkList); err != nil {
+		return nil, fmt.Errorf("failed to list network: %v", err)
+	}
+
+	for i := range networkList.Items {
+		network := &networkList.Items[i]
+		if networkingv1.GetNetworkMode(network) != networkingv1.NetworkModeBGP ||
+			!nodeBelongsToNetwork(r.ctrlHubRef.config.NodeName, network) {
+			continue
+		}
+
+		if network.Spec.NetID == nil {
+			return nil, fmt.Errorf("the net id of network %v must to be set", network.Name)
+		}
+
+		localAS := uint32(*network.Spec.NetID)
+		if err := r.ctrlHubRef.bgpManager.TryStart(localAS); err != nil {
+			return nil, fmt.Errorf("try start bgp manager for network %v failed: %v", network.Name, err)
+		}
+		return network, nil
+	}
+	return nil, nil
+}
+
+// getAllocatedLoadBalancerIPs returns the load balancer ips allocated to service from bgp network, indexed by
+// the namespaced name of service.
+func (r *serviceReconciler) getAllocatedLoadBalancerIPs(ctx context.Context, networkName string,
+	service *corev1.Service) (map[string][]string, error) {
+	loadBalancerIPList := &networkingv1.LoadBalancerIPList{}
+	if err := r.List(ctx, loadBalancerIPList, client.InNamespace(service.Namespace),
+		client.MatchingLabels{constants.LabelNetwork: networkName}); err != nil {
+		return nil, fmt.Errorf("failed to list load balancer ips: %v", err)
+	}
+
+	allocatedIPs := map[string][]string{}
+	for i := range loadBalancerIPList.Items {
+		loadBalancerIP := &loadBalancerIPList.Items[i]
+		if loadBalancerIP.Spec.Service != service.Name || !loadBalancerIP.DeletionTimestamp.IsZero() ||
+			len(loadBalancerIP.Status.IP) == 0 {
+			continue
+		}
+
+		key := loadBalancerIP.Namespace + "/" + loadBalancerIP.Spec.Service
+		allocatedIPs[key] = append(allocatedIPs[key], loadBalancerIP.Status.IP)
+	}
+	return allocatedIPs, nil
+}
+
+// getServiceIPsToAdvertise returns the ips of service to advertise from this node. External ips and
+// load balancer ips of service with "Local" external traffic policy are advertised only if there are
+// ready endpoints on this node.
+func (r *serviceReconciler) getServiceIPsToAdvertise(ctx context.Context, service *corev1.Service,
+	advertisement *networkingv1.BGPServiceAdvertisement, allocatedIPs map[string][]string) ([]net.IP, error) {
+	var ipStrings, externalIPStrings []string
+
+	if advertisement.ClusterIPs {
+		if len(service.Spec.ClusterIPs) != 0 {
+			ipStrings = append(ipStrings, service.Spec.ClusterIPs...)
+		} else {
+			ipStrings = append(ipStrings, service.Spec.ClusterIP)
+		}
+	}
+
+	if advertisement.ExternalIPs {
+		externalIPStrings = append(externalIPStrings, service.Spec.ExternalIPs...)
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		if advertisement.LoadBalancerIPs {
+			for _, ingress := range service.Status.LoadBalancer.Ingress {
+				externalIPStrings = append(externalIPStrings, ingress.IP)
+			}
+		} else {
+			externalIPStrings = append(externalIPStrings, allocatedIPs[service.Namespace+"/"+service.Name]...)
+		}
+	}
+
+	if len(externalIPStrings) != 0 && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+		endpoints := &corev1.Endpoints{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, endpoints); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get endpoints: %v", err)
+			}
+			externalIPStrings = nil
+		} else if !hasLocalReadyEndpoints(endpoints, r.ctrlHubRef.config.NodeName) {
+			externalIPStrings = nil
+		}
+	}
+
+	var serviceIPs []net.IP
+	for _, ipString := range append(ipStrings, externalIPStrings...) {
+		// headless service has a "None" cluster ip, and ingress might only have a hostname
+		if serviceIP := net.ParseIP(ipString); serviceIP != nil {
+			serviceIPs = append(serviceIPs, serviceIP)
+		}
+	}
+	return serviceIPs, nil
+}
+
+func hasLocalReadyEndpoints(endpoints *corev1.Endpoints, nodeName string) bool {
+	for _, subset := range endpoints.Subsets {
+		// not ready addresses are in NotReadyAddresses
+		for _, address := range subset.Addresses {
+			if address.NodeName != nil && *address.NodeName == nodeName {
+				return true
+			}
+		}
+	}
+	return false
+}
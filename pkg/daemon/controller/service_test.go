@@ -0,0 +1,274 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+)
+
+func newTestEndpoints(name string, readyNodes, notReadyNodes []string) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{}
+	for i := range readyNodes {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{
+			IP:       "10.0.0.1",
+			NodeName: &readyNodes[i],
+		})
+	}
+	for i := range notReadyNodes {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, corev1.EndpointAddress{
+			IP:       "10.0.0.2",
+			NodeName: &notReadyNodes[i],
+		})
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+		},
+		Subsets: []corev1.EndpointSubset{subset},
+	}
+}
+
+func TestHasLocalReadyEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints *corev1.Endpoints
+		expected  bool
+	}{
+		{
+			name:      "ready endpoint on this node",
+			endpoints: newTestEndpoints("svc", []string{"node2", "node1"}, nil),
+			expected:  true,
+		},
+		{
+			name:      "ready endpoints on other nodes",
+			endpoints: newTestEndpoints("svc", []string{"node2"}, nil),
+			expected:  false,
+		},
+		{
+			name:      "not ready endpoint on this node",
+			endpoints: newTestEndpoints("svc", []string{"node2"}, []string{"node1"}),
+			expected:  false,
+		},
+		{
+			name: "endpoint without node name",
+			endpoints: &corev1.Endpoints{
+				Subsets: []corev1.EndpointSubset{
+					{
+						Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+					},
+				},
+			},
+			expected: false,
+		},
+		{
+			name:      "no subsets",
+			endpoints: &corev1.Endpoints{},
+			expected:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, hasLocalReadyEndpoints(test.endpoints, "node1"))
+		})
+	}
+}
+
+func TestGetServiceIPsToAdvertise(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+
+	newService := func(name string, serviceType corev1.ServiceType, policy corev1.ServiceExternalTrafficPolicyType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:                  serviceType,
+				ClusterIP:             "172.16.0.1",
+				ClusterIPs:            []string{"172.16.0.1", "fd00::1"},
+				ExternalIPs:           []string{"192.168.0.1"},
+				ExternalTrafficPolicy: policy,
+			},
+			Status: corev1.ServiceStatus{
+				LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{
+						{IP: "192.168.1.1"},
+						{Hostname: "lb.example.com"},
+					},
+				},
+			},
+		}
+	}
+
+	allAdvertisement := &networkingv1.BGPServiceAdvertisement{
+		ClusterIPs:      true,
+		ExternalIPs:     true,
+		LoadBalancerIPs: true,
+	}
+	allocatedIPs := map[string][]string{
+		"default/lb": {"192.168.2.1"},
+	}
+
+	headless := newService("headless", corev1.ServiceTypeClusterIP, "")
+	headless.Spec.ClusterIP = corev1.ClusterIPNone
+	headless.Spec.ClusterIPs = nil
+	headless.Spec.ExternalIPs = nil
+
+	tests := []struct {
+		name          string
+		service       *corev1.Service
+		advertisement *networkingv1.BGPServiceAdvertisement
+		expected      []string
+	}{
+		{
+			name:          "cluster ips and external ips",
+			service:       newService("cluster", corev1.ServiceTypeClusterIP, corev1.ServiceExternalTrafficPolicyTypeCluster),
+			advertisement: allAdvertisement,
+			expected:      []string{"172.16.0.1", "fd00::1", "192.168.0.1"},
+		},
+		{
+			name:          "load balancer ips of ingress",
+			service:       newService("lb", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeCluster),
+			advertisement: allAdvertisement,
+			expected:      []string{"172.16.0.1", "fd00::1", "192.168.0.1", "192.168.1.1"},
+		},
+		{
+			name:          "allocated load balancer ips without advertisement",
+			service:       newService("lb", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeCluster),
+			advertisement: &networkingv1.BGPServiceAdvertisement{},
+			expected:      []string{"192.168.2.1"},
+		},
+		{
+			name:          "local policy with local ready endpoints",
+			service:       newService("local-ready", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeLocal),
+			advertisement: allAdvertisement,
+			expected:      []string{"172.16.0.1", "fd00::1", "192.168.0.1", "192.168.1.1"},
+		},
+		{
+			name:          "local policy without local ready endpoints",
+			service:       newService("local-remote", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeLocal),
+			advertisement: allAdvertisement,
+			expected:      []string{"172.16.0.1", "fd00::1"},
+		},
+		{
+			name:          "local policy without endpoints",
+			service:       newService("local-none", corev1.ServiceTypeLoadBalancer, corev1.ServiceExternalTrafficPolicyTypeLocal),
+			advertisement: allAdvertisement,
+			expected:      []string{"172.16.0.1", "fd00::1"},
+		},
+		{
+			name:          "headless service",
+			service:       headless,
+			advertisement: allAdvertisement,
+			expected:      nil,
+		},
+	}
+
+	r := &serviceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			newTestEndpoints("local-ready", []string{"node1"}, nil),
+			newTestEndpoints("local-remote", []string{"node2"}, []string{"node1"}),
+		).Build(),
+		ctrlHubRef: &CtrlHub{
+			config: &daemonconfig.Configuration{NodeName: "node1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serviceIPs, err := r.getServiceIPsToAdvertise(context.Background(), test.service, test.advertisement, allocatedIPs)
+			assert.NoError(t, err)
+
+			var expected []net.IP
+			for _, ip := range test.expected {
+				expected = append(expected, net.ParseIP(ip))
+			}
+			assert.Equal(t, expected, serviceIPs)
+		})
+	}
+}
+
+func TestGetServiceCommunities(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []uint32
+		warned      bool
+	}{
+		{
+			name:        "no annotation",
+			annotations: nil,
+			expected:    nil,
+		},
+		{
+			name:        "valid communities",
+			annotations: map[string]string{constants.AnnotationBGPCommunities: "65000:100,65000:200"},
+			expected:    []uint32{65000<<16 | 100, 65000<<16 | 200},
+		},
+		{
+			name:        "invalid communities",
+			annotations: map[string]string{constants.AnnotationBGPCommunities: "65000:abc"},
+			expected:    nil,
+			warned:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &serviceReconciler{
+				ctrlHubRef: &CtrlHub{
+					config:   &daemonconfig.Configuration{NodeName: "node1"},
+					recorder: recorder,
+				},
+			}
+
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "svc",
+					Annotations: test.annotations,
+				},
+			}
+
+			assert.Equal(t, test.expected, r.getServiceCommunities(context.Background(), service))
+			if test.warned {
+				assert.Len(t, recorder.Events, 1)
+				assert.Contains(t, <-recorder.Events, ReasonInvalidBGPCommunities)
+			} else {
+				assert.Len(t, recorder.Events, 0)
+			}
+		})
+	}
+}
@@ -20,18 +20,26 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/bgp"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
 	"github.com/alibaba/hybridnet/pkg/feature"
 
+	"github.com/vishvananda/netlink"
+
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -54,6 +62,8 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 
 	r.ctrlHubRef.bgpManager.ResetPeerAndSubnetInfos()
 
+	var bgpNetwork *networkingv1.Network
+	var directRoutingSubnets []string
 	for _, subnet := range subnetList.Items {
 		network := &networkingv1.Network{}
 		if err := r.Get(ctx, types.NamespacedName{Name: subnet.Spec.Network}, network); err != nil {
@@ -77,24 +87,34 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		}
 
 		var forwardNodeIfName string
-		var autoNatOutgoing, isOverlay bool
+		var autoNatOutgoing, isOverlay, importBGPRoutes bool
 		networkMode := networkingv1.GetNetworkMode(network)
 
 		switch networkMode {
 		case networkingv1.NetworkModeVlan:
 			if isUnderlayOnHost {
-				forwardNodeIfName, err = containernetwork.EnsureVlanIf(r.ctrlHubRef.config.NodeVlanIfName, netID)
+				uplinkIfName, err := r.ctrlHubRef.GetVlanUplinkIfName(network)
+				if err != nil {
+					return reconcile.Result{Requeue: true}, fmt.Errorf("failed to get vlan uplink interface: %v", err)
+				}
+
+				forwardNodeIfName, err = containernetwork.EnsureVlanIf(uplinkIfName, networkingv1.GetOuterVlanID(network), netID)
 				if err != nil {
 					return reconcile.Result{Requeue: true}, fmt.Errorf("failed to ensure vlan forward node interface: %v", err)
 				}
 			}
-		case networkingv1.NetworkModeVxlan:
-			forwardNodeIfName, err = containernetwork.GenerateVxlanNetIfName(r.ctrlHubRef.config.NodeVxlanIfName, netID)
+		case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
+			forwardNodeIfName, err = containernetwork.GenerateOverlayNetIfName(r.ctrlHubRef.config.NodeVxlanIfName,
+				networkingv1.GetNetworkMode(network), netID)
 			if err != nil {
-				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate vxlan forward node if name: %v", err)
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to generate overlay forward node if name: %v", err)
 			}
 			isOverlay = true
 			autoNatOutgoing = networkingv1.IsSubnetAutoNatOutgoing(&subnet.Spec)
+
+			if networkingv1.IsDirectRoutingEnabled(network) {
+				directRoutingSubnets = append(directRoutingSubnets, subnet.Name)
+			}
 		case networkingv1.NetworkModeBGP:
 			if isUnderlayOnHost {
 				forwardNodeIfName = r.ctrlHubRef.config.NodeBGPIfName
@@ -105,18 +125,53 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 						fmt.Errorf("try start bgp manager for network %v failed: %v", network.Name, err)
 				}
 
+				if err = r.ctrlHubRef.ensureServiceController(ctx, network); err != nil {
+					return reconcile.Result{Requeue: true}, err
+				}
+
 				if len(network.Spec.Config.BGPPeers) != 1 {
 					return reconcile.Result{Requeue: true},
 						fmt.Errorf("no bgp peer or multiple bgp peers are not supported for network %v", network.Name)
 				}
 
 				for _, peer := range network.Spec.Config.BGPPeers {
-					r.ctrlHubRef.bgpManager.RecordPeer(peer.Address, peer.Password, int(peer.ASN), peer.GracefulRestartSeconds)
+					// unnumbered peer is reached through the bgp interface of node, whose name might
+					// differ between nodes
+					var peerInterface string
+					if networkingv1.IsUnnumberedBGPPeer(&peer) {
+						peerInterface = r.ctrlHubRef.config.NodeBGPIfName
+					}
+
+					r.ctrlHubRef.bgpManager.RecordPeer(peer.Address, peerInterface, peer.Password, int(peer.ASN),
+						peer.GracefulRestartSeconds, peer.BFD)
 				}
-				r.ctrlHubRef.bgpManager.RecordSubnet(subnetCidr)
 
-				peerAddr := net.ParseIP(network.Spec.Config.BGPPeers[0].Address)
-				if peerAddr == nil {
+				pathAttributes, err := bgp.GeneratePathAttributes(networkingv1.GetBGPPathConfig(network, &subnet))
+				if err != nil {
+					return reconcile.Result{Requeue: true},
+						fmt.Errorf("failed to generate bgp path attributes for subnet %v: %v", subnet.Name, err)
+				}
+				r.ctrlHubRef.bgpManager.RecordSubnet(subnetCidr, pathAttributes)
+
+				for _, prefix := range network.Spec.Config.BGPImportPrefixes {
+					_, importCidr, err := net.ParseCIDR(prefix)
+					if err != nil {
+						return reconcile.Result{Requeue: true},
+							fmt.Errorf("get invalid bgp import prefix %v for network %v", prefix, network.Name)
+					}
+					r.ctrlHubRef.bgpManager.RecordImportPrefix(importCidr)
+				}
+				importBGPRoutes = len(network.Spec.Config.BGPImportPrefixes) != 0
+
+				var peerAddr net.IP
+				if networkingv1.IsUnnumberedBGPPeer(&network.Spec.Config.BGPPeers[0]) {
+					if subnet.Spec.Range.Version == networkingv1.IPv4 {
+						peerAddr = bgp.UnnumberedIPv4NextHop
+					} else if peerAddr, err = bgp.GetUnnumberedPeerAddress(r.ctrlHubRef.config.NodeBGPIfName); err != nil {
+						return reconcile.Result{Requeue: true},
+							fmt.Errorf("failed to get address of unnumbered bgp peer for network %v: %v", network.Name, err)
+					}
+				} else if peerAddr = net.ParseIP(network.Spec.Config.BGPPeers[0].Address); peerAddr == nil {
 					return reconcile.Result{Requeue: true},
 						fmt.Errorf("get invalid bgp peer address %v for network %v",
 							network.Spec.Config.BGPPeers[0].Address, network.Name)
@@ -124,15 +179,31 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 
 				// use peer ip as gateway
 				gatewayIP = peerAddr
+				bgpNetwork = network
 			}
 		default:
 			return reconcile.Result{Requeue: true}, fmt.Errorf("invalic network mode %v for %v", networkMode, network.Name)
 		}
 
+		if isUnderlayOnHost || isOverlay {
+			if err = r.checkSubnetMTU(ctx, &subnet, network); err != nil {
+				return reconcile.Result{Requeue: true}, fmt.Errorf("failed to check mtu of subnet %v: %v", subnet.Name, err)
+			}
+		}
+
+		extraRoutes, err := parseSubnetRoutes(networkingv1.GetSubnetRoutes(&subnet.Spec))
+		if err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to parse subnet %v routes: %v", subnet.Name, err)
+		}
+
 		// create policy route
 		routeManager := r.ctrlHubRef.getRouterManager(subnet.Spec.Range.Version)
 		routeManager.AddSubnetInfo(subnetCidr, gatewayIP, startIP, endIP, excludeIPs,
-			forwardNodeIfName, autoNatOutgoing, isOverlay, isUnderlayOnHost, networkMode)
+			forwardNodeIfName, autoNatOutgoing, isOverlay, isUnderlayOnHost, networkMode, extraRoutes)
+
+		if importBGPRoutes {
+			routeManager.EnableBGPRouteImport(subnetCidr)
+		}
 	}
 
 	if feature.MultiClusterEnabled() {
@@ -162,6 +233,12 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		}
 	}
 
+	if len(directRoutingSubnets) != 0 {
+		if err := r.addDirectRouteInfos(ctx, directRoutingSubnets); err != nil {
+			return reconcile.Result{Requeue: true}, fmt.Errorf("failed to add direct route infos: %v", err)
+		}
+	}
+
 	if err := r.ctrlHubRef.routeV4Manager.SyncRoutes(); err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync ipv4 routes: %v", err)
 	}
@@ -177,11 +254,183 @@ func (r *subnetReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		}
 	}
 
-	if err := r.ctrlHubRef.bgpManager.SyncPeerAndSubnetInfos(); err != nil {
+	peerUpdates, err := r.ctrlHubRef.bgpManager.SyncPeerAndSubnetInfos()
+	if err != nil {
 		return reconcile.Result{Requeue: true}, fmt.Errorf("failed to sync bgp peers and subnet paths: %v", err)
 	}
 
 	r.ctrlHubRef.iptablesSyncTrigger()
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: r.recordPeerUpdates(bgpNetwork, peerUpdates)}, nil
+}
+
+// recordPeerUpdates reports events on bgp network for the configuration changes of peers, and returns
+// the min duration to retry if any change is postponed.
+func (r *subnetReconciler) recordPeerUpdates(network *networkingv1.Network, peerUpdates []*bgp.PeerUpdate) time.Duration {
+	var retryAfter time.Duration
+	for _, peerUpdate := range peerUpdates {
+		if peerUpdate.RetryAfter > 0 {
+			if retryAfter == 0 || peerUpdate.RetryAfter < retryAfter {
+				retryAfter = peerUpdate.RetryAfter
+			}
+		}
+
+		if network == nil {
+			continue
+		}
+
+		changedFields := strings.Join(peerUpdate.ChangedFields, ",")
+		switch {
+		case peerUpdate.RetryAfter > 0:
+			r.ctrlHubRef.recorder.Eventf(network, corev1.EventTypeWarning, ReasonBGPPeerPostponed,
+				"changes of %s for bgp peer %s on node %s need a session reset, which is postponed for %v by rate limit",
+				changedFields, peerUpdate.Address, r.ctrlHubRef.config.NodeName, peerUpdate.RetryAfter.Round(time.Second))
+		case peerUpdate.SessionReset:
+			r.ctrlHubRef.recorder.Eventf(network, corev1.EventTypeNormal, ReasonBGPPeerUpdated,
+				"changes of %s for bgp peer %s on node %s are applied, bgp session is reset",
+				changedFields, peerUpdate.Address, r.ctrlHubRef.config.NodeName)
+		default:
+			r.ctrlHubRef.recorder.Eventf(network, corev1.EventTypeNormal, ReasonBGPPeerUpdated,
+				"changes of %s for bgp peer %s on node %s are applied through soft reset",
+				changedFields, peerUpdate.Address, r.ctrlHubRef.config.NodeName)
+		}
+	}
+
+	return retryAfter
+}
+
+// checkSubnetMTU reports a warning event on this node if the specified mtu of subnet is larger than
+// the max mtu that node interface can carry, pods will use the max mtu instead in that case.
+func (r *subnetReconciler) checkSubnetMTU(ctx context.Context, subnet *networkingv1.Subnet, network *networkingv1.Network) error {
+	specifiedMTU := networkingv1.GetMTU(network, subnet)
+	if specifiedMTU == 0 {
+		return nil
+	}
+
+	var nodeIfName string
+	networkMode := networkingv1.GetNetworkMode(network)
+	switch networkMode {
+	case networkingv1.NetworkModeVlan:
+		var err error
+		if nodeIfName, err = r.ctrlHubRef.GetVlanUplinkIfName(network); err != nil {
+			return err
+		}
+	case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
+		nodeIfName = r.ctrlHubRef.config.NodeVxlanIfName
+	case networkingv1.NetworkModeBGP:
+		nodeIfName = r.ctrlHubRef.config.NodeBGPIfName
+	}
+
+	maxMTU, err := containernetwork.GetMaxMTU(nodeIfName, networkMode, r.ctrlHubRef.config.VtepAddressFamily == netlink.FAMILY_V6)
+	if err != nil {
+		return err
+	}
+
+	if networkingv1.IsWireGuardEnabled(network) {
+		maxMTU -= networkingv1.WireGuardMTUOverhead
+	}
+
+	if specifiedMTU <= maxMTU {
+		delete(r.ctrlHubRef.subnetMTUMismatches, subnet.Name)
+		return nil
+	}
+
+	// only warn once until the mismatch changes, subnets are reconciled frequently
+	mismatch := fmt.Sprintf("%d/%d/%s", specifiedMTU, maxMTU, nodeIfName)
+	if r.ctrlHubRef.subnetMTUMismatches[subnet.Name] == mismatch {
+		return nil
+	}
+
+	node := &corev1.Node{}
+	if err = r.Get(ctx, types.NamespacedName{Name: r.ctrlHubRef.config.NodeName}, node); err != nil {
+		return fmt.Errorf("failed to get node %v: %v", r.ctrlHubRef.config.NodeName, err)
+	}
+
+	r.ctrlHubRef.recorder.Eventf(node, corev1.EventTypeWarning, ReasonMTUMismatch,
+		"mtu %d of subnet %s is larger than %d, which is the mtu of interface %s minus %s encapsulation overhead",
+		specifiedMTU, subnet.Name, maxMTU, nodeIfName, networkMode)
+	r.ctrlHubRef.subnetMTUMismatches[subnet.Name] = mismatch
+	return nil
+}
+
+// addDirectRouteInfos records direct routes for overlay pods in subnets on the nodes whose vtep ips are in the same
+// subnet with addresses of local vtep interface, pods on other nodes are still reached through overlay interface.
+func (r *subnetReconciler) addDirectRouteInfos(ctx context.Context, subnetNames []string) error {
+	vtepIfName := r.ctrlHubRef.config.NodeVxlanIfName
+	vtepAddrList, err := listVtepAddress(vtepIfName)
+	if err != nil {
+		return err
+	}
+
+	directRoutes, err := r.listDirectRoutes(ctx, subnetNames, vtepAddrList)
+	if err != nil {
+		return err
+	}
+
+	for _, directRoute := range directRoutes {
+		r.ctrlHubRef.getRouterManager(directRoute.version).
+			AddDirectRouteInfo(directRoute.podIP, directRoute.nodeIP, vtepIfName)
+	}
+
+	return nil
+}
+
+// directRoute is an overlay pod ip which can be reached directly through the vtep ip of its node.
+type directRoute struct {
+	version networkingv1.IPVersion
+	podIP   net.IP
+	nodeIP  net.IP
+}
+
+// listDirectRoutes lists the direct routes of overlay pods in subnets on the other nodes whose vtep ips are in
+// the same subnet with the local vtep addresses.
+func (r *subnetReconciler) listDirectRoutes(ctx context.Context, subnetNames []string,
+	vtepAddrList []netlink.Addr) ([]directRoute, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list node: %v", err)
+	}
+
+	// node ips which can be reached directly, indexed by node name
+	directNodeIPMap := map[string][]net.IP{}
+	for i := range nodeList.Items {
+		if nodeList.Items[i].Name == r.ctrlHubRef.config.NodeName {
+			continue
+		}
+
+		if nodeIPs := directNodeIPs(&nodeList.Items[i], vtepAddrList); len(nodeIPs) != 0 {
+			directNodeIPMap[nodeList.Items[i].Name] = nodeIPs
+		}
+	}
+
+	var directRoutes []directRoute
+	for _, subnetName := range subnetNames {
+		ipInstanceList := &networkingv1.IPInstanceList{}
+		if err := r.List(ctx, ipInstanceList, client.MatchingFields{IPInstanceSubnetIndex: subnetName}); err != nil {
+			return nil, fmt.Errorf("failed to list ip instance of subnet %v: %v", subnetName, err)
+		}
+
+		for _, ipInstance := range ipInstanceList.Items {
+			nodeIPs := directNodeIPMap[ipInstance.Labels[constants.LabelNode]]
+			if len(nodeIPs) == 0 {
+				continue
+			}
+
+			podIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse ip %v of ip instance %v: %v", ipInstance.Spec.Address.IP,
+					ipInstance.Name, err)
+			}
+
+			if nodeIP := daemonutils.PickIPOfSameFamily(podIP, nodeIPs...); nodeIP != nil {
+				directRoutes = append(directRoutes, directRoute{
+					version: ipInstance.Spec.Address.Version,
+					podIP:   podIP,
+					nodeIP:  nodeIP,
+				})
+			}
+		}
+	}
+
+	return directRoutes, nil
 }
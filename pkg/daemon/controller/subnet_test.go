@@ -0,0 +1,187 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+)
+
+func newTestVtepNode(name, vtepIP, secondaryVtepIP string) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{},
+		},
+	}
+	if len(vtepIP) != 0 {
+		node.Annotations[constants.AnnotationNodeVtepIP] = vtepIP
+	}
+	if len(secondaryVtepIP) != 0 {
+		node.Annotations[constants.AnnotationNodeSecondaryVtepIP] = secondaryVtepIP
+	}
+	return node
+}
+
+func newTestSubnetIPInstance(name, nodeName, cidr string, version networkingv1.IPVersion) *networkingv1.IPInstance {
+	return &networkingv1.IPInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels: map[string]string{
+				constants.LabelNode:   nodeName,
+				constants.LabelSubnet: "subnet1",
+			},
+		},
+		Spec: networkingv1.IPInstanceSpec{
+			Subnet: "subnet1",
+			Address: networkingv1.Address{
+				Version: version,
+				IP:      cidr,
+			},
+		},
+	}
+}
+
+func TestListDirectRoutes(t *testing.T) {
+	vtepAddrList := []netlink.Addr{
+		{IPNet: &net.IPNet{IP: net.ParseIP("192.168.0.1"), Mask: net.CIDRMask(24, 32)}},
+		{IPNet: &net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)}},
+	}
+
+	tests := []struct {
+		name        string
+		objects     []client.Object
+		expected    []directRoute
+		expectError bool
+	}{
+		{
+			name: "pod on node in the same subnet",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.0.2", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: []directRoute{
+				{version: networkingv1.IPv4, podIP: net.ParseIP("10.0.0.10"), nodeIP: net.ParseIP("192.168.0.2")},
+			},
+		},
+		{
+			name: "pod on node in other subnet",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.1.2", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: nil,
+		},
+		{
+			name: "pod on node without vtep ip",
+			objects: []client.Object{
+				newTestVtepNode("node2", "", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: nil,
+		},
+		{
+			name: "pod on node not found",
+			objects: []client.Object{
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: nil,
+		},
+		{
+			name: "pod on this node",
+			objects: []client.Object{
+				newTestVtepNode("node1", "192.168.0.3", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node1", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: nil,
+		},
+		{
+			name: "pod on node with the same ip as local vtep",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.0.1", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+			},
+			expected: nil,
+		},
+		{
+			name: "dual stack pod on node with secondary vtep ip",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.0.2", "fd00::2"),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10/24", networkingv1.IPv4),
+				newTestSubnetIPInstance("fd01-0-0-0-0-0-0-10", "node2", "fd01::10/64", networkingv1.IPv6),
+			},
+			expected: []directRoute{
+				{version: networkingv1.IPv4, podIP: net.ParseIP("10.0.0.10"), nodeIP: net.ParseIP("192.168.0.2")},
+				{version: networkingv1.IPv6, podIP: net.ParseIP("fd01::10"), nodeIP: net.ParseIP("fd00::2")},
+			},
+		},
+		{
+			name: "ipv6 pod on node reachable by ipv4 only",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.0.2", "fd02::2"),
+				newTestSubnetIPInstance("fd01-0-0-0-0-0-0-10", "node2", "fd01::10/64", networkingv1.IPv6),
+			},
+			expected: nil,
+		},
+		{
+			name: "invalid pod ip",
+			objects: []client.Object{
+				newTestVtepNode("node2", "192.168.0.2", ""),
+				newTestSubnetIPInstance("10-0-0-10", "node2", "10.0.0.10", networkingv1.IPv4),
+			},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NoError(t, corev1.AddToScheme(scheme))
+			assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+			// ip instances are all in subnet1, because fake client ignores the field selector of subnet index
+			r := &subnetReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).Build(),
+				ctrlHubRef: &CtrlHub{
+					config: &daemonconfig.Configuration{NodeName: "node1"},
+				},
+			}
+
+			directRoutes, err := r.listDirectRoutes(context.Background(), []string{"subnet1"}, vtepAddrList)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, directRoutes)
+		})
+	}
+}
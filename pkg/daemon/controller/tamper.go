@@ -0,0 +1,306 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/route"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const (
+	RouteUpdateChanSize = 200
+
+	// RulesTamperCheckInterval is much shorter than iptables-check-duration, so that tampered host
+	// rules are repaired in time, while rules are only synced periodically in case of missing events.
+	RulesTamperCheckInterval = time.Second
+
+	ReasonHostNetworkRepaired = "HostNetworkRepaired"
+
+	// struct fib_rule_hdr
+	fibRuleHeaderSize = 12
+)
+
+var native = nl.NativeEndian()
+
+// handleHostNetworkTamperEvent watches deletions of routes, rules and proxy neighs synced by hybridnet, and
+// the routes imported from bgp peers. Once they are deleted by others, e.g., someone flushes route tables,
+// the next sync will not be skipped and the corresponding controller will be triggered to repair them.
+func (c *CtrlHub) handleHostNetworkTamperEvent() error {
+	hostNetNs, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get root netns: %v", err)
+	}
+
+	go func() {
+		for {
+			routeCh := make(chan netlink.RouteUpdate, RouteUpdateChanSize)
+			exitCh := make(chan struct{})
+
+			errorCallback := func(err error) {
+				c.logger.Error(err, "subscribe netlink route event exit with error")
+				close(exitCh)
+			}
+
+			if err := netlink.RouteSubscribeWithOptions(routeCh, nil, netlink.RouteSubscribeOptions{
+				Namespace:     &hostNetNs,
+				ErrorCallback: errorCallback,
+			}); err != nil {
+				c.logger.Error(err, "failed to subscribe route update event")
+				time.Sleep(NetlinkSubscribeRetryInterval)
+				continue
+			}
+
+		routeLoop:
+			for {
+				select {
+				case update := <-routeCh:
+					if update.Type != unix.RTM_DELROUTE {
+						continue
+					}
+
+					for family, routeManager := range map[int]*route.Manager{
+						netlink.FAMILY_V4: c.routeV4Manager,
+						netlink.FAMILY_V6: c.routeV6Manager,
+					} {
+						if update.Dst != nil && ipFamily(update.Dst.IP) != family {
+							continue
+						}
+
+						if routeManager.IsManagedRoute(&update.Route) {
+							// only record once for a batch of deletions before repairing
+							if routeManager.MarkTampered() {
+								c.recordHostNetworkRepair(metrics.RepairObjectRoute, family,
+									fmt.Sprintf("route %v is deleted by others", update.Route.String()))
+							}
+							c.subnetControllerTriggerSource.Trigger()
+						}
+					}
+
+					// Imported bgp routes are re-installed while syncing bgp peers of subnet controller.
+					if c.bgpManager.IsImportedRoute(&update.Route) {
+						family := netlink.FAMILY_V4
+						if update.Dst != nil {
+							family = ipFamily(update.Dst.IP)
+						} else if update.Gw != nil {
+							family = ipFamily(update.Gw)
+						}
+
+						if c.bgpManager.MarkImportedRoutesTampered() {
+							c.recordHostNetworkRepair(metrics.RepairObjectRoute, family,
+								fmt.Sprintf("imported bgp route %v is deleted by others", update.Route.String()))
+						}
+						c.subnetControllerTriggerSource.Trigger()
+					}
+				case <-exitCh:
+					break routeLoop
+				}
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			// Rule events are not supported by netlink library, subscribe them with raw socket.
+			socket, err := nl.SubscribeAt(hostNetNs, netns.None(), unix.NETLINK_ROUTE,
+				unix.RTNLGRP_IPV4_RULE, unix.RTNLGRP_IPV6_RULE)
+			if err != nil {
+				c.logger.Error(err, "failed to subscribe rule update event")
+				time.Sleep(NetlinkSubscribeRetryInterval)
+				continue
+			}
+
+			for {
+				msgs, _, err := socket.Receive()
+				if err != nil {
+					c.logger.Error(err, "subscribe netlink rule event exit with error")
+					break
+				}
+
+				for _, msg := range msgs {
+					if msg.Header.Type != unix.RTM_DELRULE {
+						continue
+					}
+
+					family, table, err := parseRuleMessage(msg.Data)
+					if err != nil {
+						c.logger.Error(err, "failed to parse rule message")
+						continue
+					}
+
+					routeManager := c.routeV4Manager
+					switch family {
+					case netlink.FAMILY_V4:
+					case netlink.FAMILY_V6:
+						routeManager = c.routeV6Manager
+					default:
+						continue
+					}
+
+					if routeManager.IsManagedRule(table) {
+						if routeManager.MarkTampered() {
+							c.recordHostNetworkRepair(metrics.RepairObjectRule, family,
+								fmt.Sprintf("rule of table %v is deleted by others", table))
+						}
+						c.subnetControllerTriggerSource.Trigger()
+					}
+				}
+			}
+
+			socket.Close()
+		}
+	}()
+
+	go func() {
+		for {
+			neighCh := make(chan netlink.NeighUpdate, NeighUpdateChanSize)
+			exitCh := make(chan struct{})
+
+			errorCallback := func(err error) {
+				c.logger.Error(err, "subscribe netlink proxy neigh event exit with error")
+				close(exitCh)
+			}
+
+			if err := netlink.NeighSubscribeWithOptions(neighCh, nil, netlink.NeighSubscribeOptions{
+				Namespace:     &hostNetNs,
+				ErrorCallback: errorCallback,
+			}); err != nil {
+				c.logger.Error(err, "failed to subscribe proxy neigh update event")
+				time.Sleep(NetlinkSubscribeRetryInterval)
+				continue
+			}
+
+		neighLoop:
+			for {
+				select {
+				case update := <-neighCh:
+					if update.Type != unix.RTM_DELNEIGH || update.IP == nil {
+						continue
+					}
+
+					family := ipFamily(update.IP)
+					neighManager := c.neighV4Manager
+					if family == netlink.FAMILY_V6 {
+						neighManager = c.neighV6Manager
+					}
+
+					if neighManager.IsManagedNeigh(&update.Neigh) {
+						if neighManager.MarkTampered() {
+							c.recordHostNetworkRepair(metrics.RepairObjectNeigh, family,
+								fmt.Sprintf("proxy neigh %v is deleted by others", update.IP.String()))
+						}
+						c.ipInstanceControllerTriggerSource.Trigger()
+					}
+				case <-exitCh:
+					break neighLoop
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// checkRulesTampered checks if host rules synced are modified by others every RulesTamperCheckInterval,
+// the rules will be synced again if they are tampered.
+func (c *CtrlHub) checkRulesTampered() {
+	managers := map[int]interface {
+		RulesTampered() (bool, error)
+	}{
+		netlink.FAMILY_V4: c.iptablesV4Manager,
+	}
+
+	if globalDisabled, err := containernetwork.CheckIPv6GlobalDisabled(); err != nil {
+		c.logger.Error(err, "failed to check ipv6 global disabled")
+	} else if !globalDisabled {
+		managers[netlink.FAMILY_V6] = c.iptablesV6Manager
+	}
+
+	for family, manager := range managers {
+		tampered, err := manager.RulesTampered()
+		if err != nil {
+			c.logger.Error(err, "failed to check if host rules are tampered", "family", family)
+			continue
+		}
+
+		if tampered {
+			c.recordHostNetworkRepair(metrics.RepairObjectIPtables, family, "host rules are modified by others")
+			c.iptablesSyncTrigger()
+		}
+	}
+}
+
+// parseRuleMessage parses family and table of a rule message, which starts with a fib rule header.
+func parseRuleMessage(data []byte) (int, int, error) {
+	if len(data) < fibRuleHeaderSize {
+		return 0, 0, fmt.Errorf("rule message is too short")
+	}
+
+	family, table := int(data[0]), int(data[4])
+
+	attrs, err := nl.ParseRouteAttr(data[fibRuleHeaderSize:])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, attr := range attrs {
+		// table id larger than 255 is only carried by attribute
+		if attr.Attr.Type == nl.FRA_TABLE && len(attr.Value) >= 4 {
+			table = int(native.Uint32(attr.Value[0:4]))
+		}
+	}
+
+	return family, table, nil
+}
+
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// recordHostNetworkRepair records metric and event on this node for repairing host network configurations.
+func (c *CtrlHub) recordHostNetworkRepair(object string, family int, message string) {
+	ipFamily := metrics.IPv4
+	if family == netlink.FAMILY_V6 {
+		ipFamily = metrics.IPv6
+	}
+
+	metrics.HostNetworkRepairCounter.WithLabelValues(object, ipFamily).Inc()
+
+	c.logger.Info("repair host network configuration", "object", object, "family", ipFamily, "message", message)
+
+	node := &corev1.Node{}
+	if err := c.mgr.GetClient().Get(context.TODO(), types.NamespacedName{Name: c.config.NodeName}, node); err != nil {
+		c.logger.Error(err, "failed to get node for recording event", "node", c.config.NodeName)
+		return
+	}
+	c.recorder.Eventf(node, corev1.EventTypeWarning, ReasonHostNetworkRepaired, "repair %v: %v", object, message)
+}
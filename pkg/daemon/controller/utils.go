@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
@@ -40,6 +41,8 @@ import (
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/bgp"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 	"github.com/alibaba/hybridnet/pkg/daemon/iptables"
 	"github.com/alibaba/hybridnet/pkg/daemon/neigh"
 	"github.com/alibaba/hybridnet/pkg/daemon/route"
@@ -96,6 +99,91 @@ func (c *CtrlHub) getRouterManager(ipVersion networkingv1.IPVersion) *route.Mana
 	return c.routeV4Manager
 }
 
+// isRemoteDirectRoutingIPInstance checks if an ip instance belongs to an overlay network with direct routing
+// enabled and is on other nodes which can be reached directly.
+func (c *CtrlHub) isRemoteDirectRoutingIPInstance(ipInstance *networkingv1.IPInstance) bool {
+	nodeName := ipInstance.Labels[constants.LabelNode]
+	if len(nodeName) == 0 || nodeName == c.config.NodeName {
+		return false
+	}
+
+	network := &networkingv1.Network{}
+	if err := c.mgr.GetClient().Get(context.TODO(), types.NamespacedName{Name: ipInstance.Spec.Network},
+		network); err != nil {
+		// network might have been deleted
+		return false
+	}
+
+	if !networkingv1.IsDirectRoutingEnabled(network) {
+		return false
+	}
+
+	node := &corev1.Node{}
+	if err := c.mgr.GetClient().Get(context.TODO(), types.NamespacedName{Name: nodeName}, node); err != nil {
+		// node might have been deleted, which triggers subnet controller itself
+		return false
+	}
+
+	vtepAddrList, err := listVtepAddress(c.config.NodeVxlanIfName)
+	if err != nil {
+		// let subnet controller report the error
+		return true
+	}
+
+	return len(directNodeIPs(node, vtepAddrList)) != 0
+}
+
+// listVtepAddress lists global unicast addresses of vtep interface.
+func listVtepAddress(vtepIfName string) ([]netlink.Addr, error) {
+	vtepLink, err := netlink.LinkByName(vtepIfName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vtep interface %v: %v", vtepIfName, err)
+	}
+
+	vtepAddrList, err := containernetwork.ListAllAddress(vtepLink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list address for vtep interface %v: %v", vtepIfName, err)
+	}
+	return vtepAddrList, nil
+}
+
+// directNodeIPs returns the vtep ips of node which are in the same subnet with local vtep addresses.
+func directNodeIPs(node *corev1.Node, vtepAddrList []netlink.Addr) []net.IP {
+	var nodeIPs []net.IP
+	for _, ipString := range []string{node.Annotations[constants.AnnotationNodeVtepIP],
+		node.Annotations[constants.AnnotationNodeSecondaryVtepIP]} {
+		nodeIP := net.ParseIP(ipString)
+		if nodeIP == nil {
+			continue
+		}
+
+		for _, addr := range vtepAddrList {
+			if addr.IPNet.Contains(nodeIP) && !addr.IP.Equal(nodeIP) {
+				nodeIPs = append(nodeIPs, nodeIP)
+				break
+			}
+		}
+	}
+	return nodeIPs
+}
+
+// getBGPPathAttributes returns the path attributes of ips in the subnet of bgp network, and whether
+// host paths of them should not be advertised because only the aggregate subnet path is expected.
+func (c *CtrlHub) getBGPPathAttributes(ctx context.Context, network *networkingv1.Network,
+	subnetName string) (*bgp.PathAttributes, bool, error) {
+	subnet := &networkingv1.Subnet{}
+	if err := c.mgr.GetClient().Get(ctx, types.NamespacedName{Name: subnetName}, subnet); err != nil {
+		return nil, false, fmt.Errorf("failed to get subnet %v: %v", subnetName, err)
+	}
+
+	config := networkingv1.GetBGPPathConfig(network, subnet)
+	attributes, err := bgp.GeneratePathAttributes(config)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate bgp path attributes for subnet %v: %v", subnetName, err)
+	}
+	return attributes, networkingv1.IsBGPAggregateOnly(config), nil
+}
+
 func (c *CtrlHub) getNeighManager(ipVersion networkingv1.IPVersion) *neigh.Manager {
 	if ipVersion == networkingv1.IPv6 {
 		return c.neighV6Manager
@@ -103,13 +191,47 @@ func (c *CtrlHub) getNeighManager(ipVersion networkingv1.IPVersion) *neigh.Manag
 	return c.neighV4Manager
 }
 
-func (c *CtrlHub) getIPtablesManager(ipVersion networkingv1.IPVersion) *iptables.Manager {
+func (c *CtrlHub) getIPtablesManager(ipVersion networkingv1.IPVersion) iptables.Interface {
 	if ipVersion == networkingv1.IPv6 {
 		return c.iptablesV6Manager
 	}
 	return c.iptablesV4Manager
 }
 
+// GetVlanUplinkIfName returns the uplink interface of vlan network on this node, from the cached
+// vlan uplink interfaces annotation of this node.
+func (c *CtrlHub) GetVlanUplinkIfName(network *networkingv1.Network) (string, error) {
+	nodeAnnotations := map[string]string{}
+	if annotation, _ := c.vlanUplinkInterfaces.Load().(string); len(annotation) != 0 {
+		nodeAnnotations[constants.AnnotationNodeVlanUplinkInterfaces] = annotation
+	}
+
+	return containernetwork.GetVlanUplinkIfName(c.config.NodeVlanIfName, network, nodeAnnotations)
+}
+
+// generateVlanForwardNodeIfName generates the name of forward node interface for vlan id in vlan network.
+func (c *CtrlHub) generateVlanForwardNodeIfName(ctx context.Context, network *networkingv1.Network, netID *int32) (string, error) {
+	uplinkIfName, err := c.GetVlanUplinkIfName(network)
+	if err != nil {
+		return "", err
+	}
+
+	return containernetwork.GenerateVlanNetIfName(uplinkIfName, networkingv1.GetOuterVlanID(network), netID)
+}
+
+// checkVlanUplinkUpdate checks if the vlan uplink interfaces of this node are changed, and refreshes
+// the cached annotation before the change is reconciled.
+func (c *CtrlHub) checkVlanUplinkUpdate(updateEvent event.UpdateEvent) bool {
+	if updateEvent.ObjectNew.GetName() != c.config.NodeName {
+		return false
+	}
+
+	newAnnotation := updateEvent.ObjectNew.GetAnnotations()[constants.AnnotationNodeVlanUplinkInterfaces]
+	c.vlanUplinkInterfaces.Store(newAnnotation)
+
+	return updateEvent.ObjectOld.GetAnnotations()[constants.AnnotationNodeVlanUplinkInterfaces] != newAnnotation
+}
+
 func (c *CtrlHub) getIPInstanceByAddress(address net.IP) (*networkingv1.IPInstance, error) {
 	ctx := context.Background()
 	ipInstanceList := &networkingv1.IPInstanceList{}
@@ -242,6 +364,29 @@ func parseSubnetSpecRangeMeta(addressRange *networkingv1.AddressRange) (cidr *ne
 	return
 }
 
+func parseSubnetRoutes(subnetRoutes []networkingv1.SubnetRoute) ([]*netlink.Route, error) {
+	var routes []*netlink.Route
+	for _, subnetRoute := range subnetRoutes {
+		dst, err := netlink.ParseIPNet(subnetRoute.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse route destination %v: %v", subnetRoute.Dst, err)
+		}
+
+		var gateway net.IP
+		if len(subnetRoute.Gateway) != 0 {
+			if gateway = net.ParseIP(subnetRoute.Gateway); gateway == nil {
+				return nil, fmt.Errorf("invalid route gateway %v", subnetRoute.Gateway)
+			}
+		}
+
+		routes = append(routes, &netlink.Route{
+			Dst: dst,
+			Gw:  gateway,
+		})
+	}
+	return routes, nil
+}
+
 func isIPListEqual(a, b []string) bool {
 	if len(a) == 0 && len(b) == 0 {
 		return true
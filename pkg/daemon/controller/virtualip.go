@@ -0,0 +1,195 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/arp"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/ndp"
+)
+
+// syncVirtualIPs configures virtual ips bound to pods of this node, records neigh proxies and bgp paths
+// for them, and cleans up virtual ips which are no longer bound here. It returns true if some virtual
+// ips failed to be configured and need to be retried.
+//
+// Host-side routes of virtual ips are removed along with the veth when a pod sandbox is recreated,
+// so virtual ips bound to this node are checked periodically, and re-applied only if the routes
+// are missing.
+func (r *ipInstanceReconciler) syncVirtualIPs(ctx context.Context, overlayExist bool,
+	overlayForwardNodeIfName string, logger logr.Logger) (bool, error) {
+	virtualIPList := &networkingv1.VirtualIPList{}
+	if err := r.List(ctx, virtualIPList); err != nil {
+		return false, fmt.Errorf("failed to list virtual ips: %v", err)
+	}
+
+	var (
+		needRetry        bool
+		tableNum         = r.ctrlHubRef.config.LocalDirectTableNum
+		currentBindings  = r.ctrlHubRef.virtualIPBindings
+		expectedBindings = map[string]string{}
+	)
+
+	for i := range virtualIPList.Items {
+		virtualIP := &virtualIPList.Items[i]
+		if !isVirtualIPBoundToThisNode(virtualIP, r.ctrlHubRef.config.NodeName) {
+			// virtual ips of other nodes may be left over by daemon restarting
+			if currentBindings == nil && len(virtualIP.Status.IP) != 0 {
+				if err := containernetwork.RemoveVirtualIP("", net.ParseIP(virtualIP.Status.IP), tableNum); err != nil {
+					return false, fmt.Errorf("failed to clean virtual ip %v: %v", virtualIP.Status.IP, err)
+				}
+			}
+			continue
+		}
+
+		ip := net.ParseIP(virtualIP.Status.IP)
+		if ip == nil {
+			return false, fmt.Errorf("invalid ip %v of virtual ip %v", virtualIP.Status.IP, virtualIP.Name)
+		}
+
+		// the old binding should be removed before the new one configured
+		hostNicName, _ := containernetwork.GenerateContainerVethPair(virtualIP.Namespace, virtualIP.Status.PodName)
+		if oldHostNicName, exist := currentBindings[virtualIP.Status.IP]; exist && oldHostNicName != hostNicName {
+			if err := containernetwork.RemoveVirtualIP(oldHostNicName, ip, tableNum); err != nil {
+				return false, fmt.Errorf("failed to remove virtual ip %v from %v: %v", virtualIP.Status.IP, oldHostNicName, err)
+			}
+			delete(currentBindings, virtualIP.Status.IP)
+		}
+
+		// walking through netns of pods is expensive, skip the virtual ip which is still routed to the pod
+		routed := false
+		if currentBindings[virtualIP.Status.IP] == hostNicName {
+			var err error
+			if routed, err = containernetwork.IsVirtualIPRouted(hostNicName, ip, tableNum); err != nil {
+				return false, fmt.Errorf("failed to check route of virtual ip %v: %v", virtualIP.Status.IP, err)
+			}
+		}
+
+		if !routed {
+			if err := containernetwork.ConfigureVirtualIP(hostNicName, ip, tableNum); err != nil {
+				// pod may be not ready yet, retry later
+				logger.Error(err, "failed to configure virtual ip", "virtual-ip", virtualIP.Name, "pod", virtualIP.Status.PodName)
+				if _, exist := currentBindings[virtualIP.Status.IP]; exist {
+					expectedBindings[virtualIP.Status.IP] = hostNicName
+				}
+				needRetry = true
+				continue
+			}
+		}
+		expectedBindings[virtualIP.Status.IP] = hostNicName
+
+		network := &networkingv1.Network{}
+		if err := r.Get(ctx, types.NamespacedName{Name: virtualIP.Status.Network}, network); err != nil {
+			return false, fmt.Errorf("failed to get network for virtual ip %v: %v", virtualIP.Name, err)
+		}
+
+		var forwardNodeIfName string
+		switch networkingv1.GetNetworkMode(network) {
+		case networkingv1.NetworkModeVlan:
+			subnet := &networkingv1.Subnet{}
+			if err := r.Get(ctx, types.NamespacedName{Name: virtualIP.Spec.Subnet}, subnet); err != nil {
+				return false, fmt.Errorf("failed to get subnet for virtual ip %v: %v", virtualIP.Name, err)
+			}
+
+			netID := subnet.Spec.NetID
+			if netID == nil {
+				netID = network.Spec.NetID
+			}
+
+			var err error
+			forwardNodeIfName, err = r.ctrlHubRef.generateVlanForwardNodeIfName(ctx, network, netID)
+			if err != nil {
+				return false, fmt.Errorf("failed to generate vlan forward node interface name: %v", err)
+			}
+		case networkingv1.NetworkModeBGP:
+			attributes, aggregateOnly, err := r.ctrlHubRef.getBGPPathAttributes(ctx, network, virtualIP.Spec.Subnet)
+			if err != nil {
+				return false, fmt.Errorf("failed to get bgp path attributes for virtual ip %v: %v", virtualIP.Name, err)
+			}
+
+			if !aggregateOnly {
+				r.ctrlHubRef.bgpManager.RecordIP(ip, attributes)
+			}
+		}
+
+		// create proxy neigh
+		ipVersion := networkingv1.IPv4
+		if ip.To4() == nil {
+			ipVersion = networkingv1.IPv6
+		}
+		neighManager := r.ctrlHubRef.getNeighManager(ipVersion)
+
+		if overlayExist {
+			neighManager.AddPodInfo(ip, overlayForwardNodeIfName)
+		}
+
+		if len(forwardNodeIfName) != 0 {
+			neighManager.AddPodInfo(ip, forwardNodeIfName)
+
+			// flush neigh caches of other hosts if the virtual ip is newly bound to this node
+			if _, exist := currentBindings[virtualIP.Status.IP]; !exist {
+				if err := sendGratuitous(forwardNodeIfName, ip); err != nil {
+					logger.Error(err, "failed to send gratuitous packets for virtual ip", "virtual-ip", virtualIP.Name)
+				}
+			}
+		}
+	}
+
+	for ipString, hostNicName := range currentBindings {
+		if _, exist := expectedBindings[ipString]; !exist {
+			if err := containernetwork.RemoveVirtualIP(hostNicName, net.ParseIP(ipString), tableNum); err != nil {
+				return false, fmt.Errorf("failed to remove virtual ip %v from %v: %v", ipString, hostNicName, err)
+			}
+		}
+	}
+
+	r.ctrlHubRef.virtualIPBindings = expectedBindings
+
+	return needRetry, nil
+}
+
+func sendGratuitous(ifName string, ip net.IP) error {
+	ifi, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to get interface %v: %v", ifName, err)
+	}
+
+	if ip.To4() != nil {
+		return arp.SendGratuitous(ifi, ip)
+	}
+	return ndp.SendGratuitous(ifi, ip)
+}
+
+// isVirtualIPBoundToThisNode checks whether virtual ip is allocated and bound to a pod of this node
+func isVirtualIPBoundToThisNode(virtualIP *networkingv1.VirtualIP, nodeName string) bool {
+	return virtualIP.DeletionTimestamp.IsZero() && isLabeledWithThisNode(virtualIP, nodeName) &&
+		len(virtualIP.Status.IP) != 0 && len(virtualIP.Status.PodName) != 0
+}
+
+// isLabeledWithThisNode checks whether virtual ip or load balancer ip is bound to this node
+func isLabeledWithThisNode(obj client.Object, nodeName string) bool {
+	return obj.GetLabels()[constants.LabelNode] == nodeName
+}
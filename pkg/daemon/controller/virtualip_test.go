@@ -0,0 +1,97 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func TestIsVirtualIPBoundToThisNode(t *testing.T) {
+	deletionTime := metav1.Now()
+
+	tests := []struct {
+		name     string
+		mutate   func(virtualIP *networkingv1.VirtualIP)
+		expected bool
+	}{
+		{
+			name:     "bound to pod of this node",
+			mutate:   func(virtualIP *networkingv1.VirtualIP) {},
+			expected: true,
+		},
+		{
+			name: "bound to pod of other node",
+			mutate: func(virtualIP *networkingv1.VirtualIP) {
+				virtualIP.Labels[constants.LabelNode] = "node2"
+			},
+			expected: false,
+		},
+		{
+			name: "not labeled with node",
+			mutate: func(virtualIP *networkingv1.VirtualIP) {
+				virtualIP.Labels = nil
+			},
+			expected: false,
+		},
+		{
+			name: "deleting",
+			mutate: func(virtualIP *networkingv1.VirtualIP) {
+				virtualIP.DeletionTimestamp = &deletionTime
+			},
+			expected: false,
+		},
+		{
+			name: "not allocated",
+			mutate: func(virtualIP *networkingv1.VirtualIP) {
+				virtualIP.Status.IP = ""
+			},
+			expected: false,
+		},
+		{
+			name: "unbound",
+			mutate: func(virtualIP *networkingv1.VirtualIP) {
+				virtualIP.Status.PodName = ""
+			},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			virtualIP := &networkingv1.VirtualIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "web",
+					Labels:    map[string]string{constants.LabelNode: "node1"},
+				},
+				Status: networkingv1.VirtualIPStatus{
+					IP:       "192.168.0.100",
+					PodName:  "web-1",
+					NodeName: "node1",
+				},
+			}
+			test.mutate(virtualIP)
+			assert.Equal(t, test.expected, isVirtualIPBoundToThisNode(virtualIP, "node1"))
+		})
+	}
+}
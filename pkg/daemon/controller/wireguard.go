@@ -0,0 +1,200 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+	"github.com/alibaba/hybridnet/pkg/daemon/route"
+	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
+	"github.com/alibaba/hybridnet/pkg/daemon/wireguard"
+	"github.com/alibaba/hybridnet/pkg/metrics"
+)
+
+const (
+	// WireGuardLinkName is the name of wireguard device which encrypts overlay traffic between nodes.
+	WireGuardLinkName = "hybridnet-wg"
+
+	WireGuardMetricsCollectInterval = 30 * time.Second
+)
+
+// wireGuardPeer is a remote vtep which overlay traffic to will be encrypted.
+type wireGuardPeer struct {
+	publicKey wireguard.Key
+	vtepIP    net.IP
+}
+
+func newWireGuardPeer(publicKey string, vtepIP net.IP) (wireGuardPeer, bool) {
+	if publicKey == "" {
+		return wireGuardPeer{}, false
+	}
+
+	key, err := wireguard.ParseKey(publicKey)
+	if err != nil {
+		return wireGuardPeer{}, false
+	}
+
+	return wireGuardPeer{publicKey: key, vtepIP: vtepIP}, true
+}
+
+// ensureWireGuardDevice ensures wireguard device whose mtu is based on the parent of overlay interface,
+// and returns its public key.
+func (c *CtrlHub) ensureWireGuardDevice(parentMTU int) (string, error) {
+	device, err := wireguard.EnsureDevice(WireGuardLinkName, c.config.WireGuardListenPort,
+		parentMTU-networkingv1.WireGuardMTUOverhead)
+	if err != nil {
+		return "", err
+	}
+
+	// Decrypted overlay packets come from wireguard device while routes to their sources are not.
+	sysctlPath := fmt.Sprintf(containernetwork.RpFilterSysctl, WireGuardLinkName)
+	if err := daemonutils.SetSysctl(sysctlPath, 0); err != nil {
+		return "", fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
+	}
+
+	return device.PublicKey.String(), nil
+}
+
+// syncWireGuardPeers configures wireguard peers for remote vteps, and routes the overlay traffic
+// (packets to overlay udp port) to them through wireguard device.
+func (c *CtrlHub) syncWireGuardPeers(vtepIP net.IP, overlayPort int, peers []wireGuardPeer) error {
+	family, otherFamily, bits := netlink.FAMILY_V4, netlink.FAMILY_V6, 8*net.IPv4len
+	if vtepIP.To4() == nil {
+		family, otherFamily, bits = netlink.FAMILY_V6, netlink.FAMILY_V4, 8*net.IPv6len
+	}
+
+	var wgPeers []*wireguard.Peer
+	var dsts []*net.IPNet
+	for _, peer := range peers {
+		dst := &net.IPNet{IP: peer.vtepIP, Mask: net.CIDRMask(bits, bits)}
+		wgPeers = append(wgPeers, &wireguard.Peer{
+			PublicKey:  peer.publicKey,
+			Endpoint:   &net.UDPAddr{IP: peer.vtepIP, Port: c.config.WireGuardListenPort},
+			AllowedIPs: []net.IPNet{*dst},
+		})
+		dsts = append(dsts, dst)
+	}
+
+	if err := wireguard.SyncPeers(WireGuardLinkName, wgPeers); err != nil {
+		return fmt.Errorf("failed to sync wireguard peers: %v", err)
+	}
+
+	link, err := netlink.LinkByName(WireGuardLinkName)
+	if err != nil {
+		return fmt.Errorf("failed to get link %v: %v", WireGuardLinkName, err)
+	}
+
+	if err := route.SyncLinkRoutes(link, dsts, c.config.WireGuardTableNum, family); err != nil {
+		return fmt.Errorf("failed to sync wireguard routes: %v", err)
+	}
+
+	if err := route.EnsureDportRule(uint16(overlayPort), c.config.WireGuardTableNum, family); err != nil {
+		return fmt.Errorf("failed to ensure wireguard rule: %v", err)
+	}
+
+	// Vtep ip might be changed to the other family.
+	if err := route.ClearRulesAndRoutesByTable(c.config.WireGuardTableNum, otherFamily); err != nil {
+		return fmt.Errorf("failed to clear wireguard rules and routes: %v", err)
+	}
+
+	return nil
+}
+
+// cleanWireGuard removes wireguard device and the rules and routes to it.
+// warnUnencryptedWireGuardPeers reports a warning event on this node for every peer which traffic falls back
+// to plaintext because of no valid wireguard public key, only once until the peer gets a valid key.
+func (c *CtrlHub) warnUnencryptedWireGuardPeers(thisNode *corev1.Node, peers []string) {
+	unencryptedPeers := map[string]bool{}
+	for _, peer := range peers {
+		unencryptedPeers[peer] = true
+		if c.unencryptedWireGuardPeers[peer] {
+			continue
+		}
+
+		c.recorder.Eventf(thisNode, corev1.EventTypeWarning, ReasonWireGuardPeerUnencrypted,
+			"%s has no valid wireguard public key, overlay traffic to it is not encrypted", peer)
+	}
+	c.unencryptedWireGuardPeers = unencryptedPeers
+}
+
+func (c *CtrlHub) cleanWireGuard() error {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if err := route.ClearRulesAndRoutesByTable(c.config.WireGuardTableNum, family); err != nil {
+			return fmt.Errorf("failed to clear wireguard rules and routes: %v", err)
+		}
+	}
+
+	return wireguard.DeleteDevice(WireGuardLinkName)
+}
+
+func (c *CtrlHub) wireGuardMetricsLoop() {
+	go func() {
+		ticker := time.NewTicker(WireGuardMetricsCollectInterval)
+		defer ticker.Stop()
+
+		// peers whose metrics are reported, series of removed peers will be deleted
+		reportedPeers := map[string]bool{}
+
+		for range ticker.C {
+			currentPeers := map[string]bool{}
+
+			// no peer exists if wireguard is not enabled
+			if _, err := netlink.LinkByName(WireGuardLinkName); err == nil {
+				device, err := wireguard.GetDevice(WireGuardLinkName)
+				if err != nil {
+					c.logger.Error(err, "failed to collect wireguard metrics")
+					continue
+				}
+
+				for _, peer := range device.Peers {
+					peerName := peer.PublicKey.String()
+					if peer.Endpoint != nil {
+						peerName = peer.Endpoint.IP.String()
+					}
+					currentPeers[peerName] = true
+
+					metrics.WireGuardPeerTransferBytesGauge.WithLabelValues(peerName, metrics.WireGuardReceiveDirection).
+						Set(float64(peer.ReceiveBytes))
+					metrics.WireGuardPeerTransferBytesGauge.WithLabelValues(peerName, metrics.WireGuardTransmitDirection).
+						Set(float64(peer.TransmitBytes))
+
+					var latestHandshake float64
+					if !peer.LastHandshakeTime.IsZero() {
+						latestHandshake = float64(peer.LastHandshakeTime.Unix())
+					}
+					metrics.WireGuardPeerLatestHandshakeGauge.WithLabelValues(peerName).Set(latestHandshake)
+				}
+			}
+
+			for peerName := range reportedPeers {
+				if !currentPeers[peerName] {
+					metrics.WireGuardPeerTransferBytesGauge.DeleteLabelValues(peerName, metrics.WireGuardReceiveDirection)
+					metrics.WireGuardPeerTransferBytesGauge.DeleteLabelValues(peerName, metrics.WireGuardTransmitDirection)
+					metrics.WireGuardPeerLatestHandshakeGauge.DeleteLabelValues(peerName)
+				}
+			}
+			reportedPeers = currentPeers
+		}
+	}()
+}
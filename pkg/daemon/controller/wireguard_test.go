@@ -0,0 +1,83 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestWarnUnencryptedWireGuardPeers(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &CtrlHub{
+		recorder:                  recorder,
+		unencryptedWireGuardPeers: map[string]bool{},
+	}
+	thisNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	tests := []struct {
+		name           string
+		peers          []string
+		expectedEvents int
+	}{
+		{
+			name:           "all peers encrypted",
+			peers:          nil,
+			expectedEvents: 0,
+		},
+		{
+			name:           "new unencrypted peers",
+			peers:          []string{"node node2", "remote vtep vtep1"},
+			expectedEvents: 2,
+		},
+		{
+			name:           "warned peers",
+			peers:          []string{"node node2", "remote vtep vtep1"},
+			expectedEvents: 0,
+		},
+		{
+			name:           "one more unencrypted peer",
+			peers:          []string{"node node2", "node node3"},
+			expectedEvents: 1,
+		},
+		{
+			name:           "peers get valid keys",
+			peers:          nil,
+			expectedEvents: 0,
+		},
+		{
+			name:           "peer loses key again",
+			peers:          []string{"node node2"},
+			expectedEvents: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.warnUnencryptedWireGuardPeers(thisNode, test.peers)
+			assert.Len(t, recorder.Events, test.expectedEvents)
+			for i := 0; i < test.expectedEvents; i++ {
+				assert.Contains(t, <-recorder.Events, ReasonWireGuardPeerUnencrypted)
+			}
+			assert.Len(t, c.unencryptedWireGuardPeers, len(test.peers))
+		})
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package geneve
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// All the forwarding filters on egress of geneve device share the same priority.
+	forwardFilterPriority = 1
+
+	// Offsets of destination mac address relative to the network header.
+	dstMacHighOffset = -14
+	dstMacLowOffset  = -10
+)
+
+// Device is an external (collect metadata) geneve device. Since geneve has no fdb, the remote vtep
+// of every unicast frame is decided by a tc filter on egress which matches the destination mac
+// address (the remote vtep mac) and sets tunnel key for it.
+//
+// Unlike vxlan device, which floods broadcast and multicast frames to all the remote vteps with
+// all-zero fdb entries, frames of broadcast or multicast destination match no filter and are
+// dropped on geneve device. This is fine for arp and ndp, because neighbors on geneve device are
+// always resolved by daemon, but multicast traffic between pods on different nodes is not
+// supported in Geneve mode.
+type Device struct {
+	link *netlink.Geneve
+
+	vni       uint32
+	port      uint16
+	localAddr net.IP
+
+	// remote vtep mac address and ip it should be forward to.
+	remoteMacToIPMap map[string]net.IP
+}
+
+func NewGeneveDevice(name string, vni int, parent string, localAddr net.IP, port int,
+	baseReachableTime time.Duration) (*Device, error) {
+	parentLink, err := netlink.LinkByName(parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parent link %v: %v", parent, err)
+	}
+
+	// Use parent's mac as hardware address.
+	link, err := ensureLink(name, parentLink.Attrs().HardwareAddr, uint16(port))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := containernetwork.EnsureOverlayLinkSysctls(link.Name, baseReachableTime); err != nil {
+		return nil, err
+	}
+
+	if err := ensureClsactQdisc(link); err != nil {
+		return nil, err
+	}
+
+	return &Device{
+		link:             link,
+		vni:              uint32(vni),
+		port:             uint16(port),
+		localAddr:        localAddr,
+		remoteMacToIPMap: map[string]net.IP{},
+	}, nil
+}
+
+func (dev *Device) MacAddr() net.HardwareAddr {
+	return dev.link.HardwareAddr
+}
+
+func (dev *Device) Link() netlink.Link {
+	return dev.link
+}
+
+func (dev *Device) RecordVtepInfo(vtepMac net.HardwareAddr, vtepIP net.IP) {
+	dev.remoteMacToIPMap[vtepMac.String()] = vtepIP
+}
+
+func (dev *Device) SyncVtepInfo() error {
+	filterList, err := netlink.FilterList(dev.link, netlink.HANDLE_MIN_EGRESS)
+	if err != nil {
+		return fmt.Errorf("failed to list egress filters for interface %v: %v", dev.link.Name, err)
+	}
+
+	existFilterMap := map[string]bool{}
+	for _, filter := range filterList {
+		u32, ok := filter.(*netlink.U32)
+		// Filters without selector are hash tables of u32, skip them.
+		if !ok || u32.Priority != forwardFilterPriority || u32.Sel == nil {
+			continue
+		}
+
+		vtepMac := parseDstMacFromKeys(u32.Sel.Keys)
+		if vtepMac != nil {
+			if vtepIP, exist := dev.remoteMacToIPMap[vtepMac.String()]; exist &&
+				!existFilterMap[vtepMac.String()] && dev.isForwardActionValid(u32.Actions, vtepIP) {
+				existFilterMap[vtepMac.String()] = true
+				continue
+			}
+		}
+
+		// Delete invalid filters.
+		if err := netlink.FilterDel(u32); err != nil {
+			return fmt.Errorf("failed to delete filter %v for interface %v: %v", u32.Handle, dev.link.Name, err)
+		}
+	}
+
+	for vtepMacString, vtepIP := range dev.remoteMacToIPMap {
+		if existFilterMap[vtepMacString] {
+			continue
+		}
+
+		vtepMac, err := net.ParseMAC(vtepMacString)
+		if err != nil {
+			return fmt.Errorf("failed to parse vtep mac %v: %v", vtepMacString, err)
+		}
+
+		if err := netlink.FilterAdd(dev.newForwardFilter(vtepMac, vtepIP)); err != nil {
+			return fmt.Errorf("failed to add forward filter of vtep %v/%v for interface %v: %v",
+				vtepMacString, vtepIP.String(), dev.link.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (dev *Device) newForwardFilter(vtepMac net.HardwareAddr, vtepIP net.IP) *netlink.U32 {
+	tunnelKeyAction := netlink.NewTunnelKeyAction()
+	tunnelKeyAction.Action = netlink.TCA_TUNNEL_KEY_SET
+	tunnelKeyAction.SrcAddr = dev.localAddr
+	tunnelKeyAction.DstAddr = vtepIP
+	tunnelKeyAction.KeyID = dev.vni
+	tunnelKeyAction.DestPort = dev.port
+
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: dev.link.Index,
+			Parent:    netlink.HANDLE_MIN_EGRESS,
+			Priority:  forwardFilterPriority,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		Sel: &nl.TcU32Sel{
+			Flags: nl.TC_U32_TERMINAL,
+			Keys:  generateDstMacKeys(vtepMac),
+		},
+		Actions: []netlink.Action{tunnelKeyAction},
+	}
+}
+
+func (dev *Device) isForwardActionValid(actions []netlink.Action, vtepIP net.IP) bool {
+	if len(actions) != 1 {
+		return false
+	}
+
+	tunnelKeyAction, ok := actions[0].(*netlink.TunnelKeyAction)
+	if !ok {
+		return false
+	}
+
+	return tunnelKeyAction.Action == netlink.TCA_TUNNEL_KEY_SET &&
+		tunnelKeyAction.DstAddr.Equal(vtepIP) &&
+		tunnelKeyAction.SrcAddr.Equal(dev.localAddr) &&
+		tunnelKeyAction.KeyID == dev.vni &&
+		tunnelKeyAction.DestPort == dev.port
+}
+
+// generateDstMacKeys generates u32 keys which match the destination mac address of ethernet header.
+func generateDstMacKeys(mac net.HardwareAddr) []nl.TcU32Key {
+	return []nl.TcU32Key{
+		{
+			Mask: 0xffffffff,
+			Val:  binary.BigEndian.Uint32(mac[0:4]),
+			Off:  dstMacHighOffset,
+		},
+		{
+			Mask: 0xffff0000,
+			Val:  uint32(binary.BigEndian.Uint16(mac[4:6])) << 16,
+			Off:  dstMacLowOffset,
+		},
+	}
+}
+
+// parseDstMacFromKeys is the reverse of generateDstMacKeys, returns nil if keys are not generated by it.
+func parseDstMacFromKeys(keys []nl.TcU32Key) net.HardwareAddr {
+	if len(keys) != 2 ||
+		keys[0].Off != dstMacHighOffset || keys[0].Mask != 0xffffffff ||
+		keys[1].Off != dstMacLowOffset || keys[1].Mask != 0xffff0000 {
+		return nil
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	binary.BigEndian.PutUint32(mac[0:4], keys[0].Val)
+	binary.BigEndian.PutUint16(mac[4:6], uint16(keys[1].Val>>16))
+	return mac
+}
+
+func ensureClsactQdisc(link netlink.Link) error {
+	qdiscList, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdisc for interface %v: %v", link.Attrs().Name, err)
+	}
+
+	for _, qdisc := range qdiscList {
+		if qdisc.Type() == "clsact" {
+			return nil
+		}
+	}
+
+	clsact := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+
+	if err := netlink.QdiscAdd(clsact); err != nil {
+		return fmt.Errorf("failed to add clsact qdisc for interface %v: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
+
+func ensureLink(name string, hardwareAddr net.HardwareAddr, port uint16) (*netlink.Geneve, error) {
+	existing, err := netlink.LinkByName(name)
+	if err == nil {
+		// it's ok if the device already exists as long as config is similar
+		if incompat := geneveLinkIncompat(existing, hardwareAddr, port); incompat == "" {
+			if err := netlink.LinkSetUp(existing); err != nil {
+				return nil, fmt.Errorf("failed to set link %v up: %v", name, err)
+			}
+			return existing.(*netlink.Geneve), nil
+		}
+
+		// delete existing
+		if err = netlink.LinkDel(existing); err != nil {
+			return nil, fmt.Errorf("failed to delete interface: %v", err)
+		}
+	} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return nil, fmt.Errorf("failed to get link %v: %v", name, err)
+	}
+
+	if err := addExternalGeneveLink(name, hardwareAddr, port); err != nil {
+		return nil, fmt.Errorf("failed to create geneve interface: %v", err)
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't locate created geneve device %v: %v", name, err)
+	}
+
+	geneve, ok := link.(*netlink.Geneve)
+	if !ok {
+		return nil, fmt.Errorf("created geneve device %v is not geneve", name)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set link %v up: %v", name, err)
+	}
+
+	return geneve, nil
+}
+
+// addExternalGeneveLink creates geneve device in collect metadata mode, which is not correctly
+// supported by netlink.LinkAdd.
+func addExternalGeneveLink(name string, hardwareAddr net.HardwareAddr, port uint16) error {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(name)))
+	req.AddData(nl.NewRtAttr(unix.IFLA_ADDRESS, []byte(hardwareAddr)))
+
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, port)
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("geneve"))
+	data := linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	data.AddRtAttr(nl.IFLA_GENEVE_COLLECT_METADATA, []byte{})
+	data.AddRtAttr(nl.IFLA_GENEVE_PORT, portBytes)
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+func geneveLinkIncompat(link netlink.Link, hardwareAddr net.HardwareAddr, port uint16) string {
+	geneve, ok := link.(*netlink.Geneve)
+	if !ok {
+		return fmt.Sprintf("link %v is not geneve device", link.Attrs().Name)
+	}
+
+	// vni and remote will be empty for external geneve device
+	if geneve.ID != 0 {
+		return fmt.Sprintf("vni: %v vs 0", geneve.ID)
+	}
+
+	if len(geneve.Remote) != 0 && !geneve.Remote.IsUnspecified() {
+		return fmt.Sprintf("remote: %v vs none", geneve.Remote)
+	}
+
+	if geneve.HardwareAddr.String() != hardwareAddr.String() {
+		return fmt.Sprintf("vtep Mac: %v vs %v", geneve.HardwareAddr, hardwareAddr)
+	}
+
+	if geneve.Dport != port {
+		return fmt.Sprintf("port: %v vs %v", geneve.Dport, port)
+	}
+
+	return ""
+}
@@ -0,0 +1,295 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package geneve
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestDstMacKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		mac  string
+		keys []nl.TcU32Key
+	}{
+		{
+			name: "unicast mac",
+			mac:  "02:42:ac:11:00:02",
+			keys: []nl.TcU32Key{
+				{Mask: 0xffffffff, Val: 0x0242ac11, Off: -14},
+				{Mask: 0xffff0000, Val: 0x00020000, Off: -10},
+			},
+		},
+		{
+			name: "mac with all bits set",
+			mac:  "fe:ff:ff:ff:ff:ff",
+			keys: []nl.TcU32Key{
+				{Mask: 0xffffffff, Val: 0xfeffffff, Off: -14},
+				{Mask: 0xffff0000, Val: 0xffff0000, Off: -10},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mac, err := net.ParseMAC(test.mac)
+			assert.NoError(t, err)
+
+			keys := generateDstMacKeys(mac)
+			assert.Equal(t, test.keys, keys)
+			assert.Equal(t, test.mac, parseDstMacFromKeys(keys).String())
+		})
+	}
+}
+
+func TestParseDstMacFromInvalidKeys(t *testing.T) {
+	validKeys := func() []nl.TcU32Key {
+		return []nl.TcU32Key{
+			{Mask: 0xffffffff, Val: 0x0242ac11, Off: dstMacHighOffset},
+			{Mask: 0xffff0000, Val: 0x00020000, Off: dstMacLowOffset},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(keys []nl.TcU32Key) []nl.TcU32Key
+	}{
+		{
+			name: "no key",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				return nil
+			},
+		},
+		{
+			name: "one key",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				return keys[:1]
+			},
+		},
+		{
+			name: "offset of source mac",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				keys[0].Off = -8
+				return keys
+			},
+		},
+		{
+			name: "wrong low offset",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				keys[1].Off = -12
+				return keys
+			},
+		},
+		{
+			name: "partial high mask",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				keys[0].Mask = 0xffffff00
+				return keys
+			},
+		},
+		{
+			name: "wrong low mask",
+			mutate: func(keys []nl.TcU32Key) []nl.TcU32Key {
+				keys[1].Mask = 0x0000ffff
+				return keys
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Nil(t, parseDstMacFromKeys(test.mutate(validKeys())))
+		})
+	}
+}
+
+func TestIsForwardActionValid(t *testing.T) {
+	dev := &Device{
+		vni:       4,
+		port:      6081,
+		localAddr: net.ParseIP("192.168.0.1"),
+	}
+	vtepIP := net.ParseIP("192.168.0.2")
+
+	newAction := func() *netlink.TunnelKeyAction {
+		action := netlink.NewTunnelKeyAction()
+		action.Action = netlink.TCA_TUNNEL_KEY_SET
+		action.SrcAddr = net.ParseIP("192.168.0.1")
+		action.DstAddr = net.ParseIP("192.168.0.2")
+		action.KeyID = 4
+		action.DestPort = 6081
+		return action
+	}
+
+	tests := []struct {
+		name     string
+		actions  func() []netlink.Action
+		expected bool
+	}{
+		{
+			name: "valid",
+			actions: func() []netlink.Action {
+				return []netlink.Action{newAction()}
+			},
+			expected: true,
+		},
+		{
+			name: "no action",
+			actions: func() []netlink.Action {
+				return nil
+			},
+			expected: false,
+		},
+		{
+			name: "more than one action",
+			actions: func() []netlink.Action {
+				return []netlink.Action{newAction(), newAction()}
+			},
+			expected: false,
+		},
+		{
+			name: "not tunnel key action",
+			actions: func() []netlink.Action {
+				return []netlink.Action{netlink.NewMirredAction(1)}
+			},
+			expected: false,
+		},
+		{
+			name: "tunnel key release",
+			actions: func() []netlink.Action {
+				action := newAction()
+				action.Action = netlink.TCA_TUNNEL_KEY_UNSET
+				return []netlink.Action{action}
+			},
+			expected: false,
+		},
+		{
+			name: "stale remote vtep",
+			actions: func() []netlink.Action {
+				action := newAction()
+				action.DstAddr = net.ParseIP("192.168.0.3")
+				return []netlink.Action{action}
+			},
+			expected: false,
+		},
+		{
+			name: "stale local address",
+			actions: func() []netlink.Action {
+				action := newAction()
+				action.SrcAddr = net.ParseIP("192.168.0.4")
+				return []netlink.Action{action}
+			},
+			expected: false,
+		},
+		{
+			name: "different vni",
+			actions: func() []netlink.Action {
+				action := newAction()
+				action.KeyID = 5
+				return []netlink.Action{action}
+			},
+			expected: false,
+		},
+		{
+			name: "different port",
+			actions: func() []netlink.Action {
+				action := newAction()
+				action.DestPort = 6082
+				return []netlink.Action{action}
+			},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, dev.isForwardActionValid(test.actions(), vtepIP))
+		})
+	}
+}
+
+func TestAddExternalGeneveLink(t *testing.T) {
+	if unix.Geteuid() != 0 {
+		t.Skip("creating geneve device requires root")
+	}
+
+	testNS, err := testutils.NewNS()
+	if err != nil {
+		t.Skipf("failed to create test netns: %v", err)
+	}
+	defer func() {
+		_ = testNS.Close()
+		_ = testutils.UnmountNS(testNS)
+	}()
+
+	hardwareAddr, _ := net.ParseMAC("02:42:ac:11:00:02")
+
+	tests := []struct {
+		name string
+		port uint16
+	}{
+		{
+			name: "default port",
+			port: 6081,
+		},
+		{
+			name: "custom port",
+			port: 16081,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// subtests run in other goroutines, which should enter the test netns again
+			var supported bool
+			assert.NoError(t, testNS.Do(func(_ ns.NetNS) error {
+				if err := addExternalGeneveLink("geneve-test", hardwareAddr, test.port); err != nil {
+					t.Logf("geneve device is not supported: %v", err)
+					return nil
+				}
+				supported = true
+				defer func() {
+					if link, err := netlink.LinkByName("geneve-test"); err == nil {
+						_ = netlink.LinkDel(link)
+					}
+				}()
+
+				link, err := netlink.LinkByName("geneve-test")
+				assert.NoError(t, err)
+				assert.IsType(t, &netlink.Geneve{}, link)
+				assert.Empty(t, geneveLinkIncompat(link, hardwareAddr, test.port))
+				assert.NotEmpty(t, geneveLinkIncompat(link, hardwareAddr, test.port+1))
+
+				// device with the same name should not be created twice
+				assert.Error(t, addExternalGeneveLink("geneve-test", hardwareAddr, test.port))
+				return nil
+			}))
+
+			if !supported {
+				t.Skip("geneve device is not supported")
+			}
+		})
+	}
+}
@@ -0,0 +1,769 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// attributes nested in IPSET_ATTR_IP, which are not defined in netlink library
+	ipsetAttrIPAddrIPv4 = 1
+	ipsetAttrIPAddrIPv6 = 2
+
+	nlaTypeMask = ^(nl.NLA_F_NESTED | nl.NLA_F_NET_BYTEORDER)
+
+	tmpSetSuffix = "-tmp"
+)
+
+// netlinkSet is a set which exists in kernel or is expected to exist in kernel.
+type netlinkSet struct {
+	name     string
+	typeName string
+	family   uint8
+
+	timeout  *uint32
+	hashSize *uint32
+	maxElem  *uint32
+
+	// members are indexed by the normalized form of themselves
+	members map[string]*netlinkMember
+}
+
+type netlinkMember struct {
+	ip      net.IP
+	cidr    uint8
+	timeout *uint32
+	noMatch bool
+}
+
+// netlinkRunner implements Interface in terms of ipset netlink messages (NFNL_SUBSYS_IPSET),
+// existing sets are updated incrementally and will be replaced by swapping only if their
+// types are changed.
+type netlinkRunner struct {
+	mu sync.Mutex
+
+	isIpv6 bool
+
+	sets map[string]*netlinkSet
+
+	// operations are applied in order when SyncOperations is called
+	operations []func(socket *nl.SocketHandle) error
+
+	// err is the first error of recording operations, it will be returned by SyncOperations
+	err error
+
+	revisions map[string]uint8
+}
+
+// NewNetlink returns a new Interface which talks to kernel through netlink directly.
+func NewNetlink(isIpv6 bool) (Interface, error) {
+	protocol, minProtocol, err := ipsetProtocol()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ipset protocol of kernel: %v", err)
+	}
+
+	if protocol < nl.IPSET_PROTOCOL || minProtocol > nl.IPSET_PROTOCOL {
+		return nil, fmt.Errorf("ipset protocol %v is not supported by kernel, supported range is [%v, %v]",
+			nl.IPSET_PROTOCOL, minProtocol, protocol)
+	}
+
+	return &netlinkRunner{
+		isIpv6:    isIpv6,
+		sets:      make(map[string]*netlinkSet),
+		revisions: make(map[string]uint8),
+	}, nil
+}
+
+func (r *netlinkRunner) LoadData() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msgs, err := executeIPSetRequest(newIPSetRequest(nl.IPSET_CMD_LIST), nil)
+	if err != nil {
+		return fmt.Errorf("failed to list ipsets: %v", err)
+	}
+
+	sets := make(map[string]*netlinkSet)
+	for _, msg := range msgs {
+		if err = parseIPSetMessage(msg, sets); err != nil {
+			return fmt.Errorf("failed to parse ipset message: %v", err)
+		}
+	}
+	r.sets = sets
+	return nil
+}
+
+// ListNetlinkSetMembers lists the members of a set through netlink, members are returned in their
+// normalized form and sorted. The set might not exist.
+func ListNetlinkSetMembers(setName string) ([]string, bool, error) {
+	req := newIPSetRequest(nl.IPSET_CMD_LIST)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+
+	msgs, err := executeIPSetRequest(req, nil)
+	if err != nil {
+		if err == syscall.ENOENT {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to list ipset %v: %v", setName, err)
+	}
+
+	sets := make(map[string]*netlinkSet)
+	for _, msg := range msgs {
+		if err = parseIPSetMessage(msg, sets); err != nil {
+			return nil, false, fmt.Errorf("failed to parse ipset message: %v", err)
+		}
+	}
+
+	set, exist := sets[setName]
+	if !exist {
+		return nil, false, nil
+	}
+	return set.memberKeys(), true, nil
+}
+
+func (r *netlinkRunner) AddOrReplaceIPSet(setName string, members []string, createOptions ...string) {
+	memberWithOptions := make([][]string, 0, len(members))
+	for _, member := range members {
+		memberWithOptions = append(memberWithOptions, []string{member})
+	}
+	r.AddOrReplaceIPSetWithBuiltinOptions(setName, memberWithOptions, createOptions...)
+}
+
+func (r *netlinkRunner) AddOrReplaceIPSetWithBuiltinOptions(setName string, members [][]string, createOptions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	set, err := r.parseSet(setName, members, createOptions)
+	if err != nil {
+		r.recordError(fmt.Errorf("invalid ipset %v: %v", setName, err))
+		return
+	}
+
+	current, exist := r.sets[setName]
+	switch {
+	case !exist:
+		r.recordCreate(setName, set)
+	case current.typeName == set.typeName && current.family == set.family:
+		// The set is kept and only the differences are applied, members not expected any more are deleted
+		// and missing members are added. Member keys include the nomatch option, so a member whose option
+		// changes is deleted and then added back with the new option.
+		for key, member := range current.members {
+			if _, ok := set.members[key]; !ok {
+				r.recordAddDel(nl.IPSET_CMD_DEL, setName, member)
+			}
+		}
+		for key, member := range set.members {
+			if _, ok := current.members[key]; !ok {
+				r.recordAddDel(nl.IPSET_CMD_ADD, setName, member)
+			}
+		}
+	default:
+		tmpSetName := setName + tmpSetSuffix
+		if _, exist := r.sets[tmpSetName]; exist {
+			r.recordDestroy(tmpSetName)
+		}
+		r.recordCreate(tmpSetName, set)
+		r.recordReplace(tmpSetName, setName)
+		delete(r.sets, tmpSetName)
+	}
+
+	set.name = setName
+	r.sets[setName] = set
+}
+
+func (r *netlinkRunner) RemoveIPSet(setName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.sets[setName]; exist {
+		r.recordDestroy(setName)
+		delete(r.sets, setName)
+	}
+}
+
+func (r *netlinkRunner) ListIPSets() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var setList []string
+	for set := range r.sets {
+		setList = append(setList, set)
+	}
+	return setList
+}
+
+func (r *netlinkRunner) SyncOperations() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		r.operations = nil
+		r.err = nil
+	}()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if len(r.operations) == 0 {
+		return nil
+	}
+
+	// Reuse one socket for all the operations, there might be thousands of them.
+	socket, err := nl.GetNetlinkSocketAt(netns.None(), netns.None(), unix.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("failed to create netlink socket: %v", err)
+	}
+	defer socket.Close()
+
+	if err = socket.SetSendTimeout(&nl.SocketTimeoutTv); err != nil {
+		return fmt.Errorf("failed to set send timeout of netlink socket: %v", err)
+	}
+	if err = socket.SetReceiveTimeout(&nl.SocketTimeoutTv); err != nil {
+		return fmt.Errorf("failed to set receive timeout of netlink socket: %v", err)
+	}
+
+	socketHandle := &nl.SocketHandle{Socket: socket}
+	for _, operation := range r.operations {
+		if err = operation(socketHandle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *netlinkRunner) parseSet(setName string, members [][]string, createOptions []string) (*netlinkSet, error) {
+	if len(setName) > nl.IPSET_MAXNAMELEN-1 {
+		return nil, fmt.Errorf("set name is longer than %v", nl.IPSET_MAXNAMELEN-1)
+	}
+
+	set, err := parseCreateOptions(createOptions, r.isIpv6)
+	if err != nil {
+		return nil, err
+	}
+
+	set.members = make(map[string]*netlinkMember, len(members))
+	for _, memberWithOptions := range members {
+		if len(memberWithOptions) == 0 {
+			continue
+		}
+
+		member, err := parseMember(memberWithOptions[0], memberWithOptions[1:], set)
+		if err != nil {
+			return nil, err
+		}
+		set.members[member.key(isNetType(set.typeName))] = member
+	}
+
+	if _, exist := r.revisions[revisionKey(set)]; !exist {
+		revision, err := ipsetTypeRevision(set.typeName, set.family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get revision of type %v: %v", set.typeName, err)
+		}
+		r.revisions[revisionKey(set)] = revision
+	}
+
+	return set, nil
+}
+
+func (r *netlinkRunner) recordError(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *netlinkRunner) recordCreate(setName string, set *netlinkSet) {
+	revision := r.revisions[revisionKey(set)]
+	r.operations = append(r.operations, func(socket *nl.SocketHandle) error {
+		req := newIPSetRequest(nl.IPSET_CMD_CREATE)
+		req.Flags |= unix.NLM_F_EXCL
+
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(set.typeName)))
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_REVISION, nl.Uint8Attr(revision)))
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(set.family)))
+
+		data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+		if set.timeout != nil {
+			data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: *set.timeout})
+		}
+		if set.hashSize != nil {
+			data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_HASHSIZE | nl.NLA_F_NET_BYTEORDER, Value: *set.hashSize})
+		}
+		if set.maxElem != nil {
+			data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_MAXELEM | nl.NLA_F_NET_BYTEORDER, Value: *set.maxElem})
+		}
+		req.AddData(data)
+
+		if _, err := executeIPSetRequest(req, socket); err != nil {
+			return fmt.Errorf("failed to create ipset %v: %v", setName, err)
+		}
+		return nil
+	})
+
+	for _, member := range set.members {
+		r.recordAddDel(nl.IPSET_CMD_ADD, setName, member)
+	}
+}
+
+func (r *netlinkRunner) recordAddDel(cmd int, setName string, member *netlinkMember) {
+	r.operations = append(r.operations, func(socket *nl.SocketHandle) error {
+		// Without NLM_F_EXCL, adding an existing member or deleting a nonexistent member will not fail.
+		req := newIPSetRequest(cmd)
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+		req.AddData(member.serialize())
+
+		if _, err := executeIPSetRequest(req, socket); err != nil {
+			operation := "add"
+			if cmd == nl.IPSET_CMD_DEL {
+				operation = "delete"
+			}
+			return fmt.Errorf("failed to %v member %v of ipset %v: %v", operation, member.ip.String(), setName, err)
+		}
+		return nil
+	})
+}
+
+// recordReplace replaces the target set with the temporary one. Sets will be swapped atomically if possible,
+// otherwise the target set will be destroyed and the temporary set will be renamed to take its place.
+func (r *netlinkRunner) recordReplace(tmpSetName, setName string) {
+	r.operations = append(r.operations, func(socket *nl.SocketHandle) error {
+		err := swapIPSets(tmpSetName, setName, socket)
+		if err == nil {
+			// Then remove the temporary set (which was the old main set).
+			if err = destroyIPSet(tmpSetName, socket); err != nil {
+				return fmt.Errorf("failed to destroy ipset %v: %v", tmpSetName, err)
+			}
+			return nil
+		}
+
+		// Sets of different features, e.g., hash:ip and hash:net, can not be swapped.
+		if err != nl.IPSetError(nl.IPSET_ERR_TYPE_MISMATCH) {
+			return fmt.Errorf("failed to swap ipset %v and %v: %v", tmpSetName, setName, err)
+		}
+
+		if err = destroyIPSet(setName, socket); err != nil {
+			_ = destroyIPSet(tmpSetName, socket)
+			return fmt.Errorf("failed to destroy ipset %v of different type: %v", setName, err)
+		}
+
+		req := newIPSetRequest(nl.IPSET_CMD_RENAME)
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(tmpSetName)))
+		req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME2, nl.ZeroTerminated(setName)))
+		if _, err = executeIPSetRequest(req, socket); err != nil {
+			return fmt.Errorf("failed to rename ipset %v to %v: %v", tmpSetName, setName, err)
+		}
+		return nil
+	})
+}
+
+func (r *netlinkRunner) recordDestroy(setName string) {
+	r.operations = append(r.operations, func(socket *nl.SocketHandle) error {
+		if err := destroyIPSet(setName, socket); err != nil {
+			return fmt.Errorf("failed to destroy ipset %v: %v", setName, err)
+		}
+		return nil
+	})
+}
+
+// memberKeys returns the sorted keys of members, which are listed by kernel in no stable order.
+func (s *netlinkSet) memberKeys() []string {
+	keys := make([]string, 0, len(s.members))
+	for key := range s.members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *netlinkMember) key(isNet bool) string {
+	key := m.ip.String()
+	if isNet {
+		key = key + "/" + strconv.Itoa(int(m.cidr))
+	}
+	if m.noMatch {
+		key = key + " " + OptionNoMatch
+	}
+	return key
+}
+
+func (m *netlinkMember) serialize() *nl.RtAttr {
+	data := nl.NewRtAttr(nl.IPSET_ATTR_DATA|int(nl.NLA_F_NESTED), nil)
+
+	ip := nl.NewRtAttr(nl.IPSET_ATTR_IP|int(nl.NLA_F_NESTED), nil)
+	if ip4 := m.ip.To4(); ip4 != nil {
+		ip.AddRtAttr(ipsetAttrIPAddrIPv4|int(nl.NLA_F_NET_BYTEORDER), ip4)
+	} else {
+		ip.AddRtAttr(ipsetAttrIPAddrIPv6|int(nl.NLA_F_NET_BYTEORDER), m.ip.To16())
+	}
+	data.AddChild(ip)
+
+	if m.cidr != 0 {
+		data.AddRtAttr(nl.IPSET_ATTR_CIDR, nl.Uint8Attr(m.cidr))
+	}
+	if m.timeout != nil {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_TIMEOUT | nl.NLA_F_NET_BYTEORDER, Value: *m.timeout})
+	}
+	if m.noMatch {
+		data.AddChild(&nl.Uint32Attribute{Type: nl.IPSET_ATTR_CADT_FLAGS | nl.NLA_F_NET_BYTEORDER, Value: nl.IPSET_FLAG_NOMATCH})
+	}
+
+	return data
+}
+
+// parseCreateOptions parses create options of the same format as ipset command, only the types used
+// by hybridnet are supported, e.g., "hash:net timeout 0".
+func parseCreateOptions(createOptions []string, isIpv6 bool) (*netlinkSet, error) {
+	if len(createOptions) == 0 {
+		return nil, fmt.Errorf("set type is not specified")
+	}
+
+	set := &netlinkSet{
+		typeName: createOptions[0],
+		family:   unix.NFPROTO_IPV4,
+	}
+	if isIpv6 {
+		set.family = unix.NFPROTO_IPV6
+	}
+
+	if set.typeName != TypeHashIP && set.typeName != TypeHashNet {
+		return nil, fmt.Errorf("set type %v is not supported", set.typeName)
+	}
+
+	for i := 1; i < len(createOptions); i++ {
+		option := createOptions[i]
+		if i+1 >= len(createOptions) {
+			return nil, fmt.Errorf("value of option %v is not specified", option)
+		}
+		value := createOptions[i+1]
+		i++
+
+		switch option {
+		case OptionTimeout, OptionHashSize, OptionMaxElem:
+			parsedValue, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %v of option %v: %v", value, option, err)
+			}
+			uint32Value := uint32(parsedValue)
+
+			switch option {
+			case OptionTimeout:
+				set.timeout = &uint32Value
+			case OptionHashSize:
+				set.hashSize = &uint32Value
+			case OptionMaxElem:
+				set.maxElem = &uint32Value
+			}
+		case OptionFamilly:
+			switch value {
+			case FamillyInet:
+				set.family = unix.NFPROTO_IPV4
+			case FamillyInet6:
+				set.family = unix.NFPROTO_IPV6
+			default:
+				return nil, fmt.Errorf("invalid family %v", value)
+			}
+		default:
+			return nil, fmt.Errorf("option %v is not supported", option)
+		}
+	}
+
+	return set, nil
+}
+
+// parseMember parses a member with options of the same format as ipset command, e.g., "10.0.0.0/24 nomatch".
+func parseMember(member string, options []string, set *netlinkSet) (*netlinkMember, error) {
+	isNet := isNetType(set.typeName)
+
+	result := &netlinkMember{}
+	if strings.Contains(member, "/") {
+		_, ipNet, err := net.ParseCIDR(member)
+		if err != nil {
+			return nil, fmt.Errorf("invalid member %v: %v", member, err)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		if !isNet && ones != bits {
+			return nil, fmt.Errorf("cidr member %v is not supported by type %v", member, set.typeName)
+		}
+		result.ip, result.cidr = ipNet.IP, uint8(ones)
+	} else {
+		if result.ip = net.ParseIP(member); result.ip == nil {
+			return nil, fmt.Errorf("invalid member %v", member)
+		}
+		if isNet {
+			result.cidr = uint8(familyBits(set.family))
+		}
+	}
+
+	if isIPv4 := result.ip.To4() != nil; isIPv4 != (set.family == unix.NFPROTO_IPV4) {
+		return nil, fmt.Errorf("family of member %v mismatches the set", member)
+	}
+	if !isNet {
+		result.cidr = 0
+	}
+
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case OptionTimeout:
+			if i+1 >= len(options) {
+				return nil, fmt.Errorf("value of option %v is not specified", options[i])
+			}
+			timeout, err := strconv.ParseUint(options[i+1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %v of option %v: %v", options[i+1], options[i], err)
+			}
+			uint32Timeout := uint32(timeout)
+			result.timeout = &uint32Timeout
+			i++
+		case OptionNoMatch:
+			if !isNet {
+				return nil, fmt.Errorf("option %v is not supported by type %v", OptionNoMatch, set.typeName)
+			}
+			result.noMatch = true
+		default:
+			return nil, fmt.Errorf("option %v is not supported", options[i])
+		}
+	}
+
+	return result, nil
+}
+
+// parseIPSetMessage parses a message of ipset list dump and merges it into sets, members of a large
+// set might be split into several messages.
+func parseIPSetMessage(msg []byte, sets map[string]*netlinkSet) error {
+	if len(msg) < nl.SizeofNfgenmsg {
+		return fmt.Errorf("message is too short")
+	}
+
+	attrs, err := nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return err
+	}
+
+	set := &netlinkSet{}
+	var entries []syscall.NetlinkRouteAttr
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nlaTypeMask {
+		case nl.IPSET_ATTR_SETNAME:
+			set.name = nl.BytesToString(attr.Value)
+		case nl.IPSET_ATTR_TYPENAME:
+			set.typeName = nl.BytesToString(attr.Value)
+		case nl.IPSET_ATTR_FAMILY:
+			if len(attr.Value) > 0 {
+				set.family = attr.Value[0]
+			}
+		case nl.IPSET_ATTR_ADT:
+			if entries, err = nl.ParseRouteAttr(attr.Value); err != nil {
+				return fmt.Errorf("failed to parse entries of set %v: %v", set.name, err)
+			}
+		}
+	}
+
+	if len(set.name) == 0 {
+		return fmt.Errorf("set name not found")
+	}
+
+	if existingSet, exist := sets[set.name]; exist {
+		set = existingSet
+	} else {
+		set.members = map[string]*netlinkMember{}
+		sets[set.name] = set
+	}
+
+	for _, entry := range entries {
+		member, err := parseIPSetEntry(entry.Value)
+		if err != nil {
+			return fmt.Errorf("failed to parse entry of set %v: %v", set.name, err)
+		}
+		if member.cidr == 0 && isNetType(set.typeName) {
+			member.cidr = uint8(familyBits(set.family))
+		}
+		set.members[member.key(isNetType(set.typeName))] = member
+	}
+
+	return nil
+}
+
+func parseIPSetEntry(data []byte) (*netlinkMember, error) {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &netlinkMember{}
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nlaTypeMask {
+		case nl.IPSET_ATTR_IP:
+			ipAttrs, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, ipAttr := range ipAttrs {
+				switch ipAttr.Attr.Type & nlaTypeMask {
+				case ipsetAttrIPAddrIPv4, ipsetAttrIPAddrIPv6:
+					member.ip = net.IP(ipAttr.Value)
+				}
+			}
+		case nl.IPSET_ATTR_CIDR:
+			if len(attr.Value) > 0 {
+				member.cidr = attr.Value[0]
+			}
+		case nl.IPSET_ATTR_CADT_FLAGS:
+			member.noMatch = networkUint32(attr.Value)&nl.IPSET_FLAG_NOMATCH != 0
+		}
+	}
+
+	if member.ip == nil {
+		return nil, fmt.Errorf("ip not found")
+	}
+	return member, nil
+}
+
+func ipsetProtocol() (uint8, uint8, error) {
+	msgs, err := executeIPSetRequest(newIPSetRequest(nl.IPSET_CMD_PROTOCOL), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var protocol, minProtocol uint8
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofNfgenmsg {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, attr := range attrs {
+			switch attr.Attr.Type & nlaTypeMask {
+			case nl.IPSET_ATTR_PROTOCOL:
+				protocol = attr.Value[0]
+			case nl.IPSET_ATTR_PROTOCOL_MIN:
+				minProtocol = attr.Value[0]
+			}
+		}
+	}
+
+	if minProtocol == 0 {
+		minProtocol = protocol
+	}
+	return protocol, minProtocol, nil
+}
+
+// ipsetTypeRevision returns the max revision of set type supported by kernel, the same as ipset command.
+func ipsetTypeRevision(typeName string, family uint8) (uint8, error) {
+	req := newIPSetRequest(nl.IPSET_CMD_TYPE)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(typeName)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(family)))
+
+	msgs, err := executeIPSetRequest(req, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofNfgenmsg {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+		if err != nil {
+			return 0, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type&nlaTypeMask == nl.IPSET_ATTR_REVISION && len(attr.Value) > 0 {
+				return attr.Value[0], nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("revision not found")
+}
+
+func swapIPSets(fromSetName, toSetName string, socket *nl.SocketHandle) error {
+	req := newIPSetRequest(nl.IPSET_CMD_SWAP)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(fromSetName)))
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME2, nl.ZeroTerminated(toSetName)))
+	_, err := executeIPSetRequest(req, socket)
+	return err
+}
+
+func destroyIPSet(setName string, socket *nl.SocketHandle) error {
+	req := newIPSetRequest(nl.IPSET_CMD_DESTROY)
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated(setName)))
+	_, err := executeIPSetRequest(req, socket)
+	return err
+}
+
+func newIPSetRequest(cmd int) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(cmd|(unix.NFNL_SUBSYS_IPSET<<8), nl.GetIpsetFlags(cmd))
+	req.AddData(&nl.Nfgenmsg{
+		NfgenFamily: uint8(unix.AF_INET),
+		Version:     nl.NFNETLINK_V0,
+	})
+	req.AddData(nl.NewRtAttr(nl.IPSET_ATTR_PROTOCOL, nl.Uint8Attr(nl.IPSET_PROTOCOL)))
+	return req
+}
+
+func executeIPSetRequest(req *nl.NetlinkRequest, socket *nl.SocketHandle) ([][]byte, error) {
+	if socket != nil {
+		req.Sockets = map[int]*nl.SocketHandle{
+			unix.NETLINK_NETFILTER: socket,
+		}
+	}
+
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	if err != nil {
+		if errno, ok := err.(syscall.Errno); ok && int(errno) >= nl.IPSET_ERR_PRIVATE {
+			err = nl.IPSetError(uintptr(errno))
+		}
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func revisionKey(set *netlinkSet) string {
+	return set.typeName + "/" + strconv.Itoa(int(set.family))
+}
+
+func isNetType(typeName string) bool {
+	return typeName == TypeHashNet
+}
+
+func familyBits(family uint8) int {
+	if family == unix.NFPROTO_IPV6 {
+		return 8 * net.IPv6len
+	}
+	return 8 * net.IPv4len
+}
+
+func networkUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
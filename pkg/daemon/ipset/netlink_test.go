@@ -0,0 +1,134 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package ipset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseMember(t *testing.T) {
+	tests := []struct {
+		name        string
+		member      string
+		options     []string
+		createOpts  []string
+		isIpv6      bool
+		expectedKey string
+		expectErr   bool
+	}{
+		{
+			name:        "ipv4 net",
+			member:      "10.0.0.1/24",
+			createOpts:  []string{TypeHashNet, OptionTimeout, "0"},
+			expectedKey: "10.0.0.0/24",
+		},
+		{
+			name:        "ipv4 address in net set",
+			member:      "10.0.0.1",
+			createOpts:  []string{TypeHashNet},
+			expectedKey: "10.0.0.1/32",
+		},
+		{
+			name:        "ipv6 address",
+			member:      "fd00::1",
+			createOpts:  []string{TypeHashIP},
+			isIpv6:      true,
+			expectedKey: "fd00::1",
+		},
+		{
+			name:        "nomatch net",
+			member:      "10.0.0.0/24",
+			options:     []string{OptionNoMatch},
+			createOpts:  []string{TypeHashNet},
+			expectedKey: "10.0.0.0/24 nomatch",
+		},
+		{
+			name:       "cidr in ip set",
+			member:     "10.0.0.0/24",
+			createOpts: []string{TypeHashIP},
+			expectErr:  true,
+		},
+		{
+			name:       "family mismatch",
+			member:     "10.0.0.1",
+			createOpts: []string{TypeHashIP},
+			isIpv6:     true,
+			expectErr:  true,
+		},
+		{
+			name:       "unsupported type",
+			member:     "10.0.0.1",
+			createOpts: []string{TypeHashIPPort},
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := parseCreateOptions(test.createOpts, test.isIpv6)
+			if err == nil {
+				var member *netlinkMember
+				if member, err = parseMember(test.member, test.options, set); err == nil {
+					assert.Equal(t, test.expectedKey, member.key(isNetType(set.typeName)))
+				}
+			}
+			assert.Equal(t, test.expectErr, err != nil)
+		})
+	}
+}
+
+func TestParseIPSetMessage(t *testing.T) {
+	set, err := parseCreateOptions([]string{TypeHashNet}, false)
+	assert.NoError(t, err)
+
+	var members []*netlinkMember
+	for _, member := range []string{"10.0.0.0/24", "192.168.1.1"} {
+		parsedMember, err := parseMember(member, nil, set)
+		assert.NoError(t, err)
+		members = append(members, parsedMember)
+	}
+
+	generateMessage := func(members ...*netlinkMember) []byte {
+		msg := (&nl.Nfgenmsg{NfgenFamily: unix.AF_INET}).Serialize()
+		msg = append(msg, nl.NewRtAttr(nl.IPSET_ATTR_SETNAME, nl.ZeroTerminated("test")).Serialize()...)
+		msg = append(msg, nl.NewRtAttr(nl.IPSET_ATTR_TYPENAME, nl.ZeroTerminated(TypeHashNet)).Serialize()...)
+		msg = append(msg, nl.NewRtAttr(nl.IPSET_ATTR_FAMILY, nl.Uint8Attr(unix.NFPROTO_IPV4)).Serialize()...)
+
+		adt := nl.NewRtAttr(nl.IPSET_ATTR_ADT|int(nl.NLA_F_NESTED), nil)
+		for _, member := range members {
+			adt.AddChild(member.serialize())
+		}
+		return append(msg, adt.Serialize()...)
+	}
+
+	// members of one set might be split into several messages
+	sets := map[string]*netlinkSet{}
+	assert.NoError(t, parseIPSetMessage(generateMessage(members[0]), sets))
+	assert.NoError(t, parseIPSetMessage(generateMessage(members[1]), sets))
+
+	assert.Len(t, sets, 1)
+	assert.Equal(t, TypeHashNet, sets["test"].typeName)
+	assert.Equal(t, uint8(unix.NFPROTO_IPV4), sets["test"].family)
+	assert.Len(t, sets["test"].members, 2)
+	assert.Contains(t, sets["test"].members, "10.0.0.0/24")
+	assert.Contains(t, sets["test"].members, "192.168.1.1/32")
+	assert.Equal(t, []string{"10.0.0.0/24", "192.168.1.1/32"}, sets["test"].memberKeys())
+}
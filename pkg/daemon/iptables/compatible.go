@@ -52,6 +52,32 @@ func (mgr *Manager) cleanDeprecatedBasicRuleAndChains() error {
 	return nil
 }
 
+// cleanBasicRuleAndChains removes all the hybridnet chains and rules, it is used while
+// switching to nftables backend.
+func (mgr *Manager) cleanBasicRuleAndChains() error {
+	if err := mgr.ensureCleanBasicRuleAndChain(TableNAT, ChainHybridnetPostRouting, ChainPostRouting,
+		generateHybridnetPostRoutingBaseRuleSpec()...); err != nil {
+		return fmt.Errorf("failed to ensule %v chain and rules deleted in %v table: %v", ChainHybridnetPostRouting, TableNAT, err)
+	}
+
+	if err := mgr.ensureCleanBasicRuleAndChain(TableFilter, ChainHybridnetForward, ChainForward,
+		generateHybridnetForwardBaseRuleSpec()...); err != nil {
+		return fmt.Errorf("failed to ensule %v chain and rules deleted in %v table: %v", ChainHybridnetForward, TableFilter, err)
+	}
+
+	if err := mgr.ensureCleanBasicRuleAndChain(TableMangle, ChainHybridnetPreRouting, ChainPreRouting,
+		generateHybridnetPreRoutingBaseRuleSpec()...); err != nil {
+		return fmt.Errorf("failed to ensule %v chain and rules deleted in %v table: %v", ChainHybridnetPreRouting, TableMangle, err)
+	}
+
+	if err := mgr.ensureCleanBasicRuleAndChain(TableMangle, ChainHybridnetPostRouting, ChainPostRouting,
+		generateHybridnetPostRoutingBaseRuleSpec()...); err != nil {
+		return fmt.Errorf("failed to ensule %v chain and rules deleted in %v table: %v", ChainHybridnetPostRouting, TableMangle, err)
+	}
+
+	return nil
+}
+
 func (mgr *Manager) ensureCleanBasicRuleAndChain(table utiliptables.Table, chain, higherChain utiliptables.Chain, args ...string) error {
 	// ensure base "RAMA-XXX" chains in used tables, iptables -C need it to check if rule exist
 	if _, err := mgr.executor.EnsureChain(table, chain); err != nil {
@@ -18,8 +18,11 @@ package iptables
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 
@@ -63,19 +66,41 @@ const (
 	ProtocolIpv6
 )
 
+// Interface syncs host rules of hybridnet, iptables and nftables backends are supported.
+type Interface interface {
+	Reset()
+	RecordNodeIP(nodeIP net.IP)
+	RecordLocalPodIP(podIP net.IP)
+	RecordSubnet(subnetCidr *net.IPNet, isOverlay, isLocalBGP bool)
+	RecordRemoteNodeIP(nodeIP net.IP)
+	RecordRemoteSubnet(subnetCidr *net.IPNet, isOverlay bool)
+	SetOverlayIfName(overlayIfName string)
+	SetOverlayDirectRouting(enabled bool)
+	SetBgpIfName(bgpIfName string)
+
+	// SyncRules syncs recorded information to host rules, it will be skipped if nothing changes
+	// since the last successful sync and the rules are not found tampered by RulesTampered.
+	SyncRules() error
+
+	// RulesTampered checks whether the rules synced last time are modified by others, it is cheap
+	// enough to be called frequently.
+	RulesTampered() (bool, error)
+}
+
+// Backend defines the backend of rule manager
+type Backend string
+
+const (
+	BackendAuto     Backend = "auto"
+	BackendIPtables Backend = "iptables"
+	BackendNftables Backend = "nftables"
+)
+
 type Manager struct {
 	executor utiliptables.Interface
 	helper   *extraliptables.IPTables
 
-	localClusterOverlaySubnets  []*net.IPNet
-	localClusterUnderlaySubnets []*net.IPNet
-	localBGPSubnets             []*net.IPNet
-
-	nodeIPList     []net.IP
-	localPodIPList []net.IP
-
-	overlayIfName string
-	bgpIfName     string
+	recorder
 
 	protocol Protocol
 
@@ -83,10 +108,15 @@ type Manager struct {
 
 	upgradeWorkDone bool
 
-	// add cluster-mesh remote ips
-	remoteClusterOverlaySubnets  []*net.IPNet
-	remoteClusterUnderlaySubnets []*net.IPNet
-	remoteNodeIPList             []net.IP
+	// hash of recorded information and checksum of rules of the last successful sync
+	lastSyncedHash    string
+	lastRulesChecksum string
+
+	// tampered is set if rules synced are modified by others, next sync will not be skipped
+	tampered bool
+
+	// listIPSetMembers lists members of ipsets for checksum, it is nil if ipsets are not synced through netlink
+	listIPSetMembers func(setName string) ([]string, bool, error)
 }
 
 func (mgr *Manager) lock() {
@@ -97,7 +127,24 @@ func (mgr *Manager) unlock() {
 	<-mgr.c
 }
 
-func CreateIPtablesManager(protocol Protocol) (*Manager, error) {
+// CreateIPtablesManager creates a rule manager of backend, the backend will be detected
+// automatically if it is BackendAuto.
+func CreateIPtablesManager(protocol Protocol, backend Backend) (Interface, error) {
+	if backend == BackendAuto {
+		backend = DetectBackend()
+	}
+
+	switch backend {
+	case BackendIPtables:
+		return createIPtablesManager(protocol)
+	case BackendNftables:
+		return createNftablesManager(protocol)
+	default:
+		return nil, fmt.Errorf("rule backend %v not supported", backend)
+	}
+}
+
+func createIPtablesManager(protocol Protocol) (*Manager, error) {
 	// Create a iptables utils.
 	execer := exec.New()
 
@@ -127,95 +174,35 @@ func CreateIPtablesManager(protocol Protocol) (*Manager, error) {
 		executor: iptInterface,
 		helper:   helper,
 
-		localClusterOverlaySubnets:  []*net.IPNet{},
-		localClusterUnderlaySubnets: []*net.IPNet{},
-		nodeIPList:                  []net.IP{},
+		recorder: newRecorder(),
 
 		protocol: protocol,
 		c:        make(chan struct{}, 1),
-
-		remoteClusterOverlaySubnets:  []*net.IPNet{},
-		remoteClusterUnderlaySubnets: []*net.IPNet{},
-		remoteNodeIPList:             []net.IP{},
 	}
 
 	return mgr, nil
 }
 
-func (mgr *Manager) Reset() {
-	mgr.localClusterOverlaySubnets = []*net.IPNet{}
-	mgr.localClusterUnderlaySubnets = []*net.IPNet{}
-	mgr.localBGPSubnets = []*net.IPNet{}
-	mgr.nodeIPList = []net.IP{}
-	mgr.localPodIPList = []net.IP{}
-	mgr.overlayIfName = ""
-
-	mgr.remoteClusterOverlaySubnets = []*net.IPNet{}
-	mgr.remoteClusterUnderlaySubnets = []*net.IPNet{}
-	mgr.remoteNodeIPList = []net.IP{}
-}
-
-func (mgr *Manager) RecordNodeIP(nodeIP net.IP) {
-	mgr.nodeIPList = append(mgr.nodeIPList, nodeIP)
-}
-
-func (mgr *Manager) RecordLocalPodIP(podIP net.IP) {
-	mgr.localPodIPList = append(mgr.localPodIPList, podIP)
-}
-
-func (mgr *Manager) RecordSubnet(subnetCidr *net.IPNet, isOverlay, isLocalBGP bool) {
-	if isOverlay {
-		mgr.localClusterOverlaySubnets = append(mgr.localClusterOverlaySubnets, subnetCidr)
-	} else {
-		mgr.localClusterUnderlaySubnets = append(mgr.localClusterUnderlaySubnets, subnetCidr)
-		if isLocalBGP {
-			mgr.localBGPSubnets = append(mgr.localBGPSubnets, subnetCidr)
-		}
-	}
-}
-
-func (mgr *Manager) RecordRemoteNodeIP(nodeIP net.IP) {
-	mgr.remoteNodeIPList = append(mgr.remoteNodeIPList, nodeIP)
-}
-
-func (mgr *Manager) RecordRemoteSubnet(subnetCidr *net.IPNet, isOverlay bool) {
-	if isOverlay {
-		mgr.remoteClusterOverlaySubnets = append(mgr.remoteClusterOverlaySubnets, subnetCidr)
-	} else {
-		mgr.remoteClusterUnderlaySubnets = append(mgr.remoteClusterUnderlaySubnets, subnetCidr)
-	}
-}
-
-func (mgr *Manager) SetOverlayIfName(overlayIfName string) {
-	mgr.overlayIfName = overlayIfName
-}
-
-func (mgr *Manager) SetBgpIfName(bgpIfName string) {
-	mgr.bgpIfName = bgpIfName
-}
-
 func (mgr *Manager) SyncRules() error {
 	mgr.lock()
 	defer mgr.unlock()
 
-	overlayIPNets := generateStringsFromIPNets(mgr.localClusterOverlaySubnets)
-	nodeIPs := generateStringsFromIPs(mgr.nodeIPList)
-	allIPNets := generateStringsFromIPNets(mgr.localClusterUnderlaySubnets)
+	overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs := mgr.generateSetMembers()
 
-	localBGPIPNets := generateStringsFromIPNets(mgr.localBGPSubnets)
-	localPodIPs := generateStringsFromIPs(mgr.localPodIPList)
-
-	// remote subnets & nodes
-	overlayIPNets = append(overlayIPNets, generateStringsFromIPNets(mgr.remoteClusterOverlaySubnets)...)
-	allIPNets = append(allIPNets, generateStringsFromIPNets(mgr.remoteClusterUnderlaySubnets)...)
-	nodeIPs = append(nodeIPs, generateStringsFromIPs(mgr.remoteNodeIPList)...)
-
-	allIPNets = append(allIPNets, overlayIPNets...)
-	allIPNets = append(allIPNets, nodeIPs...)
+	desiredStateHash := hashDesiredState(mgr.overlayIfName, mgr.bgpIfName, mgr.overlayDirectRouting,
+		overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs)
+	if desiredStateHash == mgr.lastSyncedHash && !mgr.tampered {
+		return nil
+	}
 
-	ipsetInterface, err := ipset.New(mgr.protocol == ProtocolIpv6)
+	mgr.listIPSetMembers = ipset.ListNetlinkSetMembers
+	ipsetInterface, err := ipset.NewNetlink(mgr.protocol == ProtocolIpv6)
 	if err != nil {
-		return fmt.Errorf("failed to create ipset instance: %v", err)
+		// fall back to ipset command if ipset netlink protocol is not supported
+		mgr.listIPSetMembers = nil
+		if ipsetInterface, err = ipset.New(mgr.protocol == ProtocolIpv6); err != nil {
+			return fmt.Errorf("failed to create ipset instance: %v", err)
+		}
 	}
 
 	if err := ipsetInterface.LoadData(); err != nil {
@@ -237,6 +224,38 @@ func (mgr *Manager) SyncRules() error {
 		return fmt.Errorf("failed to ensure basic rules and chains: %v", err)
 	}
 
+	// Sync ipsets
+	if err := ipsetInterface.SyncOperations(); err != nil {
+		return fmt.Errorf("failed to execute sync ipset operations: %v", err)
+	}
+
+	// Sync rules
+	iptablesData := generateIPtablesRules(mgr.protocol, mgr.overlayIfName, mgr.bgpIfName, mgr.overlayDirectRouting)
+	if err := mgr.executor.RestoreAll(iptablesData, utiliptables.NoFlushTables,
+		utiliptables.RestoreCounters); err != nil {
+		return fmt.Errorf("failed to execute iptables-restore: " + err.Error() +
+			"\n iptables rules are:\n " + string(iptablesData))
+	}
+
+	// TODO: update logic, need to be removed further
+	if !mgr.upgradeWorkDone {
+		if err := mgr.cleanDeprecatedBasicRuleAndChains(); err != nil {
+			return fmt.Errorf("failed to clean deprecated basic rules: %v", err)
+		}
+		mgr.upgradeWorkDone = true
+	}
+
+	rulesChecksum, err := mgr.rulesChecksum()
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum of rules: %v", err)
+	}
+	mgr.lastSyncedHash, mgr.lastRulesChecksum, mgr.tampered = desiredStateHash, rulesChecksum, false
+
+	return nil
+}
+
+// generateIPtablesRules generates iptables-restore data of hybridnet chains in nat, filter and mangle tables.
+func generateIPtablesRules(protocol Protocol, overlayIfName, bgpIfName string, overlayDirectRouting bool) []byte {
 	iptablesData := bytes.NewBuffer(nil)
 	filterChains := bytes.NewBuffer(nil)
 	filterRules := bytes.NewBuffer(nil)
@@ -255,7 +274,7 @@ func (mgr *Manager) SyncRules() error {
 	writeLine(mangleChains, utiliptables.MakeChainLine(ChainHybridnetPreRouting))
 	writeLine(mangleChains, utiliptables.MakeChainLine(ChainHybridnetPostRouting))
 
-	if len(mgr.overlayIfName) != 0 {
+	if len(overlayIfName) != 0 {
 		// There might be two scenarios where overlayIfName is nil
 		// 1. overlay network never exists
 		// 2. overlay network deleted after running for a period
@@ -265,14 +284,17 @@ func (mgr *Manager) SyncRules() error {
 		// Append rules.
 		writeLine(natRules, generateSkipMasqueradeRuleSpec()...)
 		writeLine(natRules, generateOldSkipMasqueradeRuleSpec()...)
-		writeLine(natRules, generateMasqueradeRuleSpec(mgr.overlayIfName, mgr.protocol)...)
-		writeLine(filterRules, generateVxlanFilterRuleSpec(mgr.overlayIfName, mgr.protocol)...)
-		writeLine(mangleRules, generateVxlanPodToNodeReplyMarkRuleSpec(mgr.protocol)...)
-		writeLine(mangleRules, generateVxlanPodToNodeReplyRemoveMarkRuleSpec(mgr.protocol)...)
+		if overlayDirectRouting {
+			writeLine(natRules, generateSkipMasqueradeToOverlayRuleSpec(protocol)...)
+		}
+		writeLine(natRules, generateMasqueradeRuleSpec(overlayIfName, protocol)...)
+		writeLine(filterRules, generateVxlanFilterRuleSpec(overlayIfName, protocol)...)
+		writeLine(mangleRules, generateVxlanPodToNodeReplyMarkRuleSpec(protocol)...)
+		writeLine(mangleRules, generateVxlanPodToNodeReplyRemoveMarkRuleSpec(protocol)...)
 	}
 
-	if len(mgr.bgpIfName) != 0 {
-		writeLine(filterRules, generateBGPEndLoopRuleSpec(mgr.bgpIfName, mgr.protocol)...)
+	if len(bgpIfName) != 0 {
+		writeLine(filterRules, generateBGPEndLoopRuleSpec(bgpIfName, protocol)...)
 	}
 
 	// Write the end-of-table markers
@@ -280,12 +302,6 @@ func (mgr *Manager) SyncRules() error {
 	writeLine(filterRules, "COMMIT")
 	writeLine(mangleRules, "COMMIT")
 
-	// Sync ipsets
-	if err := ipsetInterface.SyncOperations(); err != nil {
-		return fmt.Errorf("failed to execute sync ipset operations: %v", err)
-	}
-
-	// Sync rules
 	iptablesData.Write(natChains.Bytes())
 	iptablesData.Write(natRules.Bytes())
 	iptablesData.Write(filterChains.Bytes())
@@ -293,21 +309,68 @@ func (mgr *Manager) SyncRules() error {
 	iptablesData.Write(mangleChains.Bytes())
 	iptablesData.Write(mangleRules.Bytes())
 
-	if err := mgr.executor.RestoreAll(iptablesData.Bytes(), utiliptables.NoFlushTables,
-		utiliptables.RestoreCounters); err != nil {
-		return fmt.Errorf("failed to execute iptables-restore: " + err.Error() +
-			"\n iptables rules are:\n " + iptablesData.String())
+	return iptablesData.Bytes()
+}
+
+// RulesTampered checks if rules synced are modified by others, the next sync will not be skipped if they are.
+func (mgr *Manager) RulesTampered() (bool, error) {
+	mgr.lock()
+	defer mgr.unlock()
+
+	if len(mgr.lastRulesChecksum) == 0 || mgr.tampered {
+		return false, nil
 	}
 
-	// TODO: update logic, need to be removed further
-	if !mgr.upgradeWorkDone {
-		if err := mgr.cleanDeprecatedBasicRuleAndChains(); err != nil {
-			return fmt.Errorf("failed to clean deprecated basic rules: %v", err)
+	rulesChecksum, err := mgr.rulesChecksum()
+	if err != nil {
+		return false, err
+	}
+
+	mgr.tampered = rulesChecksum != mgr.lastRulesChecksum
+	return mgr.tampered, nil
+}
+
+// rulesChecksum calculates checksum of hybridnet chains, the rules jumping to them and members
+// of hybridnet ipsets, which are listed through netlink.
+func (mgr *Manager) rulesChecksum() (string, error) {
+	hash := sha256.New()
+	for _, table := range []utiliptables.Table{TableNAT, TableFilter, TableMangle} {
+		tableData := bytes.NewBuffer(nil)
+		if err := mgr.executor.SaveInto(table, tableData); err != nil {
+			return "", fmt.Errorf("failed to save table %v: %v", table, err)
+		}
+
+		for _, line := range strings.Split(tableData.String(), "\n") {
+			if !strings.Contains(line, "HYBRIDNET") {
+				continue
+			}
+			// counters of chain declaration change all the time
+			if strings.HasPrefix(line, ":") {
+				line = strings.Fields(line)[0]
+			}
+			hash.Write([]byte(line + "\n"))
 		}
-		mgr.upgradeWorkDone = true
 	}
 
-	return nil
+	if mgr.listIPSetMembers != nil {
+		for _, setBaseName := range []string{HybridnetOverlayNetSetName, HybridnetAllIPSetName, HybridnetNodeIPSetName,
+			HybridnetLocalBGPNetSetName, HybridnetLocalPodIPSetName} {
+			setName := generateIPSetNameByProtocol(setBaseName, mgr.protocol)
+			members, exist, err := mgr.listIPSetMembers(setName)
+			if err != nil {
+				return "", fmt.Errorf("failed to list members of ipset %v: %v", setName, err)
+			}
+			if !exist {
+				continue
+			}
+
+			hash.Write([]byte(setName + "\n"))
+			for _, member := range members {
+				hash.Write([]byte(member + "\n"))
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func (mgr *Manager) ensureBasicRuleAndChains() error {
@@ -390,6 +453,11 @@ func generateOldSkipMasqueradeRuleSpec() []string {
 		"-o", "h_+", "-j", "RETURN"}
 }
 
+func generateSkipMasqueradeToOverlayRuleSpec(protocol Protocol) []string {
+	return []string{"-A", ChainHybridnetPostRouting, "-m", "comment", "--comment", `"skip masquerade if traffic is to overlay pod directly"`,
+		"-m", "set", "--match-set", generateIPSetNameByProtocol(HybridnetOverlayNetSetName, protocol), "dst", "-j", "RETURN"}
+}
+
 func generateVxlanFilterRuleSpec(vxlanIf string, protocol Protocol) []string {
 	return []string{"-A", ChainHybridnetForward, "-m", "comment", "--comment", `"hybridnet overlay vxlan if egress filter rule"`,
 		"-o", vxlanIf, "-m", "set", "!", "--match-set", generateIPSetNameByProtocol(HybridnetAllIPSetName, protocol),
@@ -0,0 +1,178 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iptables
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	fakeiptables "k8s.io/kubernetes/pkg/util/iptables/testing"
+)
+
+const testIPTablesSave = `*nat
+:POSTROUTING ACCEPT [10:600]
+:HYBRIDNET-POSTROUTING - [0:0]
+-A POSTROUTING -m comment --comment "hybridnet postrouting rules" -j HYBRIDNET-POSTROUTING
+-A POSTROUTING -j OTHERS
+COMMIT
+`
+
+func TestRulesChecksum(t *testing.T) {
+	nodeIPSetName := generateIPSetNameByProtocol(HybridnetNodeIPSetName, ProtocolIpv4)
+	baseIPSets := map[string][]string{
+		nodeIPSetName: {"172.16.0.1", "172.16.0.2"},
+		"OTHERS":      nil,
+	}
+
+	tests := []struct {
+		name          string
+		iptablesSave  string
+		ipsets        map[string][]string
+		expectChanged bool
+	}{
+		{
+			name:         "nothing changes",
+			iptablesSave: testIPTablesSave,
+			ipsets:       baseIPSets,
+		},
+		{
+			name: "counters and rules of others change",
+			iptablesSave: "*nat\n:POSTROUTING ACCEPT [20:1200]\n:HYBRIDNET-POSTROUTING - [0:0]\n" +
+				"-A POSTROUTING -m comment --comment \"hybridnet postrouting rules\" -j HYBRIDNET-POSTROUTING\nCOMMIT\n",
+			ipsets: baseIPSets,
+		},
+		{
+			name:         "members of other ipsets change",
+			iptablesSave: testIPTablesSave,
+			ipsets: map[string][]string{
+				nodeIPSetName: {"172.16.0.1", "172.16.0.2"},
+				"OTHERS":      {"10.0.0.1"},
+			},
+		},
+		{
+			name:          "hybridnet rule is deleted",
+			iptablesSave:  "*nat\n:POSTROUTING ACCEPT [10:600]\n:HYBRIDNET-POSTROUTING - [0:0]\nCOMMIT\n",
+			ipsets:        baseIPSets,
+			expectChanged: true,
+		},
+		{
+			name:         "member of hybridnet ipset is deleted",
+			iptablesSave: testIPTablesSave,
+			ipsets: map[string][]string{
+				nodeIPSetName: {"172.16.0.1"},
+				"OTHERS":      nil,
+			},
+			expectChanged: true,
+		},
+		{
+			name:         "hybridnet ipset is flushed",
+			iptablesSave: testIPTablesSave,
+			ipsets: map[string][]string{
+				nodeIPSetName: {},
+				"OTHERS":      nil,
+			},
+			expectChanged: true,
+		},
+		{
+			name:         "hybridnet ipset is destroyed",
+			iptablesSave: testIPTablesSave,
+			ipsets: map[string][]string{
+				"OTHERS": nil,
+			},
+			expectChanged: true,
+		},
+	}
+
+	checksum := func(iptablesSave string, ipsets map[string][]string) string {
+		mgr := &Manager{
+			executor: &fakeiptables.FakeIPTables{Lines: []byte(iptablesSave)},
+			protocol: ProtocolIpv4,
+			listIPSetMembers: func(setName string) ([]string, bool, error) {
+				members, exist := ipsets[setName]
+				return members, exist, nil
+			},
+		}
+
+		result, err := mgr.rulesChecksum()
+		assert.NoError(t, err)
+		return result
+	}
+
+	baseChecksum := checksum(testIPTablesSave, baseIPSets)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectChanged, checksum(test.iptablesSave, test.ipsets) != baseChecksum)
+		})
+	}
+}
+
+func TestGenerateIPtablesRules(t *testing.T) {
+	tests := []struct {
+		name                 string
+		protocol             Protocol
+		overlayIfName        string
+		bgpIfName            string
+		overlayDirectRouting bool
+		golden               string
+	}{
+		{
+			name:          "ipv4 overlay",
+			protocol:      ProtocolIpv4,
+			overlayIfName: "eth0.vxlan4",
+			golden:        "iptables-ipv4-overlay.golden",
+		},
+		{
+			name:                 "ipv4 overlay with direct routing",
+			protocol:             ProtocolIpv4,
+			overlayIfName:        "eth0.vxlan4",
+			overlayDirectRouting: true,
+			golden:               "iptables-ipv4-overlay-direct-routing.golden",
+		},
+		{
+			name:                 "ipv6 overlay with direct routing and bgp",
+			protocol:             ProtocolIpv6,
+			overlayIfName:        "eth0.vxlan6",
+			bgpIfName:            "eth1",
+			overlayDirectRouting: true,
+			golden:               "iptables-ipv6-overlay-direct-routing-bgp.golden",
+		},
+		{
+			name:                 "direct routing without overlay network",
+			protocol:             ProtocolIpv4,
+			bgpIfName:            "eth1",
+			overlayDirectRouting: true,
+			golden:               "iptables-ipv4-bgp.golden",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := generateIPtablesRules(test.protocol, test.overlayIfName, test.bgpIfName, test.overlayDirectRouting)
+
+			goldenPath := filepath.Join("testdata", test.golden)
+			if *updateGolden {
+				assert.NoError(t, ioutil.WriteFile(goldenPath, rules, 0644))
+			}
+
+			expected, err := ioutil.ReadFile(goldenPath)
+			assert.NoError(t, err)
+			assert.Equal(t, string(expected), string(rules))
+		})
+	}
+}
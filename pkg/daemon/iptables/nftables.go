@@ -0,0 +1,321 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iptables
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"k8s.io/utils/exec"
+
+	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
+)
+
+const (
+	NftablesTableName = "hybridnet"
+
+	NftablesOverlayNetSetName  = "overlay-net"
+	NftablesAllIPSetName       = "all"
+	NftablesNodeIPSetName      = "node-ip"
+	NftablesLocalPodIPSetName  = "local-pod-ip"
+	NftablesLocalBGPNetSetName = "local-bgp-net"
+
+	NftablesChainNATPostRouting    = "nat-postrouting"
+	NftablesChainFilterForward     = "filter-forward"
+	NftablesChainManglePreRouting  = "mangle-prerouting"
+	NftablesChainManglePostRouting = "mangle-postrouting"
+)
+
+// NftablesManager is the nftables implementation of Interface, all the rules and sets are kept
+// in a dedicated table and replaced atomically in one nft transaction.
+type NftablesManager struct {
+	executor exec.Interface
+
+	recorder
+
+	protocol Protocol
+
+	c chan struct{}
+
+	iptablesCleaned bool
+
+	// hash of recorded information and checksum of rules of the last successful sync
+	lastSyncedHash    string
+	lastRulesChecksum string
+
+	// tampered is set if rules synced are modified by others, next sync will not be skipped
+	tampered bool
+}
+
+func createNftablesManager(protocol Protocol) (*NftablesManager, error) {
+	if protocol != ProtocolIpv4 && protocol != ProtocolIpv6 {
+		return nil, fmt.Errorf("nftables family %v not supported", protocol)
+	}
+
+	execer := exec.New()
+	if _, err := execer.LookPath("nft"); err != nil {
+		return nil, fmt.Errorf("nft command not found: %v", err)
+	}
+
+	return &NftablesManager{
+		executor: execer,
+		recorder: newRecorder(),
+		protocol: protocol,
+		c:        make(chan struct{}, 1),
+	}, nil
+}
+
+// DetectBackend returns the backend which should be used on this host. iptables is preferred
+// for compatibility, nftables will be used only if there is no iptables command.
+func DetectBackend() Backend {
+	execer := exec.New()
+	if _, err := execer.LookPath("iptables"); err != nil {
+		if _, err = execer.LookPath("nft"); err == nil {
+			return BackendNftables
+		}
+	}
+	return BackendIPtables
+}
+
+func (mgr *NftablesManager) lock() {
+	mgr.c <- struct{}{}
+}
+
+func (mgr *NftablesManager) unlock() {
+	<-mgr.c
+}
+
+func (mgr *NftablesManager) SyncRules() error {
+	mgr.lock()
+	defer mgr.unlock()
+
+	overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs := mgr.generateSetMembers()
+
+	desiredStateHash := hashDesiredState(mgr.overlayIfName, mgr.bgpIfName, mgr.overlayDirectRouting,
+		overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs)
+	if desiredStateHash == mgr.lastSyncedHash && !mgr.tampered {
+		return nil
+	}
+
+	nftablesData := generateNftablesRules(mgr.protocol, mgr.overlayIfName, mgr.bgpIfName, mgr.overlayDirectRouting,
+		overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs)
+
+	cmd := mgr.executor.Command("nft", "-f", "-")
+	cmd.SetStdin(bytes.NewReader(nftablesData))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to execute nft: " + err.Error() + ", output: " + string(output) +
+			"\n nftables rules are:\n " + string(nftablesData))
+	}
+
+	// rules created by iptables backend will conflict with the ones of nftables
+	if !mgr.iptablesCleaned {
+		if err := mgr.cleanIPtablesRules(); err != nil {
+			return fmt.Errorf("failed to clean iptables rules: %v", err)
+		}
+		mgr.iptablesCleaned = true
+	}
+
+	rulesChecksum, err := mgr.rulesChecksum()
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum of rules: %v", err)
+	}
+	mgr.lastSyncedHash, mgr.lastRulesChecksum, mgr.tampered = desiredStateHash, rulesChecksum, false
+
+	return nil
+}
+
+// RulesTampered checks if rules synced are modified by others, the next sync will not be skipped if they are.
+func (mgr *NftablesManager) RulesTampered() (bool, error) {
+	mgr.lock()
+	defer mgr.unlock()
+
+	if len(mgr.lastRulesChecksum) == 0 || mgr.tampered {
+		return false, nil
+	}
+
+	rulesChecksum, err := mgr.rulesChecksum()
+	if err != nil {
+		return false, err
+	}
+
+	mgr.tampered = rulesChecksum != mgr.lastRulesChecksum
+	return mgr.tampered, nil
+}
+
+// rulesChecksum calculates checksum of the whole hybridnet table, including members of sets.
+func (mgr *NftablesManager) rulesChecksum() (string, error) {
+	output, err := mgr.executor.Command("nft", "list", "table", nftablesFamily(mgr.protocol), NftablesTableName).CombinedOutput()
+	if err != nil {
+		// table might be deleted by others
+		if strings.Contains(string(output), "No such file or directory") {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to list table %v: %v, output: %v", NftablesTableName, err, string(output))
+	}
+
+	hash := sha256.Sum256(output)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func (mgr *NftablesManager) cleanIPtablesRules() error {
+	if _, err := mgr.executor.LookPath("iptables"); err != nil {
+		return nil
+	}
+
+	iptablesManager, err := createIPtablesManager(mgr.protocol)
+	if err != nil {
+		return err
+	}
+
+	return iptablesManager.cleanBasicRuleAndChains()
+}
+
+// generateNftablesRules generates a nft script which replaces the whole hybridnet table atomically,
+// the semantics of rules are the same as the ones of iptables backend.
+func generateNftablesRules(protocol Protocol, overlayIfName, bgpIfName string, overlayDirectRouting bool,
+	overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs []string) []byte {
+	family, addrType, rejectWith := nftablesFamily(protocol), "ipv4_addr", "icmp type host-unreachable"
+	if protocol == ProtocolIpv6 {
+		addrType, rejectWith = "ipv6_addr", "icmpv6 type addr-unreachable"
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	// Declare the table before deleting it to make sure deletion never fails,
+	// the whole script is applied in one transaction.
+	writeLine(buf, "table", family, NftablesTableName)
+	writeLine(buf, "delete", "table", family, NftablesTableName)
+	writeLine(buf, "table", family, NftablesTableName, "{")
+
+	writeNftablesSet(buf, NftablesOverlayNetSetName, addrType, true, overlayIPNets)
+	writeNftablesSet(buf, NftablesAllIPSetName, addrType, true, allIPNets)
+	writeNftablesSet(buf, NftablesNodeIPSetName, addrType, false, nodeIPs)
+	writeNftablesSet(buf, NftablesLocalBGPNetSetName, addrType, true, localBGPIPNets)
+	writeNftablesSet(buf, NftablesLocalPodIPSetName, addrType, false, localPodIPs)
+
+	var natPostRoutingRules, filterForwardRules, manglePreRoutingRules, manglePostRoutingRules [][]string
+
+	if len(overlayIfName) != 0 {
+		natPostRoutingRules = append(natPostRoutingRules,
+			[]string{"oifname", `"` + containernetwork.ContainerHostLinkPrefix + `*"`, "return",
+				"comment", `"skip masquerade if traffic is to local pod"`},
+			// TODO: update logic, need to be removed further
+			[]string{"oifname", `"h_*"`, "return",
+				"comment", `"skip masquerade if traffic is to exist old local pod"`},
+		)
+
+		if overlayDirectRouting {
+			natPostRoutingRules = append(natPostRoutingRules,
+				[]string{family, "daddr", "@" + NftablesOverlayNetSetName, "return",
+					"comment", `"skip masquerade if traffic is to overlay pod directly"`},
+			)
+		}
+
+		natPostRoutingRules = append(natPostRoutingRules,
+			[]string{"oifname", "!=", `"` + overlayIfName + `"`, family, "saddr", "@" + NftablesOverlayNetSetName, "masquerade",
+				"comment", `"hybridnet overlay nat-outgoing masquerade rule"`},
+		)
+
+		filterForwardRules = append(filterForwardRules,
+			[]string{"oifname", `"` + overlayIfName + `"`, family, "daddr", "!=", "@" + NftablesAllIPSetName, "reject", "with", rejectWith,
+				"comment", `"hybridnet overlay vxlan if egress filter rule"`},
+		)
+
+		podToNodeReplyMatches := []string{"fib", "daddr", "type", "!=", "local",
+			family, "saddr", "@" + NftablesOverlayNetSetName, family, "daddr", "@" + NftablesNodeIPSetName,
+			"ct", "state", "{", "established,", "related,", "untracked", "}", "ct", "status", "&", "(snat", "|", "dnat)", "==", "0"}
+
+		manglePreRoutingRules = append(manglePreRoutingRules,
+			concatWords(podToNodeReplyMatches, "meta", "mark", "set", "meta", "mark", "|", PodToNodeBackTrafficMarkString,
+				"comment", `"mark overlay pod -> node back traffic"`),
+		)
+
+		manglePostRoutingRules = append(manglePostRoutingRules,
+			concatWords(podToNodeReplyMatches, "meta", "mark", "set", "meta", "mark", "&", fmt.Sprintf("0x%x", ^uint32(PodToNodeBackTrafficMark)),
+				"comment", `"remove overlay pod -> node back traffic mark"`),
+		)
+	}
+
+	if len(bgpIfName) != 0 {
+		filterForwardRules = append(filterForwardRules,
+			[]string{"iifname", `"` + bgpIfName + `"`, family, "daddr", "!=", "@" + NftablesLocalPodIPSetName,
+				family, "daddr", "@" + NftablesLocalBGPNetSetName, "drop",
+				"comment", `"drop endless bgp traffic because of route loop"`},
+		)
+	}
+
+	writeNftablesChain(buf, NftablesChainNATPostRouting, "nat", "postrouting", "srcnat", natPostRoutingRules)
+	writeNftablesChain(buf, NftablesChainFilterForward, "filter", "forward", "filter", filterForwardRules)
+	writeNftablesChain(buf, NftablesChainManglePreRouting, "filter", "prerouting", "mangle", manglePreRoutingRules)
+	writeNftablesChain(buf, NftablesChainManglePostRouting, "filter", "postrouting", "mangle", manglePostRoutingRules)
+
+	writeLine(buf, "}")
+
+	return buf.Bytes()
+}
+
+func nftablesFamily(protocol Protocol) string {
+	if protocol == ProtocolIpv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func writeNftablesSet(buf *bytes.Buffer, name, addrType string, interval bool, elements []string) {
+	writeLine(buf, "\tset", name, "{")
+	writeLine(buf, "\t\ttype", addrType)
+	if interval {
+		// overlapped cidrs will be merged
+		writeLine(buf, "\t\tflags", "interval")
+		writeLine(buf, "\t\tauto-merge")
+	}
+	if elements = uniqueStrings(elements); len(elements) != 0 {
+		writeLine(buf, "\t\telements", "=", "{", strings.Join(elements, ", "), "}")
+	}
+	writeLine(buf, "\t}")
+}
+
+func writeNftablesChain(buf *bytes.Buffer, name, chainType, hook, priority string, rules [][]string) {
+	writeLine(buf, "\tchain", name, "{")
+	writeLine(buf, "\t\ttype", chainType, "hook", hook, "priority", priority+";", "policy", "accept;")
+	for _, rule := range rules {
+		buf.WriteString("\t\t")
+		writeLine(buf, rule...)
+	}
+	writeLine(buf, "\t}")
+}
+
+func concatWords(words []string, moreWords ...string) []string {
+	result := make([]string, 0, len(words)+len(moreWords))
+	result = append(result, words...)
+	return append(result, moreWords...)
+}
+
+func uniqueStrings(items []string) []string {
+	var result []string
+	existing := map[string]bool{}
+	for _, item := range items {
+		if !existing[item] {
+			existing[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,237 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iptables
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files of generated iptables and nftables rules")
+
+func TestGenerateNftablesRules(t *testing.T) {
+	tests := []struct {
+		name                 string
+		protocol             Protocol
+		overlayIfName        string
+		bgpIfName            string
+		overlayDirectRouting bool
+		overlayIPNets        []string
+		allIPNets            []string
+		nodeIPs              []string
+		localBGPIPNets       []string
+		localPodIPs          []string
+		golden               string
+	}{
+		{
+			name:          "ipv4 overlay",
+			protocol:      ProtocolIpv4,
+			overlayIfName: "eth0.vxlan4",
+			overlayIPNets: []string{"10.0.0.0/16"},
+			allIPNets:     []string{"10.0.0.0/16", "192.168.0.0/24"},
+			nodeIPs:       []string{"172.16.0.1", "172.16.0.2", "172.16.0.1"},
+			localPodIPs:   []string{"10.0.0.5"},
+			golden:        "nftables-ipv4-overlay.golden",
+		},
+		{
+			name:                 "ipv4 overlay with direct routing",
+			protocol:             ProtocolIpv4,
+			overlayIfName:        "eth0.vxlan4",
+			overlayDirectRouting: true,
+			overlayIPNets:        []string{"10.0.0.0/16"},
+			allIPNets:            []string{"10.0.0.0/16"},
+			nodeIPs:              []string{"172.16.0.1"},
+			golden:               "nftables-ipv4-overlay-direct-routing.golden",
+		},
+		{
+			name:           "ipv4 bgp",
+			protocol:       ProtocolIpv4,
+			bgpIfName:      "eth1",
+			allIPNets:      []string{"192.168.0.0/24"},
+			nodeIPs:        []string{"172.16.0.1"},
+			localBGPIPNets: []string{"192.168.0.0/24"},
+			localPodIPs:    []string{"192.168.0.5", "192.168.0.6"},
+			golden:         "nftables-ipv4-bgp.golden",
+		},
+		{
+			name:                 "ipv4 overlay with direct routing and bgp",
+			protocol:             ProtocolIpv4,
+			overlayIfName:        "eth0.vxlan4",
+			bgpIfName:            "eth1",
+			overlayDirectRouting: true,
+			overlayIPNets:        []string{"10.0.0.0/16"},
+			allIPNets:            []string{"10.0.0.0/16", "192.168.0.0/24"},
+			nodeIPs:              []string{"172.16.0.1"},
+			localBGPIPNets:       []string{"192.168.0.0/24"},
+			localPodIPs:          []string{"10.0.0.5", "192.168.0.5"},
+			golden:               "nftables-ipv4-overlay-direct-routing-bgp.golden",
+		},
+		{
+			name:           "ipv6 overlay and bgp",
+			protocol:       ProtocolIpv6,
+			overlayIfName:  "eth0.vxlan6",
+			bgpIfName:      "eth1",
+			overlayIPNets:  []string{"fd00::/64"},
+			allIPNets:      []string{"fd00::/64", "fd01::/64"},
+			nodeIPs:        []string{"fc00::1"},
+			localBGPIPNets: []string{"fd01::/64"},
+			localPodIPs:    []string{"fd00::5", "fd01::5"},
+			golden:         "nftables-ipv6-overlay-bgp.golden",
+		},
+		{
+			name:     "no network",
+			protocol: ProtocolIpv4,
+			golden:   "nftables-empty.golden",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := generateNftablesRules(test.protocol, test.overlayIfName, test.bgpIfName, test.overlayDirectRouting,
+				test.overlayIPNets, test.allIPNets, test.nodeIPs, test.localBGPIPNets, test.localPodIPs)
+
+			goldenPath := filepath.Join("testdata", test.golden)
+			if *updateGolden {
+				assert.NoError(t, ioutil.WriteFile(goldenPath, rules, 0644))
+			}
+
+			expected, err := ioutil.ReadFile(goldenPath)
+			assert.NoError(t, err)
+			assert.Equal(t, string(expected), string(rules))
+		})
+	}
+}
+
+// fakeNftExecutor runs nft commands with fake outputs, the output of "nft list table" is
+// returned from listOutputs in order.
+type fakeNftExecutor struct {
+	fakeexec.FakeExec
+
+	commands    []string
+	listOutputs []string
+}
+
+func newFakeNftExecutor() *fakeNftExecutor {
+	executor := &fakeNftExecutor{}
+	executor.LookPathFunc = func(file string) (string, error) {
+		return "", fmt.Errorf("%v not found", file)
+	}
+	return executor
+}
+
+func (f *fakeNftExecutor) Command(cmd string, args ...string) exec.Cmd {
+	command := strings.Join(append([]string{cmd}, args...), " ")
+	f.commands = append(f.commands, command)
+
+	output := ""
+	if strings.HasPrefix(command, "nft list table") && len(f.listOutputs) != 0 {
+		output, f.listOutputs = f.listOutputs[0], f.listOutputs[1:]
+	}
+
+	return fakeexec.InitFakeCmd(&fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) { return []byte(output), nil, nil },
+		},
+	}, cmd, args...)
+}
+
+func TestNftablesManagerSyncRules(t *testing.T) {
+	const (
+		applyCommand = "nft -f -"
+		listCommand  = "nft list table ip hybridnet"
+	)
+
+	tests := []struct {
+		name          string
+		update        func(mgr *NftablesManager)
+		checkTampered bool
+		tampered      bool
+		listOutputs   []string
+		commands      []string
+	}{
+		{
+			name:        "first sync",
+			listOutputs: []string{"table 1"},
+			commands:    []string{applyCommand, listCommand},
+		},
+		{
+			name:     "skipped if nothing changes",
+			commands: nil,
+		},
+		{
+			name:          "skipped if rules are not tampered",
+			checkTampered: true,
+			tampered:      false,
+			listOutputs:   []string{"table 1"},
+			commands:      []string{listCommand},
+		},
+		{
+			name:          "synced if rules are tampered",
+			checkTampered: true,
+			tampered:      true,
+			listOutputs:   []string{"table 2", "table 1"},
+			commands:      []string{listCommand, applyCommand, listCommand},
+		},
+		{
+			name: "synced if recorded information changes",
+			update: func(mgr *NftablesManager) {
+				mgr.RecordNodeIP(net.ParseIP("172.16.0.2"))
+			},
+			listOutputs: []string{"table 3"},
+			commands:    []string{applyCommand, listCommand},
+		},
+		{
+			name:     "skipped if nothing changes after updating",
+			commands: nil,
+		},
+	}
+
+	executor := newFakeNftExecutor()
+	mgr := &NftablesManager{
+		executor: executor,
+		recorder: newRecorder(),
+		protocol: ProtocolIpv4,
+		c:        make(chan struct{}, 1),
+	}
+	mgr.SetOverlayIfName("eth0.vxlan4")
+	mgr.RecordNodeIP(net.ParseIP("172.16.0.1"))
+
+	// steps depend on the state left by the previous ones
+	for _, test := range tests {
+		executor.commands, executor.listOutputs = nil, test.listOutputs
+		if test.update != nil {
+			test.update(mgr)
+		}
+		if test.checkTampered {
+			tampered, err := mgr.RulesTampered()
+			assert.NoError(t, err, test.name)
+			assert.Equal(t, test.tampered, tampered, test.name)
+		}
+
+		assert.NoError(t, mgr.SyncRules(), test.name)
+		assert.Equal(t, test.commands, executor.commands, test.name)
+	}
+}
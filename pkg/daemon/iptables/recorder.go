@@ -0,0 +1,151 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package iptables
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recorder records the information which rules are generated from, it is shared by
+// iptables and nftables managers.
+type recorder struct {
+	localClusterOverlaySubnets  []*net.IPNet
+	localClusterUnderlaySubnets []*net.IPNet
+	localBGPSubnets             []*net.IPNet
+
+	nodeIPList     []net.IP
+	localPodIPList []net.IP
+
+	overlayIfName string
+	bgpIfName     string
+
+	// if traffic to overlay pods might be routed directly without overlay interface
+	overlayDirectRouting bool
+
+	// add cluster-mesh remote ips
+	remoteClusterOverlaySubnets  []*net.IPNet
+	remoteClusterUnderlaySubnets []*net.IPNet
+	remoteNodeIPList             []net.IP
+}
+
+func newRecorder() recorder {
+	return recorder{
+		localClusterOverlaySubnets:  []*net.IPNet{},
+		localClusterUnderlaySubnets: []*net.IPNet{},
+		nodeIPList:                  []net.IP{},
+
+		remoteClusterOverlaySubnets:  []*net.IPNet{},
+		remoteClusterUnderlaySubnets: []*net.IPNet{},
+		remoteNodeIPList:             []net.IP{},
+	}
+}
+
+func (r *recorder) Reset() {
+	r.localClusterOverlaySubnets = []*net.IPNet{}
+	r.localClusterUnderlaySubnets = []*net.IPNet{}
+	r.localBGPSubnets = []*net.IPNet{}
+	r.nodeIPList = []net.IP{}
+	r.localPodIPList = []net.IP{}
+	r.overlayIfName = ""
+	r.overlayDirectRouting = false
+
+	r.remoteClusterOverlaySubnets = []*net.IPNet{}
+	r.remoteClusterUnderlaySubnets = []*net.IPNet{}
+	r.remoteNodeIPList = []net.IP{}
+}
+
+func (r *recorder) RecordNodeIP(nodeIP net.IP) {
+	r.nodeIPList = append(r.nodeIPList, nodeIP)
+}
+
+func (r *recorder) RecordLocalPodIP(podIP net.IP) {
+	r.localPodIPList = append(r.localPodIPList, podIP)
+}
+
+func (r *recorder) RecordSubnet(subnetCidr *net.IPNet, isOverlay, isLocalBGP bool) {
+	if isOverlay {
+		r.localClusterOverlaySubnets = append(r.localClusterOverlaySubnets, subnetCidr)
+	} else {
+		r.localClusterUnderlaySubnets = append(r.localClusterUnderlaySubnets, subnetCidr)
+		if isLocalBGP {
+			r.localBGPSubnets = append(r.localBGPSubnets, subnetCidr)
+		}
+	}
+}
+
+func (r *recorder) RecordRemoteNodeIP(nodeIP net.IP) {
+	r.remoteNodeIPList = append(r.remoteNodeIPList, nodeIP)
+}
+
+func (r *recorder) RecordRemoteSubnet(subnetCidr *net.IPNet, isOverlay bool) {
+	if isOverlay {
+		r.remoteClusterOverlaySubnets = append(r.remoteClusterOverlaySubnets, subnetCidr)
+	} else {
+		r.remoteClusterUnderlaySubnets = append(r.remoteClusterUnderlaySubnets, subnetCidr)
+	}
+}
+
+func (r *recorder) SetOverlayIfName(overlayIfName string) {
+	r.overlayIfName = overlayIfName
+}
+
+func (r *recorder) SetOverlayDirectRouting(enabled bool) {
+	r.overlayDirectRouting = enabled
+}
+
+func (r *recorder) SetBgpIfName(bgpIfName string) {
+	r.bgpIfName = bgpIfName
+}
+
+// generateSetMembers generates members of HYBRIDNET-OVERLAY-NET, HYBRIDNET-ALL, HYBRIDNET-NODE-IP,
+// HYBRIDNET-LOCAL-BGP-NET and HYBRIDNET-LOCAL-POD-IP sets.
+func (r *recorder) generateSetMembers() (overlayIPNets, allIPNets, nodeIPs, localBGPIPNets, localPodIPs []string) {
+	overlayIPNets = generateStringsFromIPNets(r.localClusterOverlaySubnets)
+	nodeIPs = generateStringsFromIPs(r.nodeIPList)
+	allIPNets = generateStringsFromIPNets(r.localClusterUnderlaySubnets)
+
+	localBGPIPNets = generateStringsFromIPNets(r.localBGPSubnets)
+	localPodIPs = generateStringsFromIPs(r.localPodIPList)
+
+	// remote subnets & nodes
+	overlayIPNets = append(overlayIPNets, generateStringsFromIPNets(r.remoteClusterOverlaySubnets)...)
+	allIPNets = append(allIPNets, generateStringsFromIPNets(r.remoteClusterUnderlaySubnets)...)
+	nodeIPs = append(nodeIPs, generateStringsFromIPs(r.remoteNodeIPList)...)
+
+	allIPNets = append(allIPNets, overlayIPNets...)
+	allIPNets = append(allIPNets, nodeIPs...)
+
+	return
+}
+
+// hashDesiredState calculates the hash of interface names, direct routing flag and set members, which all
+// the rules are generated from.
+func hashDesiredState(overlayIfName, bgpIfName string, overlayDirectRouting bool, setMembers ...[]string) string {
+	hash := sha256.New()
+	hash.Write([]byte(overlayIfName + "\n" + bgpIfName + "\n" + strconv.FormatBool(overlayDirectRouting) + "\n"))
+	for _, members := range setMembers {
+		sortedMembers := uniqueStrings(members)
+		sort.Strings(sortedMembers)
+		hash.Write([]byte(strings.Join(sortedMembers, ",") + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
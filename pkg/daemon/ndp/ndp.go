@@ -17,14 +17,26 @@
 package ndp
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/mdlayher/ethernet"
 	"github.com/mdlayher/ndp"
+	"github.com/mdlayher/raw"
+	"golang.org/x/net/ipv6"
 )
 
-// CheckWithTimeout checks vlan network environment and duplicate ip problems,
+// dadTransmits is the number of neighbor solicitations sent for duplicate address detection,
+// as DupAddrDetectTransmits in RFC 4862.
+const dadTransmits = 3
+
+// protocolICMPv6 is the next header value of ICMPv6 in ipv6 header.
+const protocolICMPv6 = 58
+
+// CheckWithTimeout checks vlan network environment and announces the pod ip,
 // timeout parameter determines how long this function will exactly last.
 func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.Duration) error {
 	// Use link-local address as the source IPv6 address for NDP communications.
@@ -43,12 +55,6 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 			srcIP.String(), gateway.String(), err, ifi.Name)
 	}
 
-	if duplicatedHw, err := doNS(ndpConn, srcPod, ifi.HardwareAddr, timeout); err == nil {
-		return fmt.Errorf("pod ip %v duplicated"+
-			", please check if ip %v is occupied by other machines or containers, another hw addr is %v",
-			srcPod.String(), srcPod.String(), duplicatedHw.String())
-	}
-
 	if err := doGratuitous(ndpConn, srcPod, ifi.HardwareAddr); err != nil {
 		return fmt.Errorf("failed to send gratuitous ndp for pod %v: %v", srcPod.String(), err)
 	}
@@ -56,6 +62,154 @@ func CheckWithTimeout(ifi *net.Interface, srcPod, gateway net.IP, timeout time.D
 	return nil
 }
 
+// SendGratuitous sends unsolicited neighbor advertisement of ip over interface, to make
+// remote neigh cache point to the interface.
+func SendGratuitous(ifi *net.Interface, ip net.IP) error {
+	ndpConn, _, err := ndp.Dial(ifi, ndp.LinkLocal)
+	if err != nil {
+		return fmt.Errorf("failed to ndp dial interface %v: %v", ifi.Name, err)
+	}
+
+	defer func() {
+		_ = ndpConn.Close()
+	}()
+
+	if err := doGratuitous(ndpConn, ip, ifi.HardwareAddr); err != nil {
+		return fmt.Errorf("failed to send gratuitous ndp for %v: %v", ip.String(), err)
+	}
+
+	return nil
+}
+
+// DetectDuplicate runs duplicate address detection (RFC 4862) of ip over interface in timeout, and
+// returns the hw addr of the conflicting host if ip is in use. A nil hw addr means no conflict is found.
+// Advertisements from the interface itself or any of the ignored hw addrs never indicate a conflict.
+func DetectDuplicate(ifi *net.Interface, ip net.IP, timeout time.Duration,
+	ignoredHwAddrs []net.HardwareAddr) (net.HardwareAddr, error) {
+	frame, dstHwAddr, err := newDADFrame(ifi.HardwareAddr, ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build neighbor solicitation for %v: %v", ip.String(), err)
+	}
+
+	// Kernel always picks a unicast source address for icmpv6 sockets, so the solicitation
+	// from unspecified address has to be sent as an ethernet frame built by ourselves.
+	rawConn, err := raw.ListenPacket(ifi, uint16(ethernet.EtherTypeIPv6), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen raw packet on interface %v: %v", ifi.Name, err)
+	}
+
+	defer func() {
+		_ = rawConn.Close()
+	}()
+
+	// The defending host replies to all-nodes multicast group as the solicitation has no
+	// unicast source address, which can be received without any address configured.
+	ndpConn, _, err := ndp.Dial(ifi, ndp.Unspecified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ndp dial interface %v: %v", ifi.Name, err)
+	}
+
+	defer func() {
+		_ = ndpConn.Close()
+	}()
+
+	ignoredHwAddrs = append([]net.HardwareAddr{ifi.HardwareAddr}, ignoredHwAddrs...)
+
+	interval := timeout / dadTransmits
+	for i := 0; i < dadTransmits; i++ {
+		if _, err := rawConn.WriteTo(frame, &raw.Addr{HardwareAddr: dstHwAddr}); err != nil {
+			return nil, fmt.Errorf("failed to send neighbor solicitation for %v: %v", ip.String(), err)
+		}
+
+		if err := ndpConn.SetReadDeadline(time.Now().Add(interval)); err != nil {
+			return nil, fmt.Errorf("failed to set deadline: %v", err)
+		}
+
+		for {
+			msg, _, _, err := ndpConn.ReadFrom()
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, fmt.Errorf("failed to read from ndp connection: %v", err)
+			}
+
+			if duplicatedHw := duplicatedHwAddr(msg, ip, ignoredHwAddrs); duplicatedHw != nil {
+				return duplicatedHw, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// newDADFrame builds the ethernet frame of a neighbor solicitation for duplicate address detection,
+// which is sent from unspecified address to the solicited-node multicast address of target, without
+// any source link-layer address option. The destination hw addr of frame is returned as well.
+func newDADFrame(hwAddr net.HardwareAddr, target net.IP) ([]byte, net.HardwareAddr, error) {
+	snm, err := ndp.SolicitedNodeMulticast(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to determine solicited-node multicast address: %v", err)
+	}
+
+	ns, err := ndp.MarshalMessageChecksum(&ndp.NeighborSolicitation{
+		TargetAddress: target,
+	}, net.IPv6unspecified, snm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal neighbor solicitation: %v", err)
+	}
+
+	packet := make([]byte, ipv6.HeaderLen, ipv6.HeaderLen+len(ns))
+	packet[0] = ipv6.Version << 4
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(ns)))
+	packet[6] = protocolICMPv6
+	// Hop limit of ndp messages must be 255.
+	packet[7] = 255
+	copy(packet[8:24], net.IPv6unspecified)
+	copy(packet[24:40], snm)
+	packet = append(packet, ns...)
+
+	// Multicast hw addr of an ipv6 multicast address is 33:33 followed by its last 32 bits (RFC 2464).
+	dstHwAddr := net.HardwareAddr{0x33, 0x33, snm[12], snm[13], snm[14], snm[15]}
+
+	frame, err := (&ethernet.Frame{
+		Destination: dstHwAddr,
+		Source:      hwAddr,
+		EtherType:   ethernet.EtherTypeIPv6,
+		Payload:     packet,
+	}).MarshalBinary()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal ethernet frame: %v", err)
+	}
+
+	return frame, dstHwAddr, nil
+}
+
+// duplicatedHwAddr returns the target link-layer address of a neighbor advertisement for target,
+// unless it is one of the ignored hw addrs. A nil hw addr means msg indicates no duplication.
+func duplicatedHwAddr(msg ndp.Message, target net.IP, ignoredHwAddrs []net.HardwareAddr) net.HardwareAddr {
+	na, ok := msg.(*ndp.NeighborAdvertisement)
+	if !ok || !na.TargetAddress.Equal(target) {
+		return nil
+	}
+
+	for _, opt := range na.Options {
+		lla, ok := opt.(*ndp.LinkLayerAddress)
+		if !ok || lla.Direction != ndp.Target {
+			continue
+		}
+
+		for _, hwAddr := range ignoredHwAddrs {
+			if bytes.Equal(lla.Addr, hwAddr) {
+				return nil
+			}
+		}
+		return lla.Addr
+	}
+
+	return nil
+}
+
 func doNS(c *ndp.Conn, target net.IP, hwaddr net.HardwareAddr, timeout time.Duration) (net.HardwareAddr, error) {
 
 	// Always multicast the message to the target's solicited-node multicast
@@ -87,7 +241,7 @@ func doNS(c *ndp.Conn, target net.IP, hwaddr net.HardwareAddr, timeout time.Dura
 	for {
 		msg, _, _, err := c.ReadFrom()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read from ndp connection: %v", err)
+			return nil, fmt.Errorf("failed to read from ndp connection: %w", err)
 		}
 
 		// Expect neighbor advertisement messages with the correct target address.
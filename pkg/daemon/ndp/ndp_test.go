@@ -0,0 +1,135 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package ndp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mdlayher/ethernet"
+	"github.com/mdlayher/ndp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestNewDADFrame(t *testing.T) {
+	hwAddr := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x01}
+	target := net.ParseIP("fd00::a:b:c")
+	snm := net.ParseIP("ff02::1:ff0b:c")
+
+	frameBytes, dstHwAddr, err := newDADFrame(hwAddr, target)
+	assert.NoError(t, err)
+	assert.Equal(t, net.HardwareAddr{0x33, 0x33, 0xff, 0x0b, 0x00, 0x0c}, dstHwAddr)
+
+	frame := &ethernet.Frame{}
+	assert.NoError(t, frame.UnmarshalBinary(frameBytes))
+	assert.Equal(t, dstHwAddr, frame.Destination)
+	assert.Equal(t, hwAddr, frame.Source)
+	assert.Equal(t, ethernet.EtherTypeIPv6, frame.EtherType)
+
+	header, err := ipv6.ParseHeader(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, ipv6.Version, header.Version)
+	assert.Equal(t, protocolICMPv6, header.NextHeader)
+	assert.Equal(t, 255, header.HopLimit)
+	assert.True(t, header.Src.Equal(net.IPv6unspecified))
+	assert.True(t, header.Dst.Equal(snm))
+
+	payload := frame.Payload[ipv6.HeaderLen:]
+	assert.Equal(t, header.PayloadLen, len(payload))
+
+	msg, err := ndp.ParseMessage(payload)
+	assert.NoError(t, err)
+	ns, ok := msg.(*ndp.NeighborSolicitation)
+	assert.True(t, ok)
+	assert.True(t, ns.TargetAddress.Equal(target))
+	assert.Empty(t, ns.Options)
+
+	expected, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: payload[4:]},
+	}).Marshal(icmp.IPv6PseudoHeader(net.IPv6unspecified, snm))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, payload)
+}
+
+func TestDuplicatedHwAddr(t *testing.T) {
+	localHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x01}
+	remoteHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x02}
+	nodeHW := net.HardwareAddr{0xee, 0xee, 0xee, 0xee, 0xee, 0x03}
+	target := net.ParseIP("fd00::10")
+	otherIP := net.ParseIP("fd00::11")
+
+	newNA := func(ip net.IP, direction ndp.Direction, hwAddr net.HardwareAddr) *ndp.NeighborAdvertisement {
+		return &ndp.NeighborAdvertisement{
+			Override:      true,
+			TargetAddress: ip,
+			Options: []ndp.Option{
+				&ndp.LinkLayerAddress{
+					Direction: direction,
+					Addr:      hwAddr,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		msg      ndp.Message
+		expected net.HardwareAddr
+	}{
+		{
+			name:     "advertisement from another host",
+			msg:      newNA(target, ndp.Target, remoteHW),
+			expected: remoteHW,
+		},
+		{
+			name:     "advertisement from self",
+			msg:      newNA(target, ndp.Target, localHW),
+			expected: nil,
+		},
+		{
+			name:     "advertisement from another node",
+			msg:      newNA(target, ndp.Target, nodeHW),
+			expected: nil,
+		},
+		{
+			name:     "advertisement for other ip",
+			msg:      newNA(otherIP, ndp.Target, remoteHW),
+			expected: nil,
+		},
+		{
+			name:     "advertisement with source link-layer address",
+			msg:      newNA(target, ndp.Source, remoteHW),
+			expected: nil,
+		},
+		{
+			name: "solicitation for ip",
+			msg: &ndp.NeighborSolicitation{
+				TargetAddress: target,
+			},
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, duplicatedHwAddr(test.msg, target, []net.HardwareAddr{localHW, nodeHW}))
+		})
+	}
+}
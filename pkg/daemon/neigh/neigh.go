@@ -17,10 +17,16 @@
 package neigh
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/vishvananda/netlink"
 )
@@ -32,6 +38,16 @@ type Manager struct {
 
 	// forward interfaces to pod ip list
 	interfaceToIPSliceMap map[string]IPMap
+
+	// hash of pod infos of the last successful sync
+	lastSyncedHash string
+
+	// tampered is set if neighs synced are deleted by others, next sync will not be skipped
+	tampered int32
+
+	// link indexes of forward interfaces to pod ip list synced last time
+	syncedNeighs     map[int]IPMap
+	syncedNeighsLock sync.RWMutex
 }
 
 // Proxy neigh cache will be cleaned if interface is set down-up again.
@@ -40,6 +56,7 @@ func CreateNeighManager(family int) *Manager {
 	return &Manager{
 		family:                family,
 		interfaceToIPSliceMap: make(map[string]IPMap),
+		syncedNeighs:          make(map[int]IPMap),
 	}
 }
 
@@ -55,7 +72,78 @@ func (m *Manager) AddPodInfo(podIP net.IP, forwardNodeIfName string) {
 	m.interfaceToIPSliceMap[forwardNodeIfName][podIP.String()] = podIP
 }
 
+// SyncNeighs syncs proxy neighs of pod infos, it will be skipped if pod infos are not changed since
+// the last successful sync and nothing synced is tampered.
 func (m *Manager) SyncNeighs() error {
+	podInfoHash := m.hashPodInfos()
+
+	// Reset tampered flag before syncing, so that deletions happen during syncing will cause another sync.
+	if !atomic.CompareAndSwapInt32(&m.tampered, 1, 0) && podInfoHash == m.lastSyncedHash {
+		return nil
+	}
+	m.lastSyncedHash = ""
+
+	if err := m.syncNeighs(); err != nil {
+		return err
+	}
+
+	m.lastSyncedHash = podInfoHash
+	return nil
+}
+
+// MarkTampered makes the next sync not to be skipped, returns false if it has been marked.
+func (m *Manager) MarkTampered() bool {
+	return atomic.CompareAndSwapInt32(&m.tampered, 0, 1)
+}
+
+// IsManagedNeigh checks if a neigh is a proxy neigh synced by this manager.
+func (m *Manager) IsManagedNeigh(neigh *netlink.Neigh) bool {
+	if neigh.Flags&netlink.NTF_PROXY == 0 || neigh.IP == nil {
+		return false
+	}
+
+	m.syncedNeighsLock.RLock()
+	defer m.syncedNeighsLock.RUnlock()
+
+	_, exist := m.syncedNeighs[neigh.LinkIndex][neigh.IP.String()]
+	return exist
+}
+
+func (m *Manager) setSyncedNeighs(syncedNeighs map[int]IPMap) {
+	m.syncedNeighsLock.Lock()
+	defer m.syncedNeighsLock.Unlock()
+
+	m.syncedNeighs = syncedNeighs
+}
+
+func (m *Manager) hashPodInfos() string {
+	hash := sha256.New()
+
+	forwardNodeIfNames := make([]string, 0, len(m.interfaceToIPSliceMap))
+	for forwardNodeIfName := range m.interfaceToIPSliceMap {
+		forwardNodeIfNames = append(forwardNodeIfNames, forwardNodeIfName)
+	}
+	sort.Strings(forwardNodeIfNames)
+
+	for _, forwardNodeIfName := range forwardNodeIfNames {
+		ips := make([]string, 0, len(m.interfaceToIPSliceMap[forwardNodeIfName]))
+		for ip := range m.interfaceToIPSliceMap[forwardNodeIfName] {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+
+		hash.Write([]byte(forwardNodeIfName + ":" + strings.Join(ips, ",") + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (m *Manager) syncNeighs() error {
+	// Neighs deleted by this manager itself should not be considered as tampered.
+	syncedNeighs := map[int]IPMap{}
+	m.setSyncedNeighs(map[int]IPMap{})
+	defer m.setSyncedNeighs(syncedNeighs)
+
 	for forwardNodeIfName, ipMap := range m.interfaceToIPSliceMap {
 		forwardNodeIf, err := netlink.LinkByName(forwardNodeIfName)
 		if err != nil {
@@ -98,6 +186,8 @@ func (m *Manager) SyncNeighs() error {
 				}
 			}
 		}
+
+		syncedNeighs[forwardNodeIf.Attrs().Index] = ipMap
 	}
 
 	return nil
@@ -19,6 +19,8 @@ package route
 import (
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 
@@ -39,8 +41,8 @@ import (
 //    |                v (followed with)
 //    |         overlay-mark rule
 //    |                v (followed with)
-//    |     from-every-pod-subnet rules
-//    |                ...
+//    |     from-every-pod-subnet rules (a bgp-import rule is ahead of the
+//    |                ...               rule of bgp subnet importing routes)
 //    |     from-every-pod-subnet rules
 //    |
 //    |
@@ -61,6 +63,9 @@ type Manager struct {
 	// Use fixed table num to mark "overlay-mark-table rule"
 	overlayMarkTableNum int
 
+	// Use fixed table num to mark "bgp-import rule", routes of which are maintained by bgp manager
+	bgpImportTableNum int
+
 	// Vxlan interface name.
 	overlayIfName string
 
@@ -73,9 +78,25 @@ type Manager struct {
 	// add cluster-mesh remote subnet info
 	remoteOverlaySubnetInfoMap  SubnetInfoMap
 	remoteUnderlaySubnetInfoMap SubnetInfoMap
+
+	// routes to overlay pods on nodes in the same subnet, which bypass overlay interface
+	directRouteInfoMap DirectRouteInfoMap
+
+	// hash of subnet infos of the last successful sync
+	lastSyncedHash string
+
+	// tampered is set if rules or routes synced are deleted by others, next sync will not be skipped
+	tampered int32
+
+	// route tables of from-pod-subnet rules and routes synced last time, which are used to
+	// figure out if deleted rules and routes are synced by this manager
+	syncedSubnetTables map[int]bool
+	syncedRoutes       map[string]bool
+	syncedStateLock    sync.RWMutex
 }
 
-func CreateRouteManager(localDirectTableNum, toOverlaySubnetTableNum, overlayMarkTableNum, family int) (*Manager, error) {
+func CreateRouteManager(localDirectTableNum, toOverlaySubnetTableNum, overlayMarkTableNum, bgpImportTableNum,
+	family int) (*Manager, error) {
 	// Check if route tables are being used by others.
 	if empty, err := checkIfRouteTableEmpty(localDirectTableNum, family); err != nil {
 		return nil, fmt.Errorf("failed to check table %v empty: %v", localDirectTableNum, err)
@@ -127,7 +148,11 @@ func CreateRouteManager(localDirectTableNum, toOverlaySubnetTableNum, overlayMar
 					return nil, fmt.Errorf("failed to find overlay interface by index %v: %v", route.LinkIndex, err)
 				}
 
-				if route.Gw != nil || overlayIf.Type() != "vxlan" {
+				if isDirectRoute(&route) {
+					continue
+				}
+
+				if route.Gw != nil || !isOverlayLink(overlayIf) {
 					return nil, fmt.Errorf("to overlay subnet route table %v is used by others", toOverlaySubnetTableNum)
 				}
 			}
@@ -151,7 +176,7 @@ func CreateRouteManager(localDirectTableNum, toOverlaySubnetTableNum, overlayMar
 			return nil, fmt.Errorf("failed to find overlay interface by index %v: %v", routes[0].LinkIndex, err)
 		}
 
-		if routes[0].Dst != nil || routes[0].Gw != nil || overlayIf.Type() != "vxlan" {
+		if routes[0].Dst != nil || routes[0].Gw != nil || !isOverlayLink(overlayIf) {
 			return nil, fmt.Errorf("overlay-mark table %v is used by others", overlayMarkTableNum)
 		}
 	}
@@ -164,12 +189,16 @@ func CreateRouteManager(localDirectTableNum, toOverlaySubnetTableNum, overlayMar
 		localDirectTableNum:               localDirectTableNum,
 		toOverlaySubnetTableNum:           toOverlaySubnetTableNum,
 		overlayMarkTableNum:               overlayMarkTableNum,
+		bgpImportTableNum:                 bgpImportTableNum,
 		family:                            family,
 		localTotalSubnetInfoMap:           SubnetInfoMap{},
 		localClusterOverlaySubnetInfoMap:  SubnetInfoMap{},
 		localClusterUnderlaySubnetInfoMap: SubnetInfoMap{},
 		remoteOverlaySubnetInfoMap:        SubnetInfoMap{},
 		remoteUnderlaySubnetInfoMap:       SubnetInfoMap{},
+		directRouteInfoMap:                DirectRouteInfoMap{},
+		syncedSubnetTables:                map[int]bool{},
+		syncedRoutes:                      map[string]bool{},
 	}, nil
 }
 
@@ -179,10 +208,12 @@ func (m *Manager) ResetInfos() {
 	m.localClusterOverlaySubnetInfoMap = SubnetInfoMap{}
 	m.remoteOverlaySubnetInfoMap = SubnetInfoMap{}
 	m.remoteUnderlaySubnetInfoMap = SubnetInfoMap{}
+	m.directRouteInfoMap = DirectRouteInfoMap{}
 }
 
 func (m *Manager) AddSubnetInfo(cidr *net.IPNet, gateway, start, end net.IP, excludeIPs []net.IP,
-	forwardNodeIfName string, autoNatOutgoing, isOverlay, isUnderlayOnHost bool, mode networkingv1.NetworkMode) {
+	forwardNodeIfName string, autoNatOutgoing, isOverlay, isUnderlayOnHost bool, mode networkingv1.NetworkMode,
+	extraRoutes []*netlink.Route) {
 
 	cidrString := cidr.String()
 
@@ -196,6 +227,7 @@ func (m *Manager) AddSubnetInfo(cidr *net.IPNet, gateway, start, end net.IP, exc
 			excludeIPs:        []net.IP{},
 			isUnderlayOnHost:  isUnderlayOnHost,
 			mode:              mode,
+			extraRoutes:       extraRoutes,
 		}
 	}
 
@@ -277,7 +309,132 @@ func (m *Manager) AddRemoteSubnetInfo(cidr *net.IPNet, gateway, start, end net.I
 	return nil
 }
 
+// EnableBGPRouteImport makes pods of a bgp subnet on this host use the routes learned from bgp peers,
+// it should be called after the subnet info is added.
+func (m *Manager) EnableBGPRouteImport(cidr *net.IPNet) {
+	if subnetInfo, exist := m.localClusterUnderlaySubnetInfoMap[cidr.String()]; exist {
+		subnetInfo.importBGPRoutes = true
+	}
+}
+
+// AddDirectRouteInfo records an overlay pod ip which should be routed directly via the node ip
+// of its node, instead of overlay interface.
+func (m *Manager) AddDirectRouteInfo(podIP, nodeIP net.IP, forwardNodeIfName string) {
+	bits := 8 * net.IPv6len
+	if m.family == netlink.FAMILY_V4 {
+		bits = 8 * net.IPv4len
+	}
+
+	dst := &net.IPNet{IP: podIP, Mask: net.CIDRMask(bits, bits)}
+	m.directRouteInfoMap[dst.String()] = &DirectRouteInfo{
+		dst:               dst,
+		gateway:           nodeIP,
+		forwardNodeIfName: forwardNodeIfName,
+	}
+}
+
+// SyncRoutes syncs rules and routes of subnet infos, it will be skipped if subnet infos are not changed
+// since the last successful sync and nothing synced is tampered.
 func (m *Manager) SyncRoutes() error {
+	subnetInfoHash := hashSubnetInfoMaps(m.overlayIfName, m.directRouteInfoMap, m.localTotalSubnetInfoMap,
+		m.localClusterOverlaySubnetInfoMap, m.localClusterUnderlaySubnetInfoMap,
+		m.remoteOverlaySubnetInfoMap, m.remoteUnderlaySubnetInfoMap)
+
+	// Reset tampered flag before syncing, so that deletions happen during syncing will cause another sync.
+	if !atomic.CompareAndSwapInt32(&m.tampered, 1, 0) && subnetInfoHash == m.lastSyncedHash {
+		return nil
+	}
+	m.lastSyncedHash = ""
+
+	// Rules and routes deleted by this manager itself should not be considered as tampered.
+	m.setSyncedState(map[int]bool{}, map[string]bool{})
+
+	if err := m.syncRoutes(); err != nil {
+		return err
+	}
+
+	if err := m.recordSyncedState(); err != nil {
+		return fmt.Errorf("failed to record synced rules and routes: %v", err)
+	}
+
+	m.lastSyncedHash = subnetInfoHash
+	return nil
+}
+
+// MarkTampered makes the next sync not to be skipped, returns false if it has been marked.
+func (m *Manager) MarkTampered() bool {
+	return atomic.CompareAndSwapInt32(&m.tampered, 0, 1)
+}
+
+// IsManagedRoute checks if a route is synced by this manager. Routes of local-pod-direct
+// table are not included, because they are maintained by kernel.
+func (m *Manager) IsManagedRoute(route *netlink.Route) bool {
+	m.syncedStateLock.RLock()
+	defer m.syncedStateLock.RUnlock()
+
+	return m.syncedRoutes[routeKey(route)]
+}
+
+// IsManagedRule checks if a rule of table is synced by this manager.
+func (m *Manager) IsManagedRule(table int) bool {
+	if table == m.localDirectTableNum || table == m.toOverlaySubnetTableNum || table == m.overlayMarkTableNum ||
+		table == m.bgpImportTableNum {
+		return true
+	}
+
+	m.syncedStateLock.RLock()
+	defer m.syncedStateLock.RUnlock()
+
+	return m.syncedSubnetTables[table]
+}
+
+func (m *Manager) recordSyncedState() error {
+	ruleList, err := netlink.RuleList(m.family)
+	if err != nil {
+		return fmt.Errorf("failed to list rule: %v", err)
+	}
+
+	syncedSubnetTables := map[int]bool{}
+	for _, rule := range ruleList {
+		if rule.Src == nil || rule.Table < MinRouteTableNum || rule.Table >= MaxRouteTableNum {
+			continue
+		}
+
+		if _, exist := m.localTotalSubnetInfoMap[rule.Src.String()]; exist {
+			syncedSubnetTables[rule.Table] = true
+		}
+	}
+
+	tables := []int{m.toOverlaySubnetTableNum, m.overlayMarkTableNum}
+	for table := range syncedSubnetTables {
+		tables = append(tables, table)
+	}
+
+	syncedRoutes := map[string]bool{}
+	for _, table := range tables {
+		routes, err := listRoutesByTable(table, m.family)
+		if err != nil {
+			return err
+		}
+
+		for i := range routes {
+			syncedRoutes[routeKey(&routes[i])] = true
+		}
+	}
+
+	m.setSyncedState(syncedSubnetTables, syncedRoutes)
+	return nil
+}
+
+func (m *Manager) setSyncedState(syncedSubnetTables map[int]bool, syncedRoutes map[string]bool) {
+	m.syncedStateLock.Lock()
+	defer m.syncedStateLock.Unlock()
+
+	m.syncedSubnetTables = syncedSubnetTables
+	m.syncedRoutes = syncedRoutes
+}
+
+func (m *Manager) syncRoutes() error {
 	// Ensure basic rules.
 	if err := appendHighestUnusedPriorityRuleIfNotExist(nil, m.localDirectTableNum, m.family, 0, 0); err != nil {
 		return fmt.Errorf("failed to append local-pod-direct rule: %v", err)
@@ -353,7 +510,7 @@ func (m *Manager) SyncRoutes() error {
 		if err := ensureFromPodSubnetRuleAndRoutes(info.forwardNodeIfName, info.cidr, info.gateway, info.autoNatOutgoing, m.family,
 			combineLocalAndRemoteSubnetInfoMap(m.localClusterUnderlaySubnetInfoMap, m.remoteUnderlaySubnetInfoMap),
 			combineLocalAndRemoteExcludeIPBlockMap(localUnderlayExcludeIPBlockMap, remoteUnderlayExcludeIPBlockMap),
-			info.mode,
+			info.mode, nil,
 		); err != nil {
 			return fmt.Errorf("failed to add subnet %v rule and routes: %v", info.cidr, err)
 		}
@@ -367,10 +524,33 @@ func (m *Manager) SyncRoutes() error {
 
 		// Append underlay from-pod-subnet rules which don't exist and adapt to subnet configuration
 		if err := ensureFromPodSubnetRuleAndRoutes(info.forwardNodeIfName, info.cidr,
-			info.gateway, info.autoNatOutgoing, m.family, nil, nil, info.mode,
+			info.gateway, info.autoNatOutgoing, m.family, nil, nil, info.mode, info.extraRoutes,
 		); err != nil {
 			return fmt.Errorf("failed to add subnet %v rule and routes: %v", info.cidr, err)
 		}
+
+		if info.importBGPRoutes {
+			if err := ensureBGPImportRule(info.cidr, m.bgpImportTableNum, m.family); err != nil {
+				return fmt.Errorf("failed to ensure bgp-import rule for subnet %v: %v", info.cidr, err)
+			}
+		}
+	}
+
+	// Delete bgp-import rules of subnets which don't import routes any more.
+	for _, rule := range ruleList {
+		if rule.Table != m.bgpImportTableNum || rule.Src == nil {
+			continue
+		}
+
+		if info, exist := m.localClusterUnderlaySubnetInfoMap[rule.Src.String()]; exist &&
+			info.isUnderlayOnHost && info.importBGPRoutes {
+			continue
+		}
+
+		rule.Family = m.family
+		if err := netlink.RuleDel(&rule); err != nil {
+			return fmt.Errorf("failed to delete bgp-import rule %v: %v", rule.String(), err)
+		}
 	}
 
 	return nil
@@ -387,8 +567,8 @@ func (m *Manager) ensureToOverlaySubnetRoutes(excludeIPBlockMap map[string]*net.
 	existRemoteOverlaySubnetRouteMap := map[string]bool{}
 
 	for _, route := range toOverlaySubnetRoutes {
-		// skip exclude routes
-		if isExcludeRoute(&route) {
+		// skip exclude routes and direct routes
+		if isExcludeRoute(&route) || isDirectRoute(&route) {
 			continue
 		}
 
@@ -442,6 +622,11 @@ func (m *Manager) ensureToOverlaySubnetRoutes(excludeIPBlockMap map[string]*net.
 	if err := ensureExcludedIPBlockRoutes(excludeIPBlockMap, m.toOverlaySubnetTableNum, m.family); err != nil {
 		return fmt.Errorf("failed to ensure exclude ip block routes: %v", err)
 	}
+
+	// Direct routes are more specific than overlay subnet routes, so they will be chosen first.
+	if err := ensureDirectRoutes(m.directRouteInfoMap, m.toOverlaySubnetTableNum, m.family); err != nil {
+		return fmt.Errorf("failed to ensure direct routes: %v", err)
+	}
 	return nil
 }
 
@@ -453,7 +638,7 @@ func (m *Manager) ensureOverlayMarkRoutes() error {
 		}
 
 		if err := netlink.RouteReplace(&netlink.Route{
-			Dst:       defaultRouteDstByFamily(m.family),
+			Dst:       daemonutils.DefaultRouteDstByFamily(m.family),
 			LinkIndex: overlayLink.Attrs().Index,
 			Table:     m.overlayMarkTableNum,
 			Scope:     netlink.SCOPE_UNIVERSE,
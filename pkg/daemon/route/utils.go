@@ -17,9 +17,11 @@
 package route
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
-	"strings"
+	"sort"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 
@@ -38,6 +40,10 @@ const (
 
 	MaxRulePriority   = 32767
 	NodeLocalTableNum = 255
+
+	// ExtraRouteProtocol marks extra routes added by hybridnet, only routes with it can be removed
+	// from subnet tables, routes added by others are left untouched
+	ExtraRouteProtocol netlink.RouteProtocol = 72
 )
 
 type SubnetInfo struct {
@@ -56,6 +62,79 @@ type SubnetInfo struct {
 	isUnderlayOnHost bool
 
 	mode networkingv1.NetworkMode
+
+	// extra routes of underlay subnet, only dst and gw are used
+	extraRoutes []*netlink.Route
+
+	// if routes learned from bgp peers are used by pods of bgp subnet
+	importBGPRoutes bool
+}
+
+func (info *SubnetInfo) String() string {
+	var extraRoutes []string
+	for _, route := range info.extraRoutes {
+		extraRoutes = append(extraRoutes, route.String())
+	}
+
+	return fmt.Sprintf("cidr: %v, gateway: %v, exclude ips: %v, included ranges: %v, forward if: %v, "+
+		"auto nat outgoing: %v, underlay on host: %v, mode: %v, extra routes: %v, import bgp routes: %v",
+		info.cidr, info.gateway, info.excludeIPs, info.includedIPRanges, info.forwardNodeIfName,
+		info.autoNatOutgoing, info.isUnderlayOnHost, info.mode, extraRoutes, info.importBGPRoutes)
+}
+
+// DirectRouteInfo is a host route of overlay pod ip via the node ip in the same subnet.
+type DirectRouteInfo struct {
+	dst     *net.IPNet
+	gateway net.IP
+
+	// the node interface which node ip can be reached through
+	forwardNodeIfName string
+}
+
+func (info *DirectRouteInfo) String() string {
+	return fmt.Sprintf("dst: %v, gateway: %v, forward if: %v", info.dst, info.gateway, info.forwardNodeIfName)
+}
+
+type DirectRouteInfoMap map[string]*DirectRouteInfo
+
+func routeKey(route *netlink.Route) string {
+	dst := "default"
+	if route.Dst != nil {
+		dst = route.Dst.String()
+	}
+	return fmt.Sprintf("%d/%d/%s", route.Table, route.Type, dst)
+}
+
+// hashSubnetInfoMaps calculates hash of subnet info maps and direct route infos, cidrs are sorted to make it stable.
+func hashSubnetInfoMaps(overlayIfName string, directRouteInfoMap DirectRouteInfoMap, infoMaps ...SubnetInfoMap) string {
+	hash := sha256.New()
+	hash.Write([]byte(overlayIfName + "\n"))
+
+	dsts := make([]string, 0, len(directRouteInfoMap))
+	for dst := range directRouteInfoMap {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+
+	for _, dst := range dsts {
+		hash.Write([]byte(directRouteInfoMap[dst].String() + "\n"))
+	}
+	hash.Write([]byte("\n"))
+
+	for _, infoMap := range infoMaps {
+		cidrs := make([]string, 0, len(infoMap))
+		for cidr := range infoMap {
+			cidrs = append(cidrs, cidr)
+		}
+		sort.Strings(cidrs)
+
+		for _, cidr := range cidrs {
+			hash.Write([]byte(infoMap[cidr].String() + "\n"))
+		}
+		hash.Write([]byte("\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 type SubnetInfoMap map[string]*SubnetInfo
@@ -90,6 +169,12 @@ func listRoutesByTable(tableNum, family int) ([]netlink.Route, error) {
 
 // findHighestUnusedRulePriority find out the highest unused rule priority after node local rule
 func findHighestUnusedRulePriority(family int) (int, error) {
+	return findHighestUnusedRulePriorityAfter(0, family)
+}
+
+// findHighestUnusedRulePriorityAfter find out the highest unused rule priority after both node local rule
+// and the specified priority
+func findHighestUnusedRulePriorityAfter(after, family int) (int, error) {
 	ruleList, err := netlink.RuleList(family)
 	if err != nil {
 		return -1, fmt.Errorf("failed to list rules: %v", err)
@@ -107,7 +192,7 @@ func findHighestUnusedRulePriority(family int) (int, error) {
 	for priority := 0; priority <= MaxRulePriority; priority++ {
 		if _, inUsed := priorityMap[priority]; !inUsed {
 			// priority is not in used and lower than local rule
-			if priority > nodeLocalRulePrio {
+			if priority > nodeLocalRulePrio && priority > after {
 				return priority, nil
 			}
 		}
@@ -190,7 +275,7 @@ func checkIsFromPodSubnetRule(rule netlink.Rule, family int) (bool, error) {
 		}
 
 		// overlay subnet route table found
-		if strings.Contains(link.Attrs().Name, containernetwork.VxlanLinkInfix) &&
+		if containernetwork.IsOverlayLinkName(link.Attrs().Name) &&
 			!(route.Dst != nil && !route.Dst.IP.IsGlobalUnicast()) {
 			return true, nil
 		}
@@ -201,7 +286,7 @@ func checkIsFromPodSubnetRule(rule netlink.Rule, family int) (bool, error) {
 }
 
 func clearRouteTable(table int, family int) error {
-	defaultRouteDst := defaultRouteDstByFamily(family)
+	defaultRouteDst := daemonutils.DefaultRouteDstByFamily(family)
 
 	routeList, err := netlink.RouteListFiltered(family, &netlink.Route{
 		Table: table,
@@ -225,7 +310,7 @@ func clearRouteTable(table int, family int) error {
 
 func ensureFromPodSubnetRuleAndRoutes(forwardNodeIfName string, cidr *net.IPNet,
 	gateway net.IP, autoNatOutgoing bool, family int, underlaySubnetInfoMap SubnetInfoMap,
-	underlayExcludeIPBlockMap map[string]*net.IPNet, mode networkingv1.NetworkMode) error {
+	underlayExcludeIPBlockMap map[string]*net.IPNet, mode networkingv1.NetworkMode, extraRoutes []*netlink.Route) error {
 
 	var table int
 	var err error
@@ -251,17 +336,18 @@ func ensureFromPodSubnetRuleAndRoutes(forwardNodeIfName string, cidr *net.IPNet,
 	}
 
 	switch mode {
-	case networkingv1.NetworkModeVxlan:
+	case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
+		// routes of geneve subnet are the same as vxlan's, only the overlay interfaces differ
 		if err := ensureRoutesForVxlanSubnet(forwardLink, cidr, table, autoNatOutgoing, family,
 			underlaySubnetInfoMap, underlayExcludeIPBlockMap); err != nil {
-			return fmt.Errorf("failed to ensure routes for vxlan subnet %v: %v", cidr.String(), err)
+			return fmt.Errorf("failed to ensure routes for %v subnet %v: %v", mode, cidr.String(), err)
 		}
 	case networkingv1.NetworkModeVlan:
-		if err := ensureRoutesForVlanSubnet(forwardLink, cidr, gateway, table, family); err != nil {
+		if err := ensureRoutesForVlanSubnet(forwardLink, cidr, gateway, table, family, extraRoutes); err != nil {
 			return fmt.Errorf("failed to ensure routes for vlan subnet %v: %v", cidr.String(), err)
 		}
 	case networkingv1.NetworkModeBGP:
-		if err := ensureRoutesForBGPSubnet(forwardLink, cidr, table, gateway); err != nil {
+		if err := ensureRoutesForBGPSubnet(forwardLink, cidr, table, family, gateway, extraRoutes); err != nil {
 			return fmt.Errorf("failed to ensure routes for bgp subnet %v: %v", cidr.String(), err)
 		}
 	default:
@@ -301,7 +387,7 @@ func ensureRoutesForVxlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, table
 
 	if !autoNatOutgoing {
 		defaultRoute := &netlink.Route{
-			Dst:       defaultRouteDstByFamily(family),
+			Dst:       daemonutils.DefaultRouteDstByFamily(family),
 			LinkIndex: forwardLink.Attrs().Index,
 			Table:     table,
 			Scope:     netlink.SCOPE_UNIVERSE,
@@ -332,7 +418,7 @@ func ensureRoutesForVxlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, table
 					continue
 				}
 			} else {
-				route.Dst = defaultRouteDstByFamily(family)
+				route.Dst = daemonutils.DefaultRouteDstByFamily(family)
 			}
 
 			// Delete extra useless routes.
@@ -362,7 +448,8 @@ func ensureRoutesForVxlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, table
 	return nil
 }
 
-func ensureRoutesForVlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, gateway net.IP, table, family int) error {
+func ensureRoutesForVlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, gateway net.IP, table, family int,
+	extraRoutes []*netlink.Route) error {
 	localAddrList, err := netlink.AddrList(nil, family)
 	if err != nil {
 		return fmt.Errorf("failed to list local addresses: %v", err)
@@ -436,15 +523,27 @@ func ensureRoutesForVlanSubnet(forwardLink netlink.Link, cidr *net.IPNet, gatewa
 		return fmt.Errorf("failed to add vlan subnet %v default route %v: %v", cidr.String(), defaultRoute.String(), err)
 	}
 
+	if err := ensureExtraRoutes(forwardLink, gateway, table, family, int(netlink.FLAG_ONLINK), extraRoutes); err != nil {
+		return fmt.Errorf("failed to ensure extra routes for vlan subnet %v: %v", cidr.String(), err)
+	}
+
 	return nil
 }
 
-func ensureRoutesForBGPSubnet(forwardLink netlink.Link, cidr *net.IPNet, table int, gateway net.IP) error {
-	// don't use onlink flag in case the gateway is not a reachable next hop
+func ensureRoutesForBGPSubnet(forwardLink netlink.Link, cidr *net.IPNet, table, family int, gateway net.IP,
+	extraRoutes []*netlink.Route) error {
+	// don't use onlink flag in case the gateway is not a reachable next hop, except the ipv4
+	// link-local gateway of unnumbered peering, which has no ipv4 subnet on the interface
+	var flags int
+	if gateway.To4() != nil && gateway.IsLinkLocalUnicast() {
+		flags = int(netlink.FLAG_ONLINK)
+	}
+
 	defaultRoute := &netlink.Route{
 		LinkIndex: forwardLink.Attrs().Index,
 		Table:     table,
 		Scope:     netlink.SCOPE_UNIVERSE,
+		Flags:     flags,
 		Gw:        gateway,
 	}
 
@@ -452,6 +551,59 @@ func ensureRoutesForBGPSubnet(forwardLink netlink.Link, cidr *net.IPNet, table i
 		return fmt.Errorf("failed to add bgp subnet %v default route %v: %v", cidr.String(), defaultRoute.String(), err)
 	}
 
+	if err := ensureExtraRoutes(forwardLink, gateway, table, family, flags, extraRoutes); err != nil {
+		return fmt.Errorf("failed to ensure extra routes for bgp subnet %v: %v", cidr.String(), err)
+	}
+
+	return nil
+}
+
+// ensureExtraRoutes adds extra routes of subnet to its table, and removes the ones no longer needed.
+// Subnet gateway will be used for routes without gateway. Only routes marked with ExtraRouteProtocol
+// are removed, other routes in the table are added by others and kept.
+func ensureExtraRoutes(forwardLink netlink.Link, gateway net.IP, table, family, flags int,
+	extraRoutes []*netlink.Route) error {
+	expectedDsts := map[string]bool{}
+	for _, extraRoute := range extraRoutes {
+		routeGateway := extraRoute.Gw
+		if routeGateway == nil {
+			routeGateway = gateway
+		}
+
+		route := &netlink.Route{
+			LinkIndex: forwardLink.Attrs().Index,
+			Dst:       extraRoute.Dst,
+			Table:     table,
+			Scope:     netlink.SCOPE_UNIVERSE,
+			Flags:     flags,
+			Gw:        routeGateway,
+			Protocol:  ExtraRouteProtocol,
+		}
+
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add extra route %v: %v", route.String(), err)
+		}
+		expectedDsts[extraRoute.Dst.String()] = true
+	}
+
+	routeList, err := netlink.RouteListFiltered(family, &netlink.Route{
+		Table:    table,
+		Protocol: ExtraRouteProtocol,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return fmt.Errorf("failed to list route for table %v: %v", table, err)
+	}
+
+	for _, route := range routeList {
+		if route.Dst == nil || expectedDsts[route.Dst.String()] {
+			continue
+		}
+
+		if err := netlink.RouteDel(&route); err != nil {
+			return fmt.Errorf("failed to delete extra route %v for table %v: %v", route.String(), table, err)
+		}
+	}
+
 	return nil
 }
 
@@ -475,8 +627,8 @@ func checkIfRuleExist(src *net.IPNet, table, family int) (bool, *netlink.Rule, e
 					// rule exist
 					return true, &rule, nil
 				}
-			} else {
-				// rule exist
+			} else if rule.Table >= MinRouteTableNum && rule.Table < MaxRouteTableNum {
+				// from-pod-subnet rule exist, rules of fixed tables with the same src are ignored
 				return true, &rule, nil
 			}
 		}
@@ -485,18 +637,86 @@ func checkIfRuleExist(src *net.IPNet, table, family int) (bool, *netlink.Rule, e
 	return false, nil, nil
 }
 
-func defaultRouteDstByFamily(family int) *net.IPNet {
-	if family == netlink.FAMILY_V6 {
-		return &net.IPNet{
-			IP:   net.ParseIP("::").To16(),
-			Mask: net.CIDRMask(0, 128),
+// ensureBGPImportRule makes packets from bgp subnet look up bgp-import table before the from-pod-subnet
+// rule of subnet, so that routes learned from bgp peers take precedence over the subnet default route.
+func ensureBGPImportRule(cidr *net.IPNet, importTable, family int) error {
+	ruleList, err := netlink.RuleList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+	var subnetRule, importRule *netlink.Rule
+	for i := range ruleList {
+		rule := &ruleList[i]
+		if rule.Src == nil || rule.Src.String() != cidr.String() {
+			continue
 		}
+
+		if rule.Table == importTable {
+			importRule = rule
+		} else if rule.Table >= MinRouteTableNum && rule.Table < MaxRouteTableNum {
+			subnetRule = rule
+		}
+	}
+
+	if subnetRule == nil {
+		return fmt.Errorf("from-pod-subnet rule of %v not found", cidr.String())
 	}
 
-	return &net.IPNet{
-		IP:   net.ParseIP("0.0.0.0").To4(),
-		Mask: net.CIDRMask(0, 32),
+	subnetRulePriority := realRulePriority(subnetRule.Priority)
+	if importRule != nil {
+		if realRulePriority(importRule.Priority) < subnetRulePriority {
+			return nil
+		}
+
+		importRule.Family = family
+		if err := netlink.RuleDel(importRule); err != nil {
+			return fmt.Errorf("failed to delete bgp-import rule %v: %v", importRule.String(), err)
+		}
+	}
+
+	importRulePriority, err := findHighestUnusedRulePriority(family)
+	if err != nil {
+		return fmt.Errorf("failed to find highest unused rule priority for bgp-import rule: %v", err)
 	}
+
+	rule := netlink.NewRule()
+	rule.Src = cidr
+	rule.Table = importTable
+	rule.Priority = importRulePriority
+	rule.Family = family
+
+	if err := netlink.RuleAdd(rule); err != nil {
+		return fmt.Errorf("failed to add bgp-import rule %v: %v", rule.String(), err)
+	}
+
+	if importRulePriority < subnetRulePriority {
+		return nil
+	}
+
+	// No unused priority ahead of from-pod-subnet rule, move it behind bgp-import rule. The new
+	// one is added before the old one is deleted, so that the subnet traffic is never interrupted.
+	newSubnetRulePriority, err := findHighestUnusedRulePriorityAfter(importRulePriority, family)
+	if err != nil {
+		return fmt.Errorf("failed to find highest unused rule priority for from-pod-subnet rule: %v", err)
+	}
+
+	newSubnetRule := netlink.NewRule()
+	newSubnetRule.Src = cidr
+	newSubnetRule.Table = subnetRule.Table
+	newSubnetRule.Priority = newSubnetRulePriority
+	newSubnetRule.Family = family
+
+	if err := netlink.RuleAdd(newSubnetRule); err != nil {
+		return fmt.Errorf("failed to add rule %v: %v", newSubnetRule.String(), err)
+	}
+
+	subnetRule.Family = family
+	if err := netlink.RuleDel(subnetRule); err != nil {
+		return fmt.Errorf("failed to delete rule %v: %v", subnetRule.String(), err)
+	}
+
+	return nil
 }
 
 func ensureExcludedIPBlockRoutes(excludeIPBlockMap map[string]*net.IPNet, table, family int) error {
@@ -585,3 +805,184 @@ func combineLocalAndRemoteExcludeIPBlockMap(local, remote map[string]*net.IPNet)
 
 	return res
 }
+
+// isDirectRoute checks if a route of to-overlay-pod-subnet table is a direct route.
+func isDirectRoute(route *netlink.Route) bool {
+	if route == nil || route.Dst == nil || route.Gw == nil {
+		return false
+	}
+
+	ones, bits := route.Dst.Mask.Size()
+	return ones == bits
+}
+
+func ensureDirectRoutes(directRouteInfoMap DirectRouteInfoMap, table, family int) error {
+	routeList, err := listRoutesByTable(table, family)
+	if err != nil {
+		return err
+	}
+
+	linkIndexMap := map[string]int{}
+	for _, info := range directRouteInfoMap {
+		if _, exist := linkIndexMap[info.forwardNodeIfName]; exist {
+			continue
+		}
+
+		link, err := netlink.LinkByName(info.forwardNodeIfName)
+		if err != nil {
+			return fmt.Errorf("failed to get link %v: %v", info.forwardNodeIfName, err)
+		}
+		linkIndexMap[info.forwardNodeIfName] = link.Attrs().Index
+	}
+
+	existDirectRouteMap := map[string]bool{}
+	for _, route := range routeList {
+		if !isDirectRoute(&route) {
+			continue
+		}
+
+		if info, exist := directRouteInfoMap[route.Dst.String()]; exist && route.Gw.Equal(info.gateway) &&
+			route.LinkIndex == linkIndexMap[info.forwardNodeIfName] {
+			existDirectRouteMap[route.Dst.String()] = true
+			continue
+		}
+
+		if err := netlink.RouteDel(&route); err != nil {
+			return fmt.Errorf("failed to delete direct route %v: %v", route.String(), err)
+		}
+	}
+
+	for dst, info := range directRouteInfoMap {
+		if existDirectRouteMap[dst] {
+			continue
+		}
+
+		if err := netlink.RouteReplace(&netlink.Route{
+			Dst:       info.dst,
+			Gw:        info.gateway,
+			LinkIndex: linkIndexMap[info.forwardNodeIfName],
+			Table:     table,
+			Scope:     netlink.SCOPE_UNIVERSE,
+		}); err != nil {
+			return fmt.Errorf("failed to add direct route for %v via %v: %v", dst, info.gateway, err)
+		}
+	}
+
+	return nil
+}
+
+func isOverlayLink(link netlink.Link) bool {
+	return link.Type() == "vxlan" || link.Type() == "geneve"
+}
+
+// EnsureDportRule ensures a policy rule which makes packets to dport look up the table,
+// other rules of the table will be removed.
+func EnsureDportRule(dport uint16, table, family int) error {
+	ruleList, err := netlink.RuleList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+	ruleExist := false
+	for _, rule := range ruleList {
+		if rule.Table != table {
+			continue
+		}
+
+		if !ruleExist && rule.Src == nil && rule.Dst == nil && rule.Dport != nil &&
+			rule.Dport.Start == dport && rule.Dport.End == dport {
+			ruleExist = true
+			continue
+		}
+
+		if err := netlink.RuleDel(&rule); err != nil {
+			return fmt.Errorf("failed to delete policy rule %v: %v", rule.String(), err)
+		}
+	}
+
+	if ruleExist {
+		return nil
+	}
+
+	priority, err := findHighestUnusedRulePriority(family)
+	if err != nil {
+		return fmt.Errorf("failed to find highest unused rule priority for dport rule: %v", err)
+	}
+
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.Priority = priority
+	rule.Family = family
+	rule.Dport = netlink.NewRulePortRange(dport, dport)
+
+	if err := netlink.RuleAdd(rule); err != nil {
+		return fmt.Errorf("failed to add policy rule %v: %v", rule.String(), err)
+	}
+
+	return nil
+}
+
+// SyncLinkRoutes makes the table only contain routes from link to the destinations.
+func SyncLinkRoutes(link netlink.Link, dsts []*net.IPNet, table, family int) error {
+	routeList, err := listRoutesByTable(table, family)
+	if err != nil {
+		return err
+	}
+
+	expectedDstMap := map[string]bool{}
+	for _, dst := range dsts {
+		expectedDstMap[dst.String()] = true
+	}
+
+	existDstMap := map[string]bool{}
+	for _, route := range routeList {
+		if route.Dst != nil && route.LinkIndex == link.Attrs().Index && route.Gw == nil &&
+			expectedDstMap[route.Dst.String()] {
+			existDstMap[route.Dst.String()] = true
+			continue
+		}
+
+		if route.Dst == nil {
+			route.Dst = daemonutils.DefaultRouteDstByFamily(family)
+		}
+
+		if err := netlink.RouteDel(&route); err != nil {
+			return fmt.Errorf("failed to delete route %v for table %v: %v", route.String(), table, err)
+		}
+	}
+
+	for _, dst := range dsts {
+		if existDstMap[dst.String()] {
+			continue
+		}
+
+		if err := netlink.RouteReplace(&netlink.Route{
+			Dst:       dst,
+			LinkIndex: link.Attrs().Index,
+			Scope:     netlink.SCOPE_LINK,
+			Table:     table,
+		}); err != nil {
+			return fmt.Errorf("failed to add route to %v for table %v: %v", dst.String(), table, err)
+		}
+	}
+
+	return nil
+}
+
+// ClearRulesAndRoutesByTable removes all the policy rules and routes of the table.
+func ClearRulesAndRoutesByTable(table, family int) error {
+	ruleList, err := netlink.RuleList(family)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %v", err)
+	}
+
+	for _, rule := range ruleList {
+		if rule.Table == table {
+			if err := netlink.RuleDel(&rule); err != nil {
+				return fmt.Errorf("failed to delete policy rule %v: %v", rule.String(), err)
+			}
+		}
+	}
+
+	return clearRouteTable(table, family)
+}
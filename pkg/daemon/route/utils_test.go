@@ -0,0 +1,255 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package route
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
+
+func TestIsDirectRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    *netlink.Route
+		expected bool
+	}{
+		{
+			name: "ipv4 host route via node ip",
+			route: &netlink.Route{
+				Dst: mustParseCIDR("10.0.0.10/32"),
+				Gw:  net.ParseIP("192.168.0.2"),
+			},
+			expected: true,
+		},
+		{
+			name: "ipv6 host route via node ip",
+			route: &netlink.Route{
+				Dst: mustParseCIDR("fd00::10/128"),
+				Gw:  net.ParseIP("fe80::2"),
+			},
+			expected: true,
+		},
+		{
+			name:     "nil route",
+			route:    nil,
+			expected: false,
+		},
+		{
+			name: "default route",
+			route: &netlink.Route{
+				Gw: net.ParseIP("192.168.0.254"),
+			},
+			expected: false,
+		},
+		{
+			name: "subnet route via overlay interface",
+			route: &netlink.Route{
+				Dst:       mustParseCIDR("10.0.0.0/24"),
+				LinkIndex: 1,
+			},
+			expected: false,
+		},
+		{
+			name: "subnet route via gateway",
+			route: &netlink.Route{
+				Dst: mustParseCIDR("10.0.0.0/24"),
+				Gw:  net.ParseIP("192.168.0.2"),
+			},
+			expected: false,
+		},
+		{
+			name: "host route without gateway",
+			route: &netlink.Route{
+				Dst:       mustParseCIDR("10.0.0.10/32"),
+				LinkIndex: 1,
+			},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isDirectRoute(test.route))
+		})
+	}
+}
+
+func TestEnsureDirectRoutes(t *testing.T) {
+	if unix.Geteuid() != 0 {
+		t.Skip("syncing routes requires root")
+	}
+
+	testNS, err := testutils.NewNS()
+	if err != nil {
+		t.Skipf("failed to create test netns: %v", err)
+	}
+	defer func() {
+		_ = testNS.Close()
+		_ = testutils.UnmountNS(testNS)
+	}()
+
+	const table = 40000
+
+	newInfo := func(dst, gateway, forwardNodeIfName string) *DirectRouteInfo {
+		return &DirectRouteInfo{
+			dst:               mustParseCIDR(dst),
+			gateway:           net.ParseIP(gateway),
+			forwardNodeIfName: forwardNodeIfName,
+		}
+	}
+
+	tests := []struct {
+		name              string
+		directRouteInfos  []*DirectRouteInfo
+		expectedRoutes    []string
+		expectError       bool
+		expectedNonDirect int
+	}{
+		{
+			name: "add routes to pods on nodes of the same subnet",
+			directRouteInfos: []*DirectRouteInfo{
+				newInfo("10.0.0.10/32", "192.168.0.2", "veth0"),
+				newInfo("10.0.0.11/32", "192.168.0.2", "veth0"),
+				newInfo("10.0.0.20/32", "192.168.0.3", "veth0"),
+			},
+			expectedRoutes: []string{
+				"10.0.0.10/32 via 192.168.0.2",
+				"10.0.0.11/32 via 192.168.0.2",
+				"10.0.0.20/32 via 192.168.0.3",
+			},
+			expectedNonDirect: 1,
+		},
+		{
+			name: "pod moves to another node and stale routes are removed",
+			directRouteInfos: []*DirectRouteInfo{
+				newInfo("10.0.0.10/32", "192.168.0.3", "veth0"),
+			},
+			expectedRoutes: []string{
+				"10.0.0.10/32 via 192.168.0.3",
+			},
+			expectedNonDirect: 1,
+		},
+		{
+			name:              "no node can be reached directly",
+			directRouteInfos:  nil,
+			expectedRoutes:    nil,
+			expectedNonDirect: 1,
+		},
+		{
+			name: "forward interface not found",
+			directRouteInfos: []*DirectRouteInfo{
+				newInfo("10.0.0.10/32", "192.168.0.2", "not-exist"),
+			},
+			expectError:       true,
+			expectedNonDirect: 1,
+		},
+	}
+
+	var linkIndex int
+	if err := testNS.Do(func(_ ns.NetNS) error {
+		if err := netlink.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "veth0"},
+			PeerName:  "veth1",
+		}); err != nil {
+			return err
+		}
+
+		link, err := netlink.LinkByName("veth0")
+		if err != nil {
+			return err
+		}
+		peer, err := netlink.LinkByName("veth1")
+		if err != nil {
+			return err
+		}
+
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(peer); err != nil {
+			return err
+		}
+		if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{
+			IP: net.ParseIP("192.168.0.1"), Mask: net.CIDRMask(24, 32)}}); err != nil {
+			return err
+		}
+
+		// overlay subnet route of the same table should never be touched
+		linkIndex = link.Attrs().Index
+		return netlink.RouteAdd(&netlink.Route{
+			Dst:       mustParseCIDR("10.0.0.0/24"),
+			LinkIndex: linkIndex,
+			Table:     table,
+			Scope:     netlink.SCOPE_LINK,
+		})
+	}); err != nil {
+		t.Skipf("failed to set up test interface: %v", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// subtests run in other goroutines, which should enter the test netns again
+			assert.NoError(t, testNS.Do(func(_ ns.NetNS) error {
+				directRouteInfoMap := DirectRouteInfoMap{}
+				for _, info := range test.directRouteInfos {
+					directRouteInfoMap[info.dst.String()] = info
+				}
+
+				err := ensureDirectRoutes(directRouteInfoMap, table, netlink.FAMILY_V4)
+				if test.expectError {
+					assert.Error(t, err)
+					return nil
+				}
+				assert.NoError(t, err)
+
+				routeList, err := listRoutesByTable(table, netlink.FAMILY_V4)
+				assert.NoError(t, err)
+
+				var directRoutes []string
+				nonDirect := 0
+				for _, route := range routeList {
+					if !isDirectRoute(&route) {
+						nonDirect++
+						continue
+					}
+					assert.Equal(t, linkIndex, route.LinkIndex)
+					directRoutes = append(directRoutes, route.Dst.String()+" via "+route.Gw.String())
+				}
+				sort.Strings(directRoutes)
+
+				assert.Equal(t, test.expectedRoutes, directRoutes)
+				assert.Equal(t, test.expectedNonDirect, nonDirect)
+				return nil
+			}))
+		})
+	}
+}
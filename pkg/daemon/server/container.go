@@ -17,30 +17,39 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 )
 
 // ipAddr is a CIDR notation IP address and prefix length
 func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, containerID, mac string,
-	netID *int32, allocatedIPs map[networkingv1.IPVersion]*containernetwork.IPInfo,
-	networkMode networkingv1.NetworkMode) (string, error) {
+	netID *int32, allocatedIPs map[networkingv1.IPVersion]*containernetwork.IPInfo, extraRouteDsts []*net.IPNet,
+	network *networkingv1.Network, specifiedMTU int) (string, error) {
 
 	var err error
 	var nodeIfName string
 	var mtu int
+	var outerVlanID *int32
 
+	networkMode := networkingv1.GetNetworkMode(network)
 	switch networkMode {
 	case networkingv1.NetworkModeVlan:
 		mtu = cdh.config.VlanMTU
-		nodeIfName = cdh.config.NodeVlanIfName
-	case networkingv1.NetworkModeVxlan:
+		outerVlanID = networkingv1.GetOuterVlanID(network)
+
+		if nodeIfName, err = cdh.getVlanUplinkIfName(network); err != nil {
+			return "", err
+		}
+	case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
 		mtu = cdh.config.VxlanMTU
 		nodeIfName = cdh.config.NodeVxlanIfName
 	case networkingv1.NetworkModeBGP:
@@ -48,11 +57,56 @@ func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, container
 		nodeIfName = cdh.config.NodeBGPIfName
 	}
 
+	// Overlay packets will be encapsulated by wireguard again.
+	wireGuardEnabled := networkingv1.IsWireGuardEnabled(network)
+	if wireGuardEnabled {
+		mtu -= networkingv1.WireGuardMTUOverhead
+	}
+
+	if specifiedMTU != 0 {
+		maxMTU, err := containernetwork.GetMaxMTU(nodeIfName, networkMode, cdh.config.VtepAddressFamily == netlink.FAMILY_V6)
+		if err != nil {
+			return "", fmt.Errorf("failed to get max mtu: %v", err)
+		}
+
+		if wireGuardEnabled {
+			maxMTU -= networkingv1.WireGuardMTUOverhead
+		}
+
+		mtu = specifiedMTU
+		if mtu > maxMTU {
+			cdh.logger.Info("specified mtu is larger than the max mtu of node interface, use the max one instead",
+				"specifiedMTU", specifiedMTU, "maxMTU", maxMTU, "nodeInterface", nodeIfName)
+			mtu = maxMTU
+		}
+	}
+
 	macAddr, err := net.ParseMAC(mac)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse mac %s %v", macAddr, err)
 	}
 
+	// Underlay pod ips might be occupied by machines out of cluster, which should be found
+	// before any network configuration takes effect.
+	if networkMode == networkingv1.NetworkModeVlan {
+		forwardNodeIfName, err := containernetwork.GenerateVlanNetIfName(nodeIfName, outerVlanID, netID)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate vlan forward node interface name: %v", err)
+		}
+
+		nodeHwAddrs, err := cdh.listNodeHwAddrs()
+		if err != nil {
+			return "", fmt.Errorf("failed to list hw addrs of nodes: %v", err)
+		}
+
+		// The retained ip of a stateful pod might still be answered by the previous pod which is
+		// being terminated, with the same retained mac.
+		if err := containernetwork.DetectIPConflict(forwardNodeIfName, allocatedIPs, cdh.config.VlanCheckTimeout,
+			append(nodeHwAddrs, macAddr)); err != nil {
+			return "", err
+		}
+	}
+
 	containerNicName, hostNicName, podNS, err := initContainerNic(podName, podNamespace, netns, mtu)
 	if err != nil {
 		return "", fmt.Errorf("failed to init container nic for pod %v: %v", podName, err)
@@ -63,7 +117,7 @@ func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, container
 	}
 
 	if err = containernetwork.ConfigureContainerNic(containerNicName, hostNicName, nodeIfName,
-		allocatedIPs, macAddr, netID, podNS, mtu, cdh.config.VlanCheckTimeout, networkMode,
+		allocatedIPs, extraRouteDsts, macAddr, outerVlanID, netID, podNS, mtu, cdh.config.VlanCheckTimeout, networkMode,
 		cdh.config.NeighGCThresh1, cdh.config.NeighGCThresh2, cdh.config.NeighGCThresh3); err != nil {
 		return "", fmt.Errorf("failed to configure container nic for %v.%v: %v", podName, podNamespace, err)
 	}
@@ -71,17 +125,51 @@ func (cdh cniDaemonHandler) configureNic(podName, podNamespace, netns, container
 	return hostNicName, nil
 }
 
-func (cdh cniDaemonHandler) deleteNic(netns string) error {
+// listNodeHwAddrs returns the hw addrs of all the local interfaces and the vtep hw addrs of all
+// the nodes, replies from which are answered on behalf of pods rather than ip conflicts.
+func (cdh cniDaemonHandler) listNodeHwAddrs() ([]net.HardwareAddr, error) {
+	var hwAddrs []net.HardwareAddr
+
+	links, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local interfaces: %v", err)
+	}
+
+	for _, link := range links {
+		if len(link.HardwareAddr) != 0 {
+			hwAddrs = append(hwAddrs, link.HardwareAddr)
+		}
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := cdh.mgrClient.List(context.TODO(), nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	for _, node := range nodeList.Items {
+		vtepMac, err := net.ParseMAC(node.Annotations[constants.AnnotationNodeVtepMac])
+		if err != nil {
+			continue
+		}
+		hwAddrs = append(hwAddrs, vtepMac)
+	}
+
+	return hwAddrs, nil
+}
+
+// deleteNic removes addresses of container nic and returns the global ones.
+func (cdh cniDaemonHandler) deleteNic(netns string) ([]net.IP, error) {
 	if netns == "" {
-		return nil
+		return nil, nil
 	}
 
 	nsHandler, err := ns.GetNS(netns)
 	if err != nil {
-		return fmt.Errorf("get ns error: %v", err)
+		return nil, fmt.Errorf("get ns error: %v", err)
 	}
 
-	return nsHandler.Do(func(netNS ns.NetNS) error {
+	var podIPs []net.IP
+	err = nsHandler.Do(func(netNS ns.NetNS) error {
 		containerLink, err := netlink.LinkByName(containernetwork.ContainerNicName)
 		// return nil if eth0 not found.
 		if err != nil {
@@ -105,9 +193,15 @@ func (cdh cniDaemonHandler) deleteNic(netns string) error {
 			if err := netlink.AddrDel(containerLink, &addr); err != nil {
 				return fmt.Errorf("delete ns %v %v addr %v error: %v", netns, containernetwork.ContainerNicName, addr.IP, err)
 			}
+
+			if addr.IP.IsGlobalUnicast() {
+				podIPs = append(podIPs, addr.IP)
+			}
 		}
 		return nil
 	})
+
+	return podIPs, err
 }
 
 func initContainerNic(podName, podNamespace, netns string, mtu int) (string, string, ns.NetNS, error) {
@@ -0,0 +1,75 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func TestListNodeHwAddrs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+
+	newNode := func(name, vtepMac string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{constants.AnnotationNodeVtepMac: vtepMac},
+			},
+		}
+	}
+
+	cdh := cniDaemonHandler{
+		mgrClient: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			newNode("node1", "02:42:ac:11:00:01"),
+			newNode("node2", "02:42:ac:11:00:02"),
+			newNode("node3", "invalid"),
+			newNode("node4", ""),
+		).Build(),
+	}
+
+	hwAddrs, err := cdh.listNodeHwAddrs()
+	assert.NoError(t, err)
+
+	var hwAddrStrings []string
+	for _, hwAddr := range hwAddrs {
+		hwAddrStrings = append(hwAddrStrings, hwAddr.String())
+	}
+
+	// hw addrs of all the local interfaces
+	interfaces, err := net.Interfaces()
+	assert.NoError(t, err)
+	for _, iface := range interfaces {
+		if len(iface.HardwareAddr) != 0 {
+			assert.Contains(t, hwAddrStrings, iface.HardwareAddr.String())
+		}
+	}
+
+	// vtep hw addrs of all the nodes, invalid ones are ignored
+	assert.Contains(t, hwAddrStrings, "02:42:ac:11:00:01")
+	assert.Contains(t, hwAddrStrings, "02:42:ac:11:00:02")
+	assert.NotContains(t, hwAddrStrings, "")
+}
@@ -18,6 +18,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -33,7 +34,9 @@ import (
 
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
+	"github.com/alibaba/hybridnet/pkg/daemon/bandwidth"
 	daemonconfig "github.com/alibaba/hybridnet/pkg/daemon/config"
+	"github.com/alibaba/hybridnet/pkg/daemon/conntrack"
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 	"github.com/alibaba/hybridnet/pkg/daemon/controller"
 	"github.com/alibaba/hybridnet/pkg/request"
@@ -46,6 +49,10 @@ type cniDaemonHandler struct {
 	mgrClient    client.Client
 	mgrAPIReader client.Reader
 
+	// getVlanUplinkIfName resolves the vlan uplink interface from the cached annotation of this node,
+	// to avoid getting node on every pod creation
+	getVlanUplinkIfName func(network *networkingv1.Network) (string, error)
+
 	logger logr.Logger
 }
 
@@ -56,6 +63,8 @@ func createCniDaemonHandler(ctx context.Context, config *daemonconfig.Configurat
 		mgrClient:    ctrlRef.GetMgrClient(),
 		mgrAPIReader: ctrlRef.GetMgrAPIReader(),
 		logger:       logger,
+
+		getVlanUplinkIfName: ctrlRef.GetVlanUplinkIfName,
 	}
 
 	if ok := ctrlRef.CacheSynced(ctx); !ok {
@@ -79,12 +88,22 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 	var netID *int32
 	var affectedIPInstances []*networkingv1.IPInstance
 
+	// Retained ips of stateful pods can not be changed, so a conflict of them only fails the pod
+	// creation, instead of being reserved for a different ip to be allocated.
+	reserveConflictIP := true
+
 	allocatedIPs := map[networkingv1.IPVersion]*containernetwork.IPInfo{
 		networkingv1.IPv4: nil,
 		networkingv1.IPv6: nil,
 	}
 
 	var returnIPAddress []request.IPAddress
+	var returnRoutes []request.Route
+	var extraRouteDsts []*net.IPNet
+	var dnsConfig *networkingv1.DNSConfig
+	var subnets []*networkingv1.Subnet
+
+	pod := &corev1.Pod{}
 
 	backOffBase := 5 * time.Microsecond
 	retries := 11
@@ -93,7 +112,6 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		time.Sleep(backOffBase)
 		backOffBase = backOffBase * 2
 
-		pod := &corev1.Pod{}
 		if err := cdh.mgrAPIReader.Get(context.TODO(), types.NamespacedName{
 			Name:      podRequest.PodName,
 			Namespace: podRequest.PodNamespace,
@@ -130,6 +148,10 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		// IPv4 and IPv6 ip will exist at the same time
 		if ipInstance.Status.PodName == podRequest.PodName && ipInstance.Status.PodNamespace == podRequest.PodNamespace {
 
+			if networkingv1.IsStatefulIPInstance(&ipInstance) {
+				reserveConflictIP = false
+			}
+
 			if netID == nil && macAddr == "" {
 				netID = ipInstance.Spec.Address.NetID
 				macAddr = ipInstance.Spec.Address.MAC
@@ -203,6 +225,38 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 				Protocol: ipVersion,
 			})
 
+			subnet := &networkingv1.Subnet{}
+			if err := cdh.mgrClient.Get(context.TODO(), types.NamespacedName{Name: ipInstance.Spec.Subnet}, subnet); err != nil {
+				errMsg := fmt.Errorf("failed to get subnet %v for pod %v/%v: %v", ipInstance.Spec.Subnet, podRequest.PodNamespace, podRequest.PodName, err)
+				cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+				return
+			}
+
+			for _, subnetRoute := range networkingv1.GetSubnetRoutes(&subnet.Spec) {
+				_, dst, err := net.ParseCIDR(subnetRoute.Dst)
+				if err != nil {
+					errMsg := fmt.Errorf("failed to parse route destination %v of subnet %v: %v", subnetRoute.Dst, subnet.Name, err)
+					cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+					return
+				}
+
+				// subnet gateway is the default next hop
+				routeGateway := subnetRoute.Gateway
+				if len(routeGateway) == 0 {
+					routeGateway = ipInstance.Spec.Address.Gateway
+				}
+
+				extraRouteDsts = append(extraRouteDsts, dst)
+				returnRoutes = append(returnRoutes, request.Route{
+					Dst:      dst.String(),
+					Gateway:  routeGateway,
+					Protocol: ipVersion,
+				})
+			}
+
+			dnsConfig = mergeDNSConfig(dnsConfig, networkingv1.GetSubnetDNSConfig(&subnet.Spec))
+			subnets = append(subnets, subnet)
+
 			affectedIPInstances = append(affectedIPInstances, &ipInstance)
 		}
 	}
@@ -227,13 +281,54 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 		"ipAddr", printAllocatedIPs(allocatedIPs),
 		"macAddr", macAddr,
 		"netID", *netID)
+	// the smaller one will be used if subnets of a dual-stack pod have different mtu
+	var specifiedMTU int
+	for _, subnet := range subnets {
+		if subnetMTU := networkingv1.GetMTU(network, subnet); subnetMTU != 0 && (specifiedMTU == 0 || subnetMTU < specifiedMTU) {
+			specifiedMTU = subnetMTU
+		}
+	}
+
+	// Stale conntrack entries of a reused ip will blackhole or misroute the flows of the new pod.
+	var podIPs []net.IP
+	for _, ipInfo := range allocatedIPs {
+		if ipInfo != nil {
+			podIPs = append(podIPs, ipInfo.Addr)
+		}
+	}
+	cdh.flushConntrack(podRequest.PodNamespace, podRequest.PodName, podIPs...)
+
 	hostInterface, err := cdh.configureNic(podRequest.PodName, podRequest.PodNamespace, podRequest.NetNs, podRequest.ContainerID,
-		macAddr, netID, allocatedIPs, networkingv1.GetNetworkMode(network))
+		macAddr, netID, allocatedIPs, extraRouteDsts, network, specifiedMTU)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to configure nic: %v", err)
+
+		var conflictErr *containernetwork.IPConflictError
+		if errors.As(err, &conflictErr) && reserveConflictIP {
+			if markErr := cdh.markIPInstanceConflict(ipInstanceList.Items, conflictErr); markErr != nil {
+				cdh.logger.Error(markErr, "failed to mark ip instance conflict",
+					"podName", podRequest.PodName, "podNamespace", podRequest.PodNamespace)
+			} else {
+				errMsg = fmt.Errorf("failed to configure nic: %v, a different ip will be allocated", err)
+			}
+		}
+
 		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
 		return
 	}
+	bandwidthLimits, err := bandwidth.GetPodBandwidthLimits(pod, subnets)
+	if err != nil {
+		errMsg := fmt.Errorf("failed to get bandwidth limits for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusBadRequest, resp)
+		return
+	}
+
+	if err = bandwidth.EnsurePodBandwidth(hostInterface, bandwidthLimits); err != nil {
+		errMsg := fmt.Errorf("failed to ensure bandwidth for pod %v/%v: %v", podRequest.PodNamespace, podRequest.PodName, err)
+		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
 	cdh.logger.Info("Container network created",
 		"podName", podRequest.PodName,
 		"podNamespace", podRequest.PodNamespace,
@@ -260,6 +355,8 @@ func (cdh *cniDaemonHandler) handleAdd(req *restful.Request, resp *restful.Respo
 
 	_ = resp.WriteHeaderAndEntity(http.StatusOK, request.PodResponse{
 		IPAddress:     returnIPAddress,
+		Routes:        returnRoutes,
+		DNS:           dnsConfig,
 		HostInterface: hostInterface,
 	})
 }
@@ -274,16 +371,74 @@ func (cdh *cniDaemonHandler) handleDel(req *restful.Request, resp *restful.Respo
 	}
 	cdh.logger.V(5).Info("handle del request", "content", podRequest)
 
-	err = cdh.deleteNic(podRequest.NetNs)
+	podIPs, err := cdh.deleteNic(podRequest.NetNs)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to del container nic for %s: %v",
 			fmt.Sprintf("%s.%s", podRequest.PodName, podRequest.PodNamespace), err)
 		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
 		return
 	}
+
+	hostNicName, _ := containernetwork.GenerateContainerVethPair(podRequest.PodNamespace, podRequest.PodName)
+	if err = bandwidth.ClearPodBandwidth(hostNicName); err != nil {
+		errMsg := fmt.Errorf("failed to clear bandwidth for %s: %v",
+			fmt.Sprintf("%s.%s", podRequest.PodName, podRequest.PodNamespace), err)
+		cdh.errorWrapper(errMsg, http.StatusInternalServerError, resp)
+		return
+	}
+
+	// the ips might be reassigned to other pods
+	cdh.flushConntrack(podRequest.PodNamespace, podRequest.PodName, podIPs...)
+
 	resp.WriteHeader(http.StatusNoContent)
 }
 
+// flushConntrack deletes conntrack entries of pod ips, failure will only be logged
+// because it should never block the creation or deletion of pod.
+func (cdh *cniDaemonHandler) flushConntrack(podNamespace, podName string, podIPs ...net.IP) {
+	if len(podIPs) == 0 {
+		return
+	}
+
+	deleted, err := conntrack.FlushIPs(podIPs...)
+	if err != nil {
+		cdh.logger.Error(err, "failed to flush conntrack entries",
+			"podName", podName, "podNamespace", podNamespace)
+		return
+	}
+
+	cdh.logger.V(5).Info("flush conntrack entries", "podName", podName,
+		"podNamespace", podNamespace, "ips", podIPs, "deleted", deleted)
+}
+
+// markIPInstanceConflict annotates the ip instance of a conflicting pod ip with the hw addr of the
+// conflicting host, and then manager will reserve the ip and allocate a different one for pod.
+func (cdh *cniDaemonHandler) markIPInstanceConflict(ipInstances []networkingv1.IPInstance,
+	conflictErr *containernetwork.IPConflictError) error {
+	for i := range ipInstances {
+		ipInstance := &ipInstances[i]
+
+		instanceIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP)
+		if err != nil || !instanceIP.Equal(conflictErr.IP) {
+			continue
+		}
+
+		patch := client.MergeFrom(ipInstance.DeepCopy())
+		if ipInstance.Annotations == nil {
+			ipInstance.Annotations = map[string]string{}
+		}
+		ipInstance.Annotations[constants.AnnotationIPConflict] = conflictErr.HardwareAddr.String()
+
+		if err := cdh.mgrClient.Patch(context.TODO(), ipInstance, patch); err != nil {
+			return fmt.Errorf("failed to patch ip instance %v: %v", ipInstance.Name, err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("ip instance of ip %v not found", conflictErr.IP.String())
+}
+
 func (cdh *cniDaemonHandler) errorWrapper(err error, status int, resp *restful.Response) {
 	cdh.logger.Error(err, "handler error")
 	_ = resp.WriteHeaderAndEntity(status, request.PodResponse{
@@ -306,3 +461,41 @@ func printAllocatedIPs(allocatedIPs map[networkingv1.IPVersion]*containernetwork
 
 	return ipAddresseString
 }
+
+// mergeDNSConfig merges dns configs of ipv4 and ipv6 subnets, nameservers and search domains
+// will be combined, and the domain and options of the former one take precedence
+func mergeDNSConfig(current, incoming *networkingv1.DNSConfig) *networkingv1.DNSConfig {
+	if incoming == nil {
+		return current
+	}
+	if current == nil {
+		return incoming.DeepCopy()
+	}
+
+	merged := current.DeepCopy()
+	merged.Nameservers = appendIfNotExist(merged.Nameservers, incoming.Nameservers...)
+	merged.Search = appendIfNotExist(merged.Search, incoming.Search...)
+	if len(merged.Domain) == 0 {
+		merged.Domain = incoming.Domain
+	}
+	if len(merged.Options) == 0 {
+		merged.Options = incoming.Options
+	}
+	return merged
+}
+
+func appendIfNotExist(slice []string, items ...string) []string {
+	for _, item := range items {
+		exist := false
+		for _, s := range slice {
+			if s == item {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			slice = append(slice, item)
+		}
+	}
+	return slice
+}
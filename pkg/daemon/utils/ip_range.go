@@ -46,6 +46,10 @@ func CreateIPRange(start, end net.IP) (*IPRange, error) {
 	}, nil
 }
 
+func (ir *IPRange) String() string {
+	return ir.start.String() + "~" + ir.end.String()
+}
+
 func (ir *IPRange) TryAddIP(ipAddr net.IP) (success bool) {
 	if ipAddr.Equal(ip.PrevIP(ir.start)) {
 		ir.start = ipAddr
@@ -18,12 +18,14 @@ package utils
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
 )
 
 type HybridnetDaemonError string
@@ -95,3 +97,18 @@ func GetSysctl(sysctlPath string) (int, error) {
 
 	return val, nil
 }
+
+// DefaultRouteDstByFamily returns the destination of default route in the netlink family.
+func DefaultRouteDstByFamily(family int) *net.IPNet {
+	if family == netlink.FAMILY_V6 {
+		return &net.IPNet{
+			IP:   net.ParseIP("::").To16(),
+			Mask: net.CIDRMask(0, 128),
+		}
+	}
+
+	return &net.IPNet{
+		IP:   net.ParseIP("0.0.0.0").To4(),
+		Mask: net.CIDRMask(0, 32),
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+)
+
+// SelectVtepIPs selects the primary vtep ip in preferred family from candidates, the previous vtep ip will be
+// kept if it is still a candidate of the same family. Secondary one is the first candidate in the other family.
+func SelectVtepIPs(candidates []net.IP, preferIPv6 bool, preVtepIP net.IP) (primary, secondary net.IP) {
+	var preferred, others []net.IP
+	for _, ip := range candidates {
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+
+		if (ip.To4() == nil) == preferIPv6 {
+			preferred = append(preferred, ip)
+		} else {
+			others = append(others, ip)
+		}
+	}
+
+	// fall back to the other family
+	if len(preferred) == 0 {
+		preferred, others = others, nil
+	}
+
+	if len(preferred) == 0 {
+		return nil, nil
+	}
+
+	primary = preferred[0]
+	for _, ip := range preferred {
+		if ip.Equal(preVtepIP) {
+			primary = ip
+			break
+		}
+	}
+
+	if len(others) != 0 {
+		secondary = others[0]
+	}
+
+	return primary, secondary
+}
+
+// IsIPv6VtepSelected returns true if the primary vtep ip selected from candidates is ipv6, the preferred
+// family is returned if there is no candidate.
+func IsIPv6VtepSelected(candidates []net.IP, preferIPv6 bool) bool {
+	primary, _ := SelectVtepIPs(candidates, preferIPv6, nil)
+	if primary == nil {
+		return preferIPv6
+	}
+	return primary.To4() == nil
+}
+
+// PickIPOfSameFamily returns the first candidate which is in the same address family with target.
+func PickIPOfSameFamily(target net.IP, candidates ...net.IP) net.IP {
+	for _, ip := range candidates {
+		if ip != nil && (ip.To4() == nil) == (target.To4() == nil) {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectVtepIPs(t *testing.T) {
+	parseIPs := func(ips ...string) []net.IP {
+		var result []net.IP
+		for _, ip := range ips {
+			result = append(result, net.ParseIP(ip))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name              string
+		candidates        []net.IP
+		preferIPv6        bool
+		preVtepIP         net.IP
+		expectedPrimary   net.IP
+		expectedSecondary net.IP
+	}{
+		{
+			name:              "prefer ipv4 on dual-stack node",
+			candidates:        parseIPs("fd00::1", "192.168.1.1", "192.168.1.2"),
+			expectedPrimary:   net.ParseIP("192.168.1.1"),
+			expectedSecondary: net.ParseIP("fd00::1"),
+		},
+		{
+			name:              "prefer ipv6 on dual-stack node",
+			candidates:        parseIPs("192.168.1.1", "fd00::1"),
+			preferIPv6:        true,
+			expectedPrimary:   net.ParseIP("fd00::1"),
+			expectedSecondary: net.ParseIP("192.168.1.1"),
+		},
+		{
+			name:            "fall back to ipv6",
+			candidates:      parseIPs("fe80::1", "fd00::1"),
+			expectedPrimary: net.ParseIP("fd00::1"),
+		},
+		{
+			name:              "keep previous vtep ip",
+			candidates:        parseIPs("192.168.1.1", "192.168.1.2", "fd00::1"),
+			preVtepIP:         net.ParseIP("192.168.1.2"),
+			expectedPrimary:   net.ParseIP("192.168.1.2"),
+			expectedSecondary: net.ParseIP("fd00::1"),
+		},
+		{
+			name:              "previous vtep ip not in preferred family",
+			candidates:        parseIPs("192.168.1.1", "fd00::1"),
+			preferIPv6:        true,
+			preVtepIP:         net.ParseIP("192.168.1.1"),
+			expectedPrimary:   net.ParseIP("fd00::1"),
+			expectedSecondary: net.ParseIP("192.168.1.1"),
+		},
+		{
+			name:       "no global unicast ip",
+			candidates: parseIPs("fe80::1", "127.0.0.1"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary, secondary := SelectVtepIPs(test.candidates, test.preferIPv6, test.preVtepIP)
+			assert.Equal(t, test.expectedPrimary, primary)
+			assert.Equal(t, test.expectedSecondary, secondary)
+		})
+	}
+}
+
+func TestPickIPOfSameFamily(t *testing.T) {
+	assert.Equal(t, net.ParseIP("fd00::2"),
+		PickIPOfSameFamily(net.ParseIP("fd00::1"), net.ParseIP("192.168.1.2"), net.ParseIP("fd00::2")))
+	assert.Equal(t, net.ParseIP("192.168.1.2"),
+		PickIPOfSameFamily(net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2"), nil))
+	assert.Nil(t, PickIPOfSameFamily(net.ParseIP("192.168.1.1"), net.ParseIP("fd00::2"), nil))
+}
+
+func TestIsIPv6VtepSelected(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []net.IP
+		preferIPv6 bool
+		expected   bool
+	}{
+		{
+			name:       "prefer ipv4 on dual-stack node",
+			candidates: []net.IP{net.ParseIP("fd00::1"), net.ParseIP("192.168.1.1")},
+			expected:   false,
+		},
+		{
+			name:       "prefer ipv6 on dual-stack node",
+			candidates: []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("fd00::1")},
+			preferIPv6: true,
+			expected:   true,
+		},
+		{
+			name:       "fall back to ipv6 if no ipv4 address exists",
+			candidates: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("fd00::1")},
+			expected:   true,
+		},
+		{
+			name:       "fall back to ipv4 if no ipv6 address exists",
+			candidates: []net.IP{net.ParseIP("192.168.1.1")},
+			preferIPv6: true,
+			expected:   false,
+		},
+		{
+			name:       "no candidate",
+			preferIPv6: true,
+			expected:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, IsIPv6VtepSelected(test.candidates, test.preferIPv6))
+		})
+	}
+}
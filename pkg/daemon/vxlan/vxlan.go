@@ -24,8 +24,6 @@ import (
 
 	"github.com/alibaba/hybridnet/pkg/daemon/containernetwork"
 
-	daemonutils "github.com/alibaba/hybridnet/pkg/daemon/utils"
-
 	"github.com/vishvananda/netlink"
 )
 
@@ -66,36 +64,8 @@ func NewVxlanDevice(name string, vxlanID int, parent string, localAddr net.IP, p
 		return nil, err
 	}
 
-	sysctlPath := fmt.Sprintf(containernetwork.IPv4AppSolicitSysctl, link.Name)
-	if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, 1); err != nil {
-		return nil, fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
-	}
-
-	sysctlPath = fmt.Sprintf(containernetwork.IPv4BaseReachableTimeMSSysctl, link.Name)
-	if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, int(1000*baseReachableTime.Seconds())); err != nil {
-		return nil, fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
-	}
-
-	ipv6Disabled, err := containernetwork.CheckIPv6Disabled(link.Name)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check ipv6 disables for link %v: %v", link.Name, err)
-	}
-
-	if !ipv6Disabled {
-		sysctlPath = fmt.Sprintf(containernetwork.IPv6AppSolicitSysctl, link.Name)
-		if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, 1); err != nil {
-			return nil, fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
-		}
-
-		sysctlPath = fmt.Sprintf(containernetwork.IPv6BaseReachableTimeMSSysctl, link.Name)
-		if err := daemonutils.SetSysctlIgnoreNotExist(sysctlPath, int(1000*baseReachableTime.Seconds())); err != nil {
-			return nil, fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
-		}
-
-		sysctlPath = fmt.Sprintf(containernetwork.AcceptRASysctl, link.Name)
-		if err := daemonutils.SetSysctl(sysctlPath, 0); err != nil {
-			return nil, fmt.Errorf("failed to set sysctl parameter %v: %v", sysctlPath, err)
-		}
+	if err := containernetwork.EnsureOverlayLinkSysctls(link.Name, baseReachableTime); err != nil {
+		return nil, err
 	}
 
 	return &Device{
@@ -108,7 +78,7 @@ func (dev *Device) MacAddr() net.HardwareAddr {
 	return dev.link.HardwareAddr
 }
 
-func (dev *Device) Link() *netlink.Vxlan {
+func (dev *Device) Link() netlink.Link {
 	return dev.link
 }
 
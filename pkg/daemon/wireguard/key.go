@@ -0,0 +1,77 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const KeyLen = 32
+
+// Key is a curve25519 key of wireguard.
+type Key [KeyLen]byte
+
+// GeneratePrivateKey generates a random private key in the same way as "wg genkey".
+func GeneratePrivateKey() (Key, error) {
+	var key Key
+	if _, err := rand.Read(key[:]); err != nil {
+		return Key{}, fmt.Errorf("failed to read random bytes: %v", err)
+	}
+
+	// clamp the key, see https://cr.yp.to/ecdh.html
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+	return key, nil
+}
+
+// ParseKey parses a base64 encoded key.
+func ParseKey(s string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to decode key %v: %v", s, err)
+	}
+
+	if len(b) != KeyLen {
+		return Key{}, fmt.Errorf("invalid key length %v, must be %v", len(b), KeyLen)
+	}
+
+	var key Key
+	copy(key[:], b)
+	return key, nil
+}
+
+// PublicKey returns the public key of a private key.
+func (k Key) PublicKey() Key {
+	var publicKey, privateKey [KeyLen]byte
+	privateKey = k
+
+	curve25519.ScalarBaseMult(&publicKey, &privateKey)
+	return publicKey
+}
+
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// String returns the base64 encoded key.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
@@ -0,0 +1,49 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	// test vector of RFC 7748
+	privateKeyBytes, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	publicKeyBytes, _ := hex.DecodeString("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+
+	var privateKey, publicKey Key
+	copy(privateKey[:], privateKeyBytes)
+	copy(publicKey[:], publicKeyBytes)
+
+	assert.Equal(t, publicKey, privateKey.PublicKey())
+
+	parsedKey, err := ParseKey(privateKey.String())
+	assert.Nil(t, err)
+	assert.Equal(t, privateKey, parsedKey)
+
+	_, err = ParseKey("aGVsbG8=")
+	assert.EqualError(t, err, "invalid key length 5, must be 32")
+
+	generatedKey, err := GeneratePrivateKey()
+	assert.Nil(t, err)
+	assert.False(t, generatedKey.IsZero())
+	assert.Equal(t, byte(0), generatedKey[0]&7)
+	assert.Equal(t, byte(64), generatedKey[31]&192)
+}
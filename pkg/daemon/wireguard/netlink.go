@@ -0,0 +1,380 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// wireguard generic netlink api, which is not defined in netlink library, see include/uapi/linux/wireguard.h
+const (
+	wgGenlName    = "wireguard"
+	wgGenlVersion = 1
+
+	wgCmdGetDevice = 0
+	wgCmdSetDevice = 1
+
+	wgDeviceAttrIfName     = 2
+	wgDeviceAttrPrivateKey = 3
+	wgDeviceAttrPublicKey  = 4
+	wgDeviceAttrListenPort = 6
+	wgDeviceAttrPeers      = 8
+
+	wgPeerFlagRemoveMe          = 1
+	wgPeerFlagReplaceAllowedIPs = 2
+
+	wgPeerAttrPublicKey         = 1
+	wgPeerAttrFlags             = 3
+	wgPeerAttrEndpoint          = 4
+	wgPeerAttrLastHandshakeTime = 6
+	wgPeerAttrRxBytes           = 7
+	wgPeerAttrTxBytes           = 8
+	wgPeerAttrAllowedIPs        = 9
+
+	wgAllowedIPAttrFamily   = 1
+	wgAllowedIPAttrIPAddr   = 2
+	wgAllowedIPAttrCidrMask = 3
+
+	nlaTypeMask = ^(nl.NLA_F_NESTED | nl.NLA_F_NET_BYTEORDER)
+
+	sizeofSockaddrInet4 = 16
+	sizeofSockaddrInet6 = 28
+	sizeofTimespec      = 16
+
+	// peers are configured in batches to avoid exceeding the size limit of netlink message
+	maxPeersPerMessage = 64
+)
+
+var native = nl.NativeEndian()
+
+// Peer is a remote wireguard endpoint.
+type Peer struct {
+	PublicKey  Key
+	Endpoint   *net.UDPAddr
+	AllowedIPs []net.IPNet
+
+	// read-only statistics
+	LastHandshakeTime time.Time
+	ReceiveBytes      uint64
+	TransmitBytes     uint64
+}
+
+// Device is the configuration of a wireguard device in kernel.
+type Device struct {
+	Name       string
+	PrivateKey Key
+	PublicKey  Key
+	ListenPort int
+	Peers      []*Peer
+}
+
+// peerConfig is a peer to be added, updated or removed.
+type peerConfig struct {
+	peer   *Peer
+	remove bool
+}
+
+// GetDevice gets the configuration of a wireguard device, including the private key.
+func GetDevice(name string) (*Device, error) {
+	family, err := netlink.GenlFamilyGet(wgGenlName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generic netlink family %v: %v", wgGenlName, err)
+	}
+
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: wgCmdGetDevice, Version: wgGenlVersion})
+	req.AddData(nl.NewRtAttr(wgDeviceAttrIfName, nl.ZeroTerminated(name)))
+
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wireguard device %v: %v", name, err)
+	}
+
+	return parseDeviceMessages(msgs)
+}
+
+// configureDevice updates the private key, listen port and peers of a wireguard device.
+func configureDevice(name string, privateKey *Key, listenPort *int, peers []peerConfig) error {
+	family, err := netlink.GenlFamilyGet(wgGenlName)
+	if err != nil {
+		return fmt.Errorf("failed to get generic netlink family %v: %v", wgGenlName, err)
+	}
+
+	for _, msg := range newSetDeviceMessages(name, privateKey, listenPort, peers) {
+		req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_ACK)
+		req.AddData(&nl.Genlmsg{Command: wgCmdSetDevice, Version: wgGenlVersion})
+		for _, attr := range msg {
+			req.AddData(attr)
+		}
+
+		if _, err := req.Execute(unix.NETLINK_GENERIC, 0); err != nil {
+			return fmt.Errorf("failed to configure wireguard device %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// newSetDeviceMessages generates attributes of messages to configure device, peers will be split into
+// several messages if there are too many of them.
+func newSetDeviceMessages(name string, privateKey *Key, listenPort *int, peers []peerConfig) [][]*nl.RtAttr {
+	var msgs [][]*nl.RtAttr
+
+	attrs := []*nl.RtAttr{nl.NewRtAttr(wgDeviceAttrIfName, nl.ZeroTerminated(name))}
+	if privateKey != nil {
+		attrs = append(attrs, nl.NewRtAttr(wgDeviceAttrPrivateKey, privateKey[:]))
+	}
+	if listenPort != nil {
+		attrs = append(attrs, nl.NewRtAttr(wgDeviceAttrListenPort, nl.Uint16Attr(uint16(*listenPort))))
+	}
+
+	for len(peers) > 0 || len(msgs) == 0 {
+		batch := peers
+		if len(batch) > maxPeersPerMessage {
+			batch = peers[:maxPeersPerMessage]
+		}
+		peers = peers[len(batch):]
+
+		if len(batch) > 0 {
+			peersAttr := nl.NewRtAttr(int(nl.NLA_F_NESTED)|wgDeviceAttrPeers, nil)
+			for i, config := range batch {
+				peersAttr.AddChild(serializePeer(i, config))
+			}
+			attrs = append(attrs, peersAttr)
+		}
+
+		msgs = append(msgs, attrs)
+		attrs = []*nl.RtAttr{nl.NewRtAttr(wgDeviceAttrIfName, nl.ZeroTerminated(name))}
+	}
+
+	return msgs
+}
+
+func serializePeer(index int, config peerConfig) *nl.RtAttr {
+	peerAttr := nl.NewRtAttr(int(nl.NLA_F_NESTED)|index, nil)
+	peerAttr.AddRtAttr(wgPeerAttrPublicKey, config.peer.PublicKey[:])
+
+	if config.remove {
+		peerAttr.AddRtAttr(wgPeerAttrFlags, nl.Uint32Attr(wgPeerFlagRemoveMe))
+		return peerAttr
+	}
+
+	peerAttr.AddRtAttr(wgPeerAttrFlags, nl.Uint32Attr(wgPeerFlagReplaceAllowedIPs))
+	if config.peer.Endpoint != nil {
+		peerAttr.AddRtAttr(wgPeerAttrEndpoint, serializeSockaddr(config.peer.Endpoint))
+	}
+
+	allowedIPsAttr := peerAttr.AddRtAttr(int(nl.NLA_F_NESTED)|wgPeerAttrAllowedIPs, nil)
+	for i, allowedIP := range config.peer.AllowedIPs {
+		family, ip := uint16(unix.AF_INET), allowedIP.IP.To4()
+		if ip == nil {
+			family, ip = unix.AF_INET6, allowedIP.IP.To16()
+		}
+		ones, _ := allowedIP.Mask.Size()
+
+		allowedIPAttr := allowedIPsAttr.AddRtAttr(int(nl.NLA_F_NESTED)|i, nil)
+		allowedIPAttr.AddRtAttr(wgAllowedIPAttrFamily, nl.Uint16Attr(family))
+		allowedIPAttr.AddRtAttr(wgAllowedIPAttrIPAddr, ip)
+		allowedIPAttr.AddRtAttr(wgAllowedIPAttrCidrMask, nl.Uint8Attr(uint8(ones)))
+	}
+
+	return peerAttr
+}
+
+// parseDeviceMessages parses the dumped messages of a device, the peers of a device (and the allowed ips
+// of a peer) can be split into several messages.
+func parseDeviceMessages(msgs [][]byte) (*Device, error) {
+	device := &Device{}
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofGenlmsg {
+			return nil, fmt.Errorf("invalid generic netlink message length %v", len(msg))
+		}
+
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse device attributes: %v", err)
+		}
+
+		for _, attr := range attrs {
+			switch attr.Attr.Type & nlaTypeMask {
+			case wgDeviceAttrIfName:
+				device.Name = string(attr.Value[:len(attr.Value)-1])
+			case wgDeviceAttrPrivateKey:
+				copy(device.PrivateKey[:], attr.Value)
+			case wgDeviceAttrPublicKey:
+				copy(device.PublicKey[:], attr.Value)
+			case wgDeviceAttrListenPort:
+				device.ListenPort = int(native.Uint16(attr.Value))
+			case wgDeviceAttrPeers:
+				peerAttrs, err := nl.ParseRouteAttr(attr.Value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse peers: %v", err)
+				}
+
+				for _, peerAttr := range peerAttrs {
+					peer, err := parsePeer(peerAttr.Value)
+					if err != nil {
+						return nil, err
+					}
+
+					// continuation of the last peer in previous message
+					if len(device.Peers) > 0 && device.Peers[len(device.Peers)-1].PublicKey == peer.PublicKey {
+						last := device.Peers[len(device.Peers)-1]
+						last.AllowedIPs = append(last.AllowedIPs, peer.AllowedIPs...)
+						continue
+					}
+					device.Peers = append(device.Peers, peer)
+				}
+			}
+		}
+	}
+
+	return device, nil
+}
+
+func parsePeer(data []byte) (*Peer, error) {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer attributes: %v", err)
+	}
+
+	peer := &Peer{}
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nlaTypeMask {
+		case wgPeerAttrPublicKey:
+			copy(peer.PublicKey[:], attr.Value)
+		case wgPeerAttrEndpoint:
+			if peer.Endpoint, err = parseSockaddr(attr.Value); err != nil {
+				return nil, err
+			}
+		case wgPeerAttrLastHandshakeTime:
+			if len(attr.Value) != sizeofTimespec {
+				return nil, fmt.Errorf("invalid last handshake time length %v", len(attr.Value))
+			}
+			sec, nsec := int64(native.Uint64(attr.Value[0:8])), int64(native.Uint64(attr.Value[8:16]))
+			if sec != 0 || nsec != 0 {
+				peer.LastHandshakeTime = time.Unix(sec, nsec)
+			}
+		case wgPeerAttrRxBytes:
+			peer.ReceiveBytes = native.Uint64(attr.Value)
+		case wgPeerAttrTxBytes:
+			peer.TransmitBytes = native.Uint64(attr.Value)
+		case wgPeerAttrAllowedIPs:
+			if peer.AllowedIPs, err = parseAllowedIPs(attr.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return peer, nil
+}
+
+func parseAllowedIPs(data []byte) ([]net.IPNet, error) {
+	allowedIPAttrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse allowed ips: %v", err)
+	}
+
+	var allowedIPs []net.IPNet
+	for _, allowedIPAttr := range allowedIPAttrs {
+		attrs, err := nl.ParseRouteAttr(allowedIPAttr.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse allowed ip attributes: %v", err)
+		}
+
+		var family uint16
+		var ip net.IP
+		var cidrMask int
+		for _, attr := range attrs {
+			switch attr.Attr.Type & nlaTypeMask {
+			case wgAllowedIPAttrFamily:
+				family = native.Uint16(attr.Value)
+			case wgAllowedIPAttrIPAddr:
+				ip = make(net.IP, len(attr.Value))
+				copy(ip, attr.Value)
+			case wgAllowedIPAttrCidrMask:
+				cidrMask = int(attr.Value[0])
+			}
+		}
+
+		bits := 8 * net.IPv4len
+		if family == unix.AF_INET6 {
+			bits = 8 * net.IPv6len
+		}
+
+		allowedIPs = append(allowedIPs, net.IPNet{IP: ip, Mask: net.CIDRMask(cidrMask, bits)})
+	}
+
+	return allowedIPs, nil
+}
+
+// serializeSockaddr serializes udp address into struct sockaddr_in or sockaddr_in6.
+func serializeSockaddr(addr *net.UDPAddr) []byte {
+	if ip := addr.IP.To4(); ip != nil {
+		b := make([]byte, sizeofSockaddrInet4)
+		native.PutUint16(b[0:2], unix.AF_INET)
+		binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+		copy(b[4:8], ip)
+		return b
+	}
+
+	b := make([]byte, sizeofSockaddrInet6)
+	native.PutUint16(b[0:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+	copy(b[8:24], addr.IP.To16())
+	return b
+}
+
+func parseSockaddr(b []byte) (*net.UDPAddr, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("invalid sockaddr length %v", len(b))
+	}
+
+	switch native.Uint16(b[0:2]) {
+	case unix.AF_INET:
+		if len(b) < sizeofSockaddrInet4 {
+			return nil, fmt.Errorf("invalid sockaddr_in length %v", len(b))
+		}
+		return &net.UDPAddr{
+			IP:   net.IPv4(b[4], b[5], b[6], b[7]),
+			Port: int(binary.BigEndian.Uint16(b[2:4])),
+		}, nil
+	case unix.AF_INET6:
+		if len(b) < sizeofSockaddrInet6 {
+			return nil, fmt.Errorf("invalid sockaddr_in6 length %v", len(b))
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, b[8:24])
+		return &net.UDPAddr{
+			IP:   ip,
+			Port: int(binary.BigEndian.Uint16(b[2:4])),
+		}, nil
+	case syscall.AF_UNSPEC:
+		// endpoint is not set
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown sockaddr family %v", native.Uint16(b[0:2]))
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink/nl"
+)
+
+func serializeMessage(attrs []*nl.RtAttr) []byte {
+	msg := (&nl.Genlmsg{Command: wgCmdGetDevice, Version: wgGenlVersion}).Serialize()
+	for _, attr := range attrs {
+		msg = append(msg, attr.Serialize()...)
+	}
+	return msg
+}
+
+func TestDeviceMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		peers         []*Peer
+		expectedCount int
+	}{
+		{
+			name:          "no peer",
+			expectedCount: 1,
+		},
+		{
+			name: "ipv4 and ipv6 peers",
+			peers: []*Peer{
+				{
+					PublicKey:  Key{1},
+					Endpoint:   &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: 51820},
+					AllowedIPs: []net.IPNet{{IP: net.ParseIP("192.168.0.1"), Mask: net.CIDRMask(32, 32)}},
+				},
+				{
+					PublicKey:  Key{2},
+					Endpoint:   &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 51820},
+					AllowedIPs: []net.IPNet{{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(128, 128)}},
+				},
+			},
+			expectedCount: 1,
+		},
+		{
+			name:          "too many peers",
+			peers:         generatePeers(2*maxPeersPerMessage + 1),
+			expectedCount: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			privateKey := Key{255}
+			listenPort := 51820
+
+			var configs []peerConfig
+			for _, peer := range test.peers {
+				configs = append(configs, peerConfig{peer: peer})
+			}
+
+			msgs := newSetDeviceMessages("wg0", &privateKey, &listenPort, configs)
+			assert.Equal(t, test.expectedCount, len(msgs))
+
+			var rawMsgs [][]byte
+			for _, msg := range msgs {
+				rawMsgs = append(rawMsgs, serializeMessage(msg))
+			}
+
+			device, err := parseDeviceMessages(rawMsgs)
+			assert.Nil(t, err)
+			assert.Equal(t, "wg0", device.Name)
+			assert.Equal(t, privateKey, device.PrivateKey)
+			assert.Equal(t, listenPort, device.ListenPort)
+			assert.Equal(t, len(test.peers), len(device.Peers))
+
+			for i, peer := range device.Peers {
+				assert.Equal(t, test.peers[i].PublicKey, peer.PublicKey)
+				assert.True(t, isPeerConfigEqual(test.peers[i], peer))
+			}
+		})
+	}
+}
+
+func TestParseSplitPeer(t *testing.T) {
+	peer := &Peer{
+		PublicKey: Key{1},
+		AllowedIPs: []net.IPNet{
+			{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(32, 32)},
+			{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(32, 32)},
+		},
+	}
+
+	var rawMsgs [][]byte
+	for i := range peer.AllowedIPs {
+		splitPeer := &Peer{PublicKey: peer.PublicKey, AllowedIPs: peer.AllowedIPs[i : i+1]}
+		msgs := newSetDeviceMessages("wg0", nil, nil, []peerConfig{{peer: splitPeer}})
+		rawMsgs = append(rawMsgs, serializeMessage(msgs[0]))
+	}
+
+	device, err := parseDeviceMessages(rawMsgs)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(device.Peers))
+	assert.True(t, isPeerConfigEqual(peer, device.Peers[0]))
+}
+
+func TestSockaddr(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.ParseIP("192.168.0.1"), Port: 51820},
+		{IP: net.ParseIP("fd00::1"), Port: 6081},
+	} {
+		parsed, err := parseSockaddr(serializeSockaddr(addr))
+		assert.Nil(t, err)
+		assert.Equal(t, addr.String(), parsed.String())
+	}
+}
+
+func generatePeers(count int) []*Peer {
+	var peers []*Peer
+	for i := 0; i < count; i++ {
+		ip := net.IPv4(10, 0, byte(i/256), byte(i%256))
+		peers = append(peers, &Peer{
+			PublicKey:  Key{byte(i / 256), byte(i % 256), 1},
+			Endpoint:   &net.UDPAddr{IP: ip, Port: 51820},
+			AllowedIPs: []net.IPNet{{IP: ip, Mask: net.CIDRMask(32, 32)}},
+		})
+	}
+	return peers
+}
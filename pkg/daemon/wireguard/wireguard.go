@@ -0,0 +1,158 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package wireguard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// EnsureDevice ensures the wireguard device exists with a private key. The existing private key of
+// device is always kept, so peers need not to be updated after daemon restarts.
+func EnsureDevice(name string, listenPort, mtu int) (*Device, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return nil, fmt.Errorf("failed to get link %v: %v", name, err)
+		}
+
+		if err := netlink.LinkAdd(&netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: name, MTU: mtu}}); err != nil {
+			return nil, fmt.Errorf("failed to create wireguard interface %v: %v", name, err)
+		}
+
+		if link, err = netlink.LinkByName(name); err != nil {
+			return nil, fmt.Errorf("can't locate created wireguard device %v: %v", name, err)
+		}
+	}
+
+	if link.Type() != "wireguard" {
+		return nil, fmt.Errorf("link %v exists and is not wireguard device", name)
+	}
+
+	if link.Attrs().MTU != mtu {
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			return nil, fmt.Errorf("failed to set mtu of link %v to %v: %v", name, mtu, err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set link %v up: %v", name, err)
+	}
+
+	device, err := GetDevice(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var privateKey *Key
+	if device.PrivateKey.IsZero() {
+		key, err := GeneratePrivateKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate private key: %v", err)
+		}
+		privateKey = &key
+	}
+
+	var port *int
+	if device.ListenPort != listenPort {
+		port = &listenPort
+	}
+
+	if privateKey == nil && port == nil {
+		return device, nil
+	}
+
+	if err := configureDevice(name, privateKey, port, nil); err != nil {
+		return nil, err
+	}
+
+	return GetDevice(name)
+}
+
+// SyncPeers makes peers of wireguard device the same as expected ones, unchanged peers will not be touched
+// to keep their sessions.
+func SyncPeers(name string, peers []*Peer) error {
+	device, err := GetDevice(name)
+	if err != nil {
+		return err
+	}
+
+	existPeerMap := map[Key]*Peer{}
+	for _, peer := range device.Peers {
+		existPeerMap[peer.PublicKey] = peer
+	}
+
+	expectedPeerMap := map[Key]bool{}
+	var configs []peerConfig
+	for _, peer := range peers {
+		expectedPeerMap[peer.PublicKey] = true
+
+		if existPeer, exist := existPeerMap[peer.PublicKey]; exist && isPeerConfigEqual(existPeer, peer) {
+			continue
+		}
+		configs = append(configs, peerConfig{peer: peer})
+	}
+
+	for _, peer := range device.Peers {
+		if !expectedPeerMap[peer.PublicKey] {
+			configs = append(configs, peerConfig{peer: peer, remove: true})
+		}
+	}
+
+	if len(configs) == 0 {
+		return nil
+	}
+
+	return configureDevice(name, nil, nil, configs)
+}
+
+// DeleteDevice deletes the wireguard device if it exists.
+func DeleteDevice(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return fmt.Errorf("failed to get link %v: %v", name, err)
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete link %v: %v", name, err)
+	}
+	return nil
+}
+
+func isPeerConfigEqual(a, b *Peer) bool {
+	if (a.Endpoint == nil) != (b.Endpoint == nil) ||
+		(a.Endpoint != nil && a.Endpoint.String() != b.Endpoint.String()) {
+		return false
+	}
+
+	return allowedIPsString(a) == allowedIPsString(b)
+}
+
+func allowedIPsString(peer *Peer) string {
+	var allowedIPs []string
+	for _, allowedIP := range peer.AllowedIPs {
+		allowedIPs = append(allowedIPs, allowedIP.String())
+	}
+	sort.Strings(allowedIPs)
+	return strings.Join(allowedIPs, ",")
+}
@@ -45,6 +45,9 @@ const (
 	DualStack featuregate.Feature = "DualStack"
 
 	MultiCluster featuregate.Feature = "MultiCluster"
+
+	// Allocate ips of LoadBalancer type services from load balancer subnets.
+	LoadBalancer featuregate.Feature = "LoadBalancer"
 )
 
 var DefaultHybridnetFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
@@ -56,6 +59,10 @@ var DefaultHybridnetFeatureGates = map[featuregate.Feature]featuregate.FeatureSp
 		Default:    false,
 		PreRelease: featuregate.Alpha,
 	},
+	LoadBalancer: {
+		Default:    false,
+		PreRelease: featuregate.Alpha,
+	},
 }
 
 func DualStackEnabled() bool {
@@ -66,6 +73,10 @@ func MultiClusterEnabled() bool {
 	return feature.DefaultMutableFeatureGate.Enabled(MultiCluster)
 }
 
+func LoadBalancerEnabled() bool {
+	return feature.DefaultMutableFeatureGate.Enabled(LoadBalancer)
+}
+
 func KnownFeatures() []string {
 	return feature.DefaultMutableFeatureGate.KnownFeatures()
 }
@@ -131,12 +131,12 @@ func (a *Allocator) Assign(networkName, subnetName, podName, podNamespace, ip st
 
 	subnet, err := network.GetSubnetByIP(subnetName, ip)
 	if err != nil {
-		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %v", subnetName, ip, err)
+		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %w", subnetName, ip, err)
 	}
 
 	assignedIP, err := subnet.Assign(podName, podNamespace, ip, forced)
 	if err != nil {
-		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %v", ip, subnetName, err)
+		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %w", ip, subnetName, err)
 	}
 
 	return assignedIP, nil
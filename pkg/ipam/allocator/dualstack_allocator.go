@@ -246,12 +246,12 @@ func (d *DualStackAllocator) assignIPv4Only(networkName string, subnets, IPs []s
 
 	var subnet *types.Subnet
 	if subnet, err = network.GetSubnetByIP(subnetName, ip); err != nil {
-		return nil, fmt.Errorf("fail to get subnets %s by ip %s: %v", subnetName, ip, err)
+		return nil, fmt.Errorf("fail to get subnets %s by ip %s: %w", subnetName, ip, err)
 	}
 
 	var assignedIP *types.IP
 	if assignedIP, err = subnet.Assign(podName, podNamespace, ip, forced); err != nil {
-		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %v", ip, subnetName, err)
+		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %w", ip, subnetName, err)
 	}
 
 	assignedIPs = append(assignedIPs, assignedIP)
@@ -285,12 +285,12 @@ func (d *DualStackAllocator) assignIPv6Only(networkName string, subnets, IPs []s
 
 	var subnet *types.Subnet
 	if subnet, err = network.GetSubnetByIP(subnetName, ip); err != nil {
-		return nil, fmt.Errorf("fail to get subnets %s by ip %s: %v", subnetName, ip, err)
+		return nil, fmt.Errorf("fail to get subnets %s by ip %s: %w", subnetName, ip, err)
 	}
 
 	var assignedIP *types.IP
 	if assignedIP, err = subnet.Assign(podName, podNamespace, ip, forced); err != nil {
-		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %v", ip, subnetName, err)
+		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %w", ip, subnetName, err)
 	}
 
 	assignedIPs = append(assignedIPs, assignedIP)
@@ -324,18 +324,23 @@ func (d *DualStackAllocator) assignDualStack(networkName string, subnets, IPs []
 
 	var v4Subnet, v6Subnet *types.Subnet
 	if v4Subnet, err = network.GetSubnetByIP(v4Name, ipv4); err != nil {
-		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %v", v4Name, ipv4, err)
+		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %w", v4Name, ipv4, err)
 	}
 	if v6Subnet, err = network.GetSubnetByIP(v6Name, ipv6); err != nil {
-		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %v", v6Name, ipv6, err)
+		return nil, fmt.Errorf("fail to get subnet %s by ip %s: %w", v6Name, ipv6, err)
 	}
 
 	var assignedIPv4, assignedIPv6 *types.IP
+	var ipv4InUse = v4Subnet.UsingIPs.Has(ipv4)
 	if assignedIPv4, err = v4Subnet.Assign(podName, podNamespace, ipv4, forced); err != nil {
-		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %v", ipv4, v4Name, err)
+		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %w", ipv4, v4Name, err)
 	}
 	if assignedIPv6, err = v6Subnet.Assign(podName, podNamespace, ipv6, forced); err != nil {
-		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %v", ipv6, v6Name, err)
+		// roll back the ipv4 assignment only if it is newly taken
+		if !ipv4InUse {
+			v4Subnet.Release(ipv4)
+		}
+		return nil, fmt.Errorf("fail to assign ip %s in subnet %s: %w", ipv6, v6Name, err)
 	}
 
 	assignedIPs = append(assignedIPs, assignedIPv4, assignedIPv6)
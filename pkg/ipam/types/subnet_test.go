@@ -83,3 +83,100 @@ func TestSubnet_AllocateNext(t *testing.T) {
 		t.Logf("the %d ip is %s", i, allocatedIP)
 	}
 }
+
+func TestSubnet_Assign(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("192.168.0.0/24")
+	subnet := NewSubnet("test", "fake", nil, nil, nil, net.ParseIP("192.168.0.1"), cidr, nil,
+		map[string]struct{}{"192.168.0.100": {}}, nil, false, false)
+	if err := subnet.Canonicalize(); err != nil {
+		t.Fatalf("fail to canonicalize: %v", err)
+	}
+	if err := subnet.Sync(nil, NewIPSet()); err != nil {
+		t.Fatalf("fail to sync: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		podName      string
+		ip           string
+		forced       bool
+		expectedErr  error
+		expectedName string
+	}{
+		{
+			"assign an idle ip",
+			"pod1",
+			"192.168.0.10",
+			false,
+			nil,
+			"pod1",
+		},
+		{
+			"assign the same ip to the same pod again",
+			"pod1",
+			"192.168.0.10",
+			false,
+			nil,
+			"pod1",
+		},
+		{
+			"assign an ip in use by another pod",
+			"pod2",
+			"192.168.0.10",
+			false,
+			ErrNotAvailableAssignedIP,
+			"",
+		},
+		{
+			"assign an ip in use by another pod forcedly",
+			"pod2",
+			"192.168.0.10",
+			true,
+			ErrNotAvailableAssignedIP,
+			"",
+		},
+		{
+			"assign an ip out of cidr",
+			"pod2",
+			"192.168.1.10",
+			false,
+			ErrNotFoundAssignedIP,
+			"",
+		},
+		{
+			"assign the gateway ip",
+			"pod2",
+			"192.168.0.1",
+			false,
+			ErrNotFoundAssignedIP,
+			"",
+		},
+		{
+			"assign the network address",
+			"pod2",
+			"192.168.0.0",
+			false,
+			ErrNotFoundAssignedIP,
+			"",
+		},
+		{
+			"assign an excluded ip",
+			"pod2",
+			"192.168.0.100",
+			false,
+			ErrNotFoundAssignedIP,
+			"",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assignedIP, err := subnet.Assign(test.podName, "ns", test.ip, test.forced)
+			if err != test.expectedErr {
+				t.Fatalf("test %s fails: expected error %v but got %v", test.name, test.expectedErr, err)
+			}
+			if err == nil && assignedIP.PodName != test.expectedName {
+				t.Fatalf("test %s fails: expected pod %s but got %s", test.name, test.expectedName, assignedIP.PodName)
+			}
+		})
+	}
+}
@@ -25,6 +25,14 @@ func init() {
 	metrics.Registry.MustRegister(IPUsageGauge,
 		IPAllocationPeriodSummary,
 		RemoteClusterStatusCheckDuration,
+		HostNetworkRepairCounter,
+		WireGuardPeerTransferBytesGauge,
+		WireGuardPeerLatestHandshakeGauge,
+		BFDSessionStateGauge,
+		BGPPeerSessionStateGauge,
+		BGPPeerUptimeGauge,
+		BGPPeerPrefixesGauge,
+		BGPNetworkDisconnectedNodesGauge,
 	)
 }
 
@@ -55,6 +63,7 @@ var IPUsageGauge = prometheus.NewGaugeVec(
 const (
 	IPStatefulAllocateType = "stateful"
 	IPNormalAllocateType   = "normal"
+	IPSpecifiedAssignType  = "specified"
 )
 
 var IPAllocationPeriodSummary = prometheus.NewSummaryVec(
@@ -79,3 +88,104 @@ var RemoteClusterStatusCheckDuration = prometheus.NewHistogramVec(
 		"clusterName",
 	},
 )
+
+const (
+	RepairObjectIPtables = "iptables"
+	RepairObjectRoute    = "route"
+	RepairObjectRule     = "rule"
+	RepairObjectNeigh    = "neigh"
+)
+
+var HostNetworkRepairCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "host_network_repair_total",
+		Help: "the count of repairs for host network configurations tampered by others",
+	},
+	[]string{
+		"object",
+		"ipFamily",
+	},
+)
+
+const (
+	WireGuardReceiveDirection  = "receive"
+	WireGuardTransmitDirection = "transmit"
+)
+
+var WireGuardPeerTransferBytesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "wireguard_peer_transfer_bytes",
+		Help: "the bytes transferred with wireguard peers since their sessions are configured",
+	},
+	[]string{
+		"peer",
+		"direction",
+	},
+)
+
+var WireGuardPeerLatestHandshakeGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "wireguard_peer_latest_handshake_seconds",
+		Help: "the unix timestamp of latest handshake with wireguard peers, 0 if never",
+	},
+	[]string{
+		"peer",
+	},
+)
+
+var BFDSessionStateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bfd_session_state",
+		Help: "the state of bfd sessions with bgp peers, 0 for AdminDown, 1 for Down, 2 for Init and 3 for Up",
+	},
+	[]string{
+		"peer",
+	},
+)
+
+var BGPPeerSessionStateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bgp_peer_session_state",
+		Help: "the state of bgp sessions with peers, 1 for Idle, 2 for Connect, 3 for Active, 4 for OpenSent, " +
+			"5 for OpenConfirm and 6 for Established",
+	},
+	[]string{
+		"peer",
+	},
+)
+
+var BGPPeerUptimeGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bgp_peer_uptime_seconds",
+		Help: "the seconds since bgp sessions with peers are established, 0 if not established",
+	},
+	[]string{
+		"peer",
+	},
+)
+
+const (
+	BGPReceivedPrefixesType   = "received"
+	BGPAdvertisedPrefixesType = "advertised"
+)
+
+var BGPPeerPrefixesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bgp_peer_prefixes",
+		Help: "the count of prefixes received from or advertised to bgp peers",
+	},
+	[]string{
+		"peer",
+		"prefixesType",
+	},
+)
+
+var BGPNetworkDisconnectedNodesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bgp_network_disconnected_nodes",
+		Help: "the count of nodes in bgp networks without any established session with bgp peers",
+	},
+	[]string{
+		"networkName",
+	},
+)
@@ -49,11 +49,21 @@ type IPAddress struct {
 	Protocol networkingv1.IPVersion `json:"protocol"`
 }
 
+type Route struct {
+	// destination with mask
+	Dst string `json:"dst"`
+
+	Gateway  string                 `json:"gateway"`
+	Protocol networkingv1.IPVersion `json:"protocol"`
+}
+
 // PodResponse is the cnidaemon response format
 type PodResponse struct {
-	IPAddress     []IPAddress `json:"address"`
-	HostInterface string      `json:"host_interface"`
-	Err           string      `json:"error"`
+	IPAddress     []IPAddress             `json:"address"`
+	Routes        []Route                 `json:"routes,omitempty"`
+	DNS           *networkingv1.DNSConfig `json:"dns,omitempty"`
+	HostInterface string                  `json:"host_interface"`
+	Err           string                  `json:"error"`
 }
 
 // NewCniDaemonClient return a new cnidaemonclient
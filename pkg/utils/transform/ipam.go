@@ -37,7 +37,8 @@ func TransferSubnetForIPAM(in *v1.Subnet) *ipamtypes.Subnet {
 		utils.StringSliceToMap(in.Spec.Range.ReservedIPs),
 		utils.StringSliceToMap(in.Spec.Range.ExcludeIPs),
 		net.ParseIP(in.Status.LastAllocatedIP),
-		v1.IsPrivateSubnet(in),
+		// load balancer subnets are only allocated from when specified
+		v1.IsPrivateSubnet(in) || v1.IsLoadBalancerSubnet(in),
 		v1.IsIPv6Subnet(in),
 	)
 }
@@ -78,3 +79,104 @@ func int32pToUint32p(in *int32) *uint32 {
 	temp := uint32(*in)
 	return &temp
 }
+
+// VirtualIPOwnerName returns the owner name of virtual ip in IPAM, which never
+// conflicts with pod names because colons are not allowed in pod names
+func VirtualIPOwnerName(name string) string {
+	return "virtualip:" + name
+}
+
+func TransferVirtualIPForIPAM(in *v1.VirtualIP) *ipamtypes.IP {
+	ip := net.ParseIP(in.Status.IP)
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+
+	return &ipamtypes.IP{
+		Address: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		},
+		Subnet:       in.Spec.Subnet,
+		Network:      in.Status.Network,
+		PodName:      VirtualIPOwnerName(in.Name),
+		PodNamespace: in.Namespace,
+		Status:       ipamtypes.IPStatusUsing,
+	}
+}
+
+// IPReservationOwnerName returns the owner name of ip reservation in IPAM
+func IPReservationOwnerName(name string) string {
+	return "ipreservation:" + name
+}
+
+func TransferIPReservationForIPAM(in *v1.IPReservation) *ipamtypes.IP {
+	ip := net.ParseIP(in.Spec.IP)
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+
+	return &ipamtypes.IP{
+		Address: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		},
+		Subnet:  in.Spec.Subnet,
+		Network: in.Status.Network,
+		PodName: IPReservationOwnerName(in.Name),
+		Status:  ipamtypes.IPStatusUsing,
+	}
+}
+
+// LoadBalancerIPOwnerName returns the owner name of load balancer ip in IPAM
+func LoadBalancerIPOwnerName(name string) string {
+	return "loadbalancerip:" + name
+}
+
+func TransferLoadBalancerIPForIPAM(in *v1.LoadBalancerIP) *ipamtypes.IP {
+	ip := net.ParseIP(in.Status.IP)
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+
+	return &ipamtypes.IP{
+		Address: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		},
+		Subnet:       in.Status.Subnet,
+		Network:      in.Status.Network,
+		PodName:      LoadBalancerIPOwnerName(in.Name),
+		PodNamespace: in.Namespace,
+		Status:       ipamtypes.IPStatusUsing,
+	}
+}
+
+// AnycastIPOwnerName returns the owner name of anycast ip in IPAM, the anycast ip itself is
+// the only owner of address no matter how many pods it is configured on
+func AnycastIPOwnerName(name string) string {
+	return "anycastip:" + name
+}
+
+func TransferAnycastIPForIPAM(in *v1.AnycastIP) *ipamtypes.IP {
+	ip := net.ParseIP(in.Status.IP)
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+
+	return &ipamtypes.IP{
+		Address: &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		},
+		Subnet:       in.Spec.Subnet,
+		Network:      in.Status.Network,
+		PodName:      AnycastIPOwnerName(in.Name),
+		PodNamespace: in.Namespace,
+		Status:       ipamtypes.IPStatusUsing,
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package transform
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
+)
+
+func TestTransferAnycastIPForIPAM(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		expected *net.IPNet
+	}{
+		{
+			name: "ipv4",
+			ip:   "192.168.0.53",
+			expected: &net.IPNet{
+				IP:   net.ParseIP("192.168.0.53"),
+				Mask: net.CIDRMask(32, 32),
+			},
+		},
+		{
+			name: "ipv6",
+			ip:   "fd00::53",
+			expected: &net.IPNet{
+				IP:   net.ParseIP("fd00::53"),
+				Mask: net.CIDRMask(128, 128),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			anycastIP := &v1.AnycastIP{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "dns",
+				},
+				Spec: v1.AnycastIPSpec{
+					Subnet: "subnet1",
+				},
+				Status: v1.AnycastIPStatus{
+					Network: "network1",
+					IP:      test.ip,
+				},
+			}
+
+			assert.Equal(t, &ipamtypes.IP{
+				Address:      test.expected,
+				Subnet:       "subnet1",
+				Network:      "network1",
+				PodName:      "anycastip:dns",
+				PodNamespace: "default",
+				Status:       ipamtypes.IPStatusUsing,
+			}, TransferAnycastIPForIPAM(anycastIP))
+		})
+	}
+}
@@ -36,6 +36,10 @@ func SelectNetworkAndSubnetFromObject(ctx context.Context, c client.Reader, obj
 			return "", "", fmt.Errorf("specified subnet %s not found", subnetName)
 		}
 
+		if networkingv1.IsLoadBalancerSubnet(subnet) {
+			return "", "", fmt.Errorf("specified subnet %s is only for load balancer ips", subnetName)
+		}
+
 		if len(subnetNames) == 2 {
 			if index == 0 {
 				if subnet.Spec.Range.Version != networkingv1.IPv4 {
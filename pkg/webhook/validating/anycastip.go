@@ -0,0 +1,134 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	webhookutils "github.com/alibaba/hybridnet/pkg/webhook/utils"
+)
+
+var anycastIPGVK = gvkConverter(networkingv1.GroupVersion.WithKind("AnycastIP"))
+
+func init() {
+	createHandlers[anycastIPGVK] = AnycastIPCreateValidation
+	updateHandlers[anycastIPGVK] = AnycastIPUpdateValidation
+}
+
+func AnycastIPCreateValidation(ctx context.Context, req *admission.Request, handler *Handler) admission.Response {
+	logger := log.FromContext(ctx)
+
+	anycastIP := &networkingv1.AnycastIP{}
+	err := handler.Decoder.Decode(*req, anycastIP)
+	if err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+
+	// Selector validation
+	if anycastIP.Spec.Selector == nil ||
+		(len(anycastIP.Spec.Selector.MatchLabels) == 0 && len(anycastIP.Spec.Selector.MatchExpressions) == 0) {
+		return webhookutils.AdmissionDeniedWithLog("selector must not be empty", logger)
+	}
+	if _, err = metav1.LabelSelectorAsSelector(anycastIP.Spec.Selector); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid selector: %v", err), logger)
+	}
+
+	// Subnet validation
+	subnet := &networkingv1.Subnet{}
+	if err = handler.Client.Get(ctx, types.NamespacedName{Name: anycastIP.Spec.Subnet}, subnet); err != nil {
+		if errors.IsNotFound(err) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("subnet %s does not exist", anycastIP.Spec.Subnet), logger)
+		}
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	if networkingv1.IsLoadBalancerSubnet(subnet) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("subnet %s is only for load balancer ips", subnet.Name), logger)
+	}
+
+	network := &networkingv1.Network{}
+	if err = handler.Client.Get(ctx, types.NamespacedName{Name: subnet.Spec.Network}, network); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	// anycast ip is advertised by multiple nodes at the same time, which relies on ecmp of bgp
+	if networkingv1.GetNetworkMode(network) != networkingv1.NetworkModeBGP {
+		return webhookutils.AdmissionDeniedWithLog("anycast ip is only supported in bgp network", logger)
+	}
+
+	// Address validation
+	if len(anycastIP.Spec.Address) > 0 {
+		ip := net.ParseIP(anycastIP.Spec.Address)
+		if ip == nil {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid address %s", anycastIP.Spec.Address), logger)
+		}
+
+		_, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR)
+		if err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+		if !cidr.Contains(ip) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("address %s is not in subnet %s", anycastIP.Spec.Address, subnet.Name), logger)
+		}
+	}
+
+	return admission.Allowed("validation pass")
+}
+
+func AnycastIPUpdateValidation(ctx context.Context, req *admission.Request, handler *Handler) admission.Response {
+	logger := log.FromContext(ctx)
+
+	var err error
+	oldV, newV := &networkingv1.AnycastIP{}, &networkingv1.AnycastIP{}
+	if err = handler.Decoder.DecodeRaw(req.Object, newV); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+	if err = handler.Decoder.DecodeRaw(req.OldObject, oldV); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+
+	if oldV.Spec.Subnet != newV.Spec.Subnet {
+		return webhookutils.AdmissionDeniedWithLog("must not change subnet", logger)
+	}
+
+	if oldV.Spec.Address != newV.Spec.Address {
+		return webhookutils.AdmissionDeniedWithLog("must not change address", logger)
+	}
+
+	if !reflect.DeepEqual(oldV.Spec.Selector, newV.Spec.Selector) {
+		if newV.Spec.Selector == nil ||
+			(len(newV.Spec.Selector.MatchLabels) == 0 && len(newV.Spec.Selector.MatchExpressions) == 0) {
+			return webhookutils.AdmissionDeniedWithLog("selector must not be empty", logger)
+		}
+		if _, err = metav1.LabelSelectorAsSelector(newV.Spec.Selector); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid selector: %v", err), logger)
+		}
+	}
+
+	return admission.Allowed("validation pass")
+}
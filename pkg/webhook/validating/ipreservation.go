@@ -0,0 +1,174 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	cniip "github.com/containernetworking/plugins/pkg/ip"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+	webhookutils "github.com/alibaba/hybridnet/pkg/webhook/utils"
+)
+
+var ipReservationGVK = gvkConverter(networkingv1.GroupVersion.WithKind("IPReservation"))
+
+func init() {
+	createHandlers[ipReservationGVK] = IPReservationCreateValidation
+	updateHandlers[ipReservationGVK] = IPReservationUpdateValidation
+}
+
+func IPReservationCreateValidation(ctx context.Context, req *admission.Request, handler *Handler) admission.Response {
+	logger := log.FromContext(ctx)
+
+	ipReservation := &networkingv1.IPReservation{}
+	err := handler.Decoder.Decode(*req, ipReservation)
+	if err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+
+	// Subnet validation
+	subnet := &networkingv1.Subnet{}
+	if err = handler.Client.Get(ctx, types.NamespacedName{Name: ipReservation.Spec.Subnet}, subnet); err != nil {
+		if errors.IsNotFound(err) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("subnet %s does not exist", ipReservation.Spec.Subnet), logger)
+		}
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	// IP validation
+	ip := net.ParseIP(ipReservation.Spec.IP)
+	if ip == nil {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid ip %s", ipReservation.Spec.IP), logger)
+	}
+
+	_, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR)
+	if err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	if !cidr.Contains(ip) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is not in subnet %s", ipReservation.Spec.IP, subnet.Name), logger)
+	}
+
+	// addresses out of range will never be allocated, no need to reserve them
+	if start := net.ParseIP(subnet.Spec.Range.Start); start != nil && cniip.Cmp(ip, start) < 0 {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is out of range of subnet %s", ipReservation.Spec.IP, subnet.Name), logger)
+	}
+	if end := net.ParseIP(subnet.Spec.Range.End); end != nil && cniip.Cmp(ip, end) > 0 {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is out of range of subnet %s", ipReservation.Spec.IP, subnet.Name), logger)
+	}
+
+	if ip.Equal(net.ParseIP(subnet.Spec.Range.Gateway)) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is the gateway of subnet %s", ipReservation.Spec.IP, subnet.Name), logger)
+	}
+
+	if containsIP(subnet.Spec.Range.ReservedIPs, ip) || containsIP(subnet.Spec.Range.ExcludeIPs, ip) {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s has been reserved or excluded by subnet %s", ipReservation.Spec.IP, subnet.Name), logger)
+	}
+
+	// Conflict validation
+	ipInstanceList := &networkingv1.IPInstanceList{}
+	if err = handler.Client.List(ctx, ipInstanceList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	for _, ipInstance := range ipInstanceList.Items {
+		// ip of a terminating ip instance is being released, it will be reserved after that
+		if !ipInstance.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if instanceIP, _, err := net.ParseCIDR(ipInstance.Spec.Address.IP); err == nil && instanceIP.Equal(ip) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is in use by pod %s/%s",
+				ipReservation.Spec.IP, ipInstance.Namespace, ipInstance.Status.PodName), logger)
+		}
+	}
+
+	virtualIPList := &networkingv1.VirtualIPList{}
+	if err = handler.Client.List(ctx, virtualIPList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	for _, virtualIP := range virtualIPList.Items {
+		if net.ParseIP(virtualIP.Status.IP).Equal(ip) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is in use by virtual ip %s/%s",
+				ipReservation.Spec.IP, virtualIP.Namespace, virtualIP.Name), logger)
+		}
+	}
+
+	anycastIPList := &networkingv1.AnycastIPList{}
+	if err = handler.Client.List(ctx, anycastIPList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	for _, anycastIP := range anycastIPList.Items {
+		if net.ParseIP(anycastIP.Status.IP).Equal(ip) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s is in use by anycast ip %s/%s",
+				ipReservation.Spec.IP, anycastIP.Namespace, anycastIP.Name), logger)
+		}
+	}
+
+	ipReservationList := &networkingv1.IPReservationList{}
+	if err = handler.Client.List(ctx, ipReservationList); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+	for _, existing := range ipReservationList.Items {
+		if existing.Spec.Subnet == subnet.Name && net.ParseIP(existing.Spec.IP).Equal(ip) {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("ip %s has been reserved by %s",
+				ipReservation.Spec.IP, existing.Name), logger)
+		}
+	}
+
+	return admission.Allowed("validation pass")
+}
+
+func IPReservationUpdateValidation(ctx context.Context, req *admission.Request, handler *Handler) admission.Response {
+	logger := log.FromContext(ctx)
+
+	var err error
+	oldR, newR := &networkingv1.IPReservation{}, &networkingv1.IPReservation{}
+	if err = handler.Decoder.DecodeRaw(req.Object, newR); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+	if err = handler.Decoder.DecodeRaw(req.OldObject, oldR); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
+	}
+
+	if oldR.Spec.Subnet != newR.Spec.Subnet {
+		return webhookutils.AdmissionDeniedWithLog("must not change subnet", logger)
+	}
+
+	if oldR.Spec.IP != newR.Spec.IP {
+		return webhookutils.AdmissionDeniedWithLog("must not change ip", logger)
+	}
+
+	return admission.Allowed("validation pass")
+}
+
+func containsIP(ips []string, ip net.IP) bool {
+	for _, i := range ips {
+		if ip.Equal(net.ParseIP(i)) {
+			return true
+		}
+	}
+	return false
+}
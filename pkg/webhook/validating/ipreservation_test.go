@@ -0,0 +1,222 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
+)
+
+func newTestValidatingIPReservation(name, subnetName, ip string) *networkingv1.IPReservation {
+	return &networkingv1.IPReservation{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.GroupVersion.String(),
+			Kind:       "IPReservation",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: networkingv1.IPReservationSpec{
+			Subnet: subnetName,
+			IP:     ip,
+		},
+	}
+}
+
+func TestIPReservationCreateValidation(t *testing.T) {
+	subnet := newTestValidatingSubnet("subnet1", "underlay", "192.168.0.0/24", false)
+	subnet.Spec.Range.Start = "192.168.0.10"
+	subnet.Spec.Range.End = "192.168.0.200"
+	subnet.Spec.Range.Gateway = "192.168.0.100"
+	subnet.Spec.Range.ReservedIPs = []string{"192.168.0.101"}
+	subnet.Spec.Range.ExcludeIPs = []string{"192.168.0.102"}
+
+	deletionTime := metav1.Now()
+	subnetLabels := map[string]string{constants.LabelSubnet: "subnet1"}
+
+	handler := newTestHandler(t,
+		subnet,
+		&networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "192-168-0-20", Labels: subnetLabels},
+			Spec: networkingv1.IPInstanceSpec{
+				Subnet:  "subnet1",
+				Address: networkingv1.Address{IP: "192.168.0.20/24"},
+			},
+			Status: networkingv1.IPInstanceStatus{PodName: "pod1"},
+		},
+		&networkingv1.IPInstance{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "192-168-0-21", Labels: subnetLabels,
+				DeletionTimestamp: &deletionTime},
+			Spec: networkingv1.IPInstanceSpec{
+				Subnet:  "subnet1",
+				Address: networkingv1.Address{IP: "192.168.0.21/24"},
+			},
+			Status: networkingv1.IPInstanceStatus{PodName: "pod2"},
+		},
+		&networkingv1.VirtualIP{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: subnetLabels},
+			Status:     networkingv1.VirtualIPStatus{IP: "192.168.0.30"},
+		},
+		&networkingv1.AnycastIP{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "dns", Labels: subnetLabels},
+			Status:     networkingv1.AnycastIPStatus{IP: "192.168.0.40"},
+		},
+		newTestValidatingIPReservation("existing", "subnet1", "192.168.0.50"),
+	)
+
+	tests := []struct {
+		name    string
+		subnet  string
+		ip      string
+		allowed bool
+	}{
+		{
+			name:    "free ip",
+			subnet:  "subnet1",
+			ip:      "192.168.0.60",
+			allowed: true,
+		},
+		{
+			name:    "ip of terminating ip instance",
+			subnet:  "subnet1",
+			ip:      "192.168.0.21",
+			allowed: true,
+		},
+		{
+			name:    "subnet not found",
+			subnet:  "subnet2",
+			ip:      "192.168.0.60",
+			allowed: false,
+		},
+		{
+			name:    "invalid ip",
+			subnet:  "subnet1",
+			ip:      "192.168.0",
+			allowed: false,
+		},
+		{
+			name:    "ip not in subnet",
+			subnet:  "subnet1",
+			ip:      "192.168.1.60",
+			allowed: false,
+		},
+		{
+			name:    "ip before range start",
+			subnet:  "subnet1",
+			ip:      "192.168.0.5",
+			allowed: false,
+		},
+		{
+			name:    "ip after range end",
+			subnet:  "subnet1",
+			ip:      "192.168.0.210",
+			allowed: false,
+		},
+		{
+			name:    "gateway ip",
+			subnet:  "subnet1",
+			ip:      "192.168.0.100",
+			allowed: false,
+		},
+		{
+			name:    "reserved ip of subnet",
+			subnet:  "subnet1",
+			ip:      "192.168.0.101",
+			allowed: false,
+		},
+		{
+			name:    "excluded ip of subnet",
+			subnet:  "subnet1",
+			ip:      "192.168.0.102",
+			allowed: false,
+		},
+		{
+			name:    "ip allocated to pod",
+			subnet:  "subnet1",
+			ip:      "192.168.0.20",
+			allowed: false,
+		},
+		{
+			name:    "ip of virtual ip",
+			subnet:  "subnet1",
+			ip:      "192.168.0.30",
+			allowed: false,
+		},
+		{
+			name:    "ip of anycast ip",
+			subnet:  "subnet1",
+			ip:      "192.168.0.40",
+			allowed: false,
+		},
+		{
+			name:    "ip reserved already",
+			subnet:  "subnet1",
+			ip:      "192.168.0.50",
+			allowed: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := IPReservationCreateValidation(context.Background(),
+				newTestRequest(t, admissionv1.Create, newTestValidatingIPReservation("reserved", test.subnet, test.ip), nil), handler)
+			assert.Equal(t, test.allowed, resp.Allowed, resp.Result.Reason)
+		})
+	}
+}
+
+func TestIPReservationUpdateValidation(t *testing.T) {
+	oldIPReservation := newTestValidatingIPReservation("reserved", "subnet1", "192.168.0.60")
+
+	tests := []struct {
+		name          string
+		ipReservation *networkingv1.IPReservation
+		allowed       bool
+	}{
+		{
+			name:          "nothing changed",
+			ipReservation: newTestValidatingIPReservation("reserved", "subnet1", "192.168.0.60"),
+			allowed:       true,
+		},
+		{
+			name:          "subnet changed",
+			ipReservation: newTestValidatingIPReservation("reserved", "subnet2", "192.168.0.60"),
+			allowed:       false,
+		},
+		{
+			name:          "ip changed",
+			ipReservation: newTestValidatingIPReservation("reserved", "subnet1", "192.168.0.61"),
+			allowed:       false,
+		},
+	}
+
+	handler := newTestHandler(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := IPReservationUpdateValidation(context.Background(),
+				newTestRequest(t, admissionv1.Update, test.ipReservation, oldIPReservation), handler)
+			assert.Equal(t, test.allowed, resp.Allowed, resp.Result.Reason)
+		})
+	}
+}
@@ -19,15 +19,16 @@ package validating
 import (
 	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"reflect"
 
 	webhookutils "github.com/alibaba/hybridnet/pkg/webhook/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	multiclusterv1 "github.com/alibaba/hybridnet/pkg/apis/multicluster/v1"
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/feature"
 
 	"k8s.io/apimachinery/pkg/types"
@@ -92,17 +93,52 @@ func NetworkCreateValidation(ctx context.Context, req *admission.Request, handle
 			return admission.Denied("one and only one bgp router need to be set")
 		}
 
-		for _, peer := range network.Spec.Config.BGPPeers {
-			if net.ParseIP(peer.Address) == nil {
-				return admission.Denied(fmt.Sprintf("invalid bgp peer ip address %v", peer.Address))
+		for i := range network.Spec.Config.BGPPeers {
+			if err := networkingv1.ValidateBGPPeer(&network.Spec.Config.BGPPeers[i]); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 			}
 		}
 	case networkingv1.NetworkModeVlan:
 	case networkingv1.NetworkModeVxlan:
+	case networkingv1.NetworkModeGeneve:
+		if networkingv1.GetNetworkType(network) != networkingv1.NetworkTypeOverlay {
+			return webhookutils.AdmissionDeniedWithLog("geneve mode is only supported by overlay network", logger)
+		}
 	default:
 		return admission.Denied(fmt.Sprintf("unknown network mode %s", networkingv1.GetNetworkMode(network)))
 	}
 
+	if err = networkingv1.ValidateMTU(network.Spec.MTU, networkingv1.GetNetworkMode(network), false,
+		networkingv1.IsWireGuardEnabled(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateVlanConfig(network.Spec.Config, networkingv1.GetNetworkMode(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateEncryptionConfig(network.Spec.Config, networkingv1.GetNetworkType(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateDirectRoutingConfig(network.Spec.Config, networkingv1.GetNetworkType(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateBGPImportPrefixes(network.Spec.Config, networkingv1.GetNetworkMode(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateBGPServiceAdvertisement(network.Spec.Config, networkingv1.GetNetworkMode(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if network.Spec.Config != nil {
+		if err = networkingv1.ValidateBGPPathConfig(network.Spec.Config.BGPPath, networkingv1.GetNetworkMode(network)); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
 	return admission.Allowed("validation pass")
 }
 
@@ -145,21 +181,67 @@ func NetworkUpdateValidation(ctx context.Context, req *admission.Request, handle
 			return admission.Denied("one and only one bgp router need to be set")
 		}
 
-		for _, peer := range newN.Spec.Config.BGPPeers {
-			if net.ParseIP(peer.Address) == nil {
-				return admission.Denied(fmt.Sprintf("invalid bgp peer ip address %v", peer.Address))
+		for i := range newN.Spec.Config.BGPPeers {
+			if err := networkingv1.ValidateBGPPeer(&newN.Spec.Config.BGPPeers[i]); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 			}
 		}
 	case networkingv1.NetworkModeVlan:
 	case networkingv1.NetworkModeVxlan:
+	case networkingv1.NetworkModeGeneve:
 	default:
 		return admission.Denied(fmt.Sprintf("unknown network mode %s", networkingv1.GetNetworkMode(newN)))
 	}
 
+	if err = networkingv1.ValidateMTU(newN.Spec.MTU, networkingv1.GetNetworkMode(newN), false,
+		networkingv1.IsWireGuardEnabled(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateVlanConfig(newN.Spec.Config, networkingv1.GetNetworkMode(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateEncryptionConfig(newN.Spec.Config, networkingv1.GetNetworkType(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateDirectRoutingConfig(newN.Spec.Config, networkingv1.GetNetworkType(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateBGPImportPrefixes(newN.Spec.Config, networkingv1.GetNetworkMode(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if err = networkingv1.ValidateBGPServiceAdvertisement(newN.Spec.Config, networkingv1.GetNetworkMode(newN)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	if newN.Spec.Config != nil {
+		if err = networkingv1.ValidateBGPPathConfig(newN.Spec.Config.BGPPath, networkingv1.GetNetworkMode(newN)); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
 	if !reflect.DeepEqual(oldN.Spec.NetID, newN.Spec.NetID) {
 		return webhookutils.AdmissionDeniedWithLog("net ID must not be changed", logger)
 	}
 
+	// vlan interfaces of existing pods are created on the uplink interfaces with outer vlan id
+	if networkingv1.GetVlanUplinkInterfaces(oldN) != networkingv1.GetVlanUplinkInterfaces(newN) ||
+		!reflect.DeepEqual(networkingv1.GetOuterVlanID(oldN), networkingv1.GetOuterVlanID(newN)) {
+		ipList := &networkingv1.IPInstanceList{}
+		if err = handler.Client.List(ctx, ipList, client.MatchingLabels{constants.LabelNetwork: newN.Name}); err != nil {
+			return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+		}
+
+		if len(ipList.Items) > 0 {
+			return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("vlan uplink interfaces and outer vlan id must not be changed "+
+				"while network still has %d ip instances", len(ipList.Items)), logger)
+		}
+	}
+
 	return admission.Allowed("validation pass")
 }
 
@@ -19,6 +19,7 @@ package validating
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
@@ -35,6 +36,7 @@ import (
 	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
 	"github.com/alibaba/hybridnet/pkg/constants"
 	"github.com/alibaba/hybridnet/pkg/feature"
+	"github.com/alibaba/hybridnet/pkg/ipam/strategy"
 	ipamtypes "github.com/alibaba/hybridnet/pkg/ipam/types"
 	"github.com/alibaba/hybridnet/pkg/utils"
 )
@@ -54,6 +56,15 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 		return webhookutils.AdmissionErroredWithLog(http.StatusBadRequest, err, logger)
 	}
 
+	// Bandwidth validation
+	for _, annotation := range []string{constants.AnnotationIngressBandwidth, constants.AnnotationEgressBandwidth} {
+		if bandwidth, exist := pod.Annotations[annotation]; exist {
+			if _, err = networkingv1.ParseBandwidth(bandwidth); err != nil {
+				return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("invalid annotation %s: %v", annotation, err), logger)
+			}
+		}
+	}
+
 	var (
 		networkTypeFromPod = utils.PickFirstNonEmptyString(pod.Annotations[constants.AnnotationNetworkType], pod.Labels[constants.LabelNetworkType])
 		networkType        = ipamtypes.ParseNetworkTypeFromString(networkTypeFromPod)
@@ -135,6 +146,21 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 		}
 	}
 
+	// Specified IP Validation
+	var specifiedIP string
+	if specifiedIP = pod.Annotations[constants.AnnotationSpecifiedIP]; len(specifiedIP) > 0 {
+		if len(specifiedNetwork) == 0 {
+			return webhookutils.AdmissionDeniedWithLog("specified ip and network(subnet) must be specified at the same time", logger)
+		}
+		if len(ipPool) > 0 || strategy.OwnByStatefulWorkload(pod) {
+			return webhookutils.AdmissionDeniedWithLog("specified ip is not supported for stateful workloads, use ip pool instead", logger)
+		}
+		if err = validateSpecifiedIP(ctx, handler.Cache, specifiedIP, specifiedSubnetStr,
+			ipamtypes.ParseIPFamilyFromString(pod.Annotations[constants.AnnotationIPFamily])); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
 	// Overlay network capacity validation
 	if feature.DualStackEnabled() && networkType == ipamtypes.Overlay {
 		networkList := &networkingv1.NetworkList{}
@@ -165,6 +191,66 @@ func PodCreateValidation(ctx context.Context, req *admission.Request, handler *H
 	return admission.Allowed("validation pass")
 }
 
+// validateSpecifiedIP checks whether specified IPs match the ip family of pod, and
+// belong to the specified subnets if any
+func validateSpecifiedIP(ctx context.Context, c client.Reader, specifiedIP, specifiedSubnetStr string, ipFamily ipamtypes.IPFamilyMode) error {
+	var ips = []string{specifiedIP}
+	if feature.DualStackEnabled() {
+		ips = strings.Split(specifiedIP, "/")
+	}
+
+	var expectedVersions []networkingv1.IPVersion
+	switch {
+	case !feature.DualStackEnabled():
+		expectedVersions = []networkingv1.IPVersion{""}
+	case ipFamily == ipamtypes.IPv4Only:
+		expectedVersions = []networkingv1.IPVersion{networkingv1.IPv4}
+	case ipFamily == ipamtypes.IPv6Only:
+		expectedVersions = []networkingv1.IPVersion{networkingv1.IPv6}
+	case ipFamily == ipamtypes.DualStack:
+		expectedVersions = []networkingv1.IPVersion{networkingv1.IPv4, networkingv1.IPv6}
+	}
+
+	if len(ips) != len(expectedVersions) {
+		return fmt.Errorf("specified ip %s mismatches ip family %s, expect %d ip(s)", specifiedIP, ipFamily, len(expectedVersions))
+	}
+
+	var subnetNames []string
+	if len(specifiedSubnetStr) > 0 {
+		subnetNames = strings.Split(specifiedSubnetStr, "/")
+	}
+
+	for i, ip := range ips {
+		var parsedIP = net.ParseIP(ip)
+		if parsedIP == nil {
+			return fmt.Errorf("specified ip has invalid ip %s", ip)
+		}
+
+		var version = networkingv1.IPv4
+		if parsedIP.To4() == nil {
+			version = networkingv1.IPv6
+		}
+		if len(expectedVersions[i]) > 0 && expectedVersions[i] != version {
+			return fmt.Errorf("specified ip %s is not an ipv%s address", ip, expectedVersions[i])
+		}
+
+		for _, subnetName := range subnetNames {
+			subnet := &networkingv1.Subnet{}
+			if err := c.Get(ctx, types.NamespacedName{Name: subnetName}, subnet); err != nil {
+				return fmt.Errorf("specified subnet %s not found", subnetName)
+			}
+			if subnet.Spec.Range.Version != version {
+				continue
+			}
+			if _, cidr, err := net.ParseCIDR(subnet.Spec.Range.CIDR); err != nil || !cidr.Contains(parsedIP) {
+				return fmt.Errorf("specified ip %s is not in specified subnet %s", ip, subnetName)
+			}
+		}
+	}
+
+	return nil
+}
+
 func stringEqualCaseInsensitive(a, b string) bool {
 	return strings.EqualFold(a, b)
 }
@@ -103,17 +103,55 @@ func SubnetCreateValidation(ctx context.Context, req *admission.Request, handler
 		if subnet.Spec.Config != nil && subnet.Spec.Config.AutoNatOutgoing != nil {
 			return admission.Denied("must not set autoNatOutgoing with underlay subnet")
 		}
-	case networkingv1.NetworkModeVxlan:
+	case networkingv1.NetworkModeVxlan, networkingv1.NetworkModeGeneve:
 		if subnet.Spec.NetID != nil {
 			return webhookutils.AdmissionDeniedWithLog("must not assign net ID for overlay subnet", logger)
 		}
 	}
 
+	// Load balancer validation
+	if networkingv1.IsLoadBalancerSubnet(subnet) {
+		if mode := networkingv1.GetNetworkMode(network); mode != networkingv1.NetworkModeVlan && mode != networkingv1.NetworkModeBGP {
+			return webhookutils.AdmissionDeniedWithLog("load balancer subnet is only supported by vlan or bgp network", logger)
+		}
+	}
+
 	// Address Range validation
 	if err = networkingv1.ValidateAddressRange(&subnet.Spec.Range); err != nil {
 		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
 	}
 
+	// Routes and DNS validation
+	if err = networkingv1.ValidateSubnetRoutes(networkingv1.GetSubnetRoutes(&subnet.Spec), &subnet.Spec.Range,
+		networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeVlan); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+	if err = networkingv1.ValidateDNSConfig(networkingv1.GetSubnetDNSConfig(&subnet.Spec)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Bandwidth validation
+	if err = networkingv1.ValidateSubnetBandwidth(&subnet.Spec); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// MTU validation
+	var subnetMTU *int32
+	if subnet.Spec.Config != nil {
+		subnetMTU = subnet.Spec.Config.MTU
+	}
+	if err = networkingv1.ValidateMTU(subnetMTU, networkingv1.GetNetworkMode(network), networkingv1.IsIPv6Subnet(subnet),
+		networkingv1.IsWireGuardEnabled(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// BGP path validation
+	if subnet.Spec.Config != nil {
+		if err = networkingv1.ValidateBGPPathConfig(subnet.Spec.Config.BGPPath, networkingv1.GetNetworkMode(network)); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
 	// IP Family validation
 	if !feature.DualStackEnabled() && networkingv1.IsIPv6Subnet(subnet) {
 		return webhookutils.AdmissionDeniedWithLog("ipv6 subnet non-supported if dualstack not enabled", logger)
@@ -217,6 +255,41 @@ func SubnetUpdateValidation(ctx context.Context, req *admission.Request, handler
 		return webhookutils.AdmissionDeniedWithLog("must not change excluded IPs", logger)
 	}
 
+	if networkingv1.IsLoadBalancerSubnet(oldS) != networkingv1.IsLoadBalancerSubnet(newS) {
+		return webhookutils.AdmissionDeniedWithLog("must not change load balancer", logger)
+	}
+
+	// Routes and DNS validation
+	if err = networkingv1.ValidateSubnetRoutes(networkingv1.GetSubnetRoutes(&newS.Spec), &newS.Spec.Range,
+		networkingv1.GetNetworkMode(network) == networkingv1.NetworkModeVlan); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+	if err = networkingv1.ValidateDNSConfig(networkingv1.GetSubnetDNSConfig(&newS.Spec)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// Bandwidth validation
+	if err = networkingv1.ValidateSubnetBandwidth(&newS.Spec); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// MTU validation
+	var subnetMTU *int32
+	if newS.Spec.Config != nil {
+		subnetMTU = newS.Spec.Config.MTU
+	}
+	if err = networkingv1.ValidateMTU(subnetMTU, networkingv1.GetNetworkMode(network), networkingv1.IsIPv6Subnet(newS),
+		networkingv1.IsWireGuardEnabled(network)); err != nil {
+		return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+	}
+
+	// BGP path validation
+	if newS.Spec.Config != nil {
+		if err = networkingv1.ValidateBGPPathConfig(newS.Spec.Config.BGPPath, networkingv1.GetNetworkMode(network)); err != nil {
+			return webhookutils.AdmissionDeniedWithLog(err.Error(), logger)
+		}
+	}
+
 	return admission.Allowed("validation pass")
 }
 
@@ -242,5 +315,59 @@ func SubnetDeleteValidation(ctx context.Context, req *admission.Request, handler
 		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("still have using ips %v", usingIPs), logger)
 	}
 
+	virtualIPList := &networkingv1.VirtualIPList{}
+	if err = handler.Client.List(ctx, virtualIPList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	if len(virtualIPList.Items) > 0 {
+		var virtualIPs []string
+		for _, virtualIP := range virtualIPList.Items {
+			virtualIPs = append(virtualIPs, virtualIP.Status.IP)
+		}
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("still have virtual ips %v", virtualIPs), logger)
+	}
+
+	anycastIPList := &networkingv1.AnycastIPList{}
+	if err = handler.Client.List(ctx, anycastIPList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	if len(anycastIPList.Items) > 0 {
+		var anycastIPs []string
+		for _, anycastIP := range anycastIPList.Items {
+			anycastIPs = append(anycastIPs, anycastIP.Status.IP)
+		}
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("still have anycast ips %v", anycastIPs), logger)
+	}
+
+	loadBalancerIPList := &networkingv1.LoadBalancerIPList{}
+	if err = handler.Client.List(ctx, loadBalancerIPList, client.MatchingLabels{constants.LabelSubnet: subnet.Name}); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	if len(loadBalancerIPList.Items) > 0 {
+		var loadBalancerIPs []string
+		for _, loadBalancerIP := range loadBalancerIPList.Items {
+			loadBalancerIPs = append(loadBalancerIPs, loadBalancerIP.Status.IP)
+		}
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("still have load balancer ips %v", loadBalancerIPs), logger)
+	}
+
+	ipReservationList := &networkingv1.IPReservationList{}
+	if err = handler.Client.List(ctx, ipReservationList); err != nil {
+		return webhookutils.AdmissionErroredWithLog(http.StatusInternalServerError, err, logger)
+	}
+
+	var reservedIPs []string
+	for _, ipReservation := range ipReservationList.Items {
+		if ipReservation.Spec.Subnet == subnet.Name {
+			reservedIPs = append(reservedIPs, ipReservation.Spec.IP)
+		}
+	}
+	if len(reservedIPs) > 0 {
+		return webhookutils.AdmissionDeniedWithLog(fmt.Sprintf("still have reserved ips %v", reservedIPs), logger)
+	}
+
 	return admission.Allowed("validation pass")
 }
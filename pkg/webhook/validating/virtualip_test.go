@@ -0,0 +1,243 @@
+/*
+ Copyright 2021 The Hybridnet Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validating
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	networkingv1 "github.com/alibaba/hybridnet/pkg/apis/networking/v1"
+)
+
+func newTestHandler(t *testing.T, objects ...client.Object) *Handler {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	assert.NoError(t, networkingv1.AddToScheme(scheme))
+
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+
+	return &Handler{
+		Decoder: decoder,
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+	}
+}
+
+func newTestRequest(t *testing.T, operation admissionv1.Operation, obj, oldObj runtime.Object) *admission.Request {
+	req := &admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+		},
+	}
+
+	raw, err := json.Marshal(obj)
+	assert.NoError(t, err)
+	req.Object = runtime.RawExtension{Raw: raw}
+
+	if oldObj != nil {
+		raw, err = json.Marshal(oldObj)
+		assert.NoError(t, err)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+func newTestValidatingSubnet(name, networkName, cidr string, loadBalancer bool) *networkingv1.Subnet {
+	subnet := &networkingv1.Subnet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: networkingv1.SubnetSpec{
+			Network: networkName,
+			Range: networkingv1.AddressRange{
+				Version: networkingv1.IPv4,
+				CIDR:    cidr,
+			},
+		},
+	}
+	if loadBalancer {
+		subnet.Spec.Config = &networkingv1.SubnetConfig{LoadBalancer: &loadBalancer}
+	}
+	return subnet
+}
+
+func newTestValidatingNetwork(name string, networkType networkingv1.NetworkType) *networkingv1.Network {
+	return &networkingv1.Network{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: networkingv1.NetworkSpec{
+			Type: networkType,
+		},
+	}
+}
+
+func newTestValidatingVirtualIP(subnetName, address string, selector *metav1.LabelSelector) *networkingv1.VirtualIP {
+	return &networkingv1.VirtualIP{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.GroupVersion.String(),
+			Kind:       "VirtualIP",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+		},
+		Spec: networkingv1.VirtualIPSpec{
+			Subnet:   subnetName,
+			Address:  address,
+			Selector: selector,
+		},
+	}
+}
+
+func TestVirtualIPCreateValidation(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	handler := newTestHandler(t,
+		newTestValidatingNetwork("underlay", networkingv1.NetworkTypeUnderlay),
+		newTestValidatingNetwork("overlay", networkingv1.NetworkTypeOverlay),
+		newTestValidatingSubnet("subnet1", "underlay", "192.168.0.0/24", false),
+		newTestValidatingSubnet("subnet2", "overlay", "10.0.0.0/24", false),
+		newTestValidatingSubnet("lb-subnet", "underlay", "192.168.1.0/24", true),
+	)
+
+	tests := []struct {
+		name      string
+		virtualIP *networkingv1.VirtualIP
+		allowed   bool
+	}{
+		{
+			name:      "valid",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "", selector),
+			allowed:   true,
+		},
+		{
+			name:      "valid with address",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0.100", selector),
+			allowed:   true,
+		},
+		{
+			name:      "nil selector",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "", nil),
+			allowed:   false,
+		},
+		{
+			name:      "empty selector",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "", &metav1.LabelSelector{}),
+			allowed:   false,
+		},
+		{
+			name: "invalid selector",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "", &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Unknown"},
+				},
+			}),
+			allowed: false,
+		},
+		{
+			name:      "subnet not found",
+			virtualIP: newTestValidatingVirtualIP("subnet3", "", selector),
+			allowed:   false,
+		},
+		{
+			name:      "load balancer subnet",
+			virtualIP: newTestValidatingVirtualIP("lb-subnet", "", selector),
+			allowed:   false,
+		},
+		{
+			name:      "overlay network",
+			virtualIP: newTestValidatingVirtualIP("subnet2", "", selector),
+			allowed:   false,
+		},
+		{
+			name:      "invalid address",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0", selector),
+			allowed:   false,
+		},
+		{
+			name:      "address out of subnet",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.1.100", selector),
+			allowed:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := VirtualIPCreateValidation(context.Background(),
+				newTestRequest(t, admissionv1.Create, test.virtualIP, nil), handler)
+			assert.Equal(t, test.allowed, resp.Allowed, resp.Result.Reason)
+		})
+	}
+}
+
+func TestVirtualIPUpdateValidation(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	oldVirtualIP := newTestValidatingVirtualIP("subnet1", "192.168.0.100", selector)
+
+	tests := []struct {
+		name      string
+		virtualIP *networkingv1.VirtualIP
+		allowed   bool
+	}{
+		{
+			name:      "nothing changed",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0.100", selector),
+			allowed:   true,
+		},
+		{
+			name: "selector changed",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0.100",
+				&metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}),
+			allowed: true,
+		},
+		{
+			name:      "selector emptied",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0.100", &metav1.LabelSelector{}),
+			allowed:   false,
+		},
+		{
+			name:      "subnet changed",
+			virtualIP: newTestValidatingVirtualIP("subnet2", "192.168.0.100", selector),
+			allowed:   false,
+		},
+		{
+			name:      "address changed",
+			virtualIP: newTestValidatingVirtualIP("subnet1", "192.168.0.101", selector),
+			allowed:   false,
+		},
+	}
+
+	handler := newTestHandler(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := VirtualIPUpdateValidation(context.Background(),
+				newTestRequest(t, admissionv1.Update, test.virtualIP, oldVirtualIP), handler)
+			assert.Equal(t, test.allowed, resp.Allowed, resp.Result.Reason)
+		})
+	}
+}